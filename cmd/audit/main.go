@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"sso/internal/app"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/jwt"
+	"sso/internal/services/audit"
+	"sso/internal/storage/sqlite"
+)
+
+// audit is the admin command for the audit trail:
+//
+//	go run ./cmd/audit --config=./config/local.yaml verify
+//	go run ./cmd/audit --config=./config/local.yaml --keys=jwks.json verify
+//	go run ./cmd/audit --config=./config/local.yaml export > audit.jsonl
+//	go run ./cmd/audit --config=./config/local.yaml checkpoint
+//
+// verify walks the hash chain and the signed checkpoints and reports the
+// first broken link. export does the same and writes the verified events
+// as JSON Lines to stdout, stopping at the broken link. checkpoint signs
+// the head of the chain right away. Both verify and export exit with 1
+// when the chain is broken.
+//
+// Checkpoints must be signed by a key the server stores, decrypted with
+// the encryption key, or with --keys by one of the keys of a JWKS file
+// kept outside the database.
+func main() {
+	keysPath := flag.String("keys", "", "JWKS file with the only keys trusted to sign checkpoints")
+	cfg := config.MustLoad()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	storage, err := sqlite.New(cfg.StoragePath)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+
+	switch flag.Arg(0) {
+	case "verify":
+		report, err := audit.Verify(ctx, storage, trustedKeys(logger, cfg, storage, *keysPath), nil)
+		if err != nil {
+			panic(err)
+		}
+		printReport(report)
+	case "export":
+		out := bufio.NewWriter(os.Stdout)
+		enc := json.NewEncoder(out)
+
+		report, err := audit.Verify(ctx, storage, trustedKeys(logger, cfg, storage, *keysPath), func(event models.AuditEvent) error {
+			return enc.Encode(exportedEvent(event))
+		})
+		if err != nil {
+			panic(err)
+		}
+		if err := out.Flush(); err != nil {
+			panic(err)
+		}
+		printReport(report)
+	case "checkpoint":
+		keyManager, err := app.NewKeyManager(logger, cfg, storage)
+		if err != nil {
+			panic(err)
+		}
+
+		checkpointer := audit.NewCheckpointer(logger, storage, keyManager, cfg.Issuer, cfg.Audit.CheckpointInterval)
+		if err := checkpointer.Checkpoint(ctx); err != nil {
+			panic(err)
+		}
+
+		eventID, _, err := storage.AuditChainHead(ctx)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("audit chain signed up to event", eventID, "with kid", keyManager.SigningKey().ID)
+	default:
+		panic("usage: audit --config=<path> verify|export|checkpoint")
+	}
+}
+
+// trustedKeys are the keys checkpoints may be signed with: those of the
+// JWKS file when one is given, otherwise every key in storage.
+func trustedKeys(logger *slog.Logger, cfg *config.Config, storage *sqlite.Storage, path string) []jwt.JSONWebKey {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
+
+		var set jwt.JWKSet
+		if err := json.Unmarshal(data, &set); err != nil {
+			panic(fmt.Sprint("faild to read keys ", err.Error()))
+		}
+		return set.Keys
+	}
+
+	keyManager, err := app.NewKeyManager(logger, cfg, storage)
+	if err != nil {
+		panic(err)
+	}
+
+	keys, err := keyManager.Keys(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	return jwt.NewJWKSet(keys).Keys
+}
+
+// printReport writes the verification report to stderr, so it stays out
+// of an export, and exits with 1 on a broken chain.
+func printReport(report audit.Report) {
+	if report.Unchained > 0 {
+		fmt.Fprintln(os.Stderr, report.Unchained, "events predate hash chaining and were not verified")
+	}
+
+	if report.Broken != nil {
+		fmt.Fprintln(os.Stderr, "audit chain broken at", report.Broken)
+		fmt.Fprintln(os.Stderr, report.Events, "events before it verified")
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "audit chain intact: %d events verified up to event %d, %d checkpoints\n",
+		report.Events, report.LastEventID, report.Checkpoints)
+	if report.Unsigned > 0 {
+		fmt.Fprintln(os.Stderr, report.Unsigned, "events after the last checkpoint are chained but not signed yet")
+	}
+	if len(report.Keys) > 0 {
+		fmt.Fprintln(os.Stderr, "checkpoints signed by kid", strings.Join(report.Keys, ", "))
+	}
+}
+
+type exportEvent struct {
+	ID        int64             `json:"id"`
+	Type      string            `json:"type"`
+	ActorID   int64             `json:"actor_id,omitempty"`
+	UserID    int64             `json:"user_id,omitempty"`
+	AppID     int64             `json:"app_id,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt string            `json:"created_at"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+func exportedEvent(event models.AuditEvent) exportEvent {
+	return exportEvent{
+		ID:        event.ID,
+		Type:      event.Type,
+		ActorID:   event.ActorID,
+		UserID:    event.UserID,
+		AppID:     event.AppID,
+		IP:        event.IP,
+		UserAgent: event.UserAgent,
+		Outcome:   event.Outcome,
+		Details:   event.Details,
+		CreatedAt: event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		PrevHash:  event.PrevHash,
+		Hash:      event.Hash,
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"sso/internal/app"
+	"sso/internal/config"
+	"sso/internal/storage/sqlite"
+)
+
+// keys is the admin command for signing keys:
+//
+//	go run ./cmd/keys --config=./config/local.yaml rotate
+//	go run ./cmd/keys --config=./config/local.yaml list
+//
+// Running sso instances pick up a forced rotation on their next key refresh.
+func main() {
+	cfg := config.MustLoad()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	storage, err := sqlite.New(cfg.StoragePath)
+	if err != nil {
+		panic(err)
+	}
+
+	keyManager, err := app.NewKeyManager(logger, cfg, storage)
+	if err != nil {
+		panic(err)
+	}
+
+	switch flag.Arg(0) {
+	case "rotate":
+		if err := keyManager.Rotate(context.Background()); err != nil {
+			panic(err)
+		}
+		fmt.Println("signing keys rotated, active kid:", keyManager.SigningKey().ID)
+	case "list":
+		active := keyManager.SigningKey().ID
+		for _, key := range keyManager.PublicKeys() {
+			marker := ""
+			if key.ID == active {
+				marker = " (active)"
+			}
+			fmt.Printf("%s %s%s\n", key.Algorithm, key.ID, marker)
+		}
+	default:
+		panic("usage: keys --config=<path> rotate|list")
+	}
+}
@@ -24,11 +24,23 @@ func main() {
 	logger := setupLogger(cfg.Env)
 
 	// App
-	application := app.New(logger, cfg.GRPC.Port, cfg.TokenTTL, cfg.StoragePath)
+	application := app.New(logger, cfg)
 
 	// Run gRPC Server
 	go application.GRPCApp.MustRun()
 
+	// Run HTTP Server
+	go application.HTTPApp.MustRun()
+
+	// Rotate signing keys
+	go application.KeyManager.Run()
+
+	// Prune expired tokens
+	go application.Pruner.Run()
+
+	// Sign the audit chain
+	go application.Checkpointer.Run()
+
 	logger.Debug("Server running")
 
 	stop := make(chan os.Signal, 1)
@@ -39,6 +51,10 @@ func main() {
 	logger.Info("server stopping", slog.String("signal", signal.String()))
 
 	application.GRPCApp.Stop()
+	application.HTTPApp.Stop()
+	application.KeyManager.Stop()
+	application.Pruner.Stop()
+	application.Checkpointer.Stop()
 	logger.Info("application stopped")
 }
 
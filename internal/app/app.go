@@ -1,31 +1,266 @@
 package app
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	grpcapp "sso/internal/app/grpc"
+	httpapp "sso/internal/app/http"
+	"sso/internal/config"
+	"sso/internal/grpc/interceptors"
+	"sso/internal/jwt"
+	"sso/internal/lib/aead"
+	"sso/internal/lib/hasher"
+	"sso/internal/lib/passpolicy"
+	"sso/internal/lib/passpolicy/hibp"
 	"sso/internal/lib/sl"
+	"sso/internal/mail/file"
+	"sso/internal/mail/smtp"
+	"sso/internal/ratelimit"
+	"sso/internal/ratelimit/memory"
+	ratelimitredis "sso/internal/ratelimit/redis"
+	"sso/internal/services/audit"
 	"sso/internal/services/auth"
+	"sso/internal/services/pruner"
 	"sso/internal/storage/sqlite"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
 )
 
 type App struct {
-	GRPCApp *grpcapp.App
+	GRPCApp      *grpcapp.App
+	HTTPApp      *httpapp.App
+	KeyManager   *jwt.Manager
+	Pruner       *pruner.Pruner
+	Checkpointer *audit.Checkpointer
 }
 
-func New(log *slog.Logger, port int, tokenTTL time.Duration, storagePath string) *App {
-	storage, err := sqlite.New(storagePath)
+func New(log *slog.Logger, cfg *config.Config) *App {
+	storage, err := sqlite.New(cfg.StoragePath)
 	if err != nil {
 		log.Error("faild connect to db", sl.Err(err))
 		return nil
 	}
 
-	authSevice := auth.New(log, storage, tokenTTL)
+	keyManager, err := NewKeyManager(log, cfg, storage)
+	if err != nil {
+		log.Error("faild to load signing keys", sl.Err(err))
+		return nil
+	}
+
+	cipher, err := aead.New(cfg.EncryptionKey)
+	if err != nil {
+		log.Error("faild to create cipher", sl.Err(err))
+		return nil
+	}
+
+	passkeys, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthn.RPID,
+		RPDisplayName: cfg.WebAuthn.RPDisplayName,
+		RPOrigins:     cfg.WebAuthn.RPOrigins,
+		// attestation isn't checked, any authenticator may register
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+	if err != nil {
+		log.Error("faild to configure webauthn", sl.Err(err))
+		return nil
+	}
+
+	var mailer auth.Mailer
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mailer = smtp.New(cfg.Mail.SMTP.Host, cfg.Mail.SMTP.Port, cfg.Mail.SMTP.Username, cfg.Mail.SMTP.Password, cfg.Mail.From)
+	case "file":
+		mailer = file.New(cfg.Mail.FilePath)
+	default:
+		log.Error("unknown mail driver", slog.String("driver", cfg.Mail.Driver))
+		return nil
+	}
+
+	var breaches passpolicy.BreachChecker
+	if cfg.PasswordPolicy.HIBP.Enabled {
+		breaches = hibp.New(&http.Client{Timeout: cfg.PasswordPolicy.HIBP.Timeout}, cfg.PasswordPolicy.HIBP.URL)
+	}
+	passwords := passpolicy.New(passpolicy.Config{
+		MinLength:     cfg.PasswordPolicy.MinLength,
+		MaxLength:     cfg.PasswordPolicy.MaxLength,
+		RequireUpper:  cfg.PasswordPolicy.RequireUpper,
+		RequireLower:  cfg.PasswordPolicy.RequireLower,
+		RequireDigit:  cfg.PasswordPolicy.RequireDigit,
+		RequireSymbol: cfg.PasswordPolicy.RequireSymbol,
+		RejectEmail:   cfg.PasswordPolicy.RejectEmail,
+		RejectCommon:  cfg.PasswordPolicy.RejectCommon,
+	}, breaches)
+
+	passHasher, err := NewHasher(cfg.PasswordHash)
+	if err != nil {
+		log.Error("faild to configure password hashing", sl.Err(err))
+		return nil
+	}
+
+	policyLocation, err := time.LoadLocation(cfg.Policy.TimeZone)
+	if err != nil {
+		log.Error("faild to load policy time zone", sl.Err(err))
+		return nil
+	}
+
+	authSevice := auth.New(log, storage, keyManager, cipher, passkeys, mailer, passwords, passHasher, auth.Config{
+		Issuer:                          cfg.Issuer,
+		TokenTTL:                        cfg.TokenTTL,
+		RefreshTokenTTL:                 cfg.RefreshTokenTTL,
+		AuthorizationCodeTTL:            cfg.OAuth.AuthorizationCodeTTL,
+		DeviceCodeTTL:                   cfg.OAuth.DeviceCodeTTL,
+		DevicePollInterval:              cfg.OAuth.DevicePollInterval,
+		MFAIssuer:                       cfg.MFA.Issuer,
+		MFAChallengeTTL:                 cfg.MFA.ChallengeTTL,
+		MFAMaxAttempts:                  cfg.MFA.MaxAttempts,
+		WebAuthnCeremonyTTL:             cfg.WebAuthn.CeremonyTTL,
+		PasswordResetTTL:                cfg.PasswordReset.TokenTTL,
+		PasswordResetURL:                cfg.PasswordReset.URL,
+		EmailVerificationTTL:            cfg.EmailVerification.TokenTTL,
+		EmailVerificationResendInterval: cfg.EmailVerification.ResendInterval,
+		EmailVerificationURL:            cfg.EmailVerification.URL,
+		PasswordlessTTL:                 cfg.Passwordless.TokenTTL,
+		PasswordlessMaxAttempts:         cfg.Passwordless.MaxAttempts,
+		PasswordlessURL:                 cfg.Passwordless.URL,
+		LockoutThreshold:                cfg.Lockout.Threshold,
+		LockoutDuration:                 cfg.Lockout.Duration,
+		LoginDelayAfter:                 cfg.Lockout.DelayAfter,
+		LoginDelayBase:                  cfg.Lockout.DelayBase,
+		LoginDelayMax:                   cfg.Lockout.DelayMax,
+		IPMaxFailedLogins:               cfg.Lockout.IPMaxFailures,
+		IPLoginWindow:                   cfg.Lockout.IPWindow,
+		PolicyLocation:                  policyLocation,
+	})
+
+	grpcInterceptors := []grpc.UnaryServerInterceptor{interceptors.ClientInfo()}
+	if cfg.RateLimit.Enabled {
+		rateLimit, err := NewRateLimit(log, cfg.RateLimit, authSevice)
+		if err != nil {
+			log.Error("faild to configure rate limit", sl.Err(err))
+			return nil
+		}
+		grpcInterceptors = append(grpcInterceptors, rateLimit)
+	}
 
-	grpcApp := grpcapp.New(log, port, authSevice)
+	grpcApp := grpcapp.New(log, cfg.GRPC.Port, authSevice, grpcInterceptors...)
+	httpApp := httpapp.New(log, cfg.HTTP.Port, cfg.HTTP.Timeout, authSevice)
 
 	return &App{
-		GRPCApp: grpcApp,
+		GRPCApp:      grpcApp,
+		HTTPApp:      httpApp,
+		KeyManager:   keyManager,
+		Pruner:       pruner.New(log, storage, cfg.PruneInterval),
+		Checkpointer: audit.NewCheckpointer(log, storage, keyManager, cfg.Issuer, cfg.Audit.CheckpointInterval),
+	}
+}
+
+// NewKeyManager builds the signing key manager and loads its keys, so the
+// server and the admin command see the same key set.
+func NewKeyManager(log *slog.Logger, cfg *config.Config, storage jwt.KeyStorage) (*jwt.Manager, error) {
+	cipher, err := aead.New(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
 	}
+
+	keyManager := jwt.NewManager(
+		log,
+		storage,
+		cipher,
+		cfg.Keys.Algorithm,
+		cfg.Keys.RotationPeriod,
+		cfg.Keys.RefreshInterval,
+		cfg.TokenTTL,
+	)
+
+	if err := keyManager.Load(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return keyManager, nil
+}
+
+// NewHasher builds the password hasher, reading the pepper from its file
+// when one is configured.
+func NewHasher(cfg config.PasswordHashConfig) (*hasher.Hasher, error) {
+	const op = "app.NewHasher"
+
+	pepper := cfg.Pepper
+	if cfg.PepperFile != "" {
+		data, err := os.ReadFile(cfg.PepperFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		pepper = strings.TrimSpace(string(data))
+	}
+
+	return hasher.New(hasher.Config{
+		Algorithm:  cfg.Algorithm,
+		BcryptCost: cfg.BcryptCost,
+		Argon2: hasher.Argon2Params{
+			Memory:      cfg.Argon2.Memory,
+			Iterations:  cfg.Argon2.Iterations,
+			Parallelism: cfg.Argon2.Parallelism,
+			SaltLength:  cfg.Argon2.SaltLength,
+			KeyLength:   cfg.Argon2.KeyLength,
+		},
+		Pepper: []byte(pepper),
+	})
+}
+
+// NewRateLimit builds the rate limiting interceptor with the configured
+// backend and rules.
+func NewRateLimit(log *slog.Logger, cfg config.RateLimitConfig, tokens interceptors.TokenValidator) (grpc.UnaryServerInterceptor, error) {
+	const op = "app.NewRateLimit"
+
+	var limiter ratelimit.Limiter
+	switch cfg.Backend {
+	case "memory":
+		limiter = memory.New()
+	case "redis":
+		limiter = ratelimitredis.New(redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		}), cfg.Redis.Prefix)
+	default:
+		return nil, fmt.Errorf("%s unknown backend %q", op, cfg.Backend)
+	}
+
+	rules := make([]interceptors.Rule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		switch r.Key {
+		case interceptors.KeyIP, interceptors.KeyApp, interceptors.KeyUser:
+		default:
+			return nil, fmt.Errorf("%s unknown key %q for %s", op, r.Key, r.Method)
+		}
+		if r.Method == "" || r.Rate <= 0 || r.Per <= 0 {
+			return nil, fmt.Errorf("%s rule for %q needs method, rate and per", op, r.Method)
+		}
+
+		burst := r.Burst
+		if burst <= 0 {
+			burst = r.Rate
+		}
+
+		rules = append(rules, interceptors.Rule{
+			Method: r.Method,
+			Key:    r.Key,
+			Limit:  ratelimit.Limit{Rate: r.Rate, Per: r.Per, Burst: burst},
+		})
+	}
+
+	return interceptors.RateLimit(log, limiter, tokens, rules), nil
 }
@@ -16,8 +16,8 @@ type App struct {
 	port       int
 }
 
-func New(log *slog.Logger, port int, authService grpcauth.Auth) *App {
-	gRPCServer := grpc.NewServer()
+func New(log *slog.Logger, port int, authService grpcauth.Auth, interceptors ...grpc.UnaryServerInterceptor) *App {
+	gRPCServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 	grpcauth.Register(gRPCServer, authService)
 
 	return &App{
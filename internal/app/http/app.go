@@ -0,0 +1,81 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	httpauth "sso/internal/http/auth"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/sl"
+	"sso/internal/lib/useragent"
+)
+
+type App struct {
+	log        *slog.Logger
+	httpServer *http.Server
+	port       int
+}
+
+func New(log *slog.Logger, port int, timeout time.Duration, authService httpauth.Auth) *App {
+	mux := http.NewServeMux()
+	httpauth.Register(mux, log, authService)
+
+	return &App{
+		log: log,
+		httpServer: &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           clientInfo(mux),
+			ReadHeaderTimeout: timeout,
+			ReadTimeout:       timeout,
+			WriteTimeout:      timeout,
+		},
+		port: port,
+	}
+}
+
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) Run() error {
+	const op = "httpapp.Run"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("port", a.port),
+	)
+
+	log.Info("HTTP server running", slog.String("addr", a.httpServer.Addr))
+
+	if err := a.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s %v", op, err)
+	}
+
+	return nil
+}
+
+func (a *App) Stop() {
+	const op = "httpapp.Stop"
+
+	a.log.With(slog.String("op", op)).
+		Info("stopping HTTP server", slog.Int("port", a.port))
+
+	if err := a.httpServer.Shutdown(context.Background()); err != nil {
+		a.log.Error("faild to stop HTTP server", sl.Err(err))
+	}
+}
+
+// clientInfo puts the address and the user agent of the client into the
+// request context, for the login limits and the audit log.
+func clientInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := clientip.NewContext(r.Context(), clientip.FromAddr(r.RemoteAddr))
+		ctx = useragent.NewContext(ctx, r.UserAgent())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -10,15 +10,187 @@ import (
 )
 
 type Config struct {
-	Env         string        `yaml:"env" env-default:"local" env-required:"true"`
-	StoragePath string        `yaml:"storage_path" env-required:"true"`
-	TokenTTL    time.Duration `yaml:"token_ttl" env-required:"true"`
-	GRPC        GRPCConfig    `yaml:"grpc"`
+	Env               string                  `yaml:"env" env-default:"local" env-required:"true"`
+	StoragePath       string                  `yaml:"storage_path" env-required:"true"`
+	Issuer            string                  `yaml:"issuer" env:"ISSUER" env-default:"http://localhost:8080"`
+	EncryptionKey     string                  `yaml:"encryption_key" env:"ENCRYPTION_KEY" env-required:"true"`
+	TokenTTL          time.Duration           `yaml:"token_ttl" env-required:"true"`
+	RefreshTokenTTL   time.Duration           `yaml:"refresh_token_ttl" env-default:"720h"`
+	PruneInterval     time.Duration           `yaml:"prune_interval" env-default:"1h"`
+	GRPC              GRPCConfig              `yaml:"grpc"`
+	HTTP              HTTPConfig              `yaml:"http"`
+	Keys              KeysConfig              `yaml:"keys"`
+	OAuth             OAuthConfig             `yaml:"oauth"`
+	MFA               MFAConfig               `yaml:"mfa"`
+	WebAuthn          WebAuthnConfig          `yaml:"webauthn"`
+	PasswordReset     PasswordResetConfig     `yaml:"password_reset"`
+	EmailVerification EmailVerificationConfig `yaml:"email_verification"`
+	Passwordless      PasswordlessConfig      `yaml:"passwordless"`
+	Lockout           LockoutConfig           `yaml:"lockout"`
+	PasswordPolicy    PasswordPolicyConfig    `yaml:"password_policy"`
+	PasswordHash      PasswordHashConfig      `yaml:"password_hash"`
+	RateLimit         RateLimitConfig         `yaml:"rate_limit"`
+	Audit             AuditConfig             `yaml:"audit"`
+	Policy            PolicyConfig            `yaml:"policy"`
+	Mail              MailConfig              `yaml:"mail"`
 }
 type GRPCConfig struct {
 	Port    int           `yaml:"port" env-required:"true"`
 	Timeout time.Duration `yaml:"timeout" env-required:"true"`
 }
+type HTTPConfig struct {
+	Port    int           `yaml:"port" env-default:"8080"`
+	Timeout time.Duration `yaml:"timeout" env-default:"10s"`
+}
+type AuditConfig struct {
+	// CheckpointInterval is how often the head of the audit chain is
+	// signed, events after the last checkpoint are only chained.
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval" env-default:"1h"`
+}
+type PolicyConfig struct {
+	// TimeZone is the IANA zone env.hour and env.weekday of access
+	// policies are read in.
+	TimeZone string `yaml:"time_zone" env-default:"UTC"`
+}
+type OAuthConfig struct {
+	AuthorizationCodeTTL time.Duration `yaml:"authorization_code_ttl" env-default:"1m"`
+	DeviceCodeTTL        time.Duration `yaml:"device_code_ttl" env-default:"10m"`
+	DevicePollInterval   time.Duration `yaml:"device_poll_interval" env-default:"5s"`
+}
+type MFAConfig struct {
+	Issuer       string        `yaml:"issuer" env-default:"sso"`
+	ChallengeTTL time.Duration `yaml:"challenge_ttl" env-default:"5m"`
+	MaxAttempts  int           `yaml:"max_attempts" env-default:"5"`
+}
+type WebAuthnConfig struct {
+	// RPID is the domain passkeys are bound to, it must be the origins'
+	// host or a parent domain of it.
+	RPID          string        `yaml:"rp_id" env-default:"localhost"`
+	RPDisplayName string        `yaml:"rp_display_name" env-default:"sso"`
+	RPOrigins     []string      `yaml:"rp_origins" env-default:"http://localhost:8080"`
+	CeremonyTTL   time.Duration `yaml:"ceremony_ttl" env-default:"5m"`
+}
+type PasswordResetConfig struct {
+	TokenTTL time.Duration `yaml:"token_ttl" env-default:"15m"`
+	// URL is the page that takes the new password, the token is appended
+	// as ?token=. Without it the email only carries the token.
+	URL string `yaml:"url"`
+}
+type EmailVerificationConfig struct {
+	TokenTTL       time.Duration `yaml:"token_ttl" env-default:"24h"`
+	ResendInterval time.Duration `yaml:"resend_interval" env-default:"1m"`
+	// URL is the page that confirms the email, the token is appended as
+	// ?token=. Without it the email only carries the token.
+	URL string `yaml:"url"`
+}
+type PasswordlessConfig struct {
+	TokenTTL    time.Duration `yaml:"token_ttl" env-default:"10m"`
+	MaxAttempts int           `yaml:"max_attempts" env-default:"5"`
+	// URL is the page sign-in links open, the token is appended as
+	// ?token=. Without it the email only carries the token.
+	URL string `yaml:"url"`
+}
+type LockoutConfig struct {
+	// Threshold is the wrong passwords in a row that lock the account for
+	// Duration, 0 never locks.
+	Threshold int           `yaml:"threshold" env-default:"10"`
+	Duration  time.Duration `yaml:"duration" env-default:"15m"`
+	// DelayAfter is the wrong passwords in a row after which every login
+	// waits, from DelayBase doubling up to DelayMax.
+	DelayAfter int           `yaml:"delay_after" env-default:"3"`
+	DelayBase  time.Duration `yaml:"delay_base" env-default:"1s"`
+	DelayMax   time.Duration `yaml:"delay_max" env-default:"1m"`
+	// IPMaxFailures is the failed logins one address may make per
+	// IPWindow, whichever accounts they were for. 0 doesn't limit.
+	IPMaxFailures int           `yaml:"ip_max_failures" env-default:"50"`
+	IPWindow      time.Duration `yaml:"ip_window" env-default:"15m"`
+}
+type PasswordPolicyConfig struct {
+	MinLength int `yaml:"min_length" env-default:"8"`
+	// MaxLength counts bytes, bcrypt ignores what is beyond 72.
+	MaxLength     int        `yaml:"max_length" env-default:"72"`
+	RequireUpper  bool       `yaml:"require_upper"`
+	RequireLower  bool       `yaml:"require_lower"`
+	RequireDigit  bool       `yaml:"require_digit"`
+	RequireSymbol bool       `yaml:"require_symbol"`
+	RejectEmail   bool       `yaml:"reject_email" env-default:"true"`
+	RejectCommon  bool       `yaml:"reject_common" env-default:"true"`
+	HIBP          HIBPConfig `yaml:"hibp"`
+}
+
+// HIBPConfig turns on the Have I Been Pwned range lookup, only the first
+// five characters of the password's SHA-1 leave the server.
+type HIBPConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	URL     string        `yaml:"url" env-default:"https://api.pwnedpasswords.com"`
+	Timeout time.Duration `yaml:"timeout" env-default:"2s"`
+}
+type PasswordHashConfig struct {
+	// Algorithm is the one new hashes are made with, "argon2id" or
+	// "bcrypt". Hashes of the other one, or with other costs, are
+	// replaced on the next login.
+	Algorithm  string       `yaml:"algorithm" env-default:"argon2id"`
+	BcryptCost int          `yaml:"bcrypt_cost" env-default:"10"`
+	Argon2     Argon2Config `yaml:"argon2"`
+	// Pepper is a secret mixed into argon2id hashes, PepperFile reads it
+	// from a file instead. Hashes made with it can't be checked without
+	// it.
+	Pepper     string `yaml:"pepper" env:"PASSWORD_PEPPER"`
+	PepperFile string `yaml:"pepper_file" env:"PASSWORD_PEPPER_FILE"`
+}
+type Argon2Config struct {
+	// Memory is in KiB.
+	Memory      uint32 `yaml:"memory" env-default:"19456"`
+	Iterations  uint32 `yaml:"iterations" env-default:"2"`
+	Parallelism uint8  `yaml:"parallelism" env-default:"1"`
+	SaltLength  uint32 `yaml:"salt_length" env-default:"16"`
+	KeyLength   uint32 `yaml:"key_length" env-default:"32"`
+}
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend is "memory" for a single instance, or "redis" to share the
+	// buckets between instances.
+	Backend string          `yaml:"backend" env-default:"memory"`
+	Redis   RedisConfig     `yaml:"redis"`
+	Rules   []RateLimitRule `yaml:"rules"`
+}
+type RedisConfig struct {
+	Addr     string `yaml:"addr" env-default:"localhost:6379"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db"`
+	// Prefix is put before every key, so the limiter can share a server.
+	Prefix string `yaml:"prefix" env-default:"sso:ratelimit:"`
+}
+
+// RateLimitRule allows Rate calls to Method every Per, in bursts of up to
+// Burst, per client told apart by Key: "ip", "app" or "user". Method is a
+// full gRPC method name, "*" for every method.
+type RateLimitRule struct {
+	Method string        `yaml:"method"`
+	Key    string        `yaml:"key"`
+	Rate   int           `yaml:"rate"`
+	Per    time.Duration `yaml:"per"`
+	Burst  int           `yaml:"burst"`
+}
+type MailConfig struct {
+	// Driver is "smtp", or "file" to write messages to FilePath instead of
+	// sending them.
+	Driver   string     `yaml:"driver" env-default:"file"`
+	From     string     `yaml:"from" env-default:"sso@localhost"`
+	FilePath string     `yaml:"file_path" env-default:"./storage/mail.log"`
+	SMTP     SMTPConfig `yaml:"smtp"`
+}
+type SMTPConfig struct {
+	Host     string `yaml:"host" env-default:"localhost"`
+	Port     int    `yaml:"port" env-default:"587"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD"`
+}
+type KeysConfig struct {
+	Algorithm       string        `yaml:"algorithm" env-default:"RS256"`
+	RotationPeriod  time.Duration `yaml:"rotation_period" env-default:"720h"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" env-default:"1m"`
+}
 
 func MustLoad() *Config {
 	path := fetchConfigPath()
@@ -1,7 +1,16 @@
 package models
 
 type App struct {
-	ID     int64
-	Name   string
-	Secret string
+	ID         int64
+	Name       string
+	Secret     string
+	SigningAlg string
+	// RedirectURIs are the only URIs OAuth authorization responses are sent to.
+	RedirectURIs []string
+	// Scopes the app may be granted in client credentials tokens and ask
+	// for in authorization requests, besides the OpenID Connect ones.
+	Scopes []string
+	// RequireVerifiedEmail refuses tokens to users that didn't confirm
+	// their email address.
+	RequireVerifiedEmail bool
 }
@@ -0,0 +1,96 @@
+package models
+
+import "time"
+
+const (
+	AuditAccountLocked            = "user.locked"
+	AuditAccountUnlocked          = "user.unlocked"
+	AuditAdminGranted             = "user.admin_granted"
+	AuditAdminRevoked             = "user.admin_revoked"
+	AuditEmailVerified            = "user.email_verified"
+	AuditLogin                    = "user.login"
+	AuditPasswordChanged          = "user.password_changed"
+	AuditPasswordReset            = "user.password_reset"
+	AuditRegistered               = "user.registered"
+	AuditRecoveryCodeUsed         = "mfa.recovery_code_used"
+	AuditRecoveryCodesRegenerated = "mfa.recovery_codes_regenerated"
+	AuditWebAuthnCredentialAdded  = "mfa.webauthn_credential_added"
+	AuditWebAuthnCloneDetected    = "mfa.webauthn_clone_detected"
+	AuditRoleCreated              = "role.created"
+	AuditRoleDeleted              = "role.deleted"
+	AuditPermissionGranted        = "role.permission_granted"
+	AuditPermissionRevoked        = "role.permission_revoked"
+	AuditRoleAssigned             = "role.assigned"
+	AuditRoleUnassigned           = "role.unassigned"
+	AuditGroupCreated             = "group.created"
+	AuditGroupDeleted             = "group.deleted"
+	AuditGroupMemberAdded         = "group.member_added"
+	AuditGroupMemberRemoved       = "group.member_removed"
+	AuditSubgroupAdded            = "group.subgroup_added"
+	AuditSubgroupRemoved          = "group.subgroup_removed"
+	AuditGroupRoleAssigned        = "group.role_assigned"
+	AuditGroupRoleUnassigned      = "group.role_unassigned"
+	AuditPolicyCreated            = "policy.created"
+	AuditPolicyUpdated            = "policy.updated"
+	AuditPolicyDeleted            = "policy.deleted"
+	AuditUserAttributeSet         = "user.attribute_set"
+	AuditTokenRevoked             = "token.revoked"
+	AuditTokenIssued              = "token.issued"
+	AuditTokenRefreshed           = "token.refreshed"
+)
+
+// Outcomes of an audited action.
+// AuditMFARequired is a login whose password was right but that waits
+// for the second factor, the login event of VerifyMFA finishes it.
+const (
+	AuditSuccess     = "success"
+	AuditFailure     = "failure"
+	AuditMFARequired = "mfa_required"
+)
+
+// AuditEvent is a security relevant action, kept for later review. UserID
+// is the user the event is about and ActorID the signed in user who caused
+// it, they differ for admin actions. IDs are zero when the event has no
+// such user or app. Hash chains the event to the one before it, whose hash
+// is PrevHash; events saved before chaining have neither.
+type AuditEvent struct {
+	ID        int64
+	Type      string
+	ActorID   int64
+	UserID    int64
+	AppID     int64
+	IP        string
+	UserAgent string
+	Outcome   string
+	Details   map[string]string
+	CreatedAt time.Time
+	PrevHash  string
+	Hash      string
+}
+
+// AuditCheckpoint is a signature over the hash the audit chain had reached
+// at event EventID. Signature is a JWS made with a server signing key,
+// PublicKey the JWK of that key, kept because retired keys are deleted.
+type AuditCheckpoint struct {
+	ID        int64
+	EventID   int64
+	Hash      string
+	Signature string
+	PublicKey []byte
+	CreatedAt time.Time
+}
+
+// AuditFilter selects audit events, zero fields match everything. Events
+// come newest first, BeforeID continues a listing after the last event of
+// the previous page.
+type AuditFilter struct {
+	ActorID  int64
+	UserID   int64
+	AppID    int64
+	Type     string
+	Outcome  string
+	Since    time.Time
+	Until    time.Time
+	BeforeID int64
+	Limit    int
+}
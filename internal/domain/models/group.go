@@ -0,0 +1,28 @@
+package models
+
+// Group of users. A group can be a subgroup of others, its members are
+// members of those too and inherit the roles granted to them.
+type Group struct {
+	ID          int64
+	Name        string
+	Description string
+}
+
+// GroupInfo is a group with its direct members, subgroups and roles.
+type GroupInfo struct {
+	Group
+	UserIDs     []int64
+	SubgroupIDs []int64
+	Roles       []RoleAssignment
+}
+
+// GrantPath is one way a user holds a permission: the role granting it,
+// held in AppID or in every app when AppID is zero, and the groups it's
+// inherited through, from the one the user is a member of up to the one
+// granted the role. Groups is empty for a role assigned to the user.
+type GrantPath struct {
+	RoleID int64
+	Role   string
+	AppID  int64
+	Groups []Group
+}
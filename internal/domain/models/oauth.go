@@ -0,0 +1,74 @@
+package models
+
+import "time"
+
+const (
+	ResponseTypeCode = "code"
+
+	CodeChallengeS256 = "S256"
+
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+	DeviceCodeUsed     = "used"
+)
+
+// AuthorizeRequest holds the parameters of an OAuth 2.0 authorization request.
+type AuthorizeRequest struct {
+	AppID               int64
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizationCode is an issued code as stored. Only the hash of the code
+// is kept, and it can be exchanged once.
+type AuthorizationCode struct {
+	CodeHash      string
+	AppID         int64
+	UserID        int64
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+	Used          bool
+}
+
+// DeviceCode is a pending RFC 8628 device authorization as stored. The
+// device code is kept hashed, the short user code as typed by the user.
+type DeviceCode struct {
+	DeviceCodeHash string
+	UserCode       string
+	AppID          int64
+	Scope          string
+	// UserID and AuthTime are set once the user approves.
+	UserID       int64
+	Status       string
+	Interval     time.Duration
+	LastPolledAt time.Time
+	AuthTime     time.Time
+	ExpiresAt    time.Time
+}
+
+// DeviceAuthorization is the response of the device authorization endpoint.
+type DeviceAuthorization struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               time.Duration
+	Interval                time.Duration
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Effects of an access policy.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Policy is an attribute-based access rule of an app. It applies to the
+// Actions it names, "*" for all or "documents:*" for a prefix, when its
+// Condition holds; an empty condition always holds. A DryRun policy is
+// evaluated and reported but doesn't change decisions.
+type Policy struct {
+	ID          int64
+	AppID       int64
+	Name        string
+	Description string
+	Effect      string
+	Actions     []string
+	Condition   string
+	DryRun      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// PolicyResult is how a policy that applies to the action evaluated.
+// Unmet is the part of the condition that didn't hold, Error why the
+// condition couldn't be evaluated.
+type PolicyResult struct {
+	PolicyID int64
+	Name     string
+	Effect   string
+	DryRun   bool
+	Matched  bool
+	Unmet    string
+	Error    string
+}
+
+// Decision of an authorization request. A matching deny policy wins over
+// any allow, and without a matching allow policy the request is denied.
+// DryRunAllowed is the decision had the dry-run policies been enforced.
+type Decision struct {
+	Allowed       bool
+	Reason        string
+	PolicyID      int64
+	DryRunAllowed bool
+	Results       []PolicyResult
+}
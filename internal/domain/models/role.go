@@ -0,0 +1,45 @@
+package models
+
+// RoleAdmin is the built-in role of the service's administrators, it holds
+// the permissions the service itself checks.
+const RoleAdmin = "admin"
+
+// Permissions checked by the service itself. Apps define their own.
+const (
+	PermissionAuditRead      = "audit:read"
+	PermissionRolesManage    = "roles:manage"
+	PermissionUsersUnlock    = "users:unlock"
+	PermissionPoliciesManage = "policies:manage"
+)
+
+// AdminPermissions are the permissions the admin role always holds.
+var AdminPermissions = []string{
+	PermissionAuditRead,
+	PermissionRolesManage,
+	PermissionUsersUnlock,
+	PermissionPoliciesManage,
+}
+
+// Role is a named set of permissions. Roles are shared by all apps, an
+// assignment says in which app a user holds one.
+type Role struct {
+	ID          int64
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// RoleAssignment is a role a user holds in AppID, or in every app when
+// AppID is zero.
+type RoleAssignment struct {
+	RoleID int64
+	Role   string
+	AppID  int64
+}
+
+// Grants are the roles a user holds in an app and the permissions they
+// give, each listed once.
+type Grants struct {
+	Roles       []string
+	Permissions []string
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+const (
+	SigningKeyNext    = "next"
+	SigningKeyActive  = "active"
+	SigningKeyRetired = "retired"
+)
+
+// SigningKey is a server signing key as stored. PrivateKey holds the
+// encrypted PKCS#8 encoding, never the raw key.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  []byte
+	Status      string
+	CreatedAt   time.Time
+	ActivatedAt time.Time
+	ExpiresAt   time.Time
+}
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	// IDToken is only set for OpenID Connect requests.
+	IDToken   string
+	ExpiresIn time.Duration
+}
+
+type RefreshToken struct {
+	ID        int64
+	TokenHash string
+	UserID    int64
+	AppID     int64
+	FamilyID  string
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+// TokenClaims are the verified claims of an access token. Service tokens
+// from the client credentials grant have no user, UserID is zero and
+// ClientID names the app instead.
+type TokenClaims struct {
+	ID        string
+	SessionID string
+	Issuer    string
+	UserID    int64
+	Email     string
+	// EmailVerified is the email_verified claim, false for service tokens.
+	EmailVerified bool
+	AppID         int64
+	Service       bool
+	ClientID      int64
+	Scope         string
+	// Roles and Permissions the user held in the app when the token was
+	// issued.
+	Roles       []string
+	Permissions []string
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+}
+
+// PasswordReset is a forgot-password token sent to the user's email. Only
+// its hash is stored, it's single-use and dies with any login or password
+// change of the user.
+type PasswordReset struct {
+	TokenHash string
+	UserID    int64
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// EmailVerification is a token sent to a new user's email, proving the
+// address is theirs. Only its hash is stored.
+type EmailVerification struct {
+	TokenHash string
+	UserID    int64
+	ExpiresAt time.Time
+	Used      bool
+}
@@ -1,7 +1,124 @@
 package models
 
+import "time"
+
 type User struct {
 	ID       int64
 	Email    string
 	PassHash []byte
+	// EmailVerified is set once the user opened the verification email.
+	EmailVerified bool
+	// TokensRevokedAt invalidates every access token issued up to this moment.
+	TokensRevokedAt time.Time
+	// TokensRevokedExcept is the session that was kept when the others were
+	// revoked, as on a password change.
+	TokensRevokedExcept string
+	// TOTPSecret is encrypted at rest. It is set at enrolment, but only
+	// asked for at login once TOTPEnabled is confirmed.
+	TOTPSecret  []byte
+	TOTPEnabled bool
+	// TOTPLastStep is the time step of the last accepted code, codes are
+	// never accepted twice.
+	TOTPLastStep int64
+	// WebAuthnEnabled is set when the user registered a passkey or a
+	// security key.
+	WebAuthnEnabled bool
+	// FailedLogins counts wrong passwords since the last successful login.
+	FailedLogins int
+	// LoginBlockedUntil refuses password logins until this moment, set
+	// after too many wrong passwords.
+	LoginBlockedUntil time.Time
+}
+
+const (
+	MFAMethodTOTP     = "totp"
+	MFAMethodWebAuthn = "webauthn"
+)
+
+// MFAEnabled reports whether logging in with a password needs a second
+// factor.
+func (u User) MFAEnabled() bool {
+	return u.TOTPEnabled || u.WebAuthnEnabled
+}
+
+// MFAMethods lists the second factors the user can finish a login with.
+func (u User) MFAMethods() []string {
+	var methods []string
+	if u.TOTPEnabled {
+		methods = append(methods, MFAMethodTOTP)
+	}
+	if u.WebAuthnEnabled {
+		methods = append(methods, MFAMethodWebAuthn)
+	}
+	return methods
+}
+
+// MFAChallenge is the state between the password step of a login and its
+// second factor. Only the hash of the challenge token is stored.
+type MFAChallenge struct {
+	TokenHash string
+	UserID    int64
+	AppID     int64
+	Attempts  int
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// RecoveryCode is a single-use fallback for the second factor, hashed like
+// a password. LookupID is the start of the code in plain, so a login only
+// verifies the hash it may match; codes from before it was stored have none.
+type RecoveryCode struct {
+	ID       int64
+	UserID   int64
+	LookupID string
+	CodeHash []byte
+}
+
+// TOTPEnrollment is what the user needs to add the account to an
+// authenticator app.
+type TOTPEnrollment struct {
+	Secret          string
+	ProvisioningURI string
+}
+
+// LoginResult holds the tokens of a login, or for users with a second factor
+// the MFA token the login continues with and the factors it accepts.
+type LoginResult struct {
+	Tokens     TokenPair
+	MFAToken   string
+	MFAMethods []string
+}
+
+const (
+	PasswordlessLink = "link"
+	PasswordlessCode = "code"
+)
+
+// PasswordlessLogin is a login waiting for the user to open the emailed
+// link or type the emailed code. A link login is stored under the hash of
+// the link's token. A code login is stored under the hash of the token
+// returned to the app that started it, and only finishes with the code.
+type PasswordlessLogin struct {
+	TokenHash string
+	UserID    int64
+	AppID     int64
+	Method    string
+	CodeHash  string
+	Attempts  int
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// IPLoginFailures counts the failed logins from one address, the count
+// starts over after WindowEndsAt.
+type IPLoginFailures struct {
+	IP           string
+	Failures     int
+	WindowEndsAt time.Time
+}
+
+// PasswordViolation is a password policy rule a new password breaks.
+type PasswordViolation struct {
+	Rule        string
+	Description string
 }
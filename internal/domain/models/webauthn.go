@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+const (
+	WebAuthnRegistration = "registration"
+	WebAuthnLogin        = "login"
+)
+
+// WebAuthnCredential is a registered passkey or security key. Only the
+// public key is known to us.
+type WebAuthnCredential struct {
+	ID              int64
+	UserID          int64
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	// SignCount is the last counter the authenticator reported. A counter
+	// that doesn't grow means the credential was cloned.
+	SignCount      uint32
+	Transports     []string
+	BackupEligible bool
+	BackupState    bool
+	CreatedAt      time.Time
+	LastUsedAt     time.Time
+}
+
+// WebAuthnCeremony is a started registration or login, waiting for the
+// authenticator's response. Only the hash of its token is stored, Session
+// is the challenge state the response is checked against.
+type WebAuthnCeremony struct {
+	TokenHash string
+	Kind      string
+	// UserID is unset for a passwordless login, the passkey tells who it is.
+	UserID int64
+	AppID  int64
+	// MFATokenHash is set when the login is the second factor of a
+	// password login.
+	MFATokenHash string
+	Session      []byte
+	ExpiresAt    time.Time
+	Used         bool
+}
+
+// WebAuthnChallenge is what the client passes to navigator.credentials,
+// and the token it sends the result back with.
+type WebAuthnChallenge struct {
+	Token string
+	// Options is the JSON of PublicKeyCredentialCreationOptions or
+	// PublicKeyCredentialRequestOptions.
+	Options []byte
+}
@@ -4,35 +4,275 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	"sso/internal/domain/models"
+	"sso/internal/jwt"
 	"sso/internal/storage"
 
 	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
 	"github.com/go-playground/validator/v10"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 type RequestValidateLogin struct {
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8"`
+	Password string `json:"password" validate:"required"`
 	AppID    int64  `json:"app_id" validate:"required,gt=0"`
 }
 type RequestValidateRegister struct {
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8"`
+	Password string `json:"password" validate:"required"`
+}
+type RequestValidateChangePassword struct {
+	Token           string `json:"token" validate:"required"`
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+type RequestValidateRequestPasswordReset struct {
+	Email string `json:"email" validate:"required,email"`
+}
+type RequestValidateResetPassword struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+type RequestValidateVerifyEmail struct {
+	Token string `json:"token" validate:"required"`
+}
+type RequestValidateResendVerification struct {
+	Email string `json:"email" validate:"required,email"`
+}
+type RequestValidateStartPasswordlessLogin struct {
+	Email  string `json:"email" validate:"required,email"`
+	AppID  int64  `json:"app_id" validate:"required,gt=0"`
+	Method string `json:"method" validate:"required,oneof=link code"`
+}
+type RequestValidateCompletePasswordlessLogin struct {
+	Token string `json:"token" validate:"required"`
+	Code  string `json:"code" validate:"omitempty,len=6,numeric"`
+	AppID int64  `json:"app_id" validate:"required,gt=0"`
 }
 type RequestValidateIsAdmin struct {
 	UserID int64 `json:"user_id" validate:"required,gt=0"`
 }
+type RequestValidateUnlockAccount struct {
+	Token  string `json:"token" validate:"required"`
+	UserID int64  `json:"user_id" validate:"required,gt=0"`
+}
+type RequestValidateListAuditEvents struct {
+	Token     string `json:"token" validate:"required"`
+	UserID    int64  `json:"user_id" validate:"gte=0"`
+	ActorID   int64  `json:"actor_id" validate:"gte=0"`
+	AppID     int64  `json:"app_id" validate:"gte=0"`
+	Outcome   string `json:"outcome" validate:"omitempty,oneof=success failure mfa_required"`
+	PageSize  int32  `json:"page_size" validate:"gte=0"`
+	PageToken string `json:"page_token" validate:"omitempty,numeric"`
+}
+type RequestValidateSetAdmin struct {
+	Token  string `json:"token" validate:"required"`
+	UserID int64  `json:"user_id" validate:"required,gt=0"`
+}
+type RequestValidateCreateRole struct {
+	Token       string   `json:"token" validate:"required"`
+	Name        string   `json:"name" validate:"required"`
+	Permissions []string `json:"permissions" validate:"dive,required"`
+}
+type RequestValidateRole struct {
+	Token  string `json:"token" validate:"required"`
+	RoleID int64  `json:"role_id" validate:"required,gt=0"`
+}
+type RequestValidatePermission struct {
+	Token      string `json:"token" validate:"required"`
+	RoleID     int64  `json:"role_id" validate:"required,gt=0"`
+	Permission string `json:"permission" validate:"required"`
+}
+type RequestValidateAssignRole struct {
+	Token  string `json:"token" validate:"required"`
+	UserID int64  `json:"user_id" validate:"required,gt=0"`
+	RoleID int64  `json:"role_id" validate:"required,gt=0"`
+	AppID  int64  `json:"app_id" validate:"gte=0"`
+}
+type RequestValidateListUserRoles struct {
+	Token  string `json:"token" validate:"required"`
+	UserID int64  `json:"user_id" validate:"required,gt=0"`
+}
+type RequestValidateHasPermission struct {
+	UserID     int64  `json:"user_id" validate:"required,gt=0"`
+	AppID      int64  `json:"app_id" validate:"gte=0"`
+	Permission string `json:"permission" validate:"required"`
+}
+type RequestValidateCreateGroup struct {
+	Token string `json:"token" validate:"required"`
+	Name  string `json:"name" validate:"required"`
+}
+type RequestValidateGroup struct {
+	Token   string `json:"token" validate:"required"`
+	GroupID int64  `json:"group_id" validate:"required,gt=0"`
+}
+type RequestValidateGroupMember struct {
+	Token   string `json:"token" validate:"required"`
+	GroupID int64  `json:"group_id" validate:"required,gt=0"`
+	UserID  int64  `json:"user_id" validate:"required,gt=0"`
+}
+type RequestValidateSubgroup struct {
+	Token      string `json:"token" validate:"required"`
+	GroupID    int64  `json:"group_id" validate:"required,gt=0"`
+	SubgroupID int64  `json:"subgroup_id" validate:"required,gt=0"`
+}
+type RequestValidateGroupRole struct {
+	Token   string `json:"token" validate:"required"`
+	GroupID int64  `json:"group_id" validate:"required,gt=0"`
+	RoleID  int64  `json:"role_id" validate:"required,gt=0"`
+	AppID   int64  `json:"app_id" validate:"gte=0"`
+}
+type RequestValidateExplainPermission struct {
+	Token      string `json:"token" validate:"required"`
+	UserID     int64  `json:"user_id" validate:"required,gt=0"`
+	AppID      int64  `json:"app_id" validate:"gte=0"`
+	Permission string `json:"permission" validate:"required"`
+}
+type RequestValidateCreatePolicy struct {
+	Token   string   `json:"token" validate:"required"`
+	AppID   int64    `json:"app_id" validate:"required,gt=0"`
+	Name    string   `json:"name" validate:"required"`
+	Effect  string   `json:"effect" validate:"required"`
+	Actions []string `json:"actions" validate:"required"`
+}
+type RequestValidateUpdatePolicy struct {
+	Token    string   `json:"token" validate:"required"`
+	PolicyID int64    `json:"policy_id" validate:"required,gt=0"`
+	Name     string   `json:"name" validate:"required"`
+	Effect   string   `json:"effect" validate:"required"`
+	Actions  []string `json:"actions" validate:"required"`
+}
+type RequestValidateDeletePolicy struct {
+	Token    string `json:"token" validate:"required"`
+	PolicyID int64  `json:"policy_id" validate:"required,gt=0"`
+}
+type RequestValidateListPolicies struct {
+	Token string `json:"token" validate:"required"`
+	AppID int64  `json:"app_id" validate:"required,gt=0"`
+}
+type RequestValidateSetUserAttribute struct {
+	Token  string `json:"token" validate:"required"`
+	UserID int64  `json:"user_id" validate:"required,gt=0"`
+	Name   string `json:"name" validate:"required"`
+	Value  string `json:"value" validate:"omitempty,json"`
+}
+type RequestValidateAuthorize struct {
+	UserID   int64  `json:"user_id" validate:"required,gt=0"`
+	AppID    int64  `json:"app_id" validate:"required,gt=0"`
+	Action   string `json:"action" validate:"required"`
+	Resource string `json:"resource" validate:"omitempty,json"`
+	Context  string `json:"context" validate:"omitempty,json"`
+}
+type RequestValidateRefresh struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+type RequestValidateLogout struct {
+	Token string `json:"token" validate:"required"`
+}
+type RequestValidateVerifyMFA struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+type RequestValidateEnrollTOTP struct {
+	Token string `json:"token" validate:"required"`
+}
+type RequestValidateConfirmTOTP struct {
+	Token string `json:"token" validate:"required"`
+	Code  string `json:"code" validate:"required,len=6,numeric"`
+}
+type RequestValidateRegenerateRecoveryCodes struct {
+	Token string `json:"token" validate:"required"`
+}
+type RequestValidateBeginWebAuthnRegistration struct {
+	Token string `json:"token" validate:"required"`
+}
+type RequestValidateFinishWebAuthnRegistration struct {
+	Token         string `json:"token" validate:"required"`
+	CeremonyToken string `json:"ceremony_token" validate:"required"`
+	Credential    string `json:"credential" validate:"required,json"`
+}
+type RequestValidateBeginWebAuthnLogin struct {
+	AppID    int64  `json:"app_id" validate:"required_without=MFAToken,gte=0"`
+	MFAToken string `json:"mfa_token"`
+}
+type RequestValidateFinishWebAuthnLogin struct {
+	CeremonyToken string `json:"ceremony_token" validate:"required"`
+	Credential    string `json:"credential" validate:"required,json"`
+}
+type RequestValidateClientCredentials struct {
+	AppID        int64  `json:"app_id" validate:"required,gt=0"`
+	ClientSecret string `json:"client_secret" validate:"required"`
+}
+type RequestValidateToken struct {
+	Token string `json:"token" validate:"required"`
+	AppID int64  `json:"app_id" validate:"gte=0"`
+}
 
 type Auth interface {
-	Login(ctx context.Context, email string, password string, appID int64) (token string, error error)
+	Login(ctx context.Context, email string, password string, appID int64) (result models.LoginResult, error error)
+	VerifyMFA(ctx context.Context, mfaToken string, code string) (tokens models.TokenPair, error error)
+	EnrollTOTP(ctx context.Context, token string) (enrollment models.TOTPEnrollment, error error)
+	ConfirmTOTP(ctx context.Context, token string, code string) (recoveryCodes []string, error error)
+	RegenerateRecoveryCodes(ctx context.Context, token string) (recoveryCodes []string, error error)
+	BeginWebAuthnRegistration(ctx context.Context, token string) (challenge models.WebAuthnChallenge, error error)
+	FinishWebAuthnRegistration(ctx context.Context, token string, ceremonyToken string, credential []byte) (recoveryCodes []string, error error)
+	BeginWebAuthnLogin(ctx context.Context, appID int64, mfaToken string) (challenge models.WebAuthnChallenge, error error)
+	FinishWebAuthnLogin(ctx context.Context, ceremonyToken string, credential []byte) (tokens models.TokenPair, error error)
+	Refresh(ctx context.Context, refreshToken string) (tokens models.TokenPair, error error)
 	SaveUser(ctx context.Context, email string, password string) (userID int64, error error)
+	ChangePassword(ctx context.Context, token string, currentPassword string, newPassword string) (error error)
+	RequestPasswordReset(ctx context.Context, email string)
+	ResetPassword(ctx context.Context, token string, newPassword string) (error error)
+	VerifyEmail(ctx context.Context, token string) (error error)
+	ResendVerification(ctx context.Context, email string)
+	StartPasswordlessLogin(ctx context.Context, email string, appID int64, method string) (loginToken string, error error)
+	CompletePasswordlessLogin(ctx context.Context, token string, code string, appID int64) (result models.LoginResult, error error)
 	IsAdmin(ctx context.Context, userID int64) (isAdmin bool, error error)
+	UnlockAccount(ctx context.Context, token string, userID int64) (error error)
+	ListAuditEvents(ctx context.Context, token string, filter models.AuditFilter) (events []models.AuditEvent, next int64, error error)
+	SetAdmin(ctx context.Context, token string, userID int64, isAdmin bool) (error error)
+	CreateRole(ctx context.Context, token string, role models.Role) (roleID int64, error error)
+	DeleteRole(ctx context.Context, token string, roleID int64) (error error)
+	ListRoles(ctx context.Context, token string) (roles []models.Role, error error)
+	GrantPermission(ctx context.Context, token string, roleID int64, permission string, grant bool) (error error)
+	AssignRole(ctx context.Context, token string, userID int64, roleID int64, appID int64, assign bool) (error error)
+	UserRoles(ctx context.Context, token string, userID int64) (roles []models.RoleAssignment, error error)
+	HasPermission(ctx context.Context, userID int64, appID int64, permission string) (allowed bool, error error)
+	CreateGroup(ctx context.Context, token string, group models.Group) (groupID int64, error error)
+	DeleteGroup(ctx context.Context, token string, groupID int64) (error error)
+	ListGroups(ctx context.Context, token string) (groups []models.Group, error error)
+	Group(ctx context.Context, token string, groupID int64) (group models.GroupInfo, error error)
+	SetGroupMember(ctx context.Context, token string, groupID int64, userID int64, member bool) (error error)
+	SetSubgroup(ctx context.Context, token string, parentID int64, childID int64, member bool) (error error)
+	AssignGroupRole(ctx context.Context, token string, groupID int64, roleID int64, appID int64, assign bool) (error error)
+	ExplainPermission(ctx context.Context, token string, userID int64, appID int64, permission string) (paths []models.GrantPath, error error)
+	CreatePolicy(ctx context.Context, token string, policy models.Policy) (policyID int64, error error)
+	UpdatePolicy(ctx context.Context, token string, policy models.Policy) (error error)
+	DeletePolicy(ctx context.Context, token string, policyID int64) (error error)
+	ListPolicies(ctx context.Context, token string, appID int64) (policies []models.Policy, error error)
+	SetUserAttribute(ctx context.Context, token string, userID int64, name string, value string) (error error)
+	AuthorizeAction(ctx context.Context, userID int64, appID int64, action string, resource string, requestContext string) (decision models.Decision, error error)
+	JWKS(ctx context.Context) (jwks jwt.JWKSet, error error)
+	ValidateToken(ctx context.Context, token string, appID int64) (claims models.TokenClaims, error error)
+	Logout(ctx context.Context, token string, allSessions bool) (error error)
+	ClientCredentials(ctx context.Context, appID int64, clientSecret string, scope string) (tokens models.TokenPair, grantedScope string, error error)
 }
+
+// token types reported by ValidateToken
+const (
+	tokenTypeUser    = "user"
+	tokenTypeService = "service"
+)
+
 type ServerAPI struct {
 	ssov1.UnimplementedAuthServer // реалізує методи Register, Login, IsAdmin, вони returns Unimplemented тобто нереалізований ssov1.UnimplementedAuthServer корисний тим шо при додаванні не треба тут дописувати
 	auth                          Auth
@@ -57,8 +297,6 @@ func (s *ServerAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.
 					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
 				case "email":
 					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is not valid", valErr.Field()))
-				case "min":
-					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be at least %s characters long", valErr.Field(), valErr.Param()))
 				case "gt":
 					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
 				}
@@ -67,85 +305,1985 @@ func (s *ServerAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.
 		}
 	}
 
-	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), req.GetAppId())
+	result, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), req.GetAppId())
 	if err != nil {
+		var blocked *storage.LoginBlockedError
+		if errors.As(err, &blocked) {
+			return nil, loginBlockedError(blocked)
+		}
 		if errors.Is(err, storage.ErrInvalidCredentials) {
 			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
 		}
+		if errors.Is(err, storage.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
 
 		return nil, status.Errorf(codes.Internal, "internal server error")
 	}
 
+	if result.MFAToken != "" {
+		return &ssov1.LoginResponse{
+			MfaRequired: true,
+			MfaToken:    result.MFAToken,
+			MfaMethods:  result.MFAMethods,
+		}, nil
+	}
+
 	return &ssov1.LoginResponse{
-		Token: token,
+		Token:        result.Tokens.AccessToken,
+		RefreshToken: result.Tokens.RefreshToken,
 	}, nil
 }
 
-func (s *ServerAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*ssov1.RegisterResponse, error) {
-	reqValidRegister := &RequestValidateRegister{
-		Email:    req.GetEmail(),
-		Password: req.GetPassword(),
+func (s *ServerAPI) VerifyMFA(ctx context.Context, req *ssov1.VerifyMFARequest) (*ssov1.VerifyMFAResponse, error) {
+	reqValidVerifyMFA := &RequestValidateVerifyMFA{
+		MFAToken: req.GetMfaToken(),
+		Code:     req.GetCode(),
 	}
 
 	var errorsMsgs []string
-	if err := validator.New().Struct(reqValidRegister); err != nil {
+	if err := validator.New().Struct(reqValidVerifyMFA); err != nil {
 		if validationErrors, ok := err.(validator.ValidationErrors); ok {
 			for _, valErr := range validationErrors {
 				switch valErr.ActualTag() {
 				case "required":
 					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
-				case "email":
-					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is not valid", valErr.Field()))
-				case "min":
-					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be at least %s characters long", valErr.Field(), valErr.Param()))
 				}
 			}
 			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
 		}
 	}
 
-	userID, err := s.auth.SaveUser(ctx, req.GetEmail(), req.GetPassword())
+	tokens, err := s.auth.VerifyMFA(ctx, req.GetMfaToken(), req.GetCode())
 	if err != nil {
-		if errors.Is(err, storage.ErrUserExists) {
-			return nil, status.Error(codes.AlreadyExists, "user already exists")
+		var blocked *storage.LoginBlockedError
+		if errors.As(err, &blocked) {
+			return nil, loginBlockedError(blocked)
+		}
+		if errors.Is(err, storage.ErrInvalidMFAToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid mfa token")
+		}
+		if errors.Is(err, storage.ErrInvalidMFACode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid mfa code")
+		}
+		if errors.Is(err, storage.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
 		}
 		return nil, status.Error(codes.Internal, "internal server error")
 	}
 
-	return &ssov1.RegisterResponse{
-		UserId: userID,
+	return &ssov1.VerifyMFAResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 	}, nil
 }
 
-func (s *ServerAPI) IsAdmin(ctx context.Context, req *ssov1.IsAdminRequest) (*ssov1.IsAdminResponse, error) {
-	reqValidIsAdmin := &RequestValidateIsAdmin{
-		UserID: req.GetUserId(),
+func (s *ServerAPI) EnrollTOTP(ctx context.Context, req *ssov1.EnrollTOTPRequest) (*ssov1.EnrollTOTPResponse, error) {
+	reqValidEnrollTOTP := &RequestValidateEnrollTOTP{
+		Token: req.GetToken(),
 	}
 
 	var errorsMsgs []string
-	if err := validator.New().Struct(reqValidIsAdmin); err != nil {
+	if err := validator.New().Struct(reqValidEnrollTOTP); err != nil {
 		if validationErrors, ok := err.(validator.ValidationErrors); ok {
 			for _, valErr := range validationErrors {
 				switch valErr.ActualTag() {
 				case "required":
 					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
-				case "gt":
-					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
 				}
 			}
 			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
 		}
 	}
 
-	isAdmin, err := s.auth.IsAdmin(ctx, req.GetUserId())
+	enrollment, err := s.auth.EnrollTOTP(ctx, req.GetToken())
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrMFAAlreadyEnabled) {
+			return nil, status.Error(codes.FailedPrecondition, "mfa already enabled")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.EnrollTOTPResponse{
+		Secret:          enrollment.Secret,
+		ProvisioningUri: enrollment.ProvisioningURI,
+	}, nil
+}
+
+func (s *ServerAPI) ConfirmTOTP(ctx context.Context, req *ssov1.ConfirmTOTPRequest) (*ssov1.ConfirmTOTPResponse, error) {
+	reqValidConfirmTOTP := &RequestValidateConfirmTOTP{
+		Token: req.GetToken(),
+		Code:  req.GetCode(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidConfirmTOTP); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "len":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be %s characters long", valErr.Field(), valErr.Param()))
+				case "numeric":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must contain only digits", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	recoveryCodes, err := s.auth.ConfirmTOTP(ctx, req.GetToken(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrInvalidMFACode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid mfa code")
+		}
+		if errors.Is(err, storage.ErrMFAAlreadyEnabled) {
+			return nil, status.Error(codes.FailedPrecondition, "mfa already enabled")
+		}
+		if errors.Is(err, storage.ErrMFANotEnrolled) {
+			return nil, status.Error(codes.FailedPrecondition, "mfa enrolment not started")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.ConfirmTOTPResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *ServerAPI) RegenerateRecoveryCodes(ctx context.Context, req *ssov1.RegenerateRecoveryCodesRequest) (*ssov1.RegenerateRecoveryCodesResponse, error) {
+	reqValidRegenerate := &RequestValidateRegenerateRecoveryCodes{
+		Token: req.GetToken(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidRegenerate); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	recoveryCodes, err := s.auth.RegenerateRecoveryCodes(ctx, req.GetToken())
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrMFANotEnrolled) {
+			return nil, status.Error(codes.FailedPrecondition, "mfa is not enabled")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.RegenerateRecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *ServerAPI) BeginWebAuthnRegistration(ctx context.Context, req *ssov1.BeginWebAuthnRegistrationRequest) (*ssov1.BeginWebAuthnRegistrationResponse, error) {
+	reqValidBegin := &RequestValidateBeginWebAuthnRegistration{
+		Token: req.GetToken(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidBegin); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	challenge, err := s.auth.BeginWebAuthnRegistration(ctx, req.GetToken())
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.BeginWebAuthnRegistrationResponse{
+		CeremonyToken: challenge.Token,
+		Options:       string(challenge.Options),
+	}, nil
+}
+
+func (s *ServerAPI) FinishWebAuthnRegistration(ctx context.Context, req *ssov1.FinishWebAuthnRegistrationRequest) (*ssov1.FinishWebAuthnRegistrationResponse, error) {
+	reqValidFinish := &RequestValidateFinishWebAuthnRegistration{
+		Token:         req.GetToken(),
+		CeremonyToken: req.GetCeremonyToken(),
+		Credential:    req.GetCredential(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidFinish); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "json":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be valid JSON", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	recoveryCodes, err := s.auth.FinishWebAuthnRegistration(ctx, req.GetToken(), req.GetCeremonyToken(), []byte(req.GetCredential()))
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrInvalidCeremony) {
+			return nil, status.Error(codes.Unauthenticated, "invalid ceremony token")
+		}
+		if errors.Is(err, storage.ErrInvalidWebAuthn) {
+			return nil, status.Error(codes.InvalidArgument, "invalid webauthn credential")
+		}
+		if errors.Is(err, storage.ErrCredentialExists) {
+			return nil, status.Error(codes.AlreadyExists, "webauthn credential already registered")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.FinishWebAuthnRegistrationResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *ServerAPI) BeginWebAuthnLogin(ctx context.Context, req *ssov1.BeginWebAuthnLoginRequest) (*ssov1.BeginWebAuthnLoginResponse, error) {
+	reqValidBegin := &RequestValidateBeginWebAuthnLogin{
+		AppID:    req.GetAppId(),
+		MFAToken: req.GetMfaToken(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidBegin); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required_without":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required without %s", valErr.Field(), valErr.Param()))
+				case "gte":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must not be negative", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	challenge, err := s.auth.BeginWebAuthnLogin(ctx, req.GetAppId(), req.GetMfaToken())
 	if err != nil {
 		if errors.Is(err, storage.ErrAppNotFound) {
-			return nil, status.Error(codes.NotFound, "user is not exists")
+			return nil, status.Error(codes.NotFound, "app not found")
+		}
+		if errors.Is(err, storage.ErrInvalidMFAToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid mfa token")
+		}
+		if errors.Is(err, storage.ErrMFANotEnrolled) {
+			return nil, status.Error(codes.FailedPrecondition, "no webauthn credentials registered")
 		}
 		return nil, status.Error(codes.Internal, "internal server error")
 	}
 
-	return &ssov1.IsAdminResponse{
-		IsAdmin: isAdmin,
+	return &ssov1.BeginWebAuthnLoginResponse{
+		CeremonyToken: challenge.Token,
+		Options:       string(challenge.Options),
 	}, nil
 }
+
+func (s *ServerAPI) FinishWebAuthnLogin(ctx context.Context, req *ssov1.FinishWebAuthnLoginRequest) (*ssov1.FinishWebAuthnLoginResponse, error) {
+	reqValidFinish := &RequestValidateFinishWebAuthnLogin{
+		CeremonyToken: req.GetCeremonyToken(),
+		Credential:    req.GetCredential(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidFinish); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "json":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be valid JSON", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	tokens, err := s.auth.FinishWebAuthnLogin(ctx, req.GetCeremonyToken(), []byte(req.GetCredential()))
+	if err != nil {
+		var blocked *storage.LoginBlockedError
+		if errors.As(err, &blocked) {
+			return nil, loginBlockedError(blocked)
+		}
+		if errors.Is(err, storage.ErrInvalidCeremony) {
+			return nil, status.Error(codes.Unauthenticated, "invalid ceremony token")
+		}
+		if errors.Is(err, storage.ErrInvalidMFAToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid mfa token")
+		}
+		if errors.Is(err, storage.ErrInvalidWebAuthn) {
+			return nil, status.Error(codes.Unauthenticated, "invalid webauthn assertion")
+		}
+		if errors.Is(err, storage.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.FinishWebAuthnLoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+func (s *ServerAPI) Refresh(ctx context.Context, req *ssov1.RefreshRequest) (*ssov1.RefreshResponse, error) {
+	reqValidRefresh := &RequestValidateRefresh{
+		RefreshToken: req.GetRefreshToken(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidRefresh); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	tokens, err := s.auth.Refresh(ctx, req.GetRefreshToken())
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidRefreshToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+		if errors.Is(err, storage.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.RefreshResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+func (s *ServerAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*ssov1.RegisterResponse, error) {
+	reqValidRegister := &RequestValidateRegister{
+		Email:    req.GetEmail(),
+		Password: req.GetPassword(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidRegister); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "email":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is not valid", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	userID, err := s.auth.SaveUser(ctx, req.GetEmail(), req.GetPassword())
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "user already exists")
+		}
+		var weak *storage.WeakPasswordError
+		if errors.As(err, &weak) {
+			return nil, weakPasswordError(weak, "password")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.RegisterResponse{
+		UserId: userID,
+	}, nil
+}
+
+func (s *ServerAPI) ChangePassword(ctx context.Context, req *ssov1.ChangePasswordRequest) (*ssov1.ChangePasswordResponse, error) {
+	reqValidChangePassword := &RequestValidateChangePassword{
+		Token:           req.GetToken(),
+		CurrentPassword: req.GetCurrentPassword(),
+		NewPassword:     req.GetNewPassword(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidChangePassword); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.ChangePassword(ctx, req.GetToken(), req.GetCurrentPassword(), req.GetNewPassword()); err != nil {
+		var blocked *storage.LoginBlockedError
+		if errors.As(err, &blocked) {
+			return nil, loginBlockedError(blocked)
+		}
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		}
+		var weak *storage.WeakPasswordError
+		if errors.As(err, &weak) {
+			return nil, weakPasswordError(weak, "new_password")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.ChangePasswordResponse{}, nil
+}
+
+// RequestPasswordReset answers the same for registered and unknown emails.
+func (s *ServerAPI) RequestPasswordReset(ctx context.Context, req *ssov1.RequestPasswordResetRequest) (*ssov1.RequestPasswordResetResponse, error) {
+	reqValidRequestPasswordReset := &RequestValidateRequestPasswordReset{
+		Email: req.GetEmail(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidRequestPasswordReset); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "email":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is not valid", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	s.auth.RequestPasswordReset(ctx, req.GetEmail())
+
+	return &ssov1.RequestPasswordResetResponse{}, nil
+}
+
+func (s *ServerAPI) ResetPassword(ctx context.Context, req *ssov1.ResetPasswordRequest) (*ssov1.ResetPasswordResponse, error) {
+	reqValidResetPassword := &RequestValidateResetPassword{
+		Token:       req.GetToken(),
+		NewPassword: req.GetNewPassword(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidResetPassword); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.ResetPassword(ctx, req.GetToken(), req.GetNewPassword()); err != nil {
+		if errors.Is(err, storage.ErrInvalidResetToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired reset token")
+		}
+		var weak *storage.WeakPasswordError
+		if errors.As(err, &weak) {
+			return nil, weakPasswordError(weak, "new_password")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.ResetPasswordResponse{}, nil
+}
+
+func (s *ServerAPI) VerifyEmail(ctx context.Context, req *ssov1.VerifyEmailRequest) (*ssov1.VerifyEmailResponse, error) {
+	reqValidVerifyEmail := &RequestValidateVerifyEmail{
+		Token: req.GetToken(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidVerifyEmail); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.VerifyEmail(ctx, req.GetToken()); err != nil {
+		if errors.Is(err, storage.ErrInvalidVerification) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired verification token")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.VerifyEmailResponse{}, nil
+}
+
+// ResendVerification answers the same for registered, verified and unknown
+// emails, and when the email is throttled.
+func (s *ServerAPI) ResendVerification(ctx context.Context, req *ssov1.ResendVerificationRequest) (*ssov1.ResendVerificationResponse, error) {
+	reqValidResendVerification := &RequestValidateResendVerification{
+		Email: req.GetEmail(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidResendVerification); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "email":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is not valid", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	s.auth.ResendVerification(ctx, req.GetEmail())
+
+	return &ssov1.ResendVerificationResponse{}, nil
+}
+
+// StartPasswordlessLogin answers the same for registered and unknown
+// emails. For the code method the response carries the login token the code
+// is completed with.
+func (s *ServerAPI) StartPasswordlessLogin(ctx context.Context, req *ssov1.StartPasswordlessLoginRequest) (*ssov1.StartPasswordlessLoginResponse, error) {
+	reqValidStartPasswordlessLogin := &RequestValidateStartPasswordlessLogin{
+		Email:  req.GetEmail(),
+		AppID:  req.GetAppId(),
+		Method: req.GetMethod(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidStartPasswordlessLogin); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "email", "oneof":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is not valid", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	loginToken, err := s.auth.StartPasswordlessLogin(ctx, req.GetEmail(), req.GetAppId(), req.GetMethod())
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, status.Error(codes.NotFound, "app not found")
+		}
+		if errors.Is(err, storage.ErrInvalidRequest) {
+			return nil, status.Error(codes.InvalidArgument, "invalid method")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.StartPasswordlessLoginResponse{
+		LoginToken: loginToken,
+	}, nil
+}
+
+func (s *ServerAPI) CompletePasswordlessLogin(ctx context.Context, req *ssov1.CompletePasswordlessLoginRequest) (*ssov1.CompletePasswordlessLoginResponse, error) {
+	reqValidCompletePasswordlessLogin := &RequestValidateCompletePasswordlessLogin{
+		Token: req.GetToken(),
+		Code:  req.GetCode(),
+		AppID: req.GetAppId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidCompletePasswordlessLogin); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "len":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be %s characters long", valErr.Field(), valErr.Param()))
+				case "numeric":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must contain only digits", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	result, err := s.auth.CompletePasswordlessLogin(ctx, req.GetToken(), req.GetCode(), req.GetAppId())
+	if err != nil {
+		var blocked *storage.LoginBlockedError
+		if errors.As(err, &blocked) {
+			return nil, loginBlockedError(blocked)
+		}
+		if errors.Is(err, storage.ErrInvalidPasswordless) {
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired sign-in token")
+		}
+		if errors.Is(err, storage.ErrInvalidPasswordlessCode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid sign-in code")
+		}
+		if errors.Is(err, storage.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	if result.MFAToken != "" {
+		return &ssov1.CompletePasswordlessLoginResponse{
+			MfaRequired: true,
+			MfaToken:    result.MFAToken,
+			MfaMethods:  result.MFAMethods,
+		}, nil
+	}
+
+	return &ssov1.CompletePasswordlessLoginResponse{
+		Token:        result.Tokens.AccessToken,
+		RefreshToken: result.Tokens.RefreshToken,
+	}, nil
+}
+
+func (s *ServerAPI) IsAdmin(ctx context.Context, req *ssov1.IsAdminRequest) (*ssov1.IsAdminResponse, error) {
+	reqValidIsAdmin := &RequestValidateIsAdmin{
+		UserID: req.GetUserId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidIsAdmin); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	isAdmin, err := s.auth.IsAdmin(ctx, req.GetUserId())
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, status.Error(codes.NotFound, "user is not exists")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.IsAdminResponse{
+		IsAdmin: isAdmin,
+	}, nil
+}
+
+func (s *ServerAPI) UnlockAccount(ctx context.Context, req *ssov1.UnlockAccountRequest) (*ssov1.UnlockAccountResponse, error) {
+	reqValidUnlockAccount := &RequestValidateUnlockAccount{
+		Token:  req.GetToken(),
+		UserID: req.GetUserId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidUnlockAccount); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.UnlockAccount(ctx, req.GetToken(), req.GetUserId()); err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.UnlockAccountResponse{}, nil
+}
+
+func (s *ServerAPI) ListAuditEvents(ctx context.Context, req *ssov1.ListAuditEventsRequest) (*ssov1.ListAuditEventsResponse, error) {
+	reqValidListAuditEvents := &RequestValidateListAuditEvents{
+		Token:     req.GetToken(),
+		UserID:    req.GetUserId(),
+		ActorID:   req.GetActorId(),
+		AppID:     req.GetAppId(),
+		Outcome:   req.GetOutcome(),
+		PageSize:  req.GetPageSize(),
+		PageToken: req.GetPageToken(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidListAuditEvents); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gte":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must not be negative", valErr.Field()))
+				case "oneof":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be one of %s", valErr.Field(), valErr.Param()))
+				case "numeric":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is not valid", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	filter := models.AuditFilter{
+		ActorID: req.GetActorId(),
+		UserID:  req.GetUserId(),
+		AppID:   req.GetAppId(),
+		Type:    req.GetType(),
+		Outcome: req.GetOutcome(),
+		Limit:   int(req.GetPageSize()),
+	}
+	if req.GetSince() > 0 {
+		filter.Since = time.Unix(req.GetSince(), 0)
+	}
+	if req.GetUntil() > 0 {
+		filter.Until = time.Unix(req.GetUntil(), 0)
+	}
+	// the page token is the id the next page starts below
+	if req.GetPageToken() != "" {
+		beforeID, err := strconv.ParseInt(req.GetPageToken(), 10, 64)
+		if err != nil || beforeID <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "validation error: Field PageToken is not valid")
+		}
+		filter.BeforeID = beforeID
+	}
+
+	events, next, err := s.auth.ListAuditEvents(ctx, req.GetToken(), filter)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	resp := &ssov1.ListAuditEventsResponse{
+		Events: make([]*ssov1.AuditEvent, 0, len(events)),
+	}
+	for _, event := range events {
+		resp.Events = append(resp.Events, &ssov1.AuditEvent{
+			Id:        event.ID,
+			Type:      event.Type,
+			ActorId:   event.ActorID,
+			UserId:    event.UserID,
+			AppId:     event.AppID,
+			Ip:        event.IP,
+			UserAgent: event.UserAgent,
+			Outcome:   event.Outcome,
+			Details:   event.Details,
+			CreatedAt: event.CreatedAt.Unix(),
+			PrevHash:  event.PrevHash,
+			Hash:      event.Hash,
+		})
+	}
+	if next != 0 {
+		resp.NextPageToken = strconv.FormatInt(next, 10)
+	}
+
+	return resp, nil
+}
+
+func (s *ServerAPI) SetAdmin(ctx context.Context, req *ssov1.SetAdminRequest) (*ssov1.SetAdminResponse, error) {
+	reqValidSetAdmin := &RequestValidateSetAdmin{
+		Token:  req.GetToken(),
+		UserID: req.GetUserId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidSetAdmin); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.SetAdmin(ctx, req.GetToken(), req.GetUserId(), req.GetIsAdmin()); err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.SetAdminResponse{}, nil
+}
+
+func (s *ServerAPI) CreateRole(ctx context.Context, req *ssov1.CreateRoleRequest) (*ssov1.CreateRoleResponse, error) {
+	reqValidCreateRole := &RequestValidateCreateRole{
+		Token:       req.GetToken(),
+		Name:        req.GetName(),
+		Permissions: req.GetPermissions(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidCreateRole); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	roleID, err := s.auth.CreateRole(ctx, req.GetToken(), models.Role{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Permissions: req.GetPermissions(),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		if errors.Is(err, storage.ErrRoleExists) {
+			return nil, status.Error(codes.AlreadyExists, "role already exists")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.CreateRoleResponse{
+		RoleId: roleID,
+	}, nil
+}
+
+func (s *ServerAPI) DeleteRole(ctx context.Context, req *ssov1.DeleteRoleRequest) (*ssov1.DeleteRoleResponse, error) {
+	reqValidDeleteRole := &RequestValidateRole{
+		Token:  req.GetToken(),
+		RoleID: req.GetRoleId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidDeleteRole); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.DeleteRole(ctx, req.GetToken(), req.GetRoleId()); err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+		if errors.Is(err, storage.ErrRoleProtected) {
+			return nil, status.Error(codes.FailedPrecondition, "built-in role can't be changed")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.DeleteRoleResponse{}, nil
+}
+
+func (s *ServerAPI) ListRoles(ctx context.Context, req *ssov1.ListRolesRequest) (*ssov1.ListRolesResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "validation error: Field Token is required")
+	}
+
+	roles, err := s.auth.ListRoles(ctx, req.GetToken())
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	resp := &ssov1.ListRolesResponse{}
+	for _, role := range roles {
+		resp.Roles = append(resp.Roles, &ssov1.Role{
+			Id:          role.ID,
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *ServerAPI) GrantPermission(ctx context.Context, req *ssov1.GrantPermissionRequest) (*ssov1.GrantPermissionResponse, error) {
+	if err := s.changePermission(ctx, req.GetToken(), req.GetRoleId(), req.GetPermission(), true); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.GrantPermissionResponse{}, nil
+}
+
+func (s *ServerAPI) RevokePermission(ctx context.Context, req *ssov1.RevokePermissionRequest) (*ssov1.RevokePermissionResponse, error) {
+	if err := s.changePermission(ctx, req.GetToken(), req.GetRoleId(), req.GetPermission(), false); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.RevokePermissionResponse{}, nil
+}
+
+// changePermission serves GrantPermission and RevokePermission, their
+// requests only differ in name.
+func (s *ServerAPI) changePermission(ctx context.Context, token string, roleID int64, permission string, grant bool) error {
+	reqValidPermission := &RequestValidatePermission{
+		Token:      token,
+		RoleID:     roleID,
+		Permission: permission,
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidPermission); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.GrantPermission(ctx, token, roleID, permission, grant); err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return status.Error(codes.PermissionDenied, "permission denied")
+		}
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			return status.Error(codes.NotFound, "role not found")
+		}
+		if errors.Is(err, storage.ErrRoleProtected) {
+			return status.Error(codes.FailedPrecondition, "built-in role can't be changed")
+		}
+		return status.Error(codes.Internal, "internal server error")
+	}
+
+	return nil
+}
+
+func (s *ServerAPI) AssignRole(ctx context.Context, req *ssov1.AssignRoleRequest) (*ssov1.AssignRoleResponse, error) {
+	if err := s.changeRoleAssignment(ctx, req.GetToken(), req.GetUserId(), req.GetRoleId(), req.GetAppId(), true); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.AssignRoleResponse{}, nil
+}
+
+func (s *ServerAPI) UnassignRole(ctx context.Context, req *ssov1.UnassignRoleRequest) (*ssov1.UnassignRoleResponse, error) {
+	if err := s.changeRoleAssignment(ctx, req.GetToken(), req.GetUserId(), req.GetRoleId(), req.GetAppId(), false); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.UnassignRoleResponse{}, nil
+}
+
+// changeRoleAssignment serves AssignRole and UnassignRole, their requests
+// only differ in name.
+func (s *ServerAPI) changeRoleAssignment(ctx context.Context, token string, userID, roleID, appID int64, assign bool) error {
+	reqValidAssignRole := &RequestValidateAssignRole{
+		Token:  token,
+		UserID: userID,
+		RoleID: roleID,
+		AppID:  appID,
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidAssignRole); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				case "gte":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must not be negative", valErr.Field()))
+				}
+			}
+			return status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.AssignRole(ctx, token, userID, roleID, appID, assign); err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return status.Error(codes.PermissionDenied, "permission denied")
+		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return status.Error(codes.NotFound, "user not found")
+		}
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			return status.Error(codes.NotFound, "role not found")
+		}
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return status.Error(codes.NotFound, "app not found")
+		}
+		if errors.Is(err, storage.ErrRoleNotAssigned) {
+			return status.Error(codes.NotFound, "role not assigned")
+		}
+		return status.Error(codes.Internal, "internal server error")
+	}
+
+	return nil
+}
+
+func (s *ServerAPI) ListUserRoles(ctx context.Context, req *ssov1.ListUserRolesRequest) (*ssov1.ListUserRolesResponse, error) {
+	reqValidListUserRoles := &RequestValidateListUserRoles{
+		Token:  req.GetToken(),
+		UserID: req.GetUserId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidListUserRoles); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	roles, err := s.auth.UserRoles(ctx, req.GetToken(), req.GetUserId())
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	resp := &ssov1.ListUserRolesResponse{}
+	for _, role := range roles {
+		resp.Roles = append(resp.Roles, &ssov1.RoleAssignment{
+			RoleId: role.RoleID,
+			Name:   role.Role,
+			AppId:  role.AppID,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *ServerAPI) HasPermission(ctx context.Context, req *ssov1.HasPermissionRequest) (*ssov1.HasPermissionResponse, error) {
+	reqValidHasPermission := &RequestValidateHasPermission{
+		UserID:     req.GetUserId(),
+		AppID:      req.GetAppId(),
+		Permission: req.GetPermission(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidHasPermission); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				case "gte":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must not be negative", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	allowed, err := s.auth.HasPermission(ctx, req.GetUserId(), req.GetAppId(), req.GetPermission())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.HasPermissionResponse{
+		Allowed: allowed,
+	}, nil
+}
+
+// groupStatus maps the errors of group calls to gRPC statuses.
+func groupStatus(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrInvalidToken):
+		return status.Error(codes.Unauthenticated, "invalid token")
+	case errors.Is(err, storage.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, "permission denied")
+	case errors.Is(err, storage.ErrGroupExists):
+		return status.Error(codes.AlreadyExists, "group already exists")
+	case errors.Is(err, storage.ErrGroupCycle):
+		return status.Error(codes.FailedPrecondition, "group would contain itself")
+	case errors.Is(err, storage.ErrGroupNotFound):
+		return status.Error(codes.NotFound, "group not found")
+	case errors.Is(err, storage.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, storage.ErrRoleNotFound):
+		return status.Error(codes.NotFound, "role not found")
+	case errors.Is(err, storage.ErrAppNotFound):
+		return status.Error(codes.NotFound, "app not found")
+	case errors.Is(err, storage.ErrNotGroupMember):
+		return status.Error(codes.NotFound, "not a group member")
+	case errors.Is(err, storage.ErrRoleNotAssigned):
+		return status.Error(codes.NotFound, "role not assigned")
+	}
+	return status.Error(codes.Internal, "internal server error")
+}
+
+func toGroup(group models.Group) *ssov1.Group {
+	return &ssov1.Group{
+		Id:          group.ID,
+		Name:        group.Name,
+		Description: group.Description,
+	}
+}
+
+func (s *ServerAPI) CreateGroup(ctx context.Context, req *ssov1.CreateGroupRequest) (*ssov1.CreateGroupResponse, error) {
+	reqValidCreateGroup := &RequestValidateCreateGroup{
+		Token: req.GetToken(),
+		Name:  req.GetName(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidCreateGroup); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	groupID, err := s.auth.CreateGroup(ctx, req.GetToken(), models.Group{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+	})
+	if err != nil {
+		return nil, groupStatus(err)
+	}
+
+	return &ssov1.CreateGroupResponse{
+		GroupId: groupID,
+	}, nil
+}
+
+func (s *ServerAPI) DeleteGroup(ctx context.Context, req *ssov1.DeleteGroupRequest) (*ssov1.DeleteGroupResponse, error) {
+	reqValidDeleteGroup := &RequestValidateGroup{
+		Token:   req.GetToken(),
+		GroupID: req.GetGroupId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidDeleteGroup); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.DeleteGroup(ctx, req.GetToken(), req.GetGroupId()); err != nil {
+		return nil, groupStatus(err)
+	}
+
+	return &ssov1.DeleteGroupResponse{}, nil
+}
+
+func (s *ServerAPI) ListGroups(ctx context.Context, req *ssov1.ListGroupsRequest) (*ssov1.ListGroupsResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "validation error: Field Token is required")
+	}
+
+	groups, err := s.auth.ListGroups(ctx, req.GetToken())
+	if err != nil {
+		return nil, groupStatus(err)
+	}
+
+	resp := &ssov1.ListGroupsResponse{}
+	for _, group := range groups {
+		resp.Groups = append(resp.Groups, toGroup(group))
+	}
+
+	return resp, nil
+}
+
+func (s *ServerAPI) GetGroup(ctx context.Context, req *ssov1.GetGroupRequest) (*ssov1.GetGroupResponse, error) {
+	reqValidGetGroup := &RequestValidateGroup{
+		Token:   req.GetToken(),
+		GroupID: req.GetGroupId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidGetGroup); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	group, err := s.auth.Group(ctx, req.GetToken(), req.GetGroupId())
+	if err != nil {
+		return nil, groupStatus(err)
+	}
+
+	resp := &ssov1.GetGroupResponse{
+		Group:       toGroup(group.Group),
+		UserIds:     group.UserIDs,
+		SubgroupIds: group.SubgroupIDs,
+	}
+	for _, role := range group.Roles {
+		resp.Roles = append(resp.Roles, &ssov1.RoleAssignment{
+			RoleId: role.RoleID,
+			Name:   role.Role,
+			AppId:  role.AppID,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *ServerAPI) AddGroupMember(ctx context.Context, req *ssov1.AddGroupMemberRequest) (*ssov1.AddGroupMemberResponse, error) {
+	if err := s.changeGroupMember(ctx, req.GetToken(), req.GetGroupId(), req.GetUserId(), true); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.AddGroupMemberResponse{}, nil
+}
+
+func (s *ServerAPI) RemoveGroupMember(ctx context.Context, req *ssov1.RemoveGroupMemberRequest) (*ssov1.RemoveGroupMemberResponse, error) {
+	if err := s.changeGroupMember(ctx, req.GetToken(), req.GetGroupId(), req.GetUserId(), false); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.RemoveGroupMemberResponse{}, nil
+}
+
+func (s *ServerAPI) changeGroupMember(ctx context.Context, token string, groupID, userID int64, member bool) error {
+	reqValidGroupMember := &RequestValidateGroupMember{
+		Token:   token,
+		GroupID: groupID,
+		UserID:  userID,
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidGroupMember); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.SetGroupMember(ctx, token, groupID, userID, member); err != nil {
+		return groupStatus(err)
+	}
+
+	return nil
+}
+
+func (s *ServerAPI) AddSubgroup(ctx context.Context, req *ssov1.AddSubgroupRequest) (*ssov1.AddSubgroupResponse, error) {
+	if err := s.changeSubgroup(ctx, req.GetToken(), req.GetGroupId(), req.GetSubgroupId(), true); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.AddSubgroupResponse{}, nil
+}
+
+func (s *ServerAPI) RemoveSubgroup(ctx context.Context, req *ssov1.RemoveSubgroupRequest) (*ssov1.RemoveSubgroupResponse, error) {
+	if err := s.changeSubgroup(ctx, req.GetToken(), req.GetGroupId(), req.GetSubgroupId(), false); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.RemoveSubgroupResponse{}, nil
+}
+
+func (s *ServerAPI) changeSubgroup(ctx context.Context, token string, groupID, subgroupID int64, member bool) error {
+	reqValidSubgroup := &RequestValidateSubgroup{
+		Token:      token,
+		GroupID:    groupID,
+		SubgroupID: subgroupID,
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidSubgroup); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.SetSubgroup(ctx, token, groupID, subgroupID, member); err != nil {
+		return groupStatus(err)
+	}
+
+	return nil
+}
+
+func (s *ServerAPI) AssignGroupRole(ctx context.Context, req *ssov1.AssignGroupRoleRequest) (*ssov1.AssignGroupRoleResponse, error) {
+	if err := s.changeGroupRole(ctx, req.GetToken(), req.GetGroupId(), req.GetRoleId(), req.GetAppId(), true); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.AssignGroupRoleResponse{}, nil
+}
+
+func (s *ServerAPI) UnassignGroupRole(ctx context.Context, req *ssov1.UnassignGroupRoleRequest) (*ssov1.UnassignGroupRoleResponse, error) {
+	if err := s.changeGroupRole(ctx, req.GetToken(), req.GetGroupId(), req.GetRoleId(), req.GetAppId(), false); err != nil {
+		return nil, err
+	}
+
+	return &ssov1.UnassignGroupRoleResponse{}, nil
+}
+
+func (s *ServerAPI) changeGroupRole(ctx context.Context, token string, groupID, roleID, appID int64, assign bool) error {
+	reqValidGroupRole := &RequestValidateGroupRole{
+		Token:   token,
+		GroupID: groupID,
+		RoleID:  roleID,
+		AppID:   appID,
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidGroupRole); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				case "gte":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must not be negative", valErr.Field()))
+				}
+			}
+			return status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.AssignGroupRole(ctx, token, groupID, roleID, appID, assign); err != nil {
+		return groupStatus(err)
+	}
+
+	return nil
+}
+
+func (s *ServerAPI) ExplainPermission(ctx context.Context, req *ssov1.ExplainPermissionRequest) (*ssov1.ExplainPermissionResponse, error) {
+	reqValidExplainPermission := &RequestValidateExplainPermission{
+		Token:      req.GetToken(),
+		UserID:     req.GetUserId(),
+		AppID:      req.GetAppId(),
+		Permission: req.GetPermission(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidExplainPermission); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				case "gte":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must not be negative", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	paths, err := s.auth.ExplainPermission(ctx, req.GetToken(), req.GetUserId(), req.GetAppId(), req.GetPermission())
+	if err != nil {
+		return nil, groupStatus(err)
+	}
+
+	resp := &ssov1.ExplainPermissionResponse{
+		Allowed: len(paths) > 0,
+	}
+	for _, path := range paths {
+		grantPath := &ssov1.GrantPath{
+			RoleId: path.RoleID,
+			Role:   path.Role,
+			AppId:  path.AppID,
+		}
+		for _, group := range path.Groups {
+			grantPath.Groups = append(grantPath.Groups, toGroup(group))
+		}
+		resp.Paths = append(resp.Paths, grantPath)
+	}
+
+	return resp, nil
+}
+
+// policyStatus maps the errors of policy calls to gRPC statuses.
+func policyStatus(err error) error {
+	var invalid *storage.InvalidInputError
+	switch {
+	case errors.Is(err, storage.ErrInvalidToken):
+		return status.Error(codes.Unauthenticated, "invalid token")
+	case errors.Is(err, storage.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, "permission denied")
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, invalid.Error())
+	case errors.Is(err, storage.ErrPolicyExists):
+		return status.Error(codes.AlreadyExists, "policy already exists")
+	case errors.Is(err, storage.ErrPolicyNotFound):
+		return status.Error(codes.NotFound, "policy not found")
+	case errors.Is(err, storage.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, storage.ErrAppNotFound):
+		return status.Error(codes.NotFound, "app not found")
+	}
+	return status.Error(codes.Internal, "internal server error")
+}
+
+func toPolicy(policy models.Policy) *ssov1.Policy {
+	return &ssov1.Policy{
+		Id:          policy.ID,
+		AppId:       policy.AppID,
+		Name:        policy.Name,
+		Description: policy.Description,
+		Effect:      policy.Effect,
+		Actions:     policy.Actions,
+		Condition:   policy.Condition,
+		DryRun:      policy.DryRun,
+		CreatedAt:   policy.CreatedAt.Unix(),
+		UpdatedAt:   policy.UpdatedAt.Unix(),
+	}
+}
+
+func (s *ServerAPI) CreatePolicy(ctx context.Context, req *ssov1.CreatePolicyRequest) (*ssov1.CreatePolicyResponse, error) {
+	reqValidCreatePolicy := &RequestValidateCreatePolicy{
+		Token:   req.GetToken(),
+		AppID:   req.GetAppId(),
+		Name:    req.GetName(),
+		Effect:  req.GetEffect(),
+		Actions: req.GetActions(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidCreatePolicy); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	policyID, err := s.auth.CreatePolicy(ctx, req.GetToken(), models.Policy{
+		AppID:       req.GetAppId(),
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Effect:      req.GetEffect(),
+		Actions:     req.GetActions(),
+		Condition:   req.GetCondition(),
+		DryRun:      req.GetDryRun(),
+	})
+	if err != nil {
+		return nil, policyStatus(err)
+	}
+
+	return &ssov1.CreatePolicyResponse{
+		PolicyId: policyID,
+	}, nil
+}
+
+func (s *ServerAPI) UpdatePolicy(ctx context.Context, req *ssov1.UpdatePolicyRequest) (*ssov1.UpdatePolicyResponse, error) {
+	reqValidUpdatePolicy := &RequestValidateUpdatePolicy{
+		Token:    req.GetToken(),
+		PolicyID: req.GetPolicyId(),
+		Name:     req.GetName(),
+		Effect:   req.GetEffect(),
+		Actions:  req.GetActions(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidUpdatePolicy); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	err := s.auth.UpdatePolicy(ctx, req.GetToken(), models.Policy{
+		ID:          req.GetPolicyId(),
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Effect:      req.GetEffect(),
+		Actions:     req.GetActions(),
+		Condition:   req.GetCondition(),
+		DryRun:      req.GetDryRun(),
+	})
+	if err != nil {
+		return nil, policyStatus(err)
+	}
+
+	return &ssov1.UpdatePolicyResponse{}, nil
+}
+
+func (s *ServerAPI) DeletePolicy(ctx context.Context, req *ssov1.DeletePolicyRequest) (*ssov1.DeletePolicyResponse, error) {
+	reqValidDeletePolicy := &RequestValidateDeletePolicy{
+		Token:    req.GetToken(),
+		PolicyID: req.GetPolicyId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidDeletePolicy); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.DeletePolicy(ctx, req.GetToken(), req.GetPolicyId()); err != nil {
+		return nil, policyStatus(err)
+	}
+
+	return &ssov1.DeletePolicyResponse{}, nil
+}
+
+func (s *ServerAPI) ListPolicies(ctx context.Context, req *ssov1.ListPoliciesRequest) (*ssov1.ListPoliciesResponse, error) {
+	reqValidListPolicies := &RequestValidateListPolicies{
+		Token: req.GetToken(),
+		AppID: req.GetAppId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidListPolicies); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	policies, err := s.auth.ListPolicies(ctx, req.GetToken(), req.GetAppId())
+	if err != nil {
+		return nil, policyStatus(err)
+	}
+
+	resp := &ssov1.ListPoliciesResponse{}
+	for _, policy := range policies {
+		resp.Policies = append(resp.Policies, toPolicy(policy))
+	}
+
+	return resp, nil
+}
+
+func (s *ServerAPI) SetUserAttribute(ctx context.Context, req *ssov1.SetUserAttributeRequest) (*ssov1.SetUserAttributeResponse, error) {
+	reqValidSetUserAttribute := &RequestValidateSetUserAttribute{
+		Token:  req.GetToken(),
+		UserID: req.GetUserId(),
+		Name:   req.GetName(),
+		Value:  req.GetValue(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidSetUserAttribute); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				case "json":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be valid JSON", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.SetUserAttribute(ctx, req.GetToken(), req.GetUserId(), req.GetName(), req.GetValue()); err != nil {
+		return nil, policyStatus(err)
+	}
+
+	return &ssov1.SetUserAttributeResponse{}, nil
+}
+
+func (s *ServerAPI) Authorize(ctx context.Context, req *ssov1.AuthorizeRequest) (*ssov1.AuthorizeResponse, error) {
+	reqValidAuthorize := &RequestValidateAuthorize{
+		UserID:   req.GetUserId(),
+		AppID:    req.GetAppId(),
+		Action:   req.GetAction(),
+		Resource: req.GetResource(),
+		Context:  req.GetContext(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidAuthorize); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				case "json":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be valid JSON", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	decision, err := s.auth.AuthorizeAction(ctx, req.GetUserId(), req.GetAppId(), req.GetAction(), req.GetResource(), req.GetContext())
+	if err != nil {
+		return nil, policyStatus(err)
+	}
+
+	resp := &ssov1.AuthorizeResponse{
+		Allowed:       decision.Allowed,
+		Reason:        decision.Reason,
+		PolicyId:      decision.PolicyID,
+		DryRunAllowed: decision.DryRunAllowed,
+	}
+	for _, result := range decision.Results {
+		resp.Results = append(resp.Results, &ssov1.PolicyResult{
+			PolicyId: result.PolicyID,
+			Name:     result.Name,
+			Effect:   result.Effect,
+			DryRun:   result.DryRun,
+			Matched:  result.Matched,
+			Unmet:    result.Unmet,
+			Error:    result.Error,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *ServerAPI) ValidateToken(ctx context.Context, req *ssov1.ValidateTokenRequest) (*ssov1.ValidateTokenResponse, error) {
+	reqValidToken := &RequestValidateToken{
+		Token: req.GetToken(),
+		AppID: req.GetAppId(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidToken); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gte":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must not be negative", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	claims, err := s.auth.ValidateToken(ctx, req.GetToken(), req.GetAppId())
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return &ssov1.ValidateTokenResponse{
+				Active: false,
+			}, nil
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	tokenType := tokenTypeUser
+	if claims.Service {
+		tokenType = tokenTypeService
+	}
+
+	return &ssov1.ValidateTokenResponse{
+		Active:        true,
+		Jti:           claims.ID,
+		Issuer:        claims.Issuer,
+		UserId:        claims.UserID,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		AppId:         claims.AppID,
+		IssuedAt:      claims.IssuedAt.Unix(),
+		ExpiresAt:     claims.ExpiresAt.Unix(),
+		TokenType:     tokenType,
+		ClientId:      claims.ClientID,
+		Scope:         claims.Scope,
+		Roles:         claims.Roles,
+		Permissions:   claims.Permissions,
+	}, nil
+}
+
+func (s *ServerAPI) ClientCredentials(ctx context.Context, req *ssov1.ClientCredentialsRequest) (*ssov1.ClientCredentialsResponse, error) {
+	reqValidClientCredentials := &RequestValidateClientCredentials{
+		AppID:        req.GetAppId(),
+		ClientSecret: req.GetClientSecret(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidClientCredentials); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				case "gt":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s must be greater then %s", valErr.Field(), valErr.Param()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	tokens, scope, err := s.auth.ClientCredentials(ctx, req.GetAppId(), req.GetClientSecret(), req.GetScope())
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidClient) {
+			return nil, status.Error(codes.Unauthenticated, "invalid client")
+		}
+		if errors.Is(err, storage.ErrInvalidScope) {
+			return nil, status.Error(codes.InvalidArgument, "invalid scope")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.ClientCredentialsResponse{
+		Token:     tokens.AccessToken,
+		ExpiresIn: int64(tokens.ExpiresIn.Seconds()),
+		Scope:     scope,
+	}, nil
+}
+
+func (s *ServerAPI) Logout(ctx context.Context, req *ssov1.LogoutRequest) (*ssov1.LogoutResponse, error) {
+	reqValidLogout := &RequestValidateLogout{
+		Token: req.GetToken(),
+	}
+
+	var errorsMsgs []string
+	if err := validator.New().Struct(reqValidLogout); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, valErr := range validationErrors {
+				switch valErr.ActualTag() {
+				case "required":
+					errorsMsgs = append(errorsMsgs, fmt.Sprintf("Field %s is required", valErr.Field()))
+				}
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "validation error: %s", strings.Join(errorsMsgs, ", "))
+		}
+	}
+
+	if err := s.auth.Logout(ctx, req.GetToken(), req.GetAllSessions()); err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	return &ssov1.LogoutResponse{}, nil
+}
+
+func (s *ServerAPI) JWKS(ctx context.Context, req *ssov1.JWKSRequest) (*ssov1.JWKSResponse, error) {
+	jwks, err := s.auth.JWKS(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	keys := make([]*ssov1.JsonWebKey, 0, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		keys = append(keys, &ssov1.JsonWebKey{
+			Kty: key.Kty,
+			Use: key.Use,
+			Kid: key.Kid,
+			Alg: key.Alg,
+			N:   key.N,
+			E:   key.E,
+			Crv: key.Crv,
+			X:   key.X,
+			Y:   key.Y,
+		})
+	}
+
+	return &ssov1.JWKSResponse{
+		Keys: keys,
+	}, nil
+}
+
+// loginBlockedError reports a login refused for too many failures, with
+// the time to wait in RetryInfo.
+func loginBlockedError(blocked *storage.LoginBlockedError) error {
+	code, msg := codes.ResourceExhausted, "too many login attempts"
+	if errors.Is(blocked, storage.ErrAccountLocked) {
+		code, msg = codes.PermissionDenied, "account locked"
+	}
+
+	st, err := status.New(code, msg).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(blocked.RetryAfter.Round(time.Second)),
+	})
+	if err != nil {
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+// weakPasswordError reports a password refused by the policy, every broken
+// rule is a field violation of field.
+func weakPasswordError(weak *storage.WeakPasswordError, field string) error {
+	details := &errdetails.BadRequest{}
+	for _, v := range weak.Violations {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: v.Description,
+		})
+	}
+
+	st, err := status.New(codes.InvalidArgument, "password does not meet the policy").WithDetails(details)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "password does not meet the policy")
+	}
+	return st.Err()
+}
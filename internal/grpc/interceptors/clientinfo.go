@@ -0,0 +1,29 @@
+package interceptors
+
+import (
+	"context"
+
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/useragent"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientInfo puts the address and the user agent of the client into the
+// context of every call, for the login limits and the audit log.
+func ClientInfo() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			ctx = clientip.NewContext(ctx, clientip.FromAddr(p.Addr.String()))
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("user-agent"); len(values) > 0 {
+				ctx = useragent.NewContext(ctx, values[0])
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,181 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/sl"
+	"sso/internal/ratelimit"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Clients a rule can keep its buckets per.
+const (
+	KeyIP   = "ip"
+	KeyApp  = "app"
+	KeyUser = "user"
+)
+
+// AnyMethod in Rule.Method matches every method.
+const AnyMethod = "*"
+
+// Rule limits the calls to Method with one bucket per client, told apart
+// by Key. A call the client can't be told for, such as a per-app rule on a
+// request without an app id, isn't limited by the rule.
+type Rule struct {
+	Method string
+	Key    string
+	Limit  ratelimit.Limit
+}
+
+// TokenValidator resolves the user an access token belongs to, for per-user
+// rules on calls made with a token.
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string, appID int64) (models.TokenClaims, error)
+}
+
+// RateLimit refuses calls that found a bucket empty with
+// ResourceExhausted and the time to wait in RetryInfo. A call takes a token
+// from the bucket of every matching rule, or from none when one of them is
+// empty. Per-user rules of calls made with an access token are checked
+// last: the token is only looked up once the other buckets let the call
+// through. When the limiter fails the call goes through, an unreachable
+// backend doesn't take logins down with it.
+func RateLimit(log *slog.Logger, limiter ratelimit.Limiter, tokens TokenValidator, rules []Rule) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var (
+			buckets    []ratelimit.Bucket
+			tokenRules []Rule
+		)
+		for _, rule := range rules {
+			if rule.Method != AnyMethod && rule.Method != info.FullMethod {
+				continue
+			}
+
+			if rule.Key == KeyUser && requestEmail(req) == "" {
+				tokenRules = append(tokenRules, rule)
+				continue
+			}
+
+			client, ok := clientKey(ctx, req, rule.Key)
+			if !ok {
+				continue
+			}
+			buckets = append(buckets, rule.bucket(client))
+		}
+
+		if len(tokenRules) > 0 {
+			if len(buckets) > 0 {
+				res, err := limiter.Check(ctx, buckets...)
+				if err != nil {
+					log.Error("faild to check rate limit", slog.String("method", info.FullMethod), sl.Err(err))
+					return handler(ctx, req)
+				}
+				if !res.Allowed {
+					log.Info("rate limit exceeded", slog.String("method", info.FullMethod))
+					return nil, exhausted(res)
+				}
+			}
+
+			if client, ok := tokenUser(ctx, req, tokens); ok {
+				for _, rule := range tokenRules {
+					buckets = append(buckets, rule.bucket(client))
+				}
+			}
+		}
+
+		if len(buckets) == 0 {
+			return handler(ctx, req)
+		}
+
+		res, err := limiter.Allow(ctx, buckets...)
+		if err != nil {
+			log.Error("faild to check rate limit", slog.String("method", info.FullMethod), sl.Err(err))
+			return handler(ctx, req)
+		}
+		if !res.Allowed {
+			log.Info("rate limit exceeded", slog.String("method", info.FullMethod))
+			return nil, exhausted(res)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func (r Rule) bucket(client string) ratelimit.Bucket {
+	return ratelimit.Bucket{Key: r.Method + "|" + r.Key + "|" + client, Limit: r.Limit}
+}
+
+// clientKey tells the client a call came from: the peer address, the
+// request's app id, or the user by the request's email together with the
+// peer address. The email alone isn't enough, anyone could use up the
+// bucket of someone else's login.
+func clientKey(ctx context.Context, req any, key string) (string, bool) {
+	switch key {
+	case KeyIP:
+		return peerIP(ctx)
+	case KeyApp:
+		r, ok := req.(interface{ GetAppId() int64 })
+		if !ok || r.GetAppId() == 0 {
+			return "", false
+		}
+		return strconv.FormatInt(r.GetAppId(), 10), true
+	case KeyUser:
+		ip, ok := peerIP(ctx)
+		if !ok {
+			return "", false
+		}
+		return "email:" + ip + "|" + strings.ToLower(requestEmail(req)), true
+	}
+	return "", false
+}
+
+// tokenUser tells the user of a call by its access token. It costs a token
+// lookup, so it comes after the limits that don't need one.
+func tokenUser(ctx context.Context, req any, tokens TokenValidator) (string, bool) {
+	r, ok := req.(interface{ GetToken() string })
+	if !ok || r.GetToken() == "" || tokens == nil {
+		return "", false
+	}
+
+	claims, err := tokens.ValidateToken(ctx, r.GetToken(), 0)
+	if err != nil || claims.Service {
+		return "", false
+	}
+	return "id:" + strconv.FormatInt(claims.UserID, 10), true
+}
+
+func peerIP(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", false
+	}
+	return clientip.FromAddr(p.Addr.String()), true
+}
+
+func requestEmail(req any) string {
+	if r, ok := req.(interface{ GetEmail() string }); ok {
+		return r.GetEmail()
+	}
+	return ""
+}
+
+func exhausted(res ratelimit.Result) error {
+	st, err := status.New(codes.ResourceExhausted, "rate limit exceeded").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(res.RetryAfter),
+	})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return st.Err()
+}
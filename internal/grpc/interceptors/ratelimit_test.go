@@ -0,0 +1,88 @@
+package interceptors
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/ratelimit"
+	"sso/internal/ratelimit/memory"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type countingValidator struct {
+	calls int
+}
+
+func (v *countingValidator) ValidateToken(context.Context, string, int64) (models.TokenClaims, error) {
+	v.calls++
+	return models.TokenClaims{UserID: 1}, nil
+}
+
+func TestRateLimit(t *testing.T) {
+	perHour := func(burst int) ratelimit.Limit {
+		return ratelimit.Limit{Rate: 1, Per: time.Hour, Burst: burst}
+	}
+	rules := []Rule{
+		{Method: AnyMethod, Key: KeyIP, Limit: perHour(3)},
+		{Method: "/auth.Auth/Login", Key: KeyUser, Limit: perHour(1)},
+		{Method: "/auth.Auth/Logout", Key: KeyUser, Limit: perHour(1)},
+	}
+	tokens := &countingValidator{}
+	interceptor := RateLimit(slog.New(slog.NewTextHandler(io.Discard, nil)), memory.New(), tokens, rules)
+
+	call := func(ip, method string, req any) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 4000},
+		})
+		_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, func(context.Context, any) (any, error) {
+			return nil, nil
+		})
+		return err
+	}
+	login := &ssov1.LoginRequest{Email: "victim@sso.test"}
+
+	if err := call("10.0.0.1", "/auth.Auth/Login", login); err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	if err := call("10.0.0.1", "/auth.Auth/Login", login); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second login: got %v, want ResourceExhausted", err)
+	}
+
+	// another client's logins don't use up the user's bucket for the victim
+	if err := call("10.0.0.2", "/auth.Auth/Login", login); err != nil {
+		t.Errorf("login from another address: %v", err)
+	}
+
+	// the refused login took no token from the address, two are left
+	for i := range 2 {
+		if err := call("10.0.0.1", "/auth.Auth/Register", &ssov1.RegisterRequest{}); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+
+	// an address out of tokens is refused before its token is looked up
+	err := call("10.0.0.1", "/auth.Auth/Logout", &ssov1.LogoutRequest{Token: "token"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("logout: got %v, want ResourceExhausted", err)
+	}
+	if tokens.calls != 0 {
+		t.Errorf("token looked up %d times for a refused call", tokens.calls)
+	}
+
+	if err := call("10.0.0.3", "/auth.Auth/Logout", &ssov1.LogoutRequest{Token: "token"}); err != nil {
+		t.Errorf("logout: %v", err)
+	}
+	if tokens.calls != 1 {
+		t.Errorf("token looked up %d times, want once", tokens.calls)
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"sso/internal/lib/opaque"
+)
+
+const (
+	csrfCookie = "sso_csrf"
+	csrfField  = "csrf_token"
+)
+
+// csrfToken returns the token of the browser's sign in session, starting
+// one when the browser has none. The forms carry it in a hidden field, a
+// page of another site can't read it to post a form of its own.
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+
+	token, _, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/oauth",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return token, nil
+}
+
+// checkCSRF tells whether the posted form carries the token of the
+// browser's session.
+func checkCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get(csrfField))) == 1
+}
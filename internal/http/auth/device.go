@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+type devicePage struct {
+	AppName  string
+	UserCode string
+	Email    string
+	MFA      bool
+	Error    string
+	Message  string
+}
+
+// DeviceAuthorization is POST /oauth/device_authorization from RFC 8628: the
+// device gets a device code to poll with and a user code to show the user.
+func (s *ServerAPI) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.tokenError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	appID, err := strconv.ParseInt(clientID, 10, 64)
+	if err != nil || appID <= 0 {
+		s.tokenError(w, http.StatusUnauthorized, "invalid_client", "client_id is required")
+		return
+	}
+
+	authorization, err := s.auth.StartDeviceAuthorization(r.Context(), appID, clientSecret, r.PostForm.Get("scope"))
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidClient) {
+			s.tokenError(w, http.StatusUnauthorized, "invalid_client", "")
+			return
+		}
+		s.log.Error("faild to start device authorization", sl.Err(err))
+		s.tokenError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+		DeviceCode:              authorization.DeviceCode,
+		UserCode:                authorization.UserCode,
+		VerificationURI:         authorization.VerificationURI,
+		VerificationURIComplete: authorization.VerificationURIComplete,
+		ExpiresIn:               int64(authorization.ExpiresIn.Seconds()),
+		Interval:                int64(authorization.Interval.Seconds()),
+	})
+}
+
+// DevicePage is GET /oauth/device, the verification page. The user code is
+// prefilled when the user followed verification_uri_complete.
+func (s *ServerAPI) DevicePage(w http.ResponseWriter, r *http.Request) {
+	userCode := r.URL.Query().Get("user_code")
+
+	page := devicePage{UserCode: userCode}
+	if userCode != "" {
+		app, err := s.auth.CheckUserCode(r.Context(), userCode)
+		if err != nil {
+			if !errors.Is(err, storage.ErrDeviceCodeNotFound) {
+				s.log.Error("faild to check user code", sl.Err(err))
+			}
+			page.Error = "This code is invalid or has expired."
+		} else {
+			page.AppName = app.Name
+		}
+	}
+
+	s.renderDevice(w, http.StatusOK, page)
+}
+
+// DeviceVerify is POST /oauth/device, submitted by the verification page.
+func (s *ServerAPI) DeviceVerify(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	userCode := r.PostForm.Get("user_code")
+	email := r.PostForm.Get("email")
+	approve := r.PostForm.Get("action") == "approve"
+
+	app, err := s.auth.VerifyDevice(r.Context(), userCode, email, r.PostForm.Get("password"), r.PostForm.Get("mfa_code"), approve)
+	if err != nil {
+		page := devicePage{UserCode: userCode, Email: email}
+		if message, mfa, ok := signInError(err); ok {
+			page.Error, page.MFA = message, mfa
+			s.renderDevice(w, http.StatusUnauthorized, page)
+			return
+		}
+		switch {
+		case errors.Is(err, storage.ErrDeviceCodeNotFound):
+			page.Error = "This code is invalid or has expired."
+			s.renderDevice(w, http.StatusBadRequest, page)
+		default:
+			s.log.Error("faild to verify device", sl.Err(err))
+			page.Error = "Something went wrong, please try again."
+			s.renderDevice(w, http.StatusInternalServerError, page)
+		}
+		return
+	}
+
+	message := "Access denied. You can close this window."
+	if approve {
+		message = "Device connected. You can return to your device."
+	}
+	s.renderDevice(w, http.StatusOK, devicePage{AppName: app.Name, Message: message})
+}
+
+func (s *ServerAPI) renderDevice(w http.ResponseWriter, code int, page devicePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := templates.ExecuteTemplate(w, "device.html", page); err != nil {
+		s.log.Error("faild to render device page", sl.Err(err))
+	}
+}
@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type loginPage struct {
+	AppName string
+	Action  string
+	Error   string
+	Email   string
+	// MFA shows the authentication code field.
+	MFA    bool
+	Fields map[string]string
+	// CSRFToken binds the form to the browser's sign in session.
+	CSRFToken string
+}
+
+// AuthorizePage is GET /oauth/authorize: it checks the request and shows the
+// login form, carrying the request parameters along as hidden fields.
+func (s *ServerAPI) AuthorizePage(w http.ResponseWriter, r *http.Request) {
+	req := parseAuthorizeRequest(r.URL.Query())
+
+	app, err := s.auth.CheckAuthorizeRequest(r.Context(), req)
+	if err != nil {
+		s.authorizeError(w, r, req, err)
+		return
+	}
+
+	csrf, err := csrfToken(w, r)
+	if err != nil {
+		s.log.Error("faild to generate csrf token", sl.Err(err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.renderLogin(w, http.StatusOK, loginPage{
+		AppName:   app.Name,
+		Fields:    authorizeFields(req),
+		CSRFToken: csrf,
+	})
+}
+
+// Authorize is POST /oauth/authorize, submitted by the login form. On success
+// the browser is sent back to the client with the code and the client's state.
+// A form without the token of the browser's session is refused, so another
+// site can't sign the browser in to an account of its choosing.
+func (s *ServerAPI) Authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	req := parseAuthorizeRequest(r.PostForm)
+	email := r.PostForm.Get("email")
+
+	csrf, err := csrfToken(w, r)
+	if err != nil {
+		s.log.Error("faild to generate csrf token", sl.Err(err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !checkCSRF(r) {
+		s.log.Info("authorize form without csrf token")
+		s.renderLogin(w, http.StatusForbidden, loginPage{
+			Error:     "The sign in form has expired, please try again",
+			Email:     email,
+			Fields:    authorizeFields(req),
+			CSRFToken: csrf,
+		})
+		return
+	}
+
+	code, err := s.auth.Authorize(r.Context(), req, email, r.PostForm.Get("password"), r.PostForm.Get("mfa_code"))
+	if err != nil {
+		if message, mfa, ok := signInError(err); ok {
+			s.renderLogin(w, http.StatusUnauthorized, loginPage{
+				Error:     message,
+				Email:     email,
+				MFA:       mfa,
+				Fields:    authorizeFields(req),
+				CSRFToken: csrf,
+			})
+			return
+		}
+		s.authorizeError(w, r, req, err)
+		return
+	}
+
+	redirect(w, r, req.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {req.State},
+	})
+}
+
+// Token is POST /oauth/token.
+func (s *ServerAPI) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.tokenError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	var (
+		tokens models.TokenPair
+		scope  string
+		err    error
+	)
+	switch r.PostForm.Get("grant_type") {
+	case models.GrantTypeAuthorizationCode:
+		appID, parseErr := strconv.ParseInt(clientID, 10, 64)
+		if parseErr != nil || appID <= 0 {
+			s.tokenError(w, http.StatusUnauthorized, "invalid_client", "client_id is required")
+			return
+		}
+		if r.PostForm.Get("code") == "" || r.PostForm.Get("code_verifier") == "" {
+			s.tokenError(w, http.StatusBadRequest, "invalid_request", "code and code_verifier are required")
+			return
+		}
+		tokens, err = s.auth.ExchangeCode(
+			r.Context(),
+			appID,
+			clientSecret,
+			r.PostForm.Get("code"),
+			r.PostForm.Get("redirect_uri"),
+			r.PostForm.Get("code_verifier"),
+		)
+	case models.GrantTypeRefreshToken:
+		if r.PostForm.Get("refresh_token") == "" {
+			s.tokenError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+			return
+		}
+		tokens, err = s.auth.Refresh(r.Context(), r.PostForm.Get("refresh_token"))
+	case models.GrantTypeClientCredentials:
+		appID, parseErr := strconv.ParseInt(clientID, 10, 64)
+		if parseErr != nil || appID <= 0 || clientSecret == "" {
+			s.tokenError(w, http.StatusUnauthorized, "invalid_client", "client_id and client_secret are required")
+			return
+		}
+		tokens, scope, err = s.auth.ClientCredentials(r.Context(), appID, clientSecret, r.PostForm.Get("scope"))
+	case models.GrantTypeDeviceCode:
+		appID, parseErr := strconv.ParseInt(clientID, 10, 64)
+		if parseErr != nil || appID <= 0 {
+			s.tokenError(w, http.StatusUnauthorized, "invalid_client", "client_id is required")
+			return
+		}
+		if r.PostForm.Get("device_code") == "" {
+			s.tokenError(w, http.StatusBadRequest, "invalid_request", "device_code is required")
+			return
+		}
+		tokens, err = s.auth.ExchangeDeviceCode(r.Context(), appID, clientSecret, r.PostForm.Get("device_code"))
+	default:
+		s.tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidClient):
+			s.tokenError(w, http.StatusUnauthorized, "invalid_client", "")
+		case errors.Is(err, storage.ErrInvalidGrant), errors.Is(err, storage.ErrInvalidRefreshToken):
+			s.tokenError(w, http.StatusBadRequest, "invalid_grant", "")
+		case errors.Is(err, storage.ErrEmailNotVerified):
+			s.tokenError(w, http.StatusBadRequest, "invalid_grant", "email address not verified")
+		case errors.Is(err, storage.ErrInvalidScope):
+			s.tokenError(w, http.StatusBadRequest, "invalid_scope", "")
+		case errors.Is(err, storage.ErrAuthorizationPending):
+			s.tokenError(w, http.StatusBadRequest, "authorization_pending", "")
+		case errors.Is(err, storage.ErrSlowDown):
+			s.tokenError(w, http.StatusBadRequest, "slow_down", "")
+		case errors.Is(err, storage.ErrAccessDenied):
+			s.tokenError(w, http.StatusBadRequest, "access_denied", "")
+		case errors.Is(err, storage.ErrExpiredToken):
+			s.tokenError(w, http.StatusBadRequest, "expired_token", "")
+		default:
+			s.log.Error("faild to issue token", sl.Err(err))
+			s.tokenError(w, http.StatusInternalServerError, "server_error", "")
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		IDToken:      tokens.IDToken,
+		Scope:        scope,
+	})
+}
+
+// authorizeError reports a failed authorization request. Problems with the
+// client or redirect URI are shown on the page, anything else goes back to
+// the client's redirect URI as an OAuth error.
+func (s *ServerAPI) authorizeError(w http.ResponseWriter, r *http.Request, req models.AuthorizeRequest, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidClient):
+		s.renderLogin(w, http.StatusBadRequest, loginPage{Error: "Unknown client."})
+	case errors.Is(err, storage.ErrInvalidRedirectURI):
+		s.renderLogin(w, http.StatusBadRequest, loginPage{Error: "The redirect URI is not registered for this client."})
+	case errors.Is(err, storage.ErrInvalidRequest):
+		redirect(w, r, req.RedirectURI, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"response_type=code and an S256 code_challenge are required"},
+			"state":             {req.State},
+		})
+	case errors.Is(err, storage.ErrInvalidScope):
+		redirect(w, r, req.RedirectURI, url.Values{
+			"error":             {"invalid_scope"},
+			"error_description": {"the scope is not registered for this client"},
+			"state":             {req.State},
+		})
+	default:
+		s.log.Error("faild to authorize", sl.Err(err))
+		redirect(w, r, req.RedirectURI, url.Values{
+			"error": {"server_error"},
+			"state": {req.State},
+		})
+	}
+}
+
+// signInError is the message the sign in forms show for err, and whether the
+// form should ask for the authentication code.
+func signInError(err error) (message string, mfa bool, ok bool) {
+	var blocked *storage.LoginBlockedError
+	if errors.As(err, &blocked) {
+		wait := blocked.RetryAfter.Round(time.Second)
+		if errors.Is(err, storage.ErrAccountLocked) {
+			return fmt.Sprintf("Too many failed attempts, the account is locked for %s", wait), false, true
+		}
+		return fmt.Sprintf("Too many failed attempts, try again in %s", wait), false, true
+	}
+
+	switch {
+	case errors.Is(err, storage.ErrInvalidCredentials):
+		return "Invalid email or password", false, true
+	case errors.Is(err, storage.ErrMFARequired):
+		return "Enter the code from your authenticator app", true, true
+	case errors.Is(err, storage.ErrInvalidMFACode):
+		return "Invalid authentication code", true, true
+	case errors.Is(err, storage.ErrEmailNotVerified):
+		return "Confirm your email address first, the link is in the email we sent you", false, true
+	}
+	return "", false, false
+}
+
+func (s *ServerAPI) tokenError(w http.ResponseWriter, code int, errCode, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeJSON(w, code, oauthError{
+		Error:            errCode,
+		ErrorDescription: description,
+	})
+}
+
+func (s *ServerAPI) renderLogin(w http.ResponseWriter, code int, page loginPage) {
+	if page.Action == "" {
+		page.Action = "/oauth/authorize"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := templates.ExecuteTemplate(w, "login.html", page); err != nil {
+		s.log.Error("faild to render login page", sl.Err(err))
+	}
+}
+
+func parseAuthorizeRequest(values url.Values) models.AuthorizeRequest {
+	// unparsable client_id is left as 0, which no app has
+	appID, _ := strconv.ParseInt(values.Get("client_id"), 10, 64)
+
+	return models.AuthorizeRequest{
+		AppID:               appID,
+		RedirectURI:         values.Get("redirect_uri"),
+		ResponseType:        values.Get("response_type"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+func authorizeFields(req models.AuthorizeRequest) map[string]string {
+	return map[string]string{
+		"client_id":             strconv.FormatInt(req.AppID, 10),
+		"redirect_uri":          req.RedirectURI,
+		"response_type":         req.ResponseType,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	}
+}
+
+// redirect sends the browser to redirectURI with params added to its query,
+// skipping empty ones.
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
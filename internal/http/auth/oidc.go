@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+type providerMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type userInfoResponse struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// OpenIDConfiguration is the discovery document from OpenID Connect
+// Discovery 1.0. Every endpoint is published under the configured issuer.
+func (s *ServerAPI) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	issuer := strings.TrimSuffix(s.auth.Issuer(), "/")
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	s.writeJSON(w, http.StatusOK, providerMetadata{
+		Issuer:                      issuer,
+		AuthorizationEndpoint:       issuer + "/oauth/authorize",
+		TokenEndpoint:               issuer + "/oauth/token",
+		UserinfoEndpoint:            issuer + "/oauth/userinfo",
+		DeviceAuthorizationEndpoint: issuer + "/oauth/device_authorization",
+		JWKSURI:                     issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:      []string{"code"},
+		GrantTypesSupported: []string{
+			models.GrantTypeAuthorizationCode,
+			models.GrantTypeRefreshToken,
+			models.GrantTypeClientCredentials,
+			models.GrantTypeDeviceCode,
+		},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  s.auth.SigningAlgorithms(),
+		ScopesSupported:                   []string{"openid", "email"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nbf", "auth_time", "nonce", "email", "email_verified"},
+	})
+}
+
+// UserInfo returns the standard claims of the user the bearer token was
+// issued to.
+func (s *ServerAPI) UserInfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.auth.UserInfo(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.log.Error("faild to get user info", sl.Err(err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeJSON(w, http.StatusOK, userInfoResponse{
+		Sub:           strconv.FormatInt(user.ID, 10),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+
+	return token, true
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"sso/internal/domain/models"
+	"sso/internal/jwt"
+	"sso/internal/lib/sl"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+var templates = template.Must(template.ParseFS(templatesFS, "templates/*.html"))
+
+type Auth interface {
+	JWKS(ctx context.Context) (jwks jwt.JWKSet, error error)
+	CheckAuthorizeRequest(ctx context.Context, req models.AuthorizeRequest) (app models.App, error error)
+	Authorize(ctx context.Context, req models.AuthorizeRequest, email string, password string, mfaCode string) (code string, error error)
+	ExchangeCode(ctx context.Context, appID int64, clientSecret string, code string, redirectURI string, codeVerifier string) (tokens models.TokenPair, error error)
+	Refresh(ctx context.Context, refreshToken string) (tokens models.TokenPair, error error)
+	ClientCredentials(ctx context.Context, appID int64, clientSecret string, scope string) (tokens models.TokenPair, grantedScope string, error error)
+	StartDeviceAuthorization(ctx context.Context, appID int64, clientSecret string, scope string) (authorization models.DeviceAuthorization, error error)
+	CheckUserCode(ctx context.Context, userCode string) (app models.App, error error)
+	VerifyDevice(ctx context.Context, userCode string, email string, password string, mfaCode string, approve bool) (app models.App, error error)
+	ExchangeDeviceCode(ctx context.Context, appID int64, clientSecret string, deviceCode string) (tokens models.TokenPair, error error)
+	UserInfo(ctx context.Context, accessToken string) (user models.User, error error)
+	Issuer() string
+	SigningAlgorithms() []string
+}
+type ServerAPI struct {
+	log  *slog.Logger
+	auth Auth
+}
+
+func Register(mux *http.ServeMux, log *slog.Logger, auth Auth) {
+	s := &ServerAPI{log: log, auth: auth}
+
+	mux.HandleFunc("GET /.well-known/jwks.json", s.JWKS)
+	mux.HandleFunc("GET /oauth/authorize", s.AuthorizePage)
+	mux.HandleFunc("POST /oauth/authorize", s.Authorize)
+	mux.HandleFunc("POST /oauth/token", s.Token)
+	mux.HandleFunc("POST /oauth/device_authorization", s.DeviceAuthorization)
+	mux.HandleFunc("GET /oauth/device", s.DevicePage)
+	mux.HandleFunc("POST /oauth/device", s.DeviceVerify)
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.OpenIDConfiguration)
+	mux.HandleFunc("GET /oauth/userinfo", s.UserInfo)
+	mux.HandleFunc("POST /oauth/userinfo", s.UserInfo)
+}
+
+func (s *ServerAPI) JWKS(w http.ResponseWriter, r *http.Request) {
+	jwks, err := s.auth.JWKS(r.Context())
+	if err != nil {
+		s.log.Error("faild to get jwks", sl.Err(err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	s.writeJSON(w, http.StatusOK, jwks)
+}
+
+func (s *ServerAPI) writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.log.Error("faild to write response", sl.Err(err))
+	}
+}
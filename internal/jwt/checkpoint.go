@@ -0,0 +1,78 @@
+package jwt
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// CheckpointClaims of a signed audit checkpoint: the hash the audit chain
+// had reached at event EventID.
+type CheckpointClaims struct {
+	jwt.StandardClaims
+	EventID int64  `json:"event_id"`
+	Hash    string `json:"hash"`
+}
+
+// SignCheckpoint signs the head of the audit chain with key. The key is
+// passed rather than taken from a KeyProvider so the caller can store the
+// JWK of the very key that signed.
+func SignCheckpoint(key *Key, issuer string, eventID int64, hash string) (string, error) {
+	const op = "jwt.SignCheckpoint"
+
+	claims := CheckpointClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:   issuer,
+			Subject:  strconv.FormatInt(eventID, 10),
+			IssuedAt: time.Now().Unix(),
+		},
+		EventID: eventID,
+		Hash:    hash,
+	}
+
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	token.Header["kid"] = key.ID
+
+	tokenString, err := token.SignedString(key.Private)
+	if err != nil {
+		return "", fmt.Errorf("%s %w", op, err)
+	}
+
+	return tokenString, nil
+}
+
+// ParseCheckpoint verifies a checkpoint signed by key. The key must be the
+// one its kid names, which for server keys is the key's own thumbprint, so a
+// JWK stored next to the checkpoint can't be swapped for another one.
+func ParseCheckpoint(tokenString string, key JSONWebKey) (CheckpointClaims, error) {
+	const op = "jwt.ParseCheckpoint"
+
+	thumbprint, err := key.Thumbprint()
+	if err != nil {
+		return CheckpointClaims{}, fmt.Errorf("%s %w", op, err)
+	}
+	if thumbprint != key.Kid {
+		return CheckpointClaims{}, fmt.Errorf("%s %w: kid isn't the key thumbprint", op, ErrUnknownKey)
+	}
+
+	public, err := key.PublicKey()
+	if err != nil {
+		return CheckpointClaims{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	var claims CheckpointClaims
+	_, err = jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid != key.Kid || token.Method.Alg() != key.Alg {
+			return nil, ErrUnknownKey
+		}
+		return public, nil
+	})
+	if err != nil {
+		return CheckpointClaims{}, fmt.Errorf("%s %w: %w", op, ErrInvalidToken, err)
+	}
+
+	return claims, nil
+}
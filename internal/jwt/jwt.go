@@ -1,23 +1,177 @@
 package jwt
 
 import (
+	"fmt"
+	"strconv"
 	"time"
 
 	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
 
 	"github.com/golang-jwt/jwt"
 )
 
-func NewToken(user models.User, app models.App, duration time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+// Claims of an access token. sub is the user ID and aud the app ID, both as
+// strings as RFC 7519 requires. Service tokens have no user: their sub is
+// the client ID, gty is client_credentials and email_verified is absent.
+// roles and permissions are what the user was granted in the app when the
+// token was issued.
+type Claims struct {
+	jwt.StandardClaims
+	Email         string   `json:"email,omitempty"`
+	EmailVerified *bool    `json:"email_verified,omitempty"`
+	SessionID     string   `json:"sid,omitempty"`
+	ClientID      string   `json:"client_id,omitempty"`
+	Scope         string   `json:"scope,omitempty"`
+	GrantType     string   `json:"gty,omitempty"`
+	Roles         []string `json:"roles,omitempty"`
+	Permissions   []string `json:"permissions,omitempty"`
+}
+
+// IDClaims of an OpenID Connect ID token.
+type IDClaims struct {
+	jwt.StandardClaims
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	Nonce         string `json:"nonce,omitempty"`
+	AuthTime      int64  `json:"auth_time,omitempty"`
+}
+
+// typ headers. Access tokens are typed as RFC 9068 asks, so an ID token,
+// signed with the same keys for the same audience, can't pass for one.
+const (
+	typeAccessToken = "at+jwt"
+	typeIDToken     = "JWT"
+)
+
+type TokenOptions struct {
+	Issuer   string
+	Duration time.Duration
+	// SessionID ties the token to the refresh token family it was issued
+	// with, so logout can revoke both.
+	SessionID   string
+	Roles       []string
+	Permissions []string
+}
+
+type ServiceTokenOptions struct {
+	Issuer   string
+	Duration time.Duration
+	Scope    string
+}
+
+type IDTokenOptions struct {
+	Issuer   string
+	Duration time.Duration
+	Nonce    string
+	AuthTime time.Time
+}
+
+// NewToken signs the user's access token for app. Apps that opted in to HS256
+// keep getting tokens signed with their shared secret, everyone else gets
+// the current server key from keys.
+func NewToken(user models.User, app models.App, keys KeyProvider, opts TokenOptions) (string, error) {
+	jti, _, err := opaque.New()
+	if err != nil {
+		return "error", err
+	}
+
+	claims := Claims{
+		StandardClaims: standardClaims(user, app, opts.Issuer, opts.Duration),
+		Email:          user.Email,
+		EmailVerified:  &user.EmailVerified,
+		SessionID:      opts.SessionID,
+		Roles:          opts.Roles,
+		Permissions:    opts.Permissions,
+	}
+	claims.Id = jti
+
+	return sign(claims, app, keys, typeAccessToken)
+}
+
+// NewServiceToken signs an access token for app acting on its own behalf,
+// as issued by the client credentials grant.
+func NewServiceToken(app models.App, keys KeyProvider, opts ServiceTokenOptions) (string, error) {
+	jti, _, err := opaque.New()
+	if err != nil {
+		return "error", err
+	}
+
+	clientID := strconv.FormatInt(app.ID, 10)
+	now := time.Now()
+
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    opts.Issuer,
+			Subject:   clientID,
+			Audience:  clientID,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(opts.Duration).Unix(),
+		},
+		ClientID:  clientID,
+		Scope:     opts.Scope,
+		GrantType: models.GrantTypeClientCredentials,
+	}
+
+	return sign(claims, app, keys, typeAccessToken)
+}
+
+// NewIDToken signs an OpenID Connect ID token, asserting to app who the user
+// is and when they authenticated.
+func NewIDToken(user models.User, app models.App, keys KeyProvider, opts IDTokenOptions) (string, error) {
+	claims := IDClaims{
+		StandardClaims: standardClaims(user, app, opts.Issuer, opts.Duration),
+		Email:          user.Email,
+		EmailVerified:  user.EmailVerified,
+		Nonce:          opts.Nonce,
+		AuthTime:       opts.AuthTime.Unix(),
+	}
+
+	return sign(claims, app, keys, typeIDToken)
+}
+
+// AppKeyID is the kid put on HS256 tokens. It names the app whose
+// secret verifies the token and never appears in the JWKS.
+func AppKeyID(app models.App) string {
+	return fmt.Sprintf("app-%d", app.ID)
+}
+
+func standardClaims(user models.User, app models.App, issuer string, duration time.Duration) jwt.StandardClaims {
+	now := time.Now()
+
+	return jwt.StandardClaims{
+		Issuer:    issuer,
+		Subject:   strconv.FormatInt(user.ID, 10),
+		Audience:  strconv.FormatInt(app.ID, 10),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(duration).Unix(),
+	}
+}
+
+func sign(claims jwt.Claims, app models.App, keys KeyProvider, typ string) (string, error) {
+	if app.SigningAlg == AlgHS256 {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["typ"] = typ
+		token.Header["kid"] = AppKeyID(app)
+
+		tokenString, err := token.SignedString([]byte(app.Secret))
+		if err != nil {
+			return "error", err
+		}
+
+		return tokenString, nil
+	}
+
+	key := keys.SigningKey()
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["uid"] = user.ID
-	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(duration).Unix()
-	claims["app_id"] = app.ID
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	token.Header["typ"] = typ
+	token.Header["kid"] = key.ID
 
-	tokenString, err := token.SignedString([]byte(app.Secret))
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "error", err
 	}
@@ -1,10 +1,14 @@
 package jwt
 
 import (
+	"errors"
+	"slices"
 	"testing"
 	"time"
 
 	"sso/internal/domain/models"
+
+	"github.com/golang-jwt/jwt"
 )
 
 var user = &models.User{
@@ -13,6 +17,8 @@ var user = &models.User{
 	PassHash: []byte("sfvwsewfef"),
 }
 
+const sessionID = "test-session"
+
 var app = &models.App{
 	ID:     3,
 	Name:   "lokeded",
@@ -21,26 +27,457 @@ var app = &models.App{
 
 func TestJWT(t *testing.T) {
 	testData := []struct {
-		Name     string
-		user     models.User
-		app      models.App
-		duration time.Duration
+		Name       string
+		user       models.User
+		app        models.App
+		signingAlg string
+		keyAlg     string
+		duration   time.Duration
 	}{
 		{
-			Name:     "test 1",
+			Name:       "HS256 app",
+			user:       *user,
+			app:        *app,
+			signingAlg: AlgHS256,
+			keyAlg:     AlgRS256,
+			duration:   2 * time.Minute,
+		},
+		{
+			Name:     "RS256 server key",
+			user:     *user,
+			app:      *app,
+			keyAlg:   AlgRS256,
+			duration: 2 * time.Minute,
+		},
+		{
+			Name:     "ES256 server key",
+			user:     *user,
+			app:      *app,
+			keyAlg:   AlgES256,
+			duration: 2 * time.Minute,
+		},
+		{
+			Name:     "EdDSA server key",
 			user:     *user,
 			app:      *app,
+			keyAlg:   AlgEdDSA,
 			duration: 2 * time.Minute,
 		},
 	}
 	for _, tt := range testData {
 		t.Run(tt.Name, func(t *testing.T) {
-			got, err := NewToken(tt.user, tt.app, tt.duration)
+			key, err := GenerateKey(tt.keyAlg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.app.SigningAlg = tt.signingAlg
+
+			got, err := NewToken(tt.user, tt.app, NewStaticKeys(key), TokenOptions{Duration: tt.duration, SessionID: sessionID})
 			if err != nil {
 				t.Error(err)
 				return
 			}
-			t.Log(got)
+
+			token, err := jwt.Parse(got, func(token *jwt.Token) (interface{}, error) {
+				if tt.signingAlg == AlgHS256 {
+					return []byte(tt.app.Secret), nil
+				}
+				return key.Public(), nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantKid, wantAlg := key.ID, key.Algorithm
+			if tt.signingAlg == AlgHS256 {
+				wantKid, wantAlg = AppKeyID(tt.app), AlgHS256
+			}
+			if token.Header["kid"] != wantKid {
+				t.Errorf("kid = %v, want %v", token.Header["kid"], wantKid)
+			}
+			if token.Method.Alg() != wantAlg {
+				t.Errorf("alg = %v, want %v", token.Method.Alg(), wantAlg)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgES256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jwks := NewJWKSet([]*Key{key})
+			if len(jwks.Keys) != 1 {
+				t.Fatalf("got %d keys, want 1", len(jwks.Keys))
+			}
+
+			jwk := jwks.Keys[0]
+			if jwk.Kid != key.ID || jwk.Alg != alg {
+				t.Errorf("got kid %s alg %s, want kid %s alg %s", jwk.Kid, jwk.Alg, key.ID, alg)
+			}
+
+			token, err := NewToken(*user, *app, NewStaticKeys(key), TokenOptions{Duration: time.Minute, SessionID: sessionID})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			public, err := jwk.PublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return public, nil }); err != nil {
+				t.Errorf("token does not verify with published key: %v", err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	key, err := GenerateKey(AlgRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := GenerateKey(AlgRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewStaticKeys(key)
+
+	hsApp := *app
+	hsApp.SigningAlg = AlgHS256
+
+	apps := func(appID int64) (models.App, error) {
+		if appID == hsApp.ID {
+			return hsApp, nil
+		}
+		return models.App{}, ErrUnknownKey
+	}
+
+	sign := func(t *testing.T, app models.App, keys KeyProvider, duration time.Duration) string {
+		t.Helper()
+		token, err := NewToken(*user, app, keys, TokenOptions{Duration: duration, SessionID: sessionID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	idToken, err := NewIDToken(*user, *app, keys, IDTokenOptions{Duration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forge := func(t *testing.T, typ, jti string) string {
+		t.Helper()
+		claims := Claims{StandardClaims: standardClaims(*user, *app, "", time.Minute)}
+		claims.Id = jti
+
+		token := jwt.NewWithClaims(key.signingMethod(), claims)
+		token.Header["typ"] = typ
+		token.Header["kid"] = key.ID
+		tokenString, err := token.SignedString(key.Private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	testData := []struct {
+		Name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			Name:  "server key",
+			token: sign(t, *app, keys, time.Minute),
+		},
+		{
+			Name:    "id token",
+			token:   idToken,
+			wantErr: true,
+		},
+		{
+			Name:    "untyped",
+			token:   forge(t, "", "jti"),
+			wantErr: true,
+		},
+		{
+			Name:    "no jti",
+			token:   forge(t, typeAccessToken, ""),
+			wantErr: true,
+		},
+		{
+			Name:  "app secret",
+			token: sign(t, hsApp, keys, time.Minute),
+		},
+		{
+			Name:    "expired",
+			token:   sign(t, *app, keys, -time.Minute),
+			wantErr: true,
+		},
+		{
+			Name:    "unpublished key",
+			token:   sign(t, *app, NewStaticKeys(otherKey), time.Minute),
+			wantErr: true,
+		},
+		{
+			Name:    "garbage",
+			token:   "not.a.token",
+			wantErr: true,
+		},
+	}
+	for _, tt := range testData {
+		t.Run(tt.Name, func(t *testing.T) {
+			claims, err := Parse(tt.token, keys, apps)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("got error %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.UserID != user.ID || claims.Email != user.Email || claims.AppID != app.ID {
+				t.Errorf("got claims %+v", claims)
+			}
+			if claims.ID == "" || claims.SessionID != sessionID {
+				t.Errorf("got jti %q sid %q, want random jti and sid %q", claims.ID, claims.SessionID, sessionID)
+			}
+		})
+	}
+}
+
+func TestIDToken(t *testing.T) {
+	key, err := GenerateKey(AlgRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	token, err := NewIDToken(*user, *app, NewStaticKeys(key), IDTokenOptions{
+		Issuer:   "https://sso.example.com",
+		Duration: time.Minute,
+		Nonce:    "n-0S6_WzA2Mj",
+		AuthTime: authTime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := &IDClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return key.Public(), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Issuer != "https://sso.example.com" {
+		t.Errorf("iss = %q", claims.Issuer)
+	}
+	if claims.Subject != "2" || claims.Audience != "3" {
+		t.Errorf("sub = %q aud = %q, want 2 and 3", claims.Subject, claims.Audience)
+	}
+	if claims.Nonce != "n-0S6_WzA2Mj" {
+		t.Errorf("nonce = %q", claims.Nonce)
+	}
+	if claims.AuthTime != authTime.Unix() {
+		t.Errorf("auth_time = %d, want %d", claims.AuthTime, authTime.Unix())
+	}
+}
+
+func TestServiceToken(t *testing.T) {
+	key, err := GenerateKey(AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewStaticKeys(key)
+
+	token, err := NewServiceToken(*app, keys, ServiceTokenOptions{
+		Duration: time.Minute,
+		Scope:    "reports:read",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := Parse(token, keys, func(int64) (models.App, error) {
+		return models.App{}, ErrUnknownKey
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claims.Service || claims.ClientID != app.ID || claims.AppID != app.ID {
+		t.Errorf("got claims %+v, want service token of client %d", claims, app.ID)
+	}
+	if claims.UserID != 0 || claims.Scope != "reports:read" {
+		t.Errorf("got user %d scope %q", claims.UserID, claims.Scope)
+	}
+
+	// a user token must never be mistaken for a service token
+	userToken, err := NewToken(*user, *app, keys, TokenOptions{Duration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = Parse(userToken, keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Service || claims.UserID != user.ID {
+		t.Errorf("got claims %+v, want user token", claims)
+	}
+}
+
+func TestEmailVerifiedClaim(t *testing.T) {
+	key, err := GenerateKey(AlgRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewStaticKeys(key)
+
+	for _, verified := range []bool{true, false} {
+		verifiedUser := *user
+		verifiedUser.EmailVerified = verified
+
+		token, err := NewToken(verifiedUser, *app, keys, TokenOptions{Duration: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// unverified is stated, not left out
+		raw := &Claims{}
+		if _, err := jwt.ParseWithClaims(token, raw, func(*jwt.Token) (interface{}, error) {
+			return key.Public(), nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if raw.EmailVerified == nil || *raw.EmailVerified != verified {
+			t.Errorf("email_verified = %v, want %v", raw.EmailVerified, verified)
+		}
+
+		claims, err := Parse(token, keys, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.EmailVerified != verified {
+			t.Errorf("parsed email_verified = %v, want %v", claims.EmailVerified, verified)
+		}
+
+		idToken, err := NewIDToken(verifiedUser, *app, keys, IDTokenOptions{Duration: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		idClaims := &IDClaims{}
+		if _, err := jwt.ParseWithClaims(idToken, idClaims, func(*jwt.Token) (interface{}, error) {
+			return key.Public(), nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if idClaims.EmailVerified != verified {
+			t.Errorf("id token email_verified = %v, want %v", idClaims.EmailVerified, verified)
+		}
+	}
+
+	serviceToken, err := NewServiceToken(*app, keys, ServiceTokenOptions{Duration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := &Claims{}
+	if _, err := jwt.ParseWithClaims(serviceToken, raw, func(*jwt.Token) (interface{}, error) {
+		return key.Public(), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if raw.EmailVerified != nil {
+		t.Errorf("service token has email_verified %v", *raw.EmailVerified)
+	}
+}
+
+func TestRoleClaims(t *testing.T) {
+	key, err := GenerateKey(AlgRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewStaticKeys(key)
+
+	token, err := NewToken(*user, *app, keys, TokenOptions{
+		Duration:    time.Minute,
+		Roles:       []string{"admin", "editor"},
+		Permissions: []string{"audit:read", "posts:write"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := Parse(token, keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(claims.Roles, []string{"admin", "editor"}) {
+		t.Errorf("roles = %v", claims.Roles)
+	}
+	if !slices.Equal(claims.Permissions, []string{"audit:read", "posts:write"}) {
+		t.Errorf("permissions = %v", claims.Permissions)
+	}
+
+	// a user without roles gets no empty claims
+	plain, err := NewToken(*user, *app, keys, TokenOptions{Duration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(plain, raw, func(*jwt.Token) (interface{}, error) {
+		return key.Public(), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw["roles"]; ok {
+		t.Error("token of a user without roles has a roles claim")
+	}
+	if _, ok := raw["permissions"]; ok {
+		t.Error("token of a user without roles has a permissions claim")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgES256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := GenerateKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := SignCheckpoint(key, "sso", 42, "abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := ParseCheckpoint(token, key.JWK())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.EventID != 42 || claims.Hash != "abc" {
+				t.Errorf("got event %d hash %q, want 42 abc", claims.EventID, claims.Hash)
+			}
+
+			if _, err := ParseCheckpoint(token, other.JWK()); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("other key: got %v, want ErrInvalidToken", err)
+			}
+
+			// the other key passed off under the signer's kid
+			forged := other.JWK()
+			forged.Kid = key.ID
+			if _, err := ParseCheckpoint(token, forged); !errors.Is(err, ErrUnknownKey) {
+				t.Errorf("forged kid: got %v, want ErrUnknownKey", err)
+			}
 		})
 	}
 }
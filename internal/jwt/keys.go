@@ -0,0 +1,273 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrUnsupportedKey       = errors.New("unsupported private key type")
+)
+
+// Key is a server-held asymmetric signing key. ID is published as the "kid"
+// header of every token it signs and as the "kid" of its JWK.
+type Key struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+}
+
+// KeyProvider gives NewToken the key to sign with and the JWKS endpoint
+// every public key a resource server may still meet in a valid token.
+type KeyProvider interface {
+	SigningKey() *Key
+	PublicKeys() []*Key
+}
+
+// StaticKeys is a KeyProvider over a single key that never rotates.
+type StaticKeys struct {
+	key *Key
+}
+
+func NewStaticKeys(key *Key) *StaticKeys {
+	return &StaticKeys{key: key}
+}
+
+func (s *StaticKeys) SigningKey() *Key {
+	return s.key
+}
+
+func (s *StaticKeys) PublicKeys() []*Key {
+	return []*Key{s.key}
+}
+
+func GenerateKey(alg string) (*Key, error) {
+	const op = "jwt.GenerateKey"
+
+	var (
+		private crypto.Signer
+		err     error
+	)
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%s %w: %s", op, ErrUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return NewKey(private)
+}
+
+// NewKey wraps a private key, picking the algorithm from its type and
+// the key ID from its RFC 7638 thumbprint so the same key always gets the same kid.
+func NewKey(private crypto.Signer) (*Key, error) {
+	const op = "jwt.NewKey"
+
+	key := &Key{Private: private}
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = AlgRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s %w: curve %s", op, ErrUnsupportedKey, k.Curve.Params().Name)
+		}
+		key.Algorithm = AlgES256
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgEdDSA
+	default:
+		return nil, fmt.Errorf("%s %w: %T", op, ErrUnsupportedKey, private)
+	}
+
+	thumbprint, err := key.JWK().Thumbprint()
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	key.ID = thumbprint
+
+	return key, nil
+}
+
+func keyFromPrivate(private any) (*Key, error) {
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, private)
+	}
+
+	return NewKey(signer)
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+func (k *Key) signingMethod() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgES256:
+		return jwt.SigningMethodES256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodRS256
+	}
+}
+
+// JSONWebKey is the public part of a Key as described in RFC 7517.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func (k *Key) JWK() JSONWebKey {
+	jwk := JSONWebKey{
+		Use: "sig",
+		Kid: k.ID,
+		Alg: k.Algorithm,
+	}
+
+	switch pub := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(pub.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = encodeSegment(pub.X.FillBytes(make([]byte, 32)))
+		jwk.Y = encodeSegment(pub.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeSegment(pub)
+	}
+
+	return jwk
+}
+
+// Thumbprint computes the RFC 7638 SHA-256 thumbprint over the required members only.
+func (j JSONWebKey) Thumbprint() (string, error) {
+	var members any
+	switch j.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Crv, j.Kty, j.X, j.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	default:
+		return "", ErrUnsupportedKey
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+
+	return encodeSegment(sum[:]), nil
+}
+
+// PublicKey converts the JWK back into a key usable for signature verification.
+func (j JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+func NewJWKSet(keys []*Key) JWKSet {
+	set := JWKSet{Keys: make([]JSONWebKey, 0, len(keys))}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	return set
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
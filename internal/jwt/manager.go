@@ -0,0 +1,301 @@
+package jwt
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/aead"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+// clockSkew is how far the clocks of the instances may disagree.
+const clockSkew = time.Minute
+
+type KeyStorage interface {
+	SaveSigningKey(ctx context.Context, key models.SigningKey) error
+	SigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	RotateSigningKeys(ctx context.Context, activeID string, next models.SigningKey, retiredExpiresAt time.Time) error
+}
+
+// Manager is a KeyProvider backed by storage. It signs with the active key,
+// publishes the next key ahead of time so verifiers already have it cached when
+// it becomes active, and keeps retired keys published until every token they
+// signed has expired.
+type Manager struct {
+	log             *slog.Logger
+	storage         KeyStorage
+	cipher          *aead.Cipher
+	algorithm       string
+	rotationPeriod  time.Duration
+	refreshInterval time.Duration
+	tokenTTL        time.Duration
+
+	mu          sync.RWMutex
+	active      *Key
+	activatedAt time.Time
+	published   []*Key
+
+	stop chan struct{}
+}
+
+func NewManager(
+	log *slog.Logger,
+	keyStorage KeyStorage,
+	cipher *aead.Cipher,
+	algorithm string,
+	rotationPeriod time.Duration,
+	refreshInterval time.Duration,
+	tokenTTL time.Duration,
+) *Manager {
+	return &Manager{
+		log:             log,
+		storage:         keyStorage,
+		cipher:          cipher,
+		algorithm:       algorithm,
+		rotationPeriod:  rotationPeriod,
+		refreshInterval: refreshInterval,
+		tokenTTL:        tokenTTL,
+		stop:            make(chan struct{}),
+	}
+}
+
+func (m *Manager) SigningKey() *Key {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.active
+}
+
+func (m *Manager) PublicKeys() []*Key {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.published
+}
+
+// Load reads the keys from storage, creating the first active and next keys
+// when the storage is empty.
+func (m *Manager) Load(ctx context.Context) error {
+	const op = "jwt.Manager.Load"
+
+	stored, err := m.storage.SigningKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	var hasActive, hasNext bool
+	for _, key := range stored {
+		switch key.Status {
+		case models.SigningKeyActive:
+			hasActive = true
+		case models.SigningKeyNext:
+			hasNext = true
+		}
+	}
+
+	if !hasActive || !hasNext {
+		if !hasActive {
+			if err := m.createKey(ctx, models.SigningKeyActive); err != nil {
+				return fmt.Errorf("%s %w", op, err)
+			}
+		}
+		if !hasNext {
+			if err := m.createKey(ctx, models.SigningKeyNext); err != nil {
+				return fmt.Errorf("%s %w", op, err)
+			}
+		}
+
+		stored, err = m.storage.SigningKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	var (
+		active      *Key
+		activatedAt time.Time
+		published   []*Key
+		now         = time.Now()
+	)
+	for _, storedKey := range stored {
+		if storedKey.Status == models.SigningKeyRetired && now.After(storedKey.ExpiresAt) {
+			continue
+		}
+
+		key, err := m.decrypt(storedKey)
+		if err != nil {
+			return fmt.Errorf("%s %w", op, err)
+		}
+
+		if storedKey.Status == models.SigningKeyActive {
+			active = key
+			activatedAt = storedKey.ActivatedAt
+		}
+		published = append(published, key)
+	}
+
+	m.mu.Lock()
+	m.active = active
+	m.activatedAt = activatedAt
+	m.published = published
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Keys returns every stored key, retired ones no longer published too. The
+// storage keeps retired keys that signed audit checkpoints, so the
+// checkpoints can be verified with keys the server decrypted itself.
+func (m *Manager) Keys(ctx context.Context) ([]*Key, error) {
+	const op = "jwt.Manager.Keys"
+
+	stored, err := m.storage.SigningKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	keys := make([]*Key, 0, len(stored))
+	for _, storedKey := range stored {
+		key, err := m.decrypt(storedKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Rotate makes the next key active immediately and retires the current one.
+func (m *Manager) Rotate(ctx context.Context) error {
+	const op = "jwt.Manager.Rotate"
+
+	m.mu.RLock()
+	activeID := m.active.ID
+	m.mu.RUnlock()
+
+	next, err := m.newStoredKey(models.SigningKeyNext)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	// other instances sign with the retired key until their next reload,
+	// and those tokens must verify for their whole lifetime everywhere
+	retiredExpiresAt := next.CreatedAt.Add(m.tokenTTL + m.refreshInterval + clockSkew)
+
+	err = m.storage.RotateSigningKeys(ctx, activeID, next, retiredExpiresAt)
+	if err != nil && !errors.Is(err, storage.ErrSigningKeyRotated) {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := m.Load(ctx); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	m.log.Info("signing keys rotated", slog.String("kid", m.SigningKey().ID))
+
+	return nil
+}
+
+// Run reloads keys on every refresh interval, so rotations done by another
+// instance or by the admin command are picked up, and rotates once the active
+// key is older than the rotation period. It blocks until Stop is called.
+func (m *Manager) Run() {
+	const op = "jwt.Manager.Run"
+
+	log := m.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(m.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx := context.Background()
+
+		if err := m.Load(ctx); err != nil {
+			log.Error("faild to reload signing keys", sl.Err(err))
+			continue
+		}
+
+		m.mu.RLock()
+		due := time.Since(m.activatedAt) >= m.rotationPeriod
+		m.mu.RUnlock()
+
+		if due {
+			if err := m.Rotate(ctx); err != nil {
+				log.Error("faild to rotate signing keys", sl.Err(err))
+			}
+		}
+	}
+}
+
+func (m *Manager) Stop() {
+	close(m.stop)
+}
+
+func (m *Manager) createKey(ctx context.Context, status string) error {
+	stored, err := m.newStoredKey(status)
+	if err != nil {
+		return err
+	}
+
+	return m.storage.SaveSigningKey(ctx, stored)
+}
+
+func (m *Manager) newStoredKey(status string) (models.SigningKey, error) {
+	key, err := GenerateKey(m.algorithm)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	encrypted, err := m.cipher.Encrypt(der)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	now := time.Now()
+
+	stored := models.SigningKey{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: encrypted,
+		Status:     status,
+		CreatedAt:  now,
+	}
+	if status == models.SigningKeyActive {
+		stored.ActivatedAt = now
+	}
+
+	return stored, nil
+}
+
+func (m *Manager) decrypt(stored models.SigningKey) (*Key, error) {
+	der, err := m.cipher.Decrypt(stored.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", stored.ID, err)
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", stored.ID, err)
+	}
+
+	return keyFromPrivate(private)
+}
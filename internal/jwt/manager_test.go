@@ -0,0 +1,174 @@
+package jwt
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/aead"
+	"sso/internal/storage"
+)
+
+type memoryKeyStorage struct {
+	mu   sync.Mutex
+	keys []models.SigningKey
+}
+
+func (s *memoryKeyStorage) SaveSigningKey(_ context.Context, key models.SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (s *memoryKeyStorage) SigningKeys(_ context.Context) ([]models.SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]models.SigningKey(nil), s.keys...), nil
+}
+
+func (s *memoryKeyStorage) RotateSigningKeys(_ context.Context, activeID string, next models.SigningKey, retiredExpiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rotated := false
+	for i := range s.keys {
+		if s.keys[i].ID == activeID && s.keys[i].Status == models.SigningKeyActive {
+			s.keys[i].Status = models.SigningKeyRetired
+			s.keys[i].ExpiresAt = retiredExpiresAt
+			rotated = true
+		}
+	}
+	if !rotated {
+		return storage.ErrSigningKeyRotated
+	}
+
+	for i := range s.keys {
+		if s.keys[i].Status == models.SigningKeyNext {
+			s.keys[i].Status = models.SigningKeyActive
+			s.keys[i].ActivatedAt = next.CreatedAt
+		}
+	}
+	s.keys = append(s.keys, next)
+
+	return nil
+}
+
+func newTestManager(t *testing.T, keyStorage KeyStorage) *Manager {
+	t.Helper()
+
+	cipher, err := aead.New("test-encryption-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	m := NewManager(log, keyStorage, cipher, AlgES256, time.Hour, time.Minute, time.Hour)
+	if err := m.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestManagerRotation(t *testing.T) {
+	keyStorage := &memoryKeyStorage{}
+	m := newTestManager(t, keyStorage)
+
+	if got := len(m.PublicKeys()); got != 2 {
+		t.Fatalf("published %d keys after bootstrap, want active and next", got)
+	}
+
+	oldActive := m.SigningKey()
+	var next *Key
+	for _, key := range m.PublicKeys() {
+		if key.ID != oldActive.ID {
+			next = key
+		}
+	}
+
+	token, err := NewToken(*user, *app, m, TokenOptions{Duration: time.Hour, SessionID: sessionID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.SigningKey().ID != next.ID {
+		t.Errorf("active key after rotation = %s, want previous next key %s", m.SigningKey().ID, next.ID)
+	}
+	if got := len(m.PublicKeys()); got != 3 {
+		t.Errorf("published %d keys after rotation, want retired, active and next", got)
+	}
+
+	// the retired key must still verify tokens it signed before rotation
+	found := false
+	for _, key := range m.PublicKeys() {
+		if key.ID == oldActive.ID {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("retired key %s is no longer published, token %s can't be verified", oldActive.ID, token)
+	}
+
+	// a restarted instance loads the very same keys from storage
+	restarted := newTestManager(t, keyStorage)
+	if restarted.SigningKey().ID != m.SigningKey().ID {
+		t.Errorf("active key after restart = %s, want %s", restarted.SigningKey().ID, m.SigningKey().ID)
+	}
+}
+
+func TestManagerDropsExpiredRetiredKeys(t *testing.T) {
+	keyStorage := &memoryKeyStorage{}
+	m := newTestManager(t, keyStorage)
+	m.tokenTTL = -(m.refreshInterval + clockSkew + time.Second)
+
+	oldActive := m.SigningKey()
+	if err := m.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range m.PublicKeys() {
+		if key.ID == oldActive.ID {
+			t.Errorf("retired key %s is still published after its tokens expired", key.ID)
+		}
+	}
+}
+
+func TestManagerRetiredKeyOutlivesPeerTokens(t *testing.T) {
+	keyStorage := &memoryKeyStorage{}
+	m := newTestManager(t, keyStorage)
+	peer := newTestManager(t, keyStorage)
+
+	rotatedAt := time.Now()
+	if err := m.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer hasn't reloaded yet and signs with the retired key
+	retired := peer.SigningKey()
+	token, err := NewToken(*user, *app, peer, TokenOptions{Duration: peer.tokenTTL, SessionID: sessionID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(token, m, nil); err != nil {
+		t.Fatalf("token signed by a peer before its reload doesn't verify: %v", err)
+	}
+
+	// it may do so for up to a refresh interval, on a clock running behind
+	lastExpiry := rotatedAt.Add(peer.refreshInterval + peer.tokenTTL + clockSkew)
+	for _, key := range keyStorage.keys {
+		if key.ID == retired.ID && key.ExpiresAt.Before(lastExpiry) {
+			t.Errorf("retired key expires at %s, before the last token its peer signs expires at %s", key.ExpiresAt, lastExpiry)
+		}
+	}
+}
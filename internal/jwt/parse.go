@@ -0,0 +1,121 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+// AppFunc looks up the app a token claims to be issued for. It should
+// return ErrUnknownKey for apps that don't exist.
+type AppFunc func(appID int64) (models.App, error)
+
+// Parse verifies tokenString the way resource servers should: HS256 tokens
+// against the secret of the app in their aud claim, everything else against
+// the published server key named by kid, with the algorithm pinned to that key.
+// Only access tokens pass, typed at+jwt and with a jti; ID tokens are
+// signed with the same keys but aren't credentials. The issuer is returned,
+// not checked, callers compare it with their own.
+func Parse(tokenString string, keys KeyProvider, appByID AppFunc) (models.TokenClaims, error) {
+	const op = "jwt.Parse"
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); !strings.EqualFold(typ, typeAccessToken) {
+			return nil, ErrInvalidToken
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		if token.Method.Alg() == AlgHS256 {
+			claims, _ := token.Claims.(*Claims)
+			appID, err := strconv.ParseInt(claims.Audience, 10, 64)
+			if err != nil {
+				return nil, ErrInvalidToken
+			}
+
+			app, err := appByID(appID)
+			if err != nil {
+				return nil, err
+			}
+			if app.SigningAlg != AlgHS256 || kid != AppKeyID(app) {
+				return nil, ErrUnknownKey
+			}
+
+			return []byte(app.Secret), nil
+		}
+
+		for _, key := range keys.PublicKeys() {
+			if key.ID == kid && key.Algorithm == token.Method.Alg() {
+				return key.Public(), nil
+			}
+		}
+
+		return nil, ErrUnknownKey
+	})
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Inner != nil {
+			err = validationErr.Inner
+
+			// app lookup itself failed, that says nothing about the token
+			unverifiable := validationErr.Errors&jwt.ValidationErrorUnverifiable != 0
+			if unverifiable && !errors.Is(err, ErrInvalidToken) && !errors.Is(err, ErrUnknownKey) {
+				return models.TokenClaims{}, fmt.Errorf("%s %w", op, err)
+			}
+		}
+		return models.TokenClaims{}, fmt.Errorf("%s %w: %w", op, ErrInvalidToken, err)
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return models.TokenClaims{}, fmt.Errorf("%s %w", op, ErrInvalidToken)
+	}
+
+	appID, errAud := strconv.ParseInt(claims.Audience, 10, 64)
+	if errAud != nil || claims.ExpiresAt == 0 || claims.Id == "" {
+		return models.TokenClaims{}, fmt.Errorf("%s %w: missing required claims", op, ErrInvalidToken)
+	}
+
+	result := models.TokenClaims{
+		ID:        claims.Id,
+		SessionID: claims.SessionID,
+		Issuer:    claims.Issuer,
+		Email:     claims.Email,
+		AppID:     appID,
+		Scope:     claims.Scope,
+		IssuedAt:  time.Unix(claims.IssuedAt, 0),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+
+	// a service token can only act as the app it was issued to
+	if claims.GrantType == models.GrantTypeClientCredentials {
+		if claims.Subject != claims.Audience || claims.ClientID != claims.Audience {
+			return models.TokenClaims{}, fmt.Errorf("%s %w: service token for another client", op, ErrInvalidToken)
+		}
+		result.Service = true
+		result.ClientID = appID
+
+		return result, nil
+	}
+
+	result.UserID, err = strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return models.TokenClaims{}, fmt.Errorf("%s %w: missing required claims", op, ErrInvalidToken)
+	}
+	result.EmailVerified = claims.EmailVerified != nil && *claims.EmailVerified
+	result.Roles = claims.Roles
+	result.Permissions = claims.Permissions
+
+	return result, nil
+}
@@ -0,0 +1,122 @@
+// Package abac decides attribute-based access requests against the
+// policies of an app.
+package abac
+
+import (
+	"fmt"
+	"strings"
+
+	"sso/internal/domain/models"
+)
+
+// Applies reports whether the policy covers action: one of its actions is
+// the action itself, "*", or a prefix ending in "*".
+func Applies(policy models.Policy, action string) bool {
+	for _, pattern := range policy.Actions {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(action, prefix) {
+				return true
+			}
+		} else if pattern == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide evaluates the policies covering action. A deny policy that
+// matches wins, otherwise an allow policy that matches allows, otherwise
+// the request is denied. A deny policy whose condition can't be evaluated
+// counts as matching, an allow policy as not.
+func Decide(policies []models.Policy, action string, attrs Attributes) models.Decision {
+	var (
+		decision models.Decision
+		enforced verdict
+		all      verdict
+	)
+
+	for _, policy := range policies {
+		if !Applies(policy, action) {
+			continue
+		}
+
+		result := evaluate(policy, attrs)
+		decision.Results = append(decision.Results, result)
+
+		all.add(policy, result)
+		if !policy.DryRun {
+			enforced.add(policy, result)
+		}
+	}
+
+	decision.Allowed = enforced.allowed()
+	decision.DryRunAllowed = all.allowed()
+
+	switch {
+	case enforced.deny != nil:
+		decision.PolicyID = enforced.deny.ID
+		decision.Reason = fmt.Sprintf("denied by policy %q", enforced.deny.Name)
+	case enforced.allow != nil:
+		decision.PolicyID = enforced.allow.ID
+		decision.Reason = fmt.Sprintf("allowed by policy %q", enforced.allow.Name)
+	default:
+		decision.Reason = fmt.Sprintf("no policy allows %s", action)
+	}
+
+	return decision
+}
+
+// verdict keeps the first deny and allow policies that matched.
+type verdict struct {
+	deny, allow *models.Policy
+}
+
+func (v *verdict) add(policy models.Policy, result models.PolicyResult) {
+	if !result.Matched {
+		return
+	}
+	switch {
+	case policy.Effect == models.EffectDeny && v.deny == nil:
+		v.deny = &policy
+	case policy.Effect == models.EffectAllow && v.allow == nil:
+		v.allow = &policy
+	}
+}
+
+func (v *verdict) allowed() bool {
+	return v.deny == nil && v.allow != nil
+}
+
+func evaluate(policy models.Policy, attrs Attributes) models.PolicyResult {
+	result := models.PolicyResult{
+		PolicyID: policy.ID,
+		Name:     policy.Name,
+		Effect:   policy.Effect,
+		DryRun:   policy.DryRun,
+	}
+
+	if policy.Condition == "" {
+		result.Matched = true
+		return result
+	}
+
+	expr, err := Compile(policy.Condition)
+	if err != nil {
+		result.Error = err.Error()
+		result.Matched = policy.Effect == models.EffectDeny
+		return result
+	}
+
+	matched, err := expr.Eval(attrs)
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+		result.Matched = policy.Effect == models.EffectDeny
+	case !matched:
+		result.Unmet = expr.Unmet(attrs)
+	default:
+		result.Matched = true
+	}
+
+	return result
+}
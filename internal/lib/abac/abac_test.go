@@ -0,0 +1,113 @@
+package abac
+
+import (
+	"testing"
+
+	"sso/internal/domain/models"
+)
+
+func TestApplies(t *testing.T) {
+	policy := models.Policy{Actions: []string{"documents:update", "reports:*"}}
+
+	testData := []struct {
+		action string
+		want   bool
+	}{
+		{"documents:update", true},
+		{"documents:delete", false},
+		{"reports:read", true},
+		{"reports", false},
+	}
+	for _, tt := range testData {
+		if got := Applies(policy, tt.action); got != tt.want {
+			t.Errorf("Applies(%s) = %v, want %v", tt.action, got, tt.want)
+		}
+	}
+
+	if !Applies(models.Policy{Actions: []string{"*"}}, "anything") {
+		t.Error("* doesn't apply to every action")
+	}
+}
+
+func TestDecide(t *testing.T) {
+	sameDepartment := models.Policy{
+		ID: 1, Name: "editors in their department", Effect: models.EffectAllow,
+		Actions:   []string{"documents:update"},
+		Condition: `"editor" in subject.roles && subject.department == resource.department`,
+	}
+	businessHours := models.Policy{
+		ID: 2, Name: "business hours", Effect: models.EffectDeny,
+		Actions:   []string{"documents:*"},
+		Condition: `env.hour < 9 || env.hour >= 18`,
+	}
+	locked := models.Policy{
+		ID: 3, Name: "locked documents", Effect: models.EffectDeny,
+		Actions:   []string{"documents:update"},
+		Condition: `resource.status == "locked"`,
+		DryRun:    true,
+	}
+	policies := []models.Policy{sameDepartment, businessHours, locked}
+
+	attrs := func(hour float64, department, status string) Attributes {
+		return Attributes{
+			RootSubject:  {"roles": []any{"editor"}, "department": "sales"},
+			RootResource: {"department": department, "status": status},
+			RootEnv:      {"hour": hour},
+		}
+	}
+
+	testData := []struct {
+		name          string
+		attrs         Attributes
+		action        string
+		allowed       bool
+		policyID      int64
+		dryRunAllowed bool
+	}{
+		{"allowed", attrs(10, "sales", "draft"), "documents:update", true, 1, true},
+		{"other department", attrs(10, "hr", "draft"), "documents:update", false, 0, false},
+		{"after hours", attrs(20, "sales", "draft"), "documents:update", false, 2, false},
+		{"dry run deny", attrs(10, "sales", "locked"), "documents:update", true, 1, false},
+		{"no policy", attrs(10, "sales", "draft"), "documents:create", false, 0, false},
+	}
+	for _, tt := range testData {
+		d := Decide(policies, tt.action, tt.attrs)
+		if d.Allowed != tt.allowed || d.PolicyID != tt.policyID || d.DryRunAllowed != tt.dryRunAllowed {
+			t.Errorf("%s: got allowed %v by %d dry run %v, want %v by %d dry run %v (%s)",
+				tt.name, d.Allowed, d.PolicyID, d.DryRunAllowed, tt.allowed, tt.policyID, tt.dryRunAllowed, d.Reason)
+		}
+	}
+
+	d := Decide(policies, "documents:update", attrs(10, "hr", "draft"))
+	if len(d.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(d.Results))
+	}
+	if d.Results[0].Unmet != "subject.department == resource.department" {
+		t.Errorf("unmet = %q", d.Results[0].Unmet)
+	}
+	if d.Reason != "no policy allows documents:update" {
+		t.Errorf("reason = %q", d.Reason)
+	}
+}
+
+func TestDecideErrors(t *testing.T) {
+	policies := []models.Policy{
+		{ID: 1, Name: "everyone", Effect: models.EffectAllow, Actions: []string{"*"}},
+		{ID: 2, Name: "clearance", Effect: models.EffectDeny, Actions: []string{"*"}, Condition: `subject.clearance < resource.level`},
+	}
+
+	// a deny policy that can't be evaluated denies
+	d := Decide(policies, "read", Attributes{RootResource: {"level": float64(2)}})
+	if d.Allowed || d.PolicyID != 2 || d.Results[1].Error == "" {
+		t.Errorf("got %+v, want denied by the failing policy", d)
+	}
+
+	// an allow policy that can't be evaluated doesn't allow
+	policies = []models.Policy{
+		{ID: 1, Name: "owner", Effect: models.EffectAllow, Actions: []string{"*"}, Condition: `resource.owner == subject.id`},
+	}
+	d = Decide(policies, "read", Attributes{})
+	if d.Allowed || d.Results[0].Error == "" {
+		t.Errorf("got %+v, want not allowed", d)
+	}
+}
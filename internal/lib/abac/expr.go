@@ -0,0 +1,508 @@
+package abac
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Roots of the attributes a condition can read.
+const (
+	RootSubject  = "subject"
+	RootResource = "resource"
+	RootContext  = "context"
+	RootEnv      = "env"
+)
+
+var roots = []string{RootSubject, RootResource, RootContext, RootEnv}
+
+var ErrInvalidCondition = errors.New("invalid policy condition")
+
+// Expr is a compiled condition. The language is a boolean expression over
+// attributes:
+//
+//	subject.department == resource.department && env.hour >= 9 && env.hour < 18
+//	"editor" in subject.roles || !(resource.status in ["locked", "archived"])
+//
+// Values are strings in single or double quotes, numbers, true, false,
+// null and lists in brackets. ==, != compare any values, <, <=, >, >=
+// numbers or strings, in tests membership of a list. && and || short
+// circuit. Reading an attribute that isn't set is an error.
+type Expr struct {
+	root node
+}
+
+// Attributes are the values conditions read, keyed by root. Values are
+// what encoding/json decodes to: nil, bool, float64, string, []any and
+// map[string]any.
+type Attributes map[string]map[string]any
+
+// Compile parses a condition.
+func Compile(src string) (*Expr, error) {
+	p := &parser{src: src}
+	if err := p.tokenize(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCondition, err)
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCondition, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidCondition, p.tokens[p.pos].text, p.tokens[p.pos].start)
+	}
+
+	return &Expr{root: root}, nil
+}
+
+// Eval reports whether the condition holds for attrs.
+func (e *Expr) Eval(attrs Attributes) (bool, error) {
+	v, err := e.root.eval(attrs)
+	if err != nil {
+		return false, err
+	}
+	return asBool(v, e.root)
+}
+
+// Unmet returns the smallest part of the condition that made it false for
+// attrs: the first false operand of &&, followed down, or the whole of
+// anything else.
+func (e *Expr) Unmet(attrs Attributes) string {
+	return unmet(e.root, attrs)
+}
+
+func unmet(n node, attrs Attributes) string {
+	if b, ok := n.(*binary); ok && b.op == "&&" {
+		for _, side := range []node{b.left, b.right} {
+			if v, err := side.eval(attrs); err == nil && v == false {
+				return unmet(side, attrs)
+			}
+		}
+	}
+	return n.text()
+}
+
+type node interface {
+	eval(attrs Attributes) (any, error)
+	text() string
+}
+
+type literal struct {
+	value any
+	src   string
+}
+
+type attribute struct {
+	path []string
+	src  string
+}
+
+type list struct {
+	items []node
+	src   string
+}
+
+type not struct {
+	operand node
+	src     string
+}
+
+type binary struct {
+	op          string
+	left, right node
+	src         string
+}
+
+func (n *literal) text() string   { return n.src }
+func (n *attribute) text() string { return n.src }
+func (n *list) text() string      { return n.src }
+func (n *not) text() string       { return n.src }
+func (n *binary) text() string    { return n.src }
+
+func (n *literal) eval(Attributes) (any, error) {
+	return n.value, nil
+}
+
+func (n *attribute) eval(attrs Attributes) (any, error) {
+	var v any = attrs[n.path[0]]
+	for i, key := range n.path[1:] {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s is not an object", strings.Join(n.path[:i+1], "."))
+		}
+		if v, ok = m[key]; !ok {
+			return nil, fmt.Errorf("%s is not set", n.src)
+		}
+	}
+	return v, nil
+}
+
+func (n *list) eval(attrs Attributes) (any, error) {
+	items := make([]any, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(attrs)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+func (n *not) eval(attrs Attributes) (any, error) {
+	v, err := n.operand.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+	b, err := asBool(v, n.operand)
+	if err != nil {
+		return nil, err
+	}
+	return !b, nil
+}
+
+func (n *binary) eval(attrs Attributes) (any, error) {
+	left, err := n.left.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "&&" || n.op == "||" {
+		l, err := asBool(left, n.left)
+		if err != nil {
+			return nil, err
+		}
+		if l == (n.op == "||") {
+			return l, nil
+		}
+		right, err := n.right.eval(attrs)
+		if err != nil {
+			return nil, err
+		}
+		return asBool(right, n.right)
+	}
+
+	right, err := n.right.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		items, ok := right.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a list", n.right.text())
+		}
+		for _, item := range items {
+			if equal(left, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	cmp, err := compare(left, right)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.src, err)
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func asBool(v any, n node) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s is not a boolean", n.text())
+	}
+	return b, nil
+}
+
+func equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func compare(a, b any) (int, error) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	}
+	return 0, fmt.Errorf("can't order %T and %T", a, b)
+}
+
+type token struct {
+	kind  byte // 'i' identifier, 'n' number, 's' string, 'o' operator
+	text  string
+	value any
+	start int
+	end   int
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", "."}
+
+func (p *parser) tokenize() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: 'i', text: src[i:j], start: i, end: j})
+			i = j
+		case unicode.IsDigit(c) || c == '-' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1])):
+			j := i + 1
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			f, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return fmt.Errorf("invalid number %q at %d", src[i:j], i)
+			}
+			p.tokens = append(p.tokens, token{kind: 'n', text: src[i:j], value: f, start: i, end: j})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != src[i] {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return fmt.Errorf("unterminated string at %d", i)
+			}
+			quoted := src[i : j+1]
+			if c == '\'' {
+				quoted = `"` + strings.ReplaceAll(strings.ReplaceAll(src[i+1:j], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			s, err := strconv.Unquote(quoted)
+			if err != nil {
+				return fmt.Errorf("invalid string at %d", i)
+			}
+			p.tokens = append(p.tokens, token{kind: 's', text: src[i : j+1], value: s, start: i, end: j + 1})
+			i = j + 1
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			p.tokens = append(p.tokens, token{kind: 'o', text: op, start: i, end: i + len(op)})
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+func (p *parser) peek(text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind != 's' && p.tokens[p.pos].text == text
+}
+
+func (p *parser) expect(text string) (token, error) {
+	if !p.peek(text) {
+		return token{}, p.unexpected(text)
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *parser) unexpected(want string) error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("expected %s at end", want)
+	}
+	t := p.tokens[p.pos]
+	return fmt.Errorf("expected %s, got %q at %d", want, t.text, t.start)
+}
+
+// span is the source from the start of the token at pos to the end of the
+// last token read.
+func (p *parser) span(pos int) string {
+	return p.src[p.tokens[pos].start:p.tokens[p.pos-1].end]
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary(p.parseNot, "&&")
+}
+
+func (p *parser) parseBinary(operand func() (node, error), op string) (node, error) {
+	start := p.pos
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(op) {
+		p.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right, src: p.span(start)}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	start := p.pos
+	if p.peek("!") {
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{operand: operand, src: p.span(start)}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	start := p.pos
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if p.peek(op) {
+			p.pos++
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return &binary{op: op, left: left, right: right, src: p.span(start)}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, p.unexpected("a value")
+	}
+	start := p.pos
+	t := p.tokens[p.pos]
+
+	switch {
+	case t.kind == 'n' || t.kind == 's':
+		p.pos++
+		return &literal{value: t.value, src: t.text}, nil
+	case t.kind == 'i':
+		p.pos++
+		switch t.text {
+		case "true", "false":
+			return &literal{value: t.text == "true", src: t.text}, nil
+		case "null":
+			return &literal{value: nil, src: t.text}, nil
+		}
+		return p.parseAttribute(t)
+	case p.peek("("):
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return wrap(inner, p.span(start)), nil
+	case p.peek("["):
+		p.pos++
+		l := &list{}
+		for !p.peek("]") {
+			if len(l.items) > 0 {
+				if _, err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			item, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			l.items = append(l.items, item)
+		}
+		p.pos++
+		l.src = p.span(start)
+		return l, nil
+	}
+	return nil, p.unexpected("a value")
+}
+
+// wrap keeps the parentheses in the source of a parenthesized expression.
+func wrap(n node, src string) node {
+	switch n := n.(type) {
+	case *binary:
+		return &binary{op: n.op, left: n.left, right: n.right, src: src}
+	case *not:
+		return &not{operand: n.operand, src: src}
+	}
+	return n
+}
+
+func (p *parser) parseAttribute(root token) (node, error) {
+	known := false
+	for _, r := range roots {
+		known = known || r == root.text
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown attribute %q at %d, attributes start with %s", root.text, root.start, strings.Join(roots, ", "))
+	}
+
+	a := &attribute{path: []string{root.text}}
+	for p.peek(".") {
+		p.pos++
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != 'i' {
+			return nil, p.unexpected("an attribute name")
+		}
+		a.path = append(a.path, p.tokens[p.pos].text)
+		p.pos++
+	}
+	if len(a.path) == 1 {
+		return nil, fmt.Errorf("%s at %d needs an attribute name", root.text, root.start)
+	}
+	a.src = strings.Join(a.path, ".")
+	return a, nil
+}
@@ -0,0 +1,119 @@
+package abac
+
+import (
+	"errors"
+	"testing"
+)
+
+var testAttrs = Attributes{
+	RootSubject: {
+		"id":         float64(7),
+		"department": "sales",
+		"roles":      []any{"editor", "viewer"},
+	},
+	RootResource: {
+		"department": "sales",
+		"status":     "draft",
+		"owner":      map[string]any{"id": float64(7)},
+		"pages":      float64(12),
+	},
+	RootEnv: {
+		"hour":    float64(10),
+		"weekday": "monday",
+	},
+}
+
+func TestEval(t *testing.T) {
+	testData := []struct {
+		condition string
+		want      bool
+	}{
+		{`subject.department == resource.department`, true},
+		{`subject.department != resource.department`, false},
+		{`"editor" in subject.roles`, true},
+		{`'admin' in subject.roles`, false},
+		{`resource.status in ["locked", "archived"]`, false},
+		{`!(resource.status in ["locked", "archived"])`, true},
+		{`env.hour >= 9 && env.hour < 18`, true},
+		{`env.hour >= 9 && env.hour < 10`, false},
+		{`resource.pages > 10.5`, true},
+		{`resource.owner.id == subject.id`, true},
+		{`resource.status < "final"`, true},
+		{`env.weekday in ["saturday", "sunday"] || env.hour > 20`, false},
+		{`true || subject.missing == 1`, true},
+		{`false && subject.missing == 1`, false},
+		{`resource.status == null`, false},
+		{`-1 < env.hour`, true},
+	}
+
+	for _, tt := range testData {
+		expr, err := Compile(tt.condition)
+		if err != nil {
+			t.Fatalf("Compile(%s): %v", tt.condition, err)
+		}
+		got, err := expr.Eval(testAttrs)
+		if err != nil {
+			t.Fatalf("Eval(%s): %v", tt.condition, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.condition, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, condition := range []string{
+		`subject.manager == "bob"`,
+		`subject.department > 3`,
+		`subject.department`,
+		`"x" in subject.department`,
+		`subject.department.name == "x"`,
+		`!env.hour`,
+	} {
+		expr, err := Compile(condition)
+		if err != nil {
+			t.Fatalf("Compile(%s): %v", condition, err)
+		}
+		if _, err := expr.Eval(testAttrs); err == nil {
+			t.Errorf("Eval(%s): got no error", condition)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, condition := range []string{
+		``,
+		`user.department == "x"`,
+		`subject == "x"`,
+		`subject.department ==`,
+		`(env.hour > 1`,
+		`env.hour > 1)`,
+		`"unterminated`,
+		`env.hour # 1`,
+		`[1, 2`,
+	} {
+		if _, err := Compile(condition); !errors.Is(err, ErrInvalidCondition) {
+			t.Errorf("Compile(%q): got %v, want ErrInvalidCondition", condition, err)
+		}
+	}
+}
+
+func TestUnmet(t *testing.T) {
+	testData := []struct {
+		condition string
+		want      string
+	}{
+		{`subject.department == resource.department && (env.hour >= 12 && env.hour < 18)`, `env.hour >= 12`},
+		{`resource.status == "final" && env.hour > 1`, `resource.status == "final"`},
+		{`env.hour > 12 || env.hour < 8`, `env.hour > 12 || env.hour < 8`},
+	}
+	for _, tt := range testData {
+		expr, err := Compile(tt.condition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := expr.Unmet(testAttrs); got != tt.want {
+			t.Errorf("Unmet(%s) = %q, want %q", tt.condition, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package aead
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+var ErrCiphertextTooShort = errors.New("ciphertext too short")
+
+// Cipher encrypts secrets that are stored at rest (signing keys, MFA secrets)
+// with AES-256-GCM. The nonce is prepended to every ciphertext.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func New(secret string) (*Cipher, error) {
+	const op = "aead.New"
+
+	if secret == "" {
+		return nil, fmt.Errorf("%s encryption key is empty", op)
+	}
+
+	key := sha256.Sum256([]byte(secret))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return &Cipher{aead: gcm}, nil
+}
+
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	const op = "aead.Encrypt"
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	const op = "aead.Decrypt"
+
+	nonceSize := c.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, fmt.Errorf("%s %w", op, ErrCiphertextTooShort)
+	}
+
+	plaintext, err := c.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,50 @@
+package auditchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"sso/internal/domain/models"
+)
+
+// record is what a hash covers, every stored field of an event but its id
+// and own hash. The field order is part of the format, don't reorder.
+type record struct {
+	PrevHash  string            `json:"prev_hash"`
+	Type      string            `json:"type"`
+	ActorID   int64             `json:"actor_id"`
+	UserID    int64             `json:"user_id"`
+	AppID     int64             `json:"app_id"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	Outcome   string            `json:"outcome"`
+	Details   map[string]string `json:"details"`
+	CreatedAt string            `json:"created_at"`
+}
+
+// Hash chains event to the one before it, whose hash is prev, the first
+// event of the chain has an empty prev. Editing, dropping or reordering
+// events changes the hashes of everything after them.
+func Hash(prev string, event models.AuditEvent) (string, error) {
+	data, err := json.Marshal(record{
+		PrevHash:  prev,
+		Type:      event.Type,
+		ActorID:   event.ActorID,
+		UserID:    event.UserID,
+		AppID:     event.AppID,
+		IP:        event.IP,
+		UserAgent: event.UserAgent,
+		Outcome:   event.Outcome,
+		Details:   event.Details,
+		CreatedAt: event.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return "", fmt.Errorf("auditchain.Hash %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,74 @@
+package auditchain
+
+import (
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+)
+
+func mustHash(t *testing.T, prev string, event models.AuditEvent) string {
+	t.Helper()
+
+	hash, err := Hash(prev, event)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	return hash
+}
+
+func TestHash(t *testing.T) {
+	event := models.AuditEvent{
+		Type:      models.AuditLogin,
+		ActorID:   2,
+		UserID:    2,
+		AppID:     1,
+		IP:        "10.0.0.7",
+		UserAgent: "grpc-go/1.70",
+		Outcome:   models.AuditSuccess,
+		Details:   map[string]string{"method": "password", "email": "a@b.c"},
+		CreatedAt: time.Date(2026, 10, 17, 12, 0, 0, 123456789, time.UTC),
+	}
+	hash := mustHash(t, "", event)
+
+	if len(hash) != 64 {
+		t.Errorf("hash %q isn't hex SHA-256", hash)
+	}
+
+	// the time zone a time was read back in doesn't matter
+	local := event
+	local.CreatedAt = event.CreatedAt.In(time.FixedZone("EEST", 3*60*60))
+	if got := mustHash(t, "", local); got != hash {
+		t.Errorf("same instant in another zone: got %s, want %s", got, hash)
+	}
+
+	// the id isn't stored content, it's given on insert
+	withID := event
+	withID.ID = 7
+	if got := mustHash(t, "", withID); got != hash {
+		t.Errorf("event with id: got %s, want %s", got, hash)
+	}
+
+	if mustHash(t, hash, event) == hash {
+		t.Error("prev hash isn't covered")
+	}
+
+	edits := map[string]func(e *models.AuditEvent){
+		"type":       func(e *models.AuditEvent) { e.Type = models.AuditTokenRevoked },
+		"actor":      func(e *models.AuditEvent) { e.ActorID = 3 },
+		"user":       func(e *models.AuditEvent) { e.UserID = 3 },
+		"app":        func(e *models.AuditEvent) { e.AppID = 2 },
+		"ip":         func(e *models.AuditEvent) { e.IP = "10.0.0.8" },
+		"user agent": func(e *models.AuditEvent) { e.UserAgent = "curl/8.0" },
+		"outcome":    func(e *models.AuditEvent) { e.Outcome = models.AuditFailure },
+		"details":    func(e *models.AuditEvent) { e.Details = map[string]string{"method": "passkey", "email": "a@b.c"} },
+		"created at": func(e *models.AuditEvent) { e.CreatedAt = e.CreatedAt.Add(time.Nanosecond) },
+	}
+	for name, edit := range edits {
+		edited := event
+		edit(&edited)
+		if mustHash(t, "", edited) == hash {
+			t.Errorf("%s isn't covered", name)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package clientip
+
+import (
+	"context"
+	"net"
+)
+
+type ctxKey struct{}
+
+// NewContext returns ctx carrying the address the request came from, for
+// limits kept per client.
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, ip)
+}
+
+// FromContext returns the address set by NewContext, empty when unknown.
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(ctxKey{}).(string)
+	return ip
+}
+
+// FromAddr strips the port from a host:port address.
+func FromAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,223 @@
+package hasher
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms a Hasher writes and reads.
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+var (
+	ErrUnknownHash = errors.New("unknown password hash format")
+	ErrWrongPepper = errors.New("password hash was made with another pepper")
+)
+
+// Argon2Params are the argon2id cost parameters, Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type Config struct {
+	// Algorithm is the one new hashes are made with, hashes of the other
+	// one are still verified and reported for rehash.
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+	// Pepper is a server secret mixed into argon2id hashes, which carry
+	// its id as the keyid parameter. Hashes without it are reported for
+	// rehash.
+	Pepper []byte
+}
+
+type Hasher struct {
+	cfg   Config
+	keyID string
+}
+
+func New(cfg Config) (*Hasher, error) {
+	const op = "hasher.New"
+
+	switch cfg.Algorithm {
+	case Bcrypt:
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("%s bcrypt cost must be between %d and %d", op, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		// a bcrypt hash has no room to record the pepper
+		if len(cfg.Pepper) > 0 {
+			return nil, fmt.Errorf("%s pepper needs %s", op, Argon2id)
+		}
+	case Argon2id:
+		p := cfg.Argon2
+		if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 || p.SaltLength < 8 || p.KeyLength < 16 {
+			return nil, fmt.Errorf("%s invalid argon2id parameters", op)
+		}
+	default:
+		return nil, fmt.Errorf("%s unknown algorithm %q", op, cfg.Algorithm)
+	}
+
+	h := &Hasher{cfg: cfg}
+	if len(cfg.Pepper) > 0 {
+		sum := sha256.Sum256(cfg.Pepper)
+		h.keyID = base64.RawStdEncoding.EncodeToString(sum[:6])
+	}
+
+	return h, nil
+}
+
+// Hash hashes password with the configured algorithm. argon2id hashes are
+// PHC strings, bcrypt hashes keep their own $2a$ format.
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	const op = "hasher.Hash"
+
+	if h.cfg.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+		if err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		return hash, nil
+	}
+
+	p := h.cfg.Argon2
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	key := argon2.IDKey(h.peppered(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+	if h.keyID != "" {
+		params += ",keyid=" + h.keyID
+	}
+
+	return fmt.Appendf(nil, "$%s$v=%d$%s$%s$%s", Argon2id, argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches hash, and whether a matching
+// hash should be replaced because it was made with another algorithm,
+// other costs or without the current pepper.
+func (h *Hasher) Verify(hash []byte, password string) (ok bool, rehash bool, err error) {
+	const op = "hasher.Verify"
+
+	switch {
+	case bytes.HasPrefix(hash, []byte("$2")):
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, fmt.Errorf("%s %w", op, err)
+		}
+
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return false, false, fmt.Errorf("%s %w", op, err)
+		}
+		return true, h.cfg.Algorithm != Bcrypt || cost != h.cfg.BcryptCost, nil
+	case bytes.HasPrefix(hash, []byte("$"+Argon2id+"$")):
+		stored, err := parseArgon2(string(hash))
+		if err != nil {
+			return false, false, fmt.Errorf("%s %w", op, err)
+		}
+		if stored.keyID != "" && stored.keyID != h.keyID {
+			return false, false, fmt.Errorf("%s %w", op, ErrWrongPepper)
+		}
+
+		input := []byte(password)
+		if stored.keyID != "" {
+			input = h.peppered(password)
+		}
+		key := argon2.IDKey(input, stored.salt, stored.params.Iterations, stored.params.Memory, stored.params.Parallelism, stored.params.KeyLength)
+		if subtle.ConstantTimeCompare(key, stored.key) != 1 {
+			return false, false, nil
+		}
+
+		rehash = h.cfg.Algorithm != Argon2id || stored.params != h.cfg.Argon2 || stored.keyID != h.keyID
+		return true, rehash, nil
+	default:
+		return false, false, fmt.Errorf("%s %w", op, ErrUnknownHash)
+	}
+}
+
+// peppered is the input argon2id hashes: the password itself, or its
+// HMAC keyed with the pepper.
+func (h *Hasher) peppered(password string) []byte {
+	if h.keyID == "" {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, h.cfg.Pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+type argon2Hash struct {
+	params Argon2Params
+	keyID  string
+	salt   []byte
+	key    []byte
+}
+
+// parseArgon2 reads $argon2id$v=19$m=..,t=..,p=..[,keyid=..]$salt$key.
+func parseArgon2(s string) (argon2Hash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return argon2Hash{}, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Hash{}, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	var h argon2Hash
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		var err error
+		switch name {
+		case "m":
+			_, err = fmt.Sscanf(value, "%d", &h.params.Memory)
+		case "t":
+			_, err = fmt.Sscanf(value, "%d", &h.params.Iterations)
+		case "p":
+			_, err = fmt.Sscanf(value, "%d", &h.params.Parallelism)
+		case "keyid":
+			h.keyID = value
+		}
+		if err != nil {
+			return argon2Hash{}, fmt.Errorf("invalid argon2 parameter %q", param)
+		}
+	}
+	if h.params.Memory == 0 || h.params.Iterations == 0 || h.params.Parallelism == 0 {
+		return argon2Hash{}, fmt.Errorf("missing argon2 parameters %q", parts[3])
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Hash{}, fmt.Errorf("invalid argon2 salt %w", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return argon2Hash{}, fmt.Errorf("invalid argon2 key %w", err)
+	}
+	h.params.SaltLength = uint32(len(h.salt))
+	h.params.KeyLength = uint32(len(h.key))
+
+	return h, nil
+}
@@ -0,0 +1,140 @@
+package hasher
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap parameters, the tests check behavior not strength
+var testArgon2 = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func mustNew(t *testing.T, cfg Config) *Hasher {
+	t.Helper()
+
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return h
+}
+
+func verify(t *testing.T, h *Hasher, hash []byte, password string) (bool, bool) {
+	t.Helper()
+
+	ok, rehash, err := h.Verify(hash, password)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return ok, rehash
+}
+
+func TestArgon2id(t *testing.T) {
+	h := mustNew(t, Config{Algorithm: Argon2id, Argon2: testArgon2})
+
+	hash, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("hash %s isn't a PHC argon2id string", hash)
+	}
+
+	if ok, rehash := verify(t, h, hash, "correct horse"); !ok || rehash {
+		t.Errorf("right password: got ok %v rehash %v, want true false", ok, rehash)
+	}
+	if ok, _ := verify(t, h, hash, "wrong horse"); ok {
+		t.Error("wrong password matched")
+	}
+
+	other, _ := h.Hash("correct horse")
+	if string(other) == string(hash) {
+		t.Error("two hashes of one password are equal, salt not random")
+	}
+
+	stronger := mustNew(t, Config{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}})
+	if ok, rehash := verify(t, stronger, hash, "correct horse"); !ok || !rehash {
+		t.Errorf("other memory cost: got ok %v rehash %v, want true true", ok, rehash)
+	}
+}
+
+func TestBcryptMigration(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		name   string
+		cfg    Config
+		rehash bool
+	}{
+		{"same cost", Config{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}, false},
+		{"other cost", Config{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost + 1}, true},
+		{"argon2id current", Config{Algorithm: Argon2id, Argon2: testArgon2}, true},
+	}
+	for _, tt := range testData {
+		h := mustNew(t, tt.cfg)
+		if ok, rehash := verify(t, h, legacy, "correct horse"); !ok || rehash != tt.rehash {
+			t.Errorf("%s: got ok %v rehash %v, want true %v", tt.name, ok, rehash, tt.rehash)
+		}
+		if ok, _ := verify(t, h, legacy, "wrong horse"); ok {
+			t.Errorf("%s: wrong password matched", tt.name)
+		}
+	}
+}
+
+func TestPepper(t *testing.T) {
+	plain := mustNew(t, Config{Algorithm: Argon2id, Argon2: testArgon2})
+	peppered := mustNew(t, Config{Algorithm: Argon2id, Argon2: testArgon2, Pepper: []byte("secret")})
+	otherPepper := mustNew(t, Config{Algorithm: Argon2id, Argon2: testArgon2, Pepper: []byte("another")})
+
+	unpepperedHash, _ := plain.Hash("correct horse")
+	if ok, rehash := verify(t, peppered, unpepperedHash, "correct horse"); !ok || !rehash {
+		t.Errorf("hash without pepper: got ok %v rehash %v, want true true", ok, rehash)
+	}
+
+	hash, _ := peppered.Hash("correct horse")
+	if !strings.Contains(string(hash), ",keyid=") {
+		t.Errorf("peppered hash %s has no keyid", hash)
+	}
+	if ok, rehash := verify(t, peppered, hash, "correct horse"); !ok || rehash {
+		t.Errorf("peppered hash: got ok %v rehash %v, want true false", ok, rehash)
+	}
+
+	if _, _, err := otherPepper.Verify(hash, "correct horse"); !errors.Is(err, ErrWrongPepper) {
+		t.Errorf("other pepper: got %v, want ErrWrongPepper", err)
+	}
+	if _, _, err := plain.Verify(hash, "correct horse"); !errors.Is(err, ErrWrongPepper) {
+		t.Errorf("no pepper: got %v, want ErrWrongPepper", err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	testData := []struct {
+		name string
+		cfg  Config
+	}{
+		{"unknown algorithm", Config{Algorithm: "md5"}},
+		{"bcrypt cost", Config{Algorithm: Bcrypt, BcryptCost: 100}},
+		{"bcrypt pepper", Config{Algorithm: Bcrypt, BcryptCost: bcrypt.DefaultCost, Pepper: []byte("secret")}},
+		{"argon2id params", Config{Algorithm: Argon2id}},
+	}
+	for _, tt := range testData {
+		if _, err := New(tt.cfg); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
+
+func TestVerifyUnknownHash(t *testing.T) {
+	h := mustNew(t, Config{Algorithm: Argon2id, Argon2: testArgon2})
+
+	for _, hash := range []string{"", "plaintext", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		if _, _, err := h.Verify([]byte(hash), "x"); err == nil {
+			t.Errorf("Verify(%q): got no error", hash)
+		}
+	}
+}
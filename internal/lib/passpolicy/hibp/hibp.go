@@ -0,0 +1,64 @@
+package hibp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Checker asks the Pwned Passwords range API whether a password was
+// breached. Only the first five characters of the password's SHA-1 leave
+// the process, the match against the returned suffixes is done here.
+type Checker struct {
+	client  *http.Client
+	baseURL string
+}
+
+func New(client *http.Client, baseURL string) *Checker {
+	return &Checker{client: client, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (c *Checker) Breached(ctx context.Context, password string) (bool, error) {
+	const op = "hibp.Breached"
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/range/"+prefix, nil)
+	if err != nil {
+		return false, fmt.Errorf("%s %w", op, err)
+	}
+	// padded answers all have about the same size, so their length tells
+	// nothing about the prefix
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%s %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s unexpected status %s", op, resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+		// padding entries have a count of 0
+		return count != "0", nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("%s %w", op, err)
+	}
+
+	return false, nil
+}
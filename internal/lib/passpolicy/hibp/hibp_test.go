@@ -0,0 +1,62 @@
+package hibp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8.
+const (
+	passwordPrefix = "5BAA6"
+	passwordSuffix = "1E4C9B93F3F0682250B6CF8331B7EE68FD8"
+)
+
+func TestBreached(t *testing.T) {
+	var gotPath, gotPadding string
+	body := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + passwordSuffix + ":9659365\r\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotPadding = r.URL.Path, r.Header.Get("Add-Padding")
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	c := New(srv.Client(), srv.URL+"/")
+
+	breached, err := c.Breached(context.Background(), "password")
+	if err != nil || !breached {
+		t.Fatalf("Breached(password) = %v, %v, want true", breached, err)
+	}
+	if gotPath != "/range/"+passwordPrefix {
+		t.Errorf("path = %s, want /range/%s", gotPath, passwordPrefix)
+	}
+	if gotPadding != "true" {
+		t.Errorf("Add-Padding = %q, want true", gotPadding)
+	}
+
+	// a padding entry isn't a breach
+	body = passwordSuffix + ":0\r\n"
+	breached, err = c.Breached(context.Background(), "password")
+	if err != nil || breached {
+		t.Errorf("padding entry: got %v, %v, want false", breached, err)
+	}
+
+	body = "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n"
+	breached, err = c.Breached(context.Background(), "password")
+	if err != nil || breached {
+		t.Errorf("missing suffix: got %v, %v, want false", breached, err)
+	}
+}
+
+func TestBreachedStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	if _, err := New(srv.Client(), srv.URL).Breached(context.Background(), "password"); err == nil {
+		t.Error("unavailable API: got no error")
+	}
+}
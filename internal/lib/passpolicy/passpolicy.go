@@ -0,0 +1,150 @@
+package passpolicy
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"sso/internal/domain/models"
+)
+
+// Rules broken by a password, reported in models.PasswordViolation.
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleUpper     = "upper"
+	RuleLower     = "lower"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+	RuleEmail     = "email"
+	RuleCommon    = "common"
+	RuleBreached  = "breached"
+)
+
+// common holds the most frequent passwords from public breach dumps, one
+// lowercase password per line.
+//
+//go:embed common.txt
+var common string
+
+type Config struct {
+	MinLength int
+	// MaxLength counts bytes, bcrypt hashes ignore what is beyond 72.
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// RejectEmail refuses passwords that contain the local part of the
+	// user's email.
+	RejectEmail bool
+	// RejectCommon refuses the passwords of the bundled common list.
+	RejectCommon bool
+}
+
+// BreachChecker tells whether a password is known from a breach.
+type BreachChecker interface {
+	Breached(ctx context.Context, password string) (bool, error)
+}
+
+type Policy struct {
+	cfg      Config
+	common   map[string]struct{}
+	breaches BreachChecker
+}
+
+// New builds the policy. breaches may be nil to only check the bundled
+// list.
+func New(cfg Config, breaches BreachChecker) *Policy {
+	p := &Policy{cfg: cfg, breaches: breaches}
+
+	if cfg.RejectCommon {
+		p.common = make(map[string]struct{})
+		scanner := bufio.NewScanner(strings.NewReader(common))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				p.common[line] = struct{}{}
+			}
+		}
+	}
+
+	return p
+}
+
+// Check returns every rule password breaks for the user with email. The
+// breach check runs only for passwords that pass the other rules. When it
+// fails, the violations found so far come back with the error.
+func (p *Policy) Check(ctx context.Context, password, email string) ([]models.PasswordViolation, error) {
+	var violations []models.PasswordViolation
+	violate := func(rule, format string, args ...any) {
+		violations = append(violations, models.PasswordViolation{
+			Rule:        rule,
+			Description: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if utf8.RuneCountInString(password) < p.cfg.MinLength {
+		violate(RuleMinLength, "must be at least %d characters long", p.cfg.MinLength)
+	}
+	if p.cfg.MaxLength > 0 && len(password) > p.cfg.MaxLength {
+		violate(RuleMaxLength, "must be at most %d bytes long", p.cfg.MaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.cfg.RequireUpper && !upper {
+		violate(RuleUpper, "must contain an uppercase letter")
+	}
+	if p.cfg.RequireLower && !lower {
+		violate(RuleLower, "must contain a lowercase letter")
+	}
+	if p.cfg.RequireDigit && !digit {
+		violate(RuleDigit, "must contain a digit")
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		violate(RuleSymbol, "must contain a symbol")
+	}
+
+	if p.cfg.RejectEmail {
+		local, _, _ := strings.Cut(email, "@")
+		// very short local parts would reject half of all passwords
+		if len(local) >= 3 && strings.Contains(strings.ToLower(password), strings.ToLower(local)) {
+			violate(RuleEmail, "must not contain your email address")
+		}
+	}
+
+	if p.cfg.RejectCommon {
+		if _, ok := p.common[strings.ToLower(password)]; ok {
+			violate(RuleCommon, "is too common")
+		}
+	}
+
+	if len(violations) > 0 || p.breaches == nil {
+		return violations, nil
+	}
+
+	breached, err := p.breaches.Breached(ctx, password)
+	if err != nil {
+		return violations, fmt.Errorf("passpolicy.Check %w", err)
+	}
+	if breached {
+		violate(RuleBreached, "appeared in a data breach, choose another one")
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,105 @@
+package passpolicy
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type fakeBreaches struct {
+	breached bool
+	err      error
+	calls    int
+}
+
+func (f *fakeBreaches) Breached(ctx context.Context, password string) (bool, error) {
+	f.calls++
+	return f.breached, f.err
+}
+
+func rules(t *testing.T, p *Policy, password, email string) []string {
+	t.Helper()
+
+	violations, err := p.Check(context.Background(), password, email)
+	if err != nil {
+		t.Fatalf("Check(%q): %v", password, err)
+	}
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.Rule)
+	}
+	return got
+}
+
+func TestCheck(t *testing.T) {
+	p := New(Config{
+		MinLength:     8,
+		MaxLength:     16,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		RejectEmail:   true,
+		RejectCommon:  true,
+	}, nil)
+
+	testData := []struct {
+		password string
+		want     []string
+	}{
+		{"Tr0ub4dor&3x", nil},
+		{"Sh0rt!", []string{RuleMinLength}},
+		{"Way-T00-Long-For-This", []string{RuleMaxLength}},
+		{"n0-upper-case!", []string{RuleUpper}},
+		{"N0-LOWER-CASE!", []string{RuleLower}},
+		{"No-Digits-Here!", []string{RuleDigit}},
+		{"NoSymbols1234", []string{RuleSymbol}},
+		{"xJohnDoe1!", []string{RuleEmail}},
+		{"password", []string{RuleUpper, RuleDigit, RuleSymbol, RuleCommon}},
+		// the common list ignores case
+		{"PASSWORD", []string{RuleLower, RuleDigit, RuleSymbol, RuleCommon}},
+	}
+	for _, tt := range testData {
+		got := rules(t, p, tt.password, "johndoe@example.com")
+		if len(got) != len(tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCheckShortEmail(t *testing.T) {
+	p := New(Config{RejectEmail: true}, nil)
+
+	if got := rules(t, p, "ab-correct-horse", "ab@example.com"); len(got) != 0 {
+		t.Errorf("two letter local part: got %v, want no violations", got)
+	}
+}
+
+func TestCheckBreaches(t *testing.T) {
+	breaches := &fakeBreaches{breached: true}
+	p := New(Config{MinLength: 8}, breaches)
+
+	if got := rules(t, p, "short", ""); len(got) != 1 || got[0] != RuleMinLength {
+		t.Errorf("short password: got %v, want [%s]", got, RuleMinLength)
+	}
+	if breaches.calls != 0 {
+		t.Errorf("breach check ran for a password that already failed")
+	}
+
+	if got := rules(t, p, "correct horse battery", ""); len(got) != 1 || got[0] != RuleBreached {
+		t.Errorf("breached password: got %v, want [%s]", got, RuleBreached)
+	}
+
+	breaches.err = errors.New("unavailable")
+	violations, err := p.Check(context.Background(), "correct horse battery", "")
+	if err == nil || len(violations) != 0 {
+		t.Errorf("failed breach check: got %v, %v, want no violations and an error", violations, err)
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"log/slog"
 )
 
-func Err(err error) *slog.Attr {
-	return &slog.Attr{
+func Err(err error) slog.Attr {
+	return slog.Attr{
 		Key:   "error",
 		Value: slog.StringValue(err.Error()),
 	}
@@ -0,0 +1,62 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA1 seed. The RFC lists 8 digit codes, these are
+// their last 6 digits.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	testData := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range testData {
+		got := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code := Code(rfcSecret, Step(now))
+
+	step, ok := Validate(rfcSecret, code, now.Add(Period), 1)
+	if !ok || step != Step(now) {
+		t.Errorf("code from previous step: got step %d ok %v, want %d", step, ok, Step(now))
+	}
+
+	if _, ok := Validate(rfcSecret, code, now.Add(2*Period), 1); ok {
+		t.Error("code outside the skew window accepted")
+	}
+	if _, ok := Validate(rfcSecret, "12345", now, 1); ok {
+		t.Error("short code accepted")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(ProvisioningURI("sso", "rostyk@gmail.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/sso:rostyk@gmail.com" {
+		t.Errorf("got %s", uri)
+	}
+	if uri.Query().Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("secret = %s", uri.Query().Get("secret"))
+	}
+}
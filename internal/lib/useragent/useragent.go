@@ -0,0 +1,17 @@
+package useragent
+
+import "context"
+
+type ctxKey struct{}
+
+// NewContext returns ctx carrying the user agent the request was sent
+// with, for the audit log.
+func NewContext(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, userAgent)
+}
+
+// FromContext returns the user agent set by NewContext, empty when unknown.
+func FromContext(ctx context.Context) string {
+	userAgent, _ := ctx.Value(ctxKey{}).(string)
+	return userAgent
+}
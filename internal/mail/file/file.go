@@ -0,0 +1,64 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"sso/internal/mail"
+)
+
+// Mailer appends every message as a JSON line to a file instead of sending
+// it, for local development and tests.
+type Mailer struct {
+	mu   sync.Mutex
+	path string
+}
+
+// Record is one line of the file.
+type Record struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+func New(path string) *Mailer {
+	return &Mailer{path: path}
+}
+
+func (m *Mailer) Send(ctx context.Context, msg mail.Message) error {
+	const op = "mail.file.Send"
+
+	line, err := json.Marshal(Record{
+		To:      msg.To,
+		Subject: msg.Subject,
+		Body:    msg.Body,
+		SentAt:  time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
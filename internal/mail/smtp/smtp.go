@@ -0,0 +1,112 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"sso/internal/mail"
+)
+
+// Mailer delivers through an SMTP relay. The connection is upgraded with
+// STARTTLS when the server offers it, credentials are only sent over TLS
+// or to localhost.
+type Mailer struct {
+	host string
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func New(host string, port int, username, password, from string) *Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &Mailer{
+		host: host,
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		from: from,
+		auth: auth,
+	}
+}
+
+func (m *Mailer) Send(ctx context.Context, msg mail.Message) error {
+	const op = "mail.smtp.Send"
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return fmt.Errorf("%s %w", op, err)
+		}
+	}
+	if m.auth != nil {
+		if err := client.Auth(m.auth); err != nil {
+			return fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	if err := client.Mail(m.from); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if _, err := w.Write(m.compose(msg)); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := client.Quit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// compose renders the message in RFC 5322 form. The body is sent as is,
+// net/smtp takes care of line endings and dot stuffing.
+func (m *Mailer) compose(msg mail.Message) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+
+	return b.Bytes()
+}
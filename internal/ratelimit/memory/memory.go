@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sso/internal/ratelimit"
+)
+
+// sweepEvery is how many calls to Allow pass between two sweeps of idle
+// buckets.
+const sweepEvery = 1024
+
+// Limiter keeps the buckets in process memory, for a single instance.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket refills completely, after that it holds
+	// nothing a new bucket wouldn't.
+	full time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (l *Limiter) Allow(_ context.Context, buckets ...ratelimit.Bucket) (ratelimit.Result, error) {
+	return l.take(buckets, true), nil
+}
+
+func (l *Limiter) Check(_ context.Context, buckets ...ratelimit.Bucket) (ratelimit.Result, error) {
+	return l.take(buckets, false), nil
+}
+
+// take refills the buckets and, when every one of them has a token and
+// take is set, takes one from each.
+func (l *Limiter) take(buckets []ratelimit.Bucket, take bool) ratelimit.Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	res := ratelimit.Result{Allowed: true}
+	refilled := make([]*bucket, 0, len(buckets))
+
+	for _, bk := range buckets {
+		interval := bk.Limit.Interval()
+
+		b, ok := l.buckets[bk.Key]
+		if !ok {
+			b = &bucket{tokens: float64(bk.Limit.Burst), updated: now}
+			l.buckets[bk.Key] = b
+		}
+
+		b.tokens = min(float64(bk.Limit.Burst), b.tokens+float64(now.Sub(b.updated))/float64(interval))
+		b.updated = now
+		refilled = append(refilled, b)
+
+		if b.tokens < 1 {
+			res.Allowed = false
+			res.RetryAfter = max(res.RetryAfter, time.Duration((1-b.tokens)*float64(interval)))
+		}
+	}
+
+	if !res.Allowed || !take {
+		return res
+	}
+
+	for i, b := range refilled {
+		interval := buckets[i].Limit.Interval()
+
+		b.tokens--
+		b.full = now.Add(time.Duration((float64(buckets[i].Limit.Burst) - b.tokens) * float64(interval)))
+	}
+
+	return res
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.After(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/ratelimit"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New()
+	l.now = func() time.Time { return now }
+
+	limit := ratelimit.Limit{Rate: 1, Per: time.Second, Burst: 3}
+	ctx := context.Background()
+
+	for i := range limit.Burst {
+		res, err := l.Allow(ctx, ratelimit.Bucket{Key: "a", Limit: limit})
+		if err != nil || !res.Allowed {
+			t.Fatalf("call %d: got %+v %v, want allowed", i, res, err)
+		}
+	}
+
+	res, _ := l.Allow(ctx, ratelimit.Bucket{Key: "a", Limit: limit})
+	if res.Allowed || res.RetryAfter != time.Second {
+		t.Errorf("empty bucket: got %+v, want retry after 1s", res)
+	}
+
+	if res, _ := l.Allow(ctx, ratelimit.Bucket{Key: "b", Limit: limit}); !res.Allowed {
+		t.Error("other key shares the bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	res, _ = l.Allow(ctx, ratelimit.Bucket{Key: "a", Limit: limit})
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("half a token: got %+v, want retry after 500ms", res)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if res, _ := l.Allow(ctx, ratelimit.Bucket{Key: "a", Limit: limit}); !res.Allowed {
+		t.Error("refilled token refused")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New()
+	l.now = func() time.Time { return now }
+
+	limit := ratelimit.Limit{Rate: 1, Per: time.Second, Burst: 2}
+	l.Allow(context.Background(), ratelimit.Bucket{Key: "a", Limit: limit})
+
+	l.sweep(now)
+	if _, ok := l.buckets["a"]; !ok {
+		t.Fatal("bucket swept before it refilled")
+	}
+
+	l.sweep(now.Add(2 * time.Second))
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("full bucket kept")
+	}
+}
+
+func TestAllowAllOrNothing(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New()
+	l.now = func() time.Time { return now }
+
+	wide := ratelimit.Bucket{Key: "ip", Limit: ratelimit.Limit{Rate: 1, Per: time.Second, Burst: 3}}
+	narrow := ratelimit.Bucket{Key: "user", Limit: ratelimit.Limit{Rate: 1, Per: 2 * time.Second, Burst: 1}}
+	ctx := context.Background()
+
+	if res, _ := l.Allow(ctx, wide, narrow); !res.Allowed {
+		t.Fatal("full buckets refused")
+	}
+
+	res, _ := l.Check(ctx, wide, narrow)
+	if res.Allowed || res.RetryAfter != 2*time.Second {
+		t.Errorf("check of an empty bucket: got %+v, want retry after 2s", res)
+	}
+
+	// the refused calls leave the other bucket alone
+	for range 3 {
+		if res, _ := l.Allow(ctx, wide, narrow); res.Allowed {
+			t.Fatal("empty bucket allowed")
+		}
+	}
+	for i := range 2 {
+		if res, _ := l.Allow(ctx, wide); !res.Allowed {
+			t.Fatalf("call %d: tokens taken by refused calls", i)
+		}
+	}
+
+	if res, _ := l.Check(ctx, wide); res.Allowed {
+		t.Error("check of an empty bucket allowed")
+	}
+	now = now.Add(time.Second)
+	if res, _ := l.Check(ctx, wide); !res.Allowed {
+		t.Error("check of a refilled bucket refused")
+	}
+	if res, _ := l.Allow(ctx, wide); !res.Allowed {
+		t.Error("check took the token")
+	}
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is a token bucket: Rate tokens are added every Per, up to Burst.
+type Limit struct {
+	Rate  int
+	Per   time.Duration
+	Burst int
+}
+
+// Interval is the time it takes to add one token.
+func (l Limit) Interval() time.Duration {
+	return l.Per / time.Duration(l.Rate)
+}
+
+// Result tells whether a request got a token and, when it didn't, how
+// long until the bucket has one.
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Bucket is the bucket under Key, filled by Limit.
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// Limiter keeps one bucket per key, creating it full on first use. Allow
+// takes a token from each of the buckets when all of them have one and
+// from none otherwise, so a call one rule refuses doesn't use up the
+// others; RetryAfter is then the longest wait. Check answers the same
+// without taking.
+type Limiter interface {
+	Allow(ctx context.Context, buckets ...Bucket) (Result, error)
+	Check(ctx context.Context, buckets ...Bucket) (Result, error)
+}
@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sso/internal/ratelimit"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// allowScript refills the buckets and takes from all of them in one step,
+// so instances sharing the server never hand out the same token. Time is
+// the server's, the instances' clocks don't have to agree.
+//
+// KEYS the buckets, ARGV[1] 1 to take, then microseconds per token and
+// burst of each bucket. Returns {allowed, microseconds to wait}.
+var allowScript = goredis.NewScript(`
+local take = ARGV[1] == "1"
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local tokens = {}
+local wait = 0
+for i, key in ipairs(KEYS) do
+	local interval = tonumber(ARGV[i * 2])
+	local burst = tonumber(ARGV[i * 2 + 1])
+
+	local state = redis.call("HMGET", key, "tokens", "updated")
+	local left = tonumber(state[1])
+	local updated = tonumber(state[2])
+	if left == nil or updated == nil then
+		left = burst
+		updated = now
+	end
+
+	tokens[i] = math.min(burst, left + (now - updated) / interval)
+	if tokens[i] < 1 then
+		wait = math.max(wait, math.ceil((1 - tokens[i]) * interval))
+	end
+end
+
+if wait > 0 then
+	return {0, wait}
+end
+if not take then
+	return {1, 0}
+end
+
+for i, key in ipairs(KEYS) do
+	local interval = tonumber(ARGV[i * 2])
+	local burst = tonumber(ARGV[i * 2 + 1])
+	local left = tokens[i] - 1
+
+	redis.call("HSET", key, "tokens", tostring(left), "updated", tostring(now))
+	redis.call("PEXPIRE", key, math.ceil((burst - left) * interval / 1000) + 1)
+end
+
+return {1, 0}
+`)
+
+// Limiter keeps the buckets in Redis, shared by every instance that uses
+// the same server and prefix.
+type Limiter struct {
+	client goredis.Scripter
+	prefix string
+}
+
+func New(client goredis.Scripter, prefix string) *Limiter {
+	return &Limiter{client: client, prefix: prefix}
+}
+
+func (l *Limiter) Allow(ctx context.Context, buckets ...ratelimit.Bucket) (ratelimit.Result, error) {
+	const op = "ratelimit.redis.Allow"
+
+	return l.run(ctx, op, buckets, true)
+}
+
+func (l *Limiter) Check(ctx context.Context, buckets ...ratelimit.Bucket) (ratelimit.Result, error) {
+	const op = "ratelimit.redis.Check"
+
+	return l.run(ctx, op, buckets, false)
+}
+
+func (l *Limiter) run(ctx context.Context, op string, buckets []ratelimit.Bucket, take bool) (ratelimit.Result, error) {
+	if len(buckets) == 0 {
+		return ratelimit.Result{Allowed: true}, nil
+	}
+
+	keys := make([]string, 0, len(buckets))
+	args := make([]any, 0, 1+2*len(buckets))
+	args = append(args, 0)
+	if take {
+		args[0] = 1
+	}
+	for _, b := range buckets {
+		keys = append(keys, l.prefix+b.Key)
+		args = append(args, b.Limit.Interval().Microseconds(), b.Limit.Burst)
+	}
+
+	res, err := allowScript.Run(ctx, l.client, keys, args...).Int64Slice()
+	if err != nil {
+		return ratelimit.Result{}, fmt.Errorf("%s %w", op, err)
+	}
+	if len(res) != 2 {
+		return ratelimit.Result{}, fmt.Errorf("%s unexpected script result %v", op, res)
+	}
+
+	return ratelimit.Result{
+		Allowed:    res[0] == 1,
+		RetryAfter: time.Duration(res[1]) * time.Microsecond,
+	}, nil
+}
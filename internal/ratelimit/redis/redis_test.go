@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/ratelimit"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func TestAllow(t *testing.T) {
+	srv := miniredis.RunT(t)
+	now := time.Unix(1700000000, 0)
+	srv.SetTime(now)
+
+	client := goredis.NewClient(&goredis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	limit := ratelimit.Limit{Rate: 1, Per: time.Second, Burst: 3}
+	ctx := context.Background()
+
+	// two instances sharing the server share the buckets
+	first, second := New(client, "test:"), New(client, "test:")
+
+	for i := range limit.Burst {
+		l := first
+		if i%2 == 1 {
+			l = second
+		}
+		res, err := l.Allow(ctx, ratelimit.Bucket{Key: "a", Limit: limit})
+		if err != nil || !res.Allowed {
+			t.Fatalf("call %d: got %+v %v, want allowed", i, res, err)
+		}
+	}
+
+	res, err := second.Allow(ctx, ratelimit.Bucket{Key: "a", Limit: limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.RetryAfter != time.Second {
+		t.Errorf("empty bucket: got %+v, want retry after 1s", res)
+	}
+
+	if res, _ := first.Allow(ctx, ratelimit.Bucket{Key: "b", Limit: limit}); !res.Allowed {
+		t.Error("other key shares the bucket")
+	}
+	if res, _ := New(client, "other:").Allow(ctx, ratelimit.Bucket{Key: "a", Limit: limit}); !res.Allowed {
+		t.Error("other prefix shares the bucket")
+	}
+
+	srv.SetTime(now.Add(time.Second))
+	if res, _ := first.Allow(ctx, ratelimit.Bucket{Key: "a", Limit: limit}); !res.Allowed {
+		t.Error("refilled token refused")
+	}
+
+	if ttl := srv.TTL("test:a"); ttl <= 0 || ttl > 4*time.Second {
+		t.Errorf("bucket ttl = %s, want until it refills", ttl)
+	}
+}
+
+func TestAllowServerDown(t *testing.T) {
+	srv := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: srv.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	srv.Close()
+
+	_, err := New(client, "test:").Allow(context.Background(), ratelimit.Bucket{Key: "a", Limit: ratelimit.Limit{Rate: 1, Per: time.Second, Burst: 1}})
+	if err == nil {
+		t.Error("no error with the server down")
+	}
+}
+
+func TestAllowAllOrNothing(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.SetTime(time.Unix(1700000000, 0))
+
+	client := goredis.NewClient(&goredis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	l := New(client, "test:")
+	wide := ratelimit.Bucket{Key: "ip", Limit: ratelimit.Limit{Rate: 1, Per: time.Second, Burst: 3}}
+	narrow := ratelimit.Bucket{Key: "user", Limit: ratelimit.Limit{Rate: 1, Per: 2 * time.Second, Burst: 1}}
+	ctx := context.Background()
+
+	if res, err := l.Allow(ctx, wide, narrow); err != nil || !res.Allowed {
+		t.Fatalf("full buckets: got %+v %v, want allowed", res, err)
+	}
+
+	res, err := l.Check(ctx, wide, narrow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.RetryAfter != 2*time.Second {
+		t.Errorf("check of an empty bucket: got %+v, want retry after 2s", res)
+	}
+
+	// the refused calls leave the other bucket alone
+	for range 3 {
+		if res, _ := l.Allow(ctx, wide, narrow); res.Allowed {
+			t.Fatal("empty bucket allowed")
+		}
+	}
+	for i := range 2 {
+		if res, _ := l.Allow(ctx, wide); !res.Allowed {
+			t.Fatalf("call %d: tokens taken by refused calls", i)
+		}
+	}
+	if res, _ := l.Check(ctx, wide); res.Allowed {
+		t.Error("check of an empty bucket allowed")
+	}
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/jwt"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+type Storage interface {
+	AuditChainHead(ctx context.Context) (eventID int64, hash string, err error)
+	AuditEventsAfter(ctx context.Context, afterID int64, limit int) ([]models.AuditEvent, error)
+	SaveAuditCheckpoint(ctx context.Context, checkpoint models.AuditCheckpoint) error
+	LastAuditCheckpoint(ctx context.Context) (models.AuditCheckpoint, error)
+	AuditCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error)
+}
+
+// Checkpointer periodically signs the head of the audit chain with the
+// server signing key. A checkpoint pins every event up to it: rewriting
+// them would need the private key to sign the new hash.
+type Checkpointer struct {
+	log      *slog.Logger
+	storage  Storage
+	keys     jwt.KeyProvider
+	issuer   string
+	interval time.Duration
+	stop     chan struct{}
+}
+
+func NewCheckpointer(log *slog.Logger, storage Storage, keys jwt.KeyProvider, issuer string, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		log:      log,
+		storage:  storage,
+		keys:     keys,
+		issuer:   issuer,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Run makes a checkpoint once per interval until Stop is called.
+func (c *Checkpointer) Run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.Checkpoint(context.Background()); err != nil {
+				c.log.Error("faild to checkpoint audit chain", sl.Err(err))
+			}
+		}
+	}
+}
+
+func (c *Checkpointer) Stop() {
+	close(c.stop)
+}
+
+// Checkpoint signs the current head of the audit chain, unless no event
+// was added since the last checkpoint.
+func (c *Checkpointer) Checkpoint(ctx context.Context) error {
+	const op = "audit.Checkpoint"
+
+	log := c.log.With(slog.String("op", op))
+
+	eventID, hash, err := c.storage.AuditChainHead(ctx)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if eventID == 0 {
+		return nil
+	}
+
+	last, err := c.storage.LastAuditCheckpoint(ctx)
+	if err != nil && !errors.Is(err, storage.ErrCheckpointNotFound) {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if last.EventID == eventID {
+		return nil
+	}
+
+	key := c.keys.SigningKey()
+
+	signature, err := jwt.SignCheckpoint(key, c.issuer, eventID, hash)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	publicKey, err := json.Marshal(key.JWK())
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	err = c.storage.SaveAuditCheckpoint(ctx, models.AuditCheckpoint{
+		EventID:   eventID,
+		Hash:      hash,
+		Signature: signature,
+		PublicKey: publicKey,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Debug("audit chain checkpointed", slog.Int64("eventID", eventID), slog.String("kid", key.ID))
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"sso/internal/domain/models"
+	"sso/internal/jwt"
+	"sso/internal/lib/auditchain"
+)
+
+// verifyBatch is how many events Verify reads at a time.
+const verifyBatch = 500
+
+// Report is the outcome of walking the audit chain.
+type Report struct {
+	// Events is how many chained events were verified.
+	Events int64
+	// Unchained counts the events saved before hash chaining, which come
+	// before the chain and can't be verified.
+	Unchained int64
+	// Unsigned counts the verified events after the last checkpoint, only
+	// the chain vouches for them.
+	Unsigned    int64
+	LastEventID int64
+	Checkpoints int
+	// Keys are the ids of the keys that signed the checkpoints.
+	Keys []string
+	// Broken is the first broken link, nil when the chain is intact.
+	Broken *BrokenLink
+}
+
+// BrokenLink is the first event that fails verification, events before it
+// are intact.
+type BrokenLink struct {
+	EventID int64
+	Reason  string
+}
+
+func (b *BrokenLink) String() string {
+	return fmt.Sprintf("event %d: %s", b.EventID, b.Reason)
+}
+
+// Verify walks the audit chain from the first event, checking that each
+// event's hash covers its content and the hash of the one before it, and
+// that every checkpoint's signature and hash hold. Checkpoints are verified
+// with the trusted keys only, one signed by any other key breaks the
+// chain: the public key stored with a checkpoint is in the same database
+// as the events it vouches for. It stops at the first broken link. emit,
+// if not nil, is called with each verified event in order, so the
+// verified log can be exported in the same pass.
+func Verify(ctx context.Context, storage Storage, trusted []jwt.JSONWebKey, emit func(models.AuditEvent) error) (Report, error) {
+	const op = "audit.Verify"
+
+	var report Report
+
+	checkpoints, err := storage.AuditCheckpoints(ctx)
+	if err != nil {
+		return report, fmt.Errorf("%s %w", op, err)
+	}
+
+	keys := make(map[string]jwt.JSONWebKey, len(trusted))
+	for _, key := range trusted {
+		keys[key.Kid] = key
+	}
+
+	// what each checkpointed event must hash to, or why its checkpoint is void
+	signed := make(map[int64][]models.AuditCheckpoint)
+	invalid := make(map[int64]string)
+	var lastSigned int64
+	for _, checkpoint := range checkpoints {
+		if reason := verifyCheckpoint(checkpoint, keys); reason != "" {
+			if _, ok := invalid[checkpoint.EventID]; !ok {
+				invalid[checkpoint.EventID] = reason
+			}
+			continue
+		}
+		signed[checkpoint.EventID] = append(signed[checkpoint.EventID], checkpoint)
+		lastSigned = max(lastSigned, checkpoint.EventID)
+	}
+
+	var (
+		prevHash string
+		chained  bool
+		afterID  int64
+	)
+	for {
+		events, err := storage.AuditEventsAfter(ctx, afterID, verifyBatch)
+		if err != nil {
+			return report, fmt.Errorf("%s %w", op, err)
+		}
+		if len(events) == 0 {
+			break
+		}
+
+		for _, event := range events {
+			afterID = event.ID
+
+			if !chained && event.Hash == "" {
+				report.Unchained++
+				continue
+			}
+			chained = true
+
+			if reason := verifyEvent(event, prevHash, signed[event.ID], invalid[event.ID]); reason != "" {
+				report.Broken = &BrokenLink{EventID: event.ID, Reason: reason}
+				return report, nil
+			}
+
+			if emit != nil {
+				if err := emit(event); err != nil {
+					return report, fmt.Errorf("%s %w", op, err)
+				}
+			}
+
+			report.Events++
+			report.LastEventID = event.ID
+			if event.ID > lastSigned {
+				report.Unsigned++
+			}
+			for _, checkpoint := range signed[event.ID] {
+				report.Checkpoints++
+				if kid := checkpointKid(checkpoint); !slices.Contains(report.Keys, kid) {
+					report.Keys = append(report.Keys, kid)
+				}
+			}
+			prevHash = event.Hash
+		}
+	}
+
+	// a checkpoint past the end means the newest events were cut off
+	var missing int64
+	for eventID := range signed {
+		if eventID > report.LastEventID && (missing == 0 || eventID < missing) {
+			missing = eventID
+		}
+	}
+	for eventID := range invalid {
+		if eventID > report.LastEventID && (missing == 0 || eventID < missing) {
+			missing = eventID
+		}
+	}
+	if missing != 0 {
+		report.Broken = &BrokenLink{EventID: missing, Reason: "event signed by a checkpoint is missing"}
+	}
+
+	return report, nil
+}
+
+func verifyEvent(event models.AuditEvent, prevHash string, checkpoints []models.AuditCheckpoint, invalidCheckpoint string) string {
+	if event.Hash == "" {
+		return "event isn't chained"
+	}
+	if event.PrevHash != prevHash {
+		return "previous hash doesn't match the event before it"
+	}
+
+	hash, err := auditchain.Hash(event.PrevHash, event)
+	if err != nil {
+		return err.Error()
+	}
+	if hash != event.Hash {
+		return "content doesn't match its hash"
+	}
+
+	if invalidCheckpoint != "" {
+		return invalidCheckpoint
+	}
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Hash != event.Hash {
+			return fmt.Sprintf("checkpoint %d signed another hash", checkpoint.ID)
+		}
+	}
+
+	return ""
+}
+
+// verifyCheckpoint checks the signature of checkpoint with the trusted key
+// its kid names and that it signs the event and hash it is stored with.
+func verifyCheckpoint(checkpoint models.AuditCheckpoint, keys map[string]jwt.JSONWebKey) string {
+	kid := checkpointKid(checkpoint)
+	key, ok := keys[kid]
+	if !ok {
+		return fmt.Sprintf("checkpoint %d is signed by unknown key %q", checkpoint.ID, kid)
+	}
+
+	claims, err := jwt.ParseCheckpoint(checkpoint.Signature, key)
+	if err != nil {
+		return fmt.Sprintf("checkpoint %d signature doesn't verify", checkpoint.ID)
+	}
+	if claims.EventID != checkpoint.EventID || claims.Hash != checkpoint.Hash {
+		return fmt.Sprintf("checkpoint %d doesn't match what it signed", checkpoint.ID)
+	}
+
+	return ""
+}
+
+func checkpointKid(checkpoint models.AuditCheckpoint) string {
+	var key jwt.JSONWebKey
+	_ = json.Unmarshal(checkpoint.PublicKey, &key)
+	return key.Kid
+}
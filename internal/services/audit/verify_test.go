@@ -0,0 +1,261 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/jwt"
+	"sso/internal/lib/auditchain"
+	"sso/internal/storage"
+)
+
+type memoryAuditStorage struct {
+	events      []models.AuditEvent
+	checkpoints []models.AuditCheckpoint
+}
+
+// append chains event like the sqlite storage does; legacy events are
+// stored without hashes, as before chaining.
+func (s *memoryAuditStorage) append(t *testing.T, event models.AuditEvent, legacy bool) {
+	t.Helper()
+
+	event.ID = int64(len(s.events) + 1)
+	if !legacy {
+		if len(s.events) > 0 {
+			event.PrevHash = s.events[len(s.events)-1].Hash
+		}
+		hash, err := auditchain.Hash(event.PrevHash, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event.Hash = hash
+	}
+	s.events = append(s.events, event)
+}
+
+func (s *memoryAuditStorage) AuditChainHead(_ context.Context) (int64, string, error) {
+	if len(s.events) == 0 || s.events[len(s.events)-1].Hash == "" {
+		return 0, "", nil
+	}
+	last := s.events[len(s.events)-1]
+	return last.ID, last.Hash, nil
+}
+
+func (s *memoryAuditStorage) AuditEventsAfter(_ context.Context, afterID int64, limit int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	for _, event := range s.events {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryAuditStorage) SaveAuditCheckpoint(_ context.Context, checkpoint models.AuditCheckpoint) error {
+	checkpoint.ID = int64(len(s.checkpoints) + 1)
+	s.checkpoints = append(s.checkpoints, checkpoint)
+	return nil
+}
+
+func (s *memoryAuditStorage) LastAuditCheckpoint(_ context.Context) (models.AuditCheckpoint, error) {
+	if len(s.checkpoints) == 0 {
+		return models.AuditCheckpoint{}, storage.ErrCheckpointNotFound
+	}
+	return s.checkpoints[len(s.checkpoints)-1], nil
+}
+
+func (s *memoryAuditStorage) AuditCheckpoints(_ context.Context) ([]models.AuditCheckpoint, error) {
+	return s.checkpoints, nil
+}
+
+// newTestChain stores 2 legacy events and 6 chained ones, with checkpoints
+// after events 4 and 6.
+func newTestChain(t *testing.T) (*memoryAuditStorage, *jwt.Key) {
+	t.Helper()
+
+	key, err := jwt.GenerateKey(jwt.AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &memoryAuditStorage{}
+	c := NewCheckpointer(slog.New(slog.NewTextHandler(io.Discard, nil)), s, jwt.NewStaticKeys(key), "sso", time.Hour)
+	ctx := context.Background()
+
+	for i := range 8 {
+		s.append(t, models.AuditEvent{
+			Type:      models.AuditLogin,
+			UserID:    int64(i + 1),
+			Outcome:   models.AuditSuccess,
+			Details:   map[string]string{"method": "password"},
+			CreatedAt: time.Date(2026, 10, 17, 12, i, 0, 0, time.UTC),
+		}, i < 2)
+
+		if i == 3 || i == 5 {
+			if err := c.Checkpoint(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// nothing new, no second checkpoint of the same head
+	if err := c.Checkpoint(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Checkpoint(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.checkpoints) != 3 {
+		t.Fatalf("got %d checkpoints, want 3", len(s.checkpoints))
+	}
+
+	return s, key
+}
+
+func TestVerifyIntact(t *testing.T) {
+	s, key := newTestChain(t)
+
+	var emitted []int64
+	report, err := Verify(context.Background(), s, []jwt.JSONWebKey{key.JWK()}, func(event models.AuditEvent) error {
+		emitted = append(emitted, event.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Broken != nil {
+		t.Fatalf("intact chain reported broken at %s", report.Broken)
+	}
+	if report.Events != 6 || report.Unchained != 2 || report.Unsigned != 0 || report.LastEventID != 8 || report.Checkpoints != 3 {
+		t.Errorf("got report %+v", report)
+	}
+	if len(report.Keys) != 1 || report.Keys[0] != key.ID {
+		t.Errorf("got keys %v, want [%s]", report.Keys, key.ID)
+	}
+	if len(emitted) != 6 || emitted[0] != 3 || emitted[5] != 8 {
+		t.Errorf("emitted %v, want events 3 to 8", emitted)
+	}
+}
+
+func TestVerifyBroken(t *testing.T) {
+	other, err := jwt.GenerateKey(jwt.AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		name    string
+		tamper  func(s *memoryAuditStorage)
+		eventID int64
+		reason  string
+	}{
+		{
+			name:    "edited event",
+			tamper:  func(s *memoryAuditStorage) { s.events[4].Outcome = models.AuditFailure },
+			eventID: 5,
+			reason:  "content",
+		},
+		{
+			name:    "deleted event",
+			tamper:  func(s *memoryAuditStorage) { s.events = append(s.events[:3], s.events[4:]...) },
+			eventID: 5,
+			reason:  "previous hash",
+		},
+		{
+			name: "rehashed event",
+			tamper: func(s *memoryAuditStorage) {
+				s.events[2].IP = "10.0.0.1"
+				s.events[2].Hash, _ = auditchain.Hash(s.events[2].PrevHash, s.events[2])
+			},
+			eventID: 4,
+			reason:  "previous hash",
+		},
+		{
+			name: "rewritten chain",
+			tamper: func(s *memoryAuditStorage) {
+				// every hash redone after the edit, only the checkpoint tells
+				s.events[2].IP = "10.0.0.1"
+				for i := 2; i < len(s.events); i++ {
+					if i > 2 {
+						s.events[i].PrevHash = s.events[i-1].Hash
+					}
+					s.events[i].Hash, _ = auditchain.Hash(s.events[i].PrevHash, s.events[i])
+				}
+			},
+			eventID: 4,
+			reason:  "checkpoint 1 signed another hash",
+		},
+		{
+			name: "forged checkpoint",
+			tamper: func(s *memoryAuditStorage) {
+				s.checkpoints[0].Signature, _ = jwt.SignCheckpoint(other, "sso", 4, s.checkpoints[0].Hash)
+			},
+			eventID: 4,
+			reason:  "checkpoint 1 signature",
+		},
+		{
+			name: "swapped key and signature",
+			tamper: func(s *memoryAuditStorage) {
+				// a checkpoint that verifies with the key stored next to it
+				s.checkpoints[0].PublicKey, _ = json.Marshal(other.JWK())
+				s.checkpoints[0].Signature, _ = jwt.SignCheckpoint(other, "sso", 4, s.checkpoints[0].Hash)
+			},
+			eventID: 4,
+			reason:  "checkpoint 1 is signed by unknown key",
+		},
+		{
+			name:    "truncated tail",
+			tamper:  func(s *memoryAuditStorage) { s.events = s.events[:5] },
+			eventID: 6,
+			reason:  "missing",
+		},
+		{
+			name:    "unchained event",
+			tamper:  func(s *memoryAuditStorage) { s.events[6].Hash = "" },
+			eventID: 7,
+			reason:  "isn't chained",
+		},
+	}
+
+	for _, tt := range testData {
+		t.Run(tt.name, func(t *testing.T) {
+			s, key := newTestChain(t)
+			tt.tamper(s)
+
+			report, err := Verify(context.Background(), s, []jwt.JSONWebKey{key.JWK()}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Broken == nil {
+				t.Fatal("tampered chain reported intact")
+			}
+			if report.Broken.EventID != tt.eventID || !strings.Contains(report.Broken.Reason, tt.reason) {
+				t.Errorf("got broken link %s, want event %d: %s", report.Broken, tt.eventID, tt.reason)
+			}
+		})
+	}
+}
+
+func TestVerifyUntrustedKey(t *testing.T) {
+	s, _ := newTestChain(t)
+
+	other, err := jwt.GenerateKey(jwt.AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Verify(context.Background(), s, []jwt.JSONWebKey{other.JWK()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Broken == nil || report.Broken.EventID != 4 || !strings.Contains(report.Broken.Reason, "unknown key") {
+		t.Errorf("got broken link %v, want event 4 signed by an unknown key", report.Broken)
+	}
+}
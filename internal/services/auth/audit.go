@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/sl"
+	"sso/internal/lib/useragent"
+	"sso/internal/storage"
+)
+
+const (
+	auditPageSize    = 50
+	auditMaxPageSize = 500
+)
+
+// auditReasons name the failure of an audited action in the event details,
+// without the internals of the error.
+var auditReasons = []struct {
+	err    error
+	reason string
+}{
+	{storage.ErrInvalidCredentials, "invalid_credentials"},
+	{storage.ErrAccountLocked, "account_locked"},
+	{storage.ErrLoginThrottled, "login_throttled"},
+	{storage.ErrEmailNotVerified, "email_not_verified"},
+	{storage.ErrInvalidToken, "invalid_token"},
+	{storage.ErrInvalidRefreshToken, "invalid_refresh_token"},
+	{storage.ErrInvalidClient, "invalid_client"},
+	{storage.ErrInvalidGrant, "invalid_grant"},
+	{storage.ErrInvalidScope, "invalid_scope"},
+	{storage.ErrAccessDenied, "access_denied"},
+	{storage.ErrExpiredToken, "expired_token"},
+	{storage.ErrInvalidMFAToken, "invalid_mfa_token"},
+	{storage.ErrInvalidMFACode, "invalid_mfa_code"},
+	{storage.ErrPermissionDenied, "permission_denied"},
+	{storage.ErrUserExists, "user_exists"},
+	{storage.ErrUserNotFound, "user_not_found"},
+	{storage.ErrWeakPassword, "weak_password"},
+	{storage.ErrInvalidResetToken, "invalid_reset_token"},
+	{storage.ErrInvalidPasswordless, "invalid_passwordless_token"},
+	{storage.ErrInvalidPasswordlessCode, "invalid_passwordless_code"},
+	{storage.ErrInvalidWebAuthn, "invalid_webauthn"},
+	{storage.ErrInvalidCeremony, "invalid_webauthn"},
+	{storage.ErrAppNotFound, "app_not_found"},
+	{storage.ErrRoleExists, "role_exists"},
+	{storage.ErrRoleNotFound, "role_not_found"},
+	{storage.ErrRoleNotAssigned, "role_not_assigned"},
+	{storage.ErrRoleProtected, "role_protected"},
+	{storage.ErrGroupExists, "group_exists"},
+	{storage.ErrGroupNotFound, "group_not_found"},
+	{storage.ErrGroupCycle, "group_cycle"},
+	{storage.ErrNotGroupMember, "not_group_member"},
+	{storage.ErrPolicyExists, "policy_exists"},
+	{storage.ErrPolicyNotFound, "policy_not_found"},
+	{storage.ErrInvalidPolicy, "invalid_policy"},
+	{storage.ErrInvalidAttribute, "invalid_attribute"},
+}
+
+// audit saves event when the caller returns, with the outcome told by the
+// error it returns, or set on event by a caller that succeeded with another
+// outcome than AuditSuccess. Deferred as soon as the action starts, no
+// return path skips the event:
+//
+//	event := models.AuditEvent{Type: models.AuditLogin}
+//	defer a.audit(ctx, log, &event, &err)
+//
+// If the event can't be saved a successful call fails instead, an action
+// that left no trace isn't reported as done.
+func (a *Auth) audit(ctx context.Context, log *slog.Logger, event *models.AuditEvent, errp *error) {
+	switch {
+	case errors.Is(*errp, storage.ErrMFARequired):
+		event.Outcome = models.AuditMFARequired
+	case *errp != nil:
+		event.Outcome = models.AuditFailure
+		if event.Details == nil {
+			event.Details = make(map[string]string)
+		}
+		event.Details["reason"] = auditReason(*errp)
+	case event.Outcome == "":
+		event.Outcome = models.AuditSuccess
+	}
+
+	if err := a.recordAudit(ctx, *event); err != nil {
+		log.Error("faild to save audit event", sl.Err(err))
+		if *errp == nil {
+			*errp = err
+		}
+	}
+}
+
+// recordAudit saves event with the address and user agent of the client.
+func (a *Auth) recordAudit(ctx context.Context, event models.AuditEvent) error {
+	if event.Outcome == "" {
+		event.Outcome = models.AuditSuccess
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	event.IP = clientip.FromContext(ctx)
+	event.UserAgent = useragent.FromContext(ctx)
+
+	// a client that hangs up doesn't cancel the trace of what it did
+	return a.storage.SaveAuditEvent(context.WithoutCancel(ctx), event)
+}
+
+// tokenIssuedEvent is the audit event of a token endpoint grant to appID.
+func tokenIssuedEvent(appID int64, grantType string) models.AuditEvent {
+	return models.AuditEvent{
+		Type:    models.AuditTokenIssued,
+		AppID:   appID,
+		Details: map[string]string{"grant": grantType},
+	}
+}
+
+func auditReason(err error) string {
+	for _, r := range auditReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "error"
+}
+
+// requirePermission returns the user of the access token and, if no role
+// they hold in every app grants permission, ErrPermissionDenied with them.
+func (a *Auth) requirePermission(ctx context.Context, log *slog.Logger, accessToken, permission string) (models.User, error) {
+	user, err := a.userFromToken(ctx, accessToken)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	allowed, err := a.storage.HasPermission(ctx, user.ID, 0, permission)
+	if err != nil {
+		log.Error("faild to check permission", sl.Err(err))
+		return models.User{}, err
+	}
+	if !allowed {
+		log.Warn("action requested without permission", slog.Int64("actorID", user.ID), slog.String("permission", permission))
+		return user, storage.ErrPermissionDenied
+	}
+
+	return user, nil
+}
+
+// ListAuditEvents returns a page of the audit events matching filter,
+// newest first, and the BeforeID of the next page, zero on the last one.
+// The access token must carry the audit:read permission.
+func (a *Auth) ListAuditEvents(ctx context.Context, accessToken string, filter models.AuditFilter) ([]models.AuditEvent, int64, error) {
+	const op = "New.ListAuditEvents"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if _, err := a.requirePermission(ctx, log, accessToken, models.PermissionAuditRead); err != nil {
+		return nil, 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = auditPageSize
+	}
+	limit = min(limit, auditMaxPageSize)
+
+	// one more than asked tells whether another page follows
+	filter.Limit = limit + 1
+
+	events, err := a.storage.AuditEvents(ctx, filter)
+	if err != nil {
+		log.Error("faild to list audit events", sl.Err(err))
+		return nil, 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	var next int64
+	if len(events) > limit {
+		events = events[:limit]
+		next = events[limit-1].ID
+	}
+
+	return events, next, nil
+}
+
+// SetAdmin assigns or unassigns the admin role of a user in every app. The
+// access token must carry the roles:manage permission.
+func (a *Auth) SetAdmin(ctx context.Context, accessToken string, userID int64, isAdmin bool) (err error) {
+	const op = "New.SetAdmin"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("userID", userID),
+		slog.Bool("isAdmin", isAdmin),
+	)
+
+	event := models.AuditEvent{Type: models.AuditAdminGranted, UserID: userID}
+	if !isAdmin {
+		event.Type = models.AuditAdminRevoked
+	}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.storage.SetAdmin(ctx, userID, isAdmin); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user is not exists")
+			return fmt.Errorf("%s %w", op, storage.ErrUserNotFound)
+		}
+		log.Error("faild to set admin", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("admin rights succefully changed", slog.Int64("adminID", admin.ID))
+
+	return nil
+}
@@ -8,16 +8,20 @@ import (
 	"time"
 
 	"sso/internal/jwt"
+	"sso/internal/lib/aead"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/opaque"
+	"sso/internal/mail"
 	"sso/internal/storage"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/go-webauthn/webauthn/webauthn"
 
 	"sso/internal/domain/models"
 	"sso/internal/lib/sl"
 )
 
-func HashPassword(log *slog.Logger, password, op string) ([]byte, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func (a *Auth) hashPassword(log *slog.Logger, password, op string) ([]byte, error) {
+	hashed, err := a.hasher.Hash(password)
 	if err != nil {
 		log.Error("Error while hash password", sl.Err(err))
 		return nil, fmt.Errorf("%s %w", op, err)
@@ -27,34 +31,237 @@ func HashPassword(log *slog.Logger, password, op string) ([]byte, error) {
 
 type UserSaver interface {
 	SaveUser(ctx context.Context, email string, passHash []byte) (int64, error)
+	UpdatePassword(ctx context.Context, userID int64, passHash []byte, revokedAt time.Time, keepSessionID string) error
+	ReplacePassHash(ctx context.Context, userID int64, oldHash, newHash []byte) error
+	SetAdmin(ctx context.Context, userID int64, isAdmin bool) error
+}
+type EmailVerificationStorage interface {
+	SaveEmailVerification(ctx context.Context, verification models.EmailVerification) error
+	EmailVerification(ctx context.Context, tokenHash string) (models.EmailVerification, error)
+	VerifyEmail(ctx context.Context, tokenHash string, userID int64) error
+	SetEmailVerified(ctx context.Context, userID int64) error
+	MarkVerificationSent(ctx context.Context, userID int64, sentAt, notBefore time.Time) error
+}
+type PasswordlessStorage interface {
+	SavePasswordlessLogin(ctx context.Context, login models.PasswordlessLogin) error
+	PasswordlessLogin(ctx context.Context, tokenHash string) (models.PasswordlessLogin, error)
+	FailPasswordlessLogin(ctx context.Context, tokenHash string) error
+	UsePasswordlessLogin(ctx context.Context, tokenHash string) error
+}
+type LoginThrottleStorage interface {
+	FailLogin(ctx context.Context, userID int64) (int, error)
+	BlockLogin(ctx context.Context, userID int64, until time.Time) error
+	ResetFailedLogins(ctx context.Context, userID int64) error
+	IPLoginFailures(ctx context.Context, ip string) (models.IPLoginFailures, error)
+	FailIPLogin(ctx context.Context, ip string, now, windowEndsAt time.Time) (models.IPLoginFailures, error)
+}
+type PasswordResetStorage interface {
+	SavePasswordReset(ctx context.Context, reset models.PasswordReset) error
+	PasswordReset(ctx context.Context, tokenHash string) (models.PasswordReset, error)
+	UsePasswordReset(ctx context.Context, tokenHash string) error
+	InvalidatePasswordResets(ctx context.Context, userID int64) error
 }
 type UserProvider interface {
 	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
 }
 type AppProvider interface {
 	App(ctx context.Context, appID int64) (models.App, error)
 }
+type RefreshTokenStorage interface {
+	SaveRefreshToken(ctx context.Context, token models.RefreshToken) (int64, error)
+	RefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, tokenID int64) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	SessionActive(ctx context.Context, familyID string) (bool, error)
+}
+type TokenRevoker interface {
+	RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	RevokeUserSessions(ctx context.Context, userID int64, revokedAt time.Time) error
+}
+type AuthorizationCodeStorage interface {
+	SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error
+	AuthorizationCode(ctx context.Context, codeHash string) (models.AuthorizationCode, error)
+	UseAuthorizationCode(ctx context.Context, codeHash string) error
+}
+type DeviceCodeStorage interface {
+	SaveDeviceCode(ctx context.Context, code models.DeviceCode) error
+	DeviceCode(ctx context.Context, deviceCodeHash string) (models.DeviceCode, error)
+	DeviceCodeByUserCode(ctx context.Context, userCode string) (models.DeviceCode, error)
+	UpdateDeviceCodePoll(ctx context.Context, deviceCodeHash string, polledAt time.Time, interval time.Duration) error
+	ResolveDeviceCode(ctx context.Context, userCode string, status string, userID int64, authTime time.Time) error
+	UseDeviceCode(ctx context.Context, deviceCodeHash string) error
+}
+type MFAStorage interface {
+	SaveTOTPSecret(ctx context.Context, userID int64, secret []byte) error
+	EnableTOTP(ctx context.Context, userID int64, step int64) error
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error
+	MFAChallenge(ctx context.Context, tokenHash string) (models.MFAChallenge, error)
+	FailMFAChallenge(ctx context.Context, tokenHash string) error
+	UseMFAChallenge(ctx context.Context, tokenHash string) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, codes []models.RecoveryCode) error
+	RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error)
+	UseRecoveryCode(ctx context.Context, codeID int64) error
+}
+type WebAuthnStorage interface {
+	SaveWebAuthnCredential(ctx context.Context, credential models.WebAuthnCredential) (int64, error)
+	WebAuthnCredentials(ctx context.Context, userID int64) ([]models.WebAuthnCredential, error)
+	UpdateWebAuthnCredential(ctx context.Context, credentialID []byte, signCount uint32, backupState bool, usedAt time.Time) error
+	SaveWebAuthnCeremony(ctx context.Context, ceremony models.WebAuthnCeremony) error
+	WebAuthnCeremony(ctx context.Context, tokenHash string) (models.WebAuthnCeremony, error)
+	UseWebAuthnCeremony(ctx context.Context, tokenHash string) error
+}
+type RoleStorage interface {
+	CreateRole(ctx context.Context, role models.Role) (int64, error)
+	DeleteRole(ctx context.Context, roleID int64) error
+	Roles(ctx context.Context) ([]models.Role, error)
+	GrantPermission(ctx context.Context, roleID int64, permission string) error
+	RevokePermission(ctx context.Context, roleID int64, permission string) error
+	AssignRole(ctx context.Context, userID, roleID, appID int64) error
+	UnassignRole(ctx context.Context, userID, roleID, appID int64) error
+	UserRoles(ctx context.Context, userID int64) ([]models.RoleAssignment, error)
+	UserGrants(ctx context.Context, userID, appID int64) (models.Grants, error)
+	HasPermission(ctx context.Context, userID, appID int64, permission string) (bool, error)
+}
+type GroupStorage interface {
+	CreateGroup(ctx context.Context, group models.Group) (int64, error)
+	DeleteGroup(ctx context.Context, groupID int64) error
+	Groups(ctx context.Context) ([]models.Group, error)
+	Group(ctx context.Context, groupID int64) (models.GroupInfo, error)
+	AddGroupMember(ctx context.Context, groupID, userID int64) error
+	RemoveGroupMember(ctx context.Context, groupID, userID int64) error
+	AddSubgroup(ctx context.Context, parentID, childID int64) error
+	RemoveSubgroup(ctx context.Context, parentID, childID int64) error
+	AssignGroupRole(ctx context.Context, groupID, roleID, appID int64) error
+	UnassignGroupRole(ctx context.Context, groupID, roleID, appID int64) error
+	GrantPaths(ctx context.Context, userID, appID int64, permission string) ([]models.GrantPath, error)
+}
+type PolicyStorage interface {
+	SavePolicy(ctx context.Context, policy models.Policy) (int64, error)
+	UpdatePolicy(ctx context.Context, policy models.Policy) error
+	DeletePolicy(ctx context.Context, policyID int64) error
+	Policy(ctx context.Context, policyID int64) (models.Policy, error)
+	Policies(ctx context.Context, appID int64) ([]models.Policy, error)
+	SetUserAttribute(ctx context.Context, userID int64, name, value string) error
+	UserAttributes(ctx context.Context, userID int64) (map[string]string, error)
+	UserGroups(ctx context.Context, userID int64) ([]string, error)
+}
+type AuditLogger interface {
+	SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+	AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+}
 type UserOperation interface {
 	UserSaver
+	PasswordResetStorage
+	EmailVerificationStorage
+	PasswordlessStorage
+	LoginThrottleStorage
 	UserProvider
 	AppProvider
+	RefreshTokenStorage
+	TokenRevoker
+	AuthorizationCodeStorage
+	DeviceCodeStorage
+	MFAStorage
+	WebAuthnStorage
+	RoleStorage
+	GroupStorage
+	PolicyStorage
+	AuditLogger
+}
+type Mailer interface {
+	Send(ctx context.Context, msg mail.Message) error
+}
+type PasswordPolicy interface {
+	Check(ctx context.Context, password, email string) ([]models.PasswordViolation, error)
+}
+
+// PasswordHasher hashes new passwords and verifies stored ones. Verify
+// also tells whether a matching hash is outdated and should be replaced.
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	Verify(hash []byte, password string) (ok bool, rehash bool, err error)
+}
+type Config struct {
+	Issuer               string
+	TokenTTL             time.Duration
+	RefreshTokenTTL      time.Duration
+	AuthorizationCodeTTL time.Duration
+	DeviceCodeTTL        time.Duration
+	DevicePollInterval   time.Duration
+	// MFAIssuer is the provider name shown in authenticator apps.
+	MFAIssuer       string
+	MFAChallengeTTL time.Duration
+	MFAMaxAttempts  int
+	// WebAuthnCeremonyTTL is how long a started registration or login
+	// waits for the authenticator.
+	WebAuthnCeremonyTTL time.Duration
+	PasswordResetTTL    time.Duration
+	// PasswordResetURL is the page reset links point to, empty to send
+	// the bare token.
+	PasswordResetURL     string
+	EmailVerificationTTL time.Duration
+	// EmailVerificationResendInterval is the least time between two
+	// verification emails to the same user.
+	EmailVerificationResendInterval time.Duration
+	// EmailVerificationURL is the page verification links point to, empty
+	// to send the bare token.
+	EmailVerificationURL    string
+	PasswordlessTTL         time.Duration
+	PasswordlessMaxAttempts int
+	// PasswordlessURL is the page sign-in links point to, empty to send
+	// the bare token.
+	PasswordlessURL string
+	// LockoutThreshold is the wrong passwords in a row that lock the
+	// account for LockoutDuration, 0 never locks.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// LoginDelayAfter is the wrong passwords in a row after which every
+	// login waits, from LoginDelayBase doubling up to LoginDelayMax.
+	LoginDelayAfter int
+	LoginDelayBase  time.Duration
+	LoginDelayMax   time.Duration
+	// IPMaxFailedLogins is the failed logins one address may make per
+	// IPLoginWindow, 0 doesn't limit addresses.
+	IPMaxFailedLogins int
+	IPLoginWindow     time.Duration
+	// PolicyLocation is the time zone of the env attributes access
+	// policies read.
+	PolicyLocation *time.Location
 }
 type Auth struct {
-	log      *slog.Logger
-	storage  UserOperation
-	tokenTTL time.Duration
+	log       *slog.Logger
+	storage   UserOperation
+	keys      jwt.KeyProvider
+	cipher    *aead.Cipher
+	passkeys  *webauthn.WebAuthn
+	mailer    Mailer
+	passwords PasswordPolicy
+	hasher    PasswordHasher
+	cfg       Config
 }
 
-func New(log *slog.Logger, storageOprations UserOperation, tokenTTL time.Duration) *Auth {
+func New(log *slog.Logger, storageOprations UserOperation, keys jwt.KeyProvider, cipher *aead.Cipher, passkeys *webauthn.WebAuthn, mailer Mailer, passwords PasswordPolicy, hasher PasswordHasher, cfg Config) *Auth {
 	return &Auth{
-		log:      log,
-		storage:  storageOprations,
-		tokenTTL: tokenTTL,
+		log:       log,
+		storage:   storageOprations,
+		keys:      keys,
+		cipher:    cipher,
+		passkeys:  passkeys,
+		mailer:    mailer,
+		passwords: passwords,
+		hasher:    hasher,
+		cfg:       cfg,
 	}
 }
 
-func (a *Auth) Login(ctx context.Context, email, password string, appID int64) (string, error) {
+// Login checks the user's password. Users with a second factor get an MFA
+// token instead of tokens, the login is finished by VerifyMFA. The login is
+// audited with its outcome, a right password alone is not a login.
+func (a *Auth) Login(ctx context.Context, email, password string, appID int64) (result models.LoginResult, err error) {
 	const op = "New.Login"
 
 	log := a.log.With(
@@ -62,43 +269,291 @@ func (a *Auth) Login(ctx context.Context, email, password string, appID int64) (
 		slog.String("email", email),
 	)
 
+	event := passwordLoginEvent(email, appID)
+	defer a.audit(ctx, log, &event, &err)
+
+	user, err := a.authenticate(ctx, log, &event, email, password)
+	if err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	app, err := a.storage.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Error("app is not exists", sl.Err(err))
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, storage.ErrAppNotFound)
+		}
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := requireVerifiedEmail(user, app); err != nil {
+		log.Info("login refused, email not verified")
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if user.MFAEnabled() {
+		mfaToken, err := a.startMFA(ctx, user, app)
+		if err != nil {
+			log.Error("faild to start mfa challenge", sl.Err(err))
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		log.Info("password accepted, mfa required")
+		event.Outcome = models.AuditMFARequired
+		return models.LoginResult{MFAToken: mfaToken, MFAMethods: user.MFAMethods()}, nil
+	}
+
+	log.Info("user succefully logged in")
+
+	tokens, err := a.issueTokens(ctx, user, app, "")
+	if err != nil {
+		log.Error("faild to generate token", sl.Err(err))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("token succefully generated")
+
+	return models.LoginResult{Tokens: tokens}, nil
+}
+
+// passwordLoginEvent is the audit event of a password login to appID, the
+// caller saves it with the outcome of the whole login.
+func passwordLoginEvent(email string, appID int64) models.AuditEvent {
+	return models.AuditEvent{
+		Type:    models.AuditLogin,
+		AppID:   appID,
+		Details: map[string]string{"method": "password", "email": email},
+	}
+}
+
+// authenticate checks the user's password. An unknown email and a wrong
+// password both come back as ErrInvalidCredentials. Accounts and client
+// addresses with too many failures get a LoginBlockedError instead, before
+// the password is looked at. The user found is set on event.
+func (a *Auth) authenticate(ctx context.Context, log *slog.Logger, event *models.AuditEvent, email, password string) (models.User, error) {
+	ip := clientip.FromContext(ctx)
+	if err := a.checkIPLogins(ctx, log, ip); err != nil {
+		return models.User{}, err
+	}
+
 	user, err := a.storage.User(ctx, email)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
 			log.Error("user is not exists", sl.Err(err))
-			return "", fmt.Errorf("%s %w", op, storage.ErrInvalidCredentials)
+			if err := a.failIPLogin(ctx, log, ip); err != nil {
+				return models.User{}, err
+			}
+			return models.User{}, storage.ErrInvalidCredentials
 		}
 
 		log.Error("faild to get user", sl.Err(err))
-		return "", fmt.Errorf("%s %w", op, err)
+		return models.User{}, err
+	}
+
+	event.ActorID, event.UserID = user.ID, user.ID
+
+	rehash, err := a.verifyPassword(ctx, log, user, password)
+	if err != nil {
+		return models.User{}, err
 	}
 
-	err = bcrypt.CompareHashAndPassword(user.PassHash, []byte(password))
-	if errors.Is(err, storage.ErrPasswordIncorect) {
-		log.Error("invalid credentials", sl.Err(err))
-		return "", fmt.Errorf("%s %w", op, storage.ErrInvalidCredentials)
+	if rehash {
+		a.rehashPassword(ctx, log, user, password)
 	}
 
-	app, err := a.storage.App(ctx, appID)
+	// the user remembered the password, a pending reset is not needed
+	if err := a.storage.InvalidatePasswordResets(ctx, user.ID); err != nil {
+		log.Error("faild to invalidate password resets", sl.Err(err))
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// rehashPassword replaces a hash made with an outdated algorithm or cost
+// while the password is at hand. The login goes on if it fails, the next
+// one tries again.
+func (a *Auth) rehashPassword(ctx context.Context, log *slog.Logger, user models.User, password string) {
+	hashed, err := a.hasher.Hash(password)
 	if err != nil {
-		if errors.Is(err, storage.ErrAppNotFound) {
-			log.Error("app is not exists", sl.Err(err))
-			return "", fmt.Errorf("%s %w", op, storage.ErrAppNotFound)
+		log.Error("faild to rehash password", sl.Err(err))
+		return
+	}
+
+	if err := a.storage.ReplacePassHash(ctx, user.ID, user.PassHash, hashed); err != nil {
+		log.Error("faild to store rehashed password", sl.Err(err))
+		return
+	}
+
+	log.Info("password succefully rehashed")
+}
+
+// authenticateWithCode is authenticate for forms that ask for the password
+// and the second factor together, the login page and the device page.
+// Users with a second factor that didn't send a code get ErrMFARequired,
+// passkey users can answer with a recovery code.
+func (a *Auth) authenticateWithCode(ctx context.Context, log *slog.Logger, event *models.AuditEvent, email, password, code string) (models.User, error) {
+	user, err := a.authenticate(ctx, log, event, email, password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if !user.MFAEnabled() {
+		return user, nil
+	}
+	if code == "" {
+		return models.User{}, storage.ErrMFARequired
+	}
+	if err := a.verifySecondFactor(ctx, log, user, code); err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// Refresh exchanges a refresh token for a new access/refresh pair. Every refresh token
+// is single-use: presenting one that was already rotated means it leaked, so the whole
+// family issued from the same login is revoked.
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (pair models.TokenPair, err error) {
+	const op = "New.Refresh"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	event := models.AuditEvent{Type: models.AuditTokenRefreshed}
+	defer a.audit(ctx, log, &event, &err)
+
+	stored, err := a.storage.RefreshToken(ctx, opaque.Hash(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Warn("refresh token is not exists")
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidRefreshToken)
 		}
-		return "", fmt.Errorf("%s %w", op, err)
+		log.Error("faild to get refresh token", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
 	}
 
-	log.Info("user succefully logged in")
+	log = log.With(
+		slog.Int64("userID", stored.UserID),
+		slog.String("familyID", stored.FamilyID),
+	)
+	event.ActorID, event.UserID, event.AppID = stored.UserID, stored.UserID, stored.AppID
 
-	token, err := jwt.NewToken(user, app, a.tokenTTL)
+	if stored.Revoked {
+		log.Warn("refresh token reuse detected, revoking token family")
+		event.Details = map[string]string{"family_revoked": "true"}
+		if err := a.storage.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+			log.Error("faild to revoke token family", sl.Err(err))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidRefreshToken)
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		log.Warn("refresh token expired")
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidRefreshToken)
+	}
+
+	if err := a.storage.RevokeRefreshToken(ctx, stored.ID); err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenRevoked) {
+			log.Warn("refresh token was rotated concurrently, revoking token family")
+			event.Details = map[string]string{"family_revoked": "true"}
+			if err := a.storage.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+				log.Error("faild to revoke token family", sl.Err(err))
+				return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+			}
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidRefreshToken)
+		}
+		log.Error("faild to revoke refresh token", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	user, err := a.storage.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user is not exists anymore")
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidRefreshToken)
+		}
+		log.Error("faild to get user", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	app, err := a.storage.App(ctx, stored.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app is not exists anymore")
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidRefreshToken)
+		}
+		log.Error("faild to get app", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	tokens, err := a.issueTokens(ctx, user, app, stored.FamilyID)
 	if err != nil {
 		log.Error("faild to generate token", sl.Err(err))
-		return "", fmt.Errorf("%s %w", op, err)
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
 	}
 
-	log.Info("token succefully generated")
+	log.Info("token succefully refreshed")
+
+	return tokens, nil
+}
+
+// issueTokens creates an access token and a refresh token belonging to familyID.
+// An empty familyID starts a new family, as happens on every fresh login.
+func (a *Auth) issueTokens(ctx context.Context, user models.User, app models.App, familyID string) (models.TokenPair, error) {
+	if err := requireVerifiedEmail(user, app); err != nil {
+		return models.TokenPair{}, err
+	}
+
+	var err error
+	if familyID == "" {
+		familyID, _, err = opaque.New()
+		if err != nil {
+			return models.TokenPair{}, err
+		}
+	}
+
+	grants, err := a.storage.UserGrants(ctx, user.ID, app.ID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	refreshToken, refreshHash, err := opaque.New()
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	// saved before the access token is signed, so a logout of all sessions
+	// in between revokes the family the access token names
+	_, err = a.storage.SaveRefreshToken(ctx, models.RefreshToken{
+		TokenHash: refreshHash,
+		UserID:    user.ID,
+		AppID:     app.ID,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(a.cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	accessToken, err := jwt.NewToken(user, app, a.keys, jwt.TokenOptions{
+		Issuer:      a.cfg.Issuer,
+		Duration:    a.cfg.TokenTTL,
+		SessionID:   familyID,
+		Roles:       grants.Roles,
+		Permissions: grants.Permissions,
+	})
+	if err != nil {
+		return models.TokenPair{}, err
+	}
 
-	return token, nil
+	return models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    a.cfg.TokenTTL,
+	}, nil
 }
 
 func (a *Auth) SaveUser(ctx context.Context, email, password string) (userID int64, err error) {
@@ -109,7 +564,14 @@ func (a *Auth) SaveUser(ctx context.Context, email, password string) (userID int
 		slog.String("email", email),
 	)
 
-	hashed, err := HashPassword(log, password, op)
+	event := models.AuditEvent{Type: models.AuditRegistered, Details: map[string]string{"email": email}}
+	defer a.audit(ctx, log, &event, &err)
+
+	if err := a.checkPassword(ctx, log, password, email); err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	hashed, err := a.hashPassword(log, password, op)
 	if err != nil {
 		log.Error("error while hashing password", sl.Err(err))
 		return 0, fmt.Errorf("%s %w", op, err)
@@ -126,6 +588,12 @@ func (a *Auth) SaveUser(ctx context.Context, email, password string) (userID int
 	}
 
 	log.Info("user succefully registered")
+	event.ActorID, event.UserID = id, id
+
+	a.inBackground(ctx, func(ctx context.Context) {
+		a.sendVerification(ctx, log.With(slog.Int64("userID", id)), models.User{ID: id, Email: email})
+	})
+
 	return id, nil
 }
 
@@ -151,3 +619,143 @@ func (a *Auth) IsAdmin(ctx context.Context, userID int64) (isAdmin bool, err err
 
 	return isAdmin, err
 }
+
+// ValidateToken introspects an access token. Any token that fails a check
+// (signature, expiry, app mismatch, user deleted) yields ErrInvalidToken so
+// callers can report it as inactive without leaking why.
+func (a *Auth) ValidateToken(ctx context.Context, token string, appID int64) (models.TokenClaims, error) {
+	const op = "New.ValidateToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := jwt.Parse(token, a.keys, func(tokenAppID int64) (models.App, error) {
+		app, err := a.storage.App(ctx, tokenAppID)
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.App{}, jwt.ErrUnknownKey
+		}
+		return app, err
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrInvalidToken) {
+			log.Info("token is not valid", sl.Err(err))
+			return models.TokenClaims{}, fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+		}
+		log.Error("faild to parse token", sl.Err(err))
+		return models.TokenClaims{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(
+		slog.Int64("userID", claims.UserID),
+		slog.Int64("appID", claims.AppID),
+		slog.Bool("service", claims.Service),
+	)
+
+	if claims.Issuer != a.cfg.Issuer {
+		log.Info("token issued by another issuer", slog.String("iss", claims.Issuer))
+		return models.TokenClaims{}, fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+	}
+
+	if appID != 0 && claims.AppID != appID {
+		log.Info("token issued for another app", slog.Int64("expectedAppID", appID))
+		return models.TokenClaims{}, fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+	}
+
+	if _, err := a.storage.App(ctx, claims.AppID); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app is not exists anymore")
+			return models.TokenClaims{}, fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+		}
+		log.Error("faild to get app", sl.Err(err))
+		return models.TokenClaims{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	// service tokens have no user behind them
+	if !claims.Service {
+		user, err := a.storage.UserByID(ctx, claims.UserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Info("user is not exists anymore")
+				return models.TokenClaims{}, fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+			}
+			log.Error("faild to get user", sl.Err(err))
+			return models.TokenClaims{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		// logout of all sessions revokes everything issued before it, a password
+		// change everything but the session it was made from
+		kept := user.TokensRevokedExcept != "" && claims.SessionID == user.TokensRevokedExcept
+		if !user.TokensRevokedAt.IsZero() && !kept {
+			revoked, err := a.revokedBySessionsLogout(ctx, claims, user.TokensRevokedAt)
+			if err != nil {
+				log.Error("faild to check session", sl.Err(err))
+				return models.TokenClaims{}, fmt.Errorf("%s %w", op, err)
+			}
+			if revoked {
+				log.Info("token revoked by logout of all sessions")
+				return models.TokenClaims{}, fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+			}
+		}
+	}
+
+	if claims.ID != "" {
+		revoked, err := a.storage.IsTokenRevoked(ctx, claims.ID)
+		if err != nil {
+			log.Error("faild to check token revocation", sl.Err(err))
+			return models.TokenClaims{}, fmt.Errorf("%s %w", op, err)
+		}
+		if revoked {
+			log.Info("token revoked")
+			return models.TokenClaims{}, fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+		}
+	}
+
+	return claims, nil
+}
+
+// revokedBySessionsLogout tells whether a logout of all sessions at revokedAt
+// covers the token. iat only has whole seconds, so a token from the same
+// second as the logout is told apart by its session: the logout revoked
+// every refresh token family there was, a login after it started a new one.
+func (a *Auth) revokedBySessionsLogout(ctx context.Context, claims models.TokenClaims, revokedAt time.Time) (bool, error) {
+	issued, revoked := claims.IssuedAt.Unix(), revokedAt.Unix()
+	if issued != revoked || claims.SessionID == "" {
+		return issued <= revoked, nil
+	}
+
+	active, err := a.storage.SessionActive(ctx, claims.SessionID)
+	if err != nil {
+		return false, err
+	}
+
+	return !active, nil
+}
+
+// JWKS returns the public keys resource servers need to verify access tokens
+// without holding any app secret.
+func (a *Auth) JWKS(ctx context.Context) (jwt.JWKSet, error) {
+	return jwt.NewJWKSet(a.keys.PublicKeys()), nil
+}
+
+// userFromToken returns the user an access token was issued to. Service
+// tokens have none and are rejected with ErrInvalidToken.
+func (a *Auth) userFromToken(ctx context.Context, accessToken string) (models.User, error) {
+	claims, err := a.ValidateToken(ctx, accessToken, 0)
+	if err != nil {
+		return models.User{}, err
+	}
+	if claims.Service {
+		return models.User{}, fmt.Errorf("%w: service token", storage.ErrInvalidToken)
+	}
+
+	user, err := a.storage.UserByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, storage.ErrInvalidToken
+		}
+		return models.User{}, err
+	}
+
+	return user, nil
+}
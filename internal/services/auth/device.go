@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+// RFC 8628 section 6.1: no vowels, so codes can't spell words, and nothing
+// that is easily confused when read off a TV screen.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const (
+	userCodeLength = 8
+	// added to the poll interval every time a client polls too fast
+	slowDownStep = 5 * time.Second
+)
+
+// StartDeviceAuthorization is the device authorization endpoint. Devices
+// can't keep secrets, so clientSecret is only checked when sent.
+func (a *Auth) StartDeviceAuthorization(ctx context.Context, appID int64, clientSecret, scope string) (models.DeviceAuthorization, error) {
+	const op = "New.StartDeviceAuthorization"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("appID", appID),
+	)
+
+	if _, err := a.deviceClient(ctx, log, appID, clientSecret); err != nil {
+		return models.DeviceAuthorization{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	deviceCode, deviceCodeHash, err := opaque.New()
+	if err != nil {
+		log.Error("faild to generate device code", sl.Err(err))
+		return models.DeviceAuthorization{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	userCode, err := newUserCode()
+	if err != nil {
+		log.Error("faild to generate user code", sl.Err(err))
+		return models.DeviceAuthorization{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	err = a.storage.SaveDeviceCode(ctx, models.DeviceCode{
+		DeviceCodeHash: deviceCodeHash,
+		UserCode:       userCode,
+		AppID:          appID,
+		Scope:          scope,
+		Interval:       a.cfg.DevicePollInterval,
+		ExpiresAt:      time.Now().Add(a.cfg.DeviceCodeTTL),
+	})
+	if err != nil {
+		log.Error("faild to save device code", sl.Err(err))
+		return models.DeviceAuthorization{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("device code issued")
+
+	verificationURI := strings.TrimSuffix(a.cfg.Issuer, "/") + "/oauth/device"
+
+	return models.DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {formatUserCode(userCode)}}.Encode(),
+		ExpiresIn:               a.cfg.DeviceCodeTTL,
+		Interval:                a.cfg.DevicePollInterval,
+	}, nil
+}
+
+// CheckUserCode returns the app a pending user code was issued to, so the
+// verification page can tell the user what they are approving.
+func (a *Auth) CheckUserCode(ctx context.Context, userCode string) (models.App, error) {
+	const op = "New.CheckUserCode"
+
+	code, err := a.storage.DeviceCodeByUserCode(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s %w", op, err)
+	}
+	if code.Status != models.DeviceCodePending || time.Now().After(code.ExpiresAt) {
+		return models.App{}, fmt.Errorf("%s %w", op, storage.ErrDeviceCodeNotFound)
+	}
+
+	app, err := a.storage.App(ctx, code.AppID)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return app, nil
+}
+
+// VerifyDevice signs the user in on the verification page and records
+// whether they approved or denied the device.
+func (a *Auth) VerifyDevice(ctx context.Context, userCode, email, password, mfaCode string, approve bool) (_ models.App, err error) {
+	const op = "New.VerifyDevice"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+		slog.Bool("approve", approve),
+	)
+
+	app, err := a.CheckUserCode(ctx, userCode)
+	if err != nil {
+		if !errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Error("faild to get device code", sl.Err(err))
+		}
+		return models.App{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	event := passwordLoginEvent(email, app.ID)
+	defer a.audit(ctx, log, &event, &err)
+
+	user, err := a.authenticateWithCode(ctx, log, &event, email, password, mfaCode)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := requireVerifiedEmail(user, app); err != nil {
+		log.Info("device verification refused, email not verified")
+		return models.App{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	status := models.DeviceCodeDenied
+	if approve {
+		status = models.DeviceCodeApproved
+	}
+
+	err = a.storage.ResolveDeviceCode(ctx, normalizeUserCode(userCode), status, user.ID, time.Now())
+	if err != nil {
+		if !errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Error("faild to resolve device code", sl.Err(err))
+		}
+		return models.App{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("device code resolved", slog.Int64("appID", app.ID), slog.Int64("userID", user.ID))
+
+	return app, nil
+}
+
+// ExchangeDeviceCode is the device_code grant of the token endpoint, polled
+// by the device until the user decides. Polling faster than the interval
+// gets ErrSlowDown and a longer interval.
+func (a *Auth) ExchangeDeviceCode(ctx context.Context, appID int64, clientSecret, deviceCode string) (pair models.TokenPair, err error) {
+	const op = "New.ExchangeDeviceCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("appID", appID),
+	)
+
+	event := tokenIssuedEvent(appID, models.GrantTypeDeviceCode)
+	defer func() {
+		// polling an undecided code is how the grant works, not an event
+		if errors.Is(err, storage.ErrAuthorizationPending) || errors.Is(err, storage.ErrSlowDown) {
+			return
+		}
+		a.audit(ctx, log, &event, &err)
+	}()
+
+	app, err := a.deviceClient(ctx, log, appID, clientSecret)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	stored, err := a.storage.DeviceCode(ctx, opaque.Hash(deviceCode))
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Info("device code is not exists")
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+		}
+		log.Error("faild to get device code", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	event.ActorID, event.UserID = stored.UserID, stored.UserID
+
+	if stored.AppID != appID {
+		log.Info("device code issued to another app", slog.Int64("codeAppID", stored.AppID))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+	}
+
+	// a used code is dead however fast it is polled
+	if stored.Status == models.DeviceCodeUsed {
+		log.Info("device code already used")
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+	}
+
+	now := time.Now()
+	if now.After(stored.ExpiresAt) {
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrExpiredToken)
+	}
+
+	interval := stored.Interval
+	tooFast := !stored.LastPolledAt.IsZero() && now.Sub(stored.LastPolledAt) < interval
+	if tooFast {
+		interval += slowDownStep
+	}
+	if err := a.storage.UpdateDeviceCodePoll(ctx, stored.DeviceCodeHash, now, interval); err != nil {
+		log.Error("faild to update device code poll", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+	if tooFast {
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrSlowDown)
+	}
+
+	switch stored.Status {
+	case models.DeviceCodePending:
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrAuthorizationPending)
+	case models.DeviceCodeDenied:
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrAccessDenied)
+	case models.DeviceCodeApproved:
+	default:
+		log.Info("device code already used")
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+	}
+
+	if err := a.storage.UseDeviceCode(ctx, stored.DeviceCodeHash); err != nil {
+		if errors.Is(err, storage.ErrCodeUsed) {
+			log.Warn("device code exchanged concurrently")
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+		}
+		log.Error("faild to use device code", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	user, err := a.storage.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+		}
+		log.Error("faild to get user", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	tokens, err := a.issueTokens(ctx, user, app, "")
+	if err != nil {
+		log.Error("faild to generate token", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if hasScope(stored.Scope, ScopeOpenID) {
+		tokens.IDToken, err = a.issueIDToken(user, app, "", stored.AuthTime)
+		if err != nil {
+			log.Error("faild to generate id token", sl.Err(err))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	log.Info("device code exchanged", slog.Int64("userID", user.ID))
+
+	return tokens, nil
+}
+
+func (a *Auth) deviceClient(ctx context.Context, log *slog.Logger, appID int64, clientSecret string) (models.App, error) {
+	app, err := a.storage.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("device request for unknown app")
+			return models.App{}, storage.ErrInvalidClient
+		}
+		log.Error("faild to get app", sl.Err(err))
+		return models.App{}, err
+	}
+
+	if clientSecret != "" && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(app.Secret)) != 1 {
+		log.Info("device request with wrong client secret")
+		return models.App{}, storage.ErrInvalidClient
+	}
+
+	return app, nil
+}
+
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// formatUserCode splits the code in halves for reading, BCDF-GHJK.
+func formatUserCode(code string) string {
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// normalizeUserCode undoes what users do to codes while typing them:
+// lowercase, dashes and spaces.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToUpper(r)
+		if !strings.ContainsRune(userCodeAlphabet, r) {
+			return -1
+		}
+		return r
+	}, code)
+}
@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+// groupErrors are the storage errors of group calls passed on to the
+// caller, anything else is a failure of the service.
+var groupErrors = []error{
+	storage.ErrGroupExists,
+	storage.ErrGroupNotFound,
+	storage.ErrGroupCycle,
+	storage.ErrNotGroupMember,
+	storage.ErrUserNotFound,
+	storage.ErrRoleNotFound,
+	storage.ErrRoleNotAssigned,
+	storage.ErrAppNotFound,
+}
+
+// groupError logs err and returns it wrapped in op.
+func groupError(log *slog.Logger, op string, err error) error {
+	for _, known := range groupErrors {
+		if errors.Is(err, known) {
+			log.Info("group can't be changed", sl.Err(err))
+			return fmt.Errorf("%s %w", op, known)
+		}
+	}
+	log.Error("faild to change group", sl.Err(err))
+	return fmt.Errorf("%s %w", op, err)
+}
+
+// CreateGroup creates a group. The access token must carry the
+// roles:manage permission, as for every call managing groups.
+func (a *Auth) CreateGroup(ctx context.Context, accessToken string, group models.Group) (groupID int64, err error) {
+	const op = "New.CreateGroup"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("group", group.Name),
+	)
+
+	event := models.AuditEvent{Type: models.AuditGroupCreated, Details: map[string]string{"group": group.Name}}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	groupID, err = a.storage.CreateGroup(ctx, group)
+	if err != nil {
+		return 0, groupError(log, op, err)
+	}
+	event.Details["group_id"] = strconv.FormatInt(groupID, 10)
+
+	log.Info("group succefully created", slog.Int64("groupID", groupID))
+
+	return groupID, nil
+}
+
+// DeleteGroup deletes a group. Its members lose the roles they inherited
+// from it, its subgroups stay.
+func (a *Auth) DeleteGroup(ctx context.Context, accessToken string, groupID int64) (err error) {
+	const op = "New.DeleteGroup"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("groupID", groupID),
+	)
+
+	event := models.AuditEvent{Type: models.AuditGroupDeleted, Details: map[string]string{
+		"group_id": strconv.FormatInt(groupID, 10),
+	}}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.storage.DeleteGroup(ctx, groupID); err != nil {
+		return groupError(log, op, err)
+	}
+
+	log.Info("group succefully deleted")
+
+	return nil
+}
+
+// ListGroups returns every group.
+func (a *Auth) ListGroups(ctx context.Context, accessToken string) ([]models.Group, error) {
+	const op = "New.ListGroups"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if _, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	groups, err := a.storage.Groups(ctx)
+	if err != nil {
+		log.Error("faild to list groups", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return groups, nil
+}
+
+// Group returns a group with its direct members, subgroups and roles.
+func (a *Auth) Group(ctx context.Context, accessToken string, groupID int64) (models.GroupInfo, error) {
+	const op = "New.Group"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("groupID", groupID),
+	)
+
+	if _, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage); err != nil {
+		return models.GroupInfo{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	group, err := a.storage.Group(ctx, groupID)
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupNotFound) {
+			return models.GroupInfo{}, fmt.Errorf("%s %w", op, storage.ErrGroupNotFound)
+		}
+		log.Error("faild to get group", sl.Err(err))
+		return models.GroupInfo{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return group, nil
+}
+
+// SetGroupMember adds a user to a group, or removes them when member is
+// false.
+func (a *Auth) SetGroupMember(ctx context.Context, accessToken string, groupID, userID int64, member bool) (err error) {
+	const op = "New.SetGroupMember"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("groupID", groupID),
+		slog.Int64("userID", userID),
+		slog.Bool("member", member),
+	)
+
+	event := models.AuditEvent{Type: models.AuditGroupMemberAdded, UserID: userID, Details: map[string]string{
+		"group_id": strconv.FormatInt(groupID, 10),
+	}}
+	if !member {
+		event.Type = models.AuditGroupMemberRemoved
+	}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if member {
+		err = a.storage.AddGroupMember(ctx, groupID, userID)
+	} else {
+		err = a.storage.RemoveGroupMember(ctx, groupID, userID)
+	}
+	if err != nil {
+		return groupError(log, op, err)
+	}
+
+	log.Info("group membership succefully changed")
+
+	return nil
+}
+
+// SetSubgroup makes the child group a member of the parent group, or takes
+// it out when member is false. A group can't end up a member of itself.
+func (a *Auth) SetSubgroup(ctx context.Context, accessToken string, parentID, childID int64, member bool) (err error) {
+	const op = "New.SetSubgroup"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("parentID", parentID),
+		slog.Int64("childID", childID),
+		slog.Bool("member", member),
+	)
+
+	event := models.AuditEvent{Type: models.AuditSubgroupAdded, Details: map[string]string{
+		"group_id":    strconv.FormatInt(parentID, 10),
+		"subgroup_id": strconv.FormatInt(childID, 10),
+	}}
+	if !member {
+		event.Type = models.AuditSubgroupRemoved
+	}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if member {
+		err = a.storage.AddSubgroup(ctx, parentID, childID)
+	} else {
+		err = a.storage.RemoveSubgroup(ctx, parentID, childID)
+	}
+	if err != nil {
+		return groupError(log, op, err)
+	}
+
+	log.Info("subgroup succefully changed")
+
+	return nil
+}
+
+// AssignGroupRole grants a role to a group's members in an app, or in every
+// app when appID is zero, or takes it away when assign is false.
+func (a *Auth) AssignGroupRole(ctx context.Context, accessToken string, groupID, roleID, appID int64, assign bool) (err error) {
+	const op = "New.AssignGroupRole"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("groupID", groupID),
+		slog.Int64("roleID", roleID),
+		slog.Int64("appID", appID),
+		slog.Bool("assign", assign),
+	)
+
+	event := models.AuditEvent{Type: models.AuditGroupRoleAssigned, AppID: appID, Details: map[string]string{
+		"group_id": strconv.FormatInt(groupID, 10),
+		"role_id":  strconv.FormatInt(roleID, 10),
+	}}
+	if !assign {
+		event.Type = models.AuditGroupRoleUnassigned
+	}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if assign {
+		err = a.storage.AssignGroupRole(ctx, groupID, roleID, appID)
+	} else {
+		err = a.storage.UnassignGroupRole(ctx, groupID, roleID, appID)
+	}
+	if err != nil {
+		return groupError(log, op, err)
+	}
+
+	log.Info("group role succefully changed")
+
+	return nil
+}
+
+// ExplainPermission answers why a user holds a permission in an app: every
+// role granting it, assigned to the user or inherited along a chain of
+// groups. No paths means the user doesn't hold it.
+func (a *Auth) ExplainPermission(ctx context.Context, accessToken string, userID, appID int64, permission string) ([]models.GrantPath, error) {
+	const op = "New.ExplainPermission"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("userID", userID),
+		slog.Int64("appID", appID),
+		slog.String("permission", permission),
+	)
+
+	if _, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	paths, err := a.storage.GrantPaths(ctx, userID, appID, permission)
+	if err != nil {
+		log.Error("faild to explain permission", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return paths, nil
+}
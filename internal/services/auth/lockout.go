@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+// checkLoginBlock refuses a login while the account is blocked by earlier
+// wrong passwords, whatever password comes with it.
+func (a *Auth) checkLoginBlock(user models.User) error {
+	wait := time.Until(user.LoginBlockedUntil)
+	if wait <= 0 {
+		return nil
+	}
+
+	blocked := &storage.LoginBlockedError{Err: storage.ErrLoginThrottled, RetryAfter: wait}
+	if a.lockedOut(user.FailedLogins) {
+		blocked.Err = storage.ErrAccountLocked
+	}
+	return blocked
+}
+
+// failLogin counts a wrong password and blocks the account for the delay
+// the failures earned. It returns ErrInvalidCredentials, or the lockout
+// when this failure locked the account.
+func (a *Auth) failLogin(ctx context.Context, log *slog.Logger, user models.User) error {
+	failures, err := a.storage.FailLogin(ctx, user.ID)
+	if err != nil {
+		log.Error("faild to count failed login", sl.Err(err))
+		return err
+	}
+
+	delay := a.loginDelay(failures)
+	if delay <= 0 {
+		return storage.ErrInvalidCredentials
+	}
+
+	if err := a.storage.BlockLogin(ctx, user.ID, time.Now().Add(delay)); err != nil {
+		log.Error("faild to block login", sl.Err(err))
+		return err
+	}
+
+	if !a.lockedOut(failures) {
+		log.Info("login delayed", slog.Int("failures", failures), slog.Duration("delay", delay))
+		return storage.ErrInvalidCredentials
+	}
+
+	log.Warn("account locked", slog.Int("failures", failures), slog.Duration("duration", delay))
+
+	err = a.recordAudit(ctx, models.AuditEvent{
+		Type:      models.AuditAccountLocked,
+		UserID:    user.ID,
+		Details:   map[string]string{"failures": strconv.Itoa(failures)},
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Error("faild to save audit event", sl.Err(err))
+		return err
+	}
+
+	return &storage.LoginBlockedError{Err: storage.ErrAccountLocked, RetryAfter: delay}
+}
+
+// verifyPassword checks the password of a known user, refusing a blocked
+// account before the password is looked at. A wrong password counts
+// against the account and the client address, whose own limit the caller
+// checked with checkIPLogins. rehash reports a hash made with outdated
+// settings.
+func (a *Auth) verifyPassword(ctx context.Context, log *slog.Logger, user models.User, password string) (rehash bool, err error) {
+	if err := a.checkLoginBlock(user); err != nil {
+		log.Info("login refused, account blocked", sl.Err(err))
+		return false, err
+	}
+
+	ok, rehash, err := a.hasher.Verify(user.PassHash, password)
+	if err != nil {
+		log.Error("faild to verify password", sl.Err(err))
+		return false, err
+	}
+	if !ok {
+		log.Error("invalid credentials")
+		if err := a.failIPLogin(ctx, log, clientip.FromContext(ctx)); err != nil {
+			return false, err
+		}
+		return false, a.failLogin(ctx, log, user)
+	}
+
+	// with a second factor the failures are forgotten once it passed too,
+	// wrong codes count with them
+	if !user.MFAEnabled() {
+		if err := a.resetFailedLogins(ctx, log, user); err != nil {
+			return false, err
+		}
+	}
+
+	return rehash, nil
+}
+
+// resetFailedLogins forgets the user's failures after a successful login.
+func (a *Auth) resetFailedLogins(ctx context.Context, log *slog.Logger, user models.User) error {
+	if user.FailedLogins == 0 {
+		return nil
+	}
+
+	if err := a.storage.ResetFailedLogins(ctx, user.ID); err != nil {
+		log.Error("faild to reset failed logins", sl.Err(err))
+		return err
+	}
+
+	return nil
+}
+
+func (a *Auth) lockedOut(failures int) bool {
+	return a.cfg.LockoutThreshold > 0 && failures >= a.cfg.LockoutThreshold
+}
+
+// loginDelay is how long the account waits after failures wrong passwords
+// in a row: nothing for the first LoginDelayAfter, then LoginDelayBase
+// doubling with every failure up to LoginDelayMax, and LockoutDuration
+// from LockoutThreshold on.
+func (a *Auth) loginDelay(failures int) time.Duration {
+	if a.lockedOut(failures) {
+		return a.cfg.LockoutDuration
+	}
+	if a.cfg.LoginDelayAfter <= 0 || failures < a.cfg.LoginDelayAfter {
+		return 0
+	}
+
+	delay := a.cfg.LoginDelayBase
+	for i := a.cfg.LoginDelayAfter; i < failures && delay < a.cfg.LoginDelayMax; i++ {
+		delay *= 2
+	}
+	return min(delay, a.cfg.LoginDelayMax)
+}
+
+// checkIPLogins refuses logins from an address that failed
+// IPMaxFailedLogins times in the current window, so one client can't walk
+// through many accounts. Requests without an address aren't limited.
+func (a *Auth) checkIPLogins(ctx context.Context, log *slog.Logger, ip string) error {
+	if ip == "" || a.cfg.IPMaxFailedLogins <= 0 {
+		return nil
+	}
+
+	failures, err := a.storage.IPLoginFailures(ctx, ip)
+	if err != nil {
+		log.Error("faild to get failed logins for address", sl.Err(err))
+		return err
+	}
+
+	wait := time.Until(failures.WindowEndsAt)
+	if failures.Failures < a.cfg.IPMaxFailedLogins || wait <= 0 {
+		return nil
+	}
+
+	log.Warn("login throttled for address", slog.String("ip", ip), slog.Int("failures", failures.Failures))
+	return &storage.LoginBlockedError{Err: storage.ErrLoginThrottled, RetryAfter: wait}
+}
+
+func (a *Auth) failIPLogin(ctx context.Context, log *slog.Logger, ip string) error {
+	if ip == "" || a.cfg.IPMaxFailedLogins <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if _, err := a.storage.FailIPLogin(ctx, ip, now, now.Add(a.cfg.IPLoginWindow)); err != nil {
+		log.Error("faild to count failed login for address", sl.Err(err))
+		return err
+	}
+
+	return nil
+}
+
+// UnlockAccount lifts a lockout and forgets the user's wrong passwords.
+// The access token must carry the users:unlock permission.
+func (a *Auth) UnlockAccount(ctx context.Context, accessToken string, userID int64) (err error) {
+	const op = "New.UnlockAccount"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("userID", userID),
+	)
+
+	event := models.AuditEvent{Type: models.AuditAccountUnlocked, UserID: userID}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionUsersUnlock)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("adminID", admin.ID))
+
+	if err := a.storage.ResetFailedLogins(ctx, userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user is not exists")
+			return fmt.Errorf("%s %w", op, storage.ErrUserNotFound)
+		}
+		log.Error("faild to unlock account", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("account succefully unlocked")
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+// Logout revokes the session the access token belongs to: the token itself
+// and its refresh token family. With allSessions every token and refresh
+// token the user holds, in any app, is revoked. Service tokens only ever
+// revoke themselves.
+func (a *Auth) Logout(ctx context.Context, token string, allSessions bool) (err error) {
+	const op = "New.Logout"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Bool("allSessions", allSessions),
+	)
+
+	event := models.AuditEvent{Type: models.AuditTokenRevoked, Details: map[string]string{"scope": "session"}}
+	defer a.audit(ctx, log, &event, &err)
+
+	claims, err := a.ValidateToken(ctx, token, 0)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			log.Info("logout with invalid token")
+			return fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+		}
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", claims.UserID))
+	event.ActorID, event.UserID, event.AppID = claims.UserID, claims.UserID, claims.AppID
+	event.Details["jti"] = claims.ID
+
+	// a service token is its own session
+	if allSessions && !claims.Service {
+		event.Details["scope"] = "all_sessions"
+		if err := a.storage.RevokeUserSessions(ctx, claims.UserID, time.Now()); err != nil {
+			log.Error("faild to revoke user sessions", sl.Err(err))
+			return fmt.Errorf("%s %w", op, err)
+		}
+
+		log.Info("user logged out of all sessions")
+		return nil
+	}
+
+	if claims.ID == "" {
+		log.Info("logout with token issued without jti")
+		return fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+	}
+
+	if err := a.storage.RevokeToken(ctx, claims.ID, claims.UserID, claims.ExpiresAt); err != nil {
+		log.Error("faild to revoke token", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if claims.SessionID != "" {
+		if err := a.storage.RevokeRefreshTokenFamily(ctx, claims.SessionID); err != nil {
+			log.Error("faild to revoke refresh tokens", sl.Err(err))
+			return fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	log.Info("user logged out")
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// mailTimeout bounds the background work of sending an email, it no longer
+// runs under the request's deadline.
+const mailTimeout = time.Minute
+
+// inBackground runs send detached from the request. Callers answer before
+// the lookup and the delivery happen, so neither the answer nor its timing
+// tells whether an email went out.
+func (a *Auth) inBackground(ctx context.Context, send func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailTimeout)
+
+	go func() {
+		defer cancel()
+		send(ctx)
+	}()
+}
+
+// tokenLink is the page at base with token added as ?token=.
+func tokenLink(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
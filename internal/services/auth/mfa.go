@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/sl"
+	"sso/internal/lib/totp"
+	"sso/internal/storage"
+)
+
+// codes from one step either side of now are accepted, phones drift
+const totpSkew = 1
+
+// EnrollTOTP generates a TOTP secret for the user the access token belongs
+// to. The second factor isn't required until ConfirmTOTP, so enrolling again
+// before that just replaces the secret.
+func (a *Auth) EnrollTOTP(ctx context.Context, accessToken string) (models.TOTPEnrollment, error) {
+	const op = "New.EnrollTOTP"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	user, err := a.userFromToken(ctx, accessToken)
+	if err != nil {
+		return models.TOTPEnrollment{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", user.ID))
+
+	if user.TOTPEnabled {
+		log.Info("totp already enabled")
+		return models.TOTPEnrollment{}, fmt.Errorf("%s %w", op, storage.ErrMFAAlreadyEnabled)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Error("faild to generate totp secret", sl.Err(err))
+		return models.TOTPEnrollment{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	encrypted, err := a.cipher.Encrypt(secret)
+	if err != nil {
+		log.Error("faild to encrypt totp secret", sl.Err(err))
+		return models.TOTPEnrollment{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.storage.SaveTOTPSecret(ctx, user.ID, encrypted); err != nil {
+		if !errors.Is(err, storage.ErrMFAAlreadyEnabled) {
+			log.Error("faild to save totp secret", sl.Err(err))
+		}
+		return models.TOTPEnrollment{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("totp enrolment started")
+
+	return models.TOTPEnrollment{
+		Secret:          totp.EncodeSecret(secret),
+		ProvisioningURI: totp.ProvisioningURI(a.cfg.MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables the second factor once the user entered a code from
+// their authenticator app, proving it was set up with the enrolled secret.
+// It returns the first set of recovery codes.
+func (a *Auth) ConfirmTOTP(ctx context.Context, accessToken, code string) ([]string, error) {
+	const op = "New.ConfirmTOTP"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	user, err := a.userFromToken(ctx, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", user.ID))
+
+	if user.TOTPEnabled {
+		return nil, fmt.Errorf("%s %w", op, storage.ErrMFAAlreadyEnabled)
+	}
+	if user.TOTPSecret == nil {
+		return nil, fmt.Errorf("%s %w", op, storage.ErrMFANotEnrolled)
+	}
+
+	secret, err := a.cipher.Decrypt(user.TOTPSecret)
+	if err != nil {
+		log.Error("faild to decrypt totp secret", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		log.Info("totp confirmation with wrong code")
+		return nil, fmt.Errorf("%s %w", op, storage.ErrInvalidMFACode)
+	}
+
+	if err := a.storage.EnableTOTP(ctx, user.ID, step); err != nil {
+		if !errors.Is(err, storage.ErrMFAAlreadyEnabled) {
+			log.Error("faild to enable totp", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	recoveryCodes, err := a.newRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		log.Error("faild to generate recovery codes", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("totp succefully enabled")
+
+	return recoveryCodes, nil
+}
+
+// VerifyMFA finishes a login that Login answered with an MFA token. Each
+// token allows a limited number of wrong codes, and every wrong code counts
+// towards the user's lockout.
+func (a *Auth) VerifyMFA(ctx context.Context, mfaToken, code string) (pair models.TokenPair, err error) {
+	const op = "New.VerifyMFA"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	event := models.AuditEvent{
+		Type:    models.AuditLogin,
+		Details: map[string]string{"method": "mfa"},
+	}
+	defer a.audit(ctx, log, &event, &err)
+
+	challenge, err := a.mfaChallenge(ctx, log, opaque.Hash(mfaToken))
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	event.ActorID, event.UserID, event.AppID = challenge.UserID, challenge.UserID, challenge.AppID
+
+	log = log.With(
+		slog.Int64("userID", challenge.UserID),
+		slog.Int64("appID", challenge.AppID),
+	)
+
+	user, err := a.storage.UserByID(ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidMFAToken)
+		}
+		log.Error("faild to get user", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.verifySecondFactor(ctx, log, user, code); err != nil {
+		if errors.Is(err, storage.ErrInvalidMFACode) {
+			if err := a.storage.FailMFAChallenge(ctx, challenge.TokenHash); err != nil {
+				log.Error("faild to count mfa attempt", sl.Err(err))
+				return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+			}
+		}
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	tokens, err := a.finishMFA(ctx, log, challenge, user)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("user succefully logged in with mfa")
+
+	return tokens, nil
+}
+
+// mfaChallenge returns the challenge with the given token hash while it can
+// still finish a login, otherwise ErrInvalidMFAToken.
+func (a *Auth) mfaChallenge(ctx context.Context, log *slog.Logger, tokenHash string) (models.MFAChallenge, error) {
+	challenge, err := a.storage.MFAChallenge(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, storage.ErrChallengeNotFound) {
+			log.Info("mfa challenge is not exists")
+			return models.MFAChallenge{}, storage.ErrInvalidMFAToken
+		}
+		log.Error("faild to get mfa challenge", sl.Err(err))
+		return models.MFAChallenge{}, err
+	}
+
+	if challenge.Used || challenge.Attempts >= a.cfg.MFAMaxAttempts || time.Now().After(challenge.ExpiresAt) {
+		log.Info("mfa challenge rejected", slog.Bool("used", challenge.Used), slog.Int("attempts", challenge.Attempts))
+		return models.MFAChallenge{}, storage.ErrInvalidMFAToken
+	}
+
+	return challenge, nil
+}
+
+// finishMFA consumes the challenge once the user passed the second factor
+// and issues the tokens of the login.
+func (a *Auth) finishMFA(ctx context.Context, log *slog.Logger, challenge models.MFAChallenge, user models.User) (models.TokenPair, error) {
+	if err := a.storage.UseMFAChallenge(ctx, challenge.TokenHash); err != nil {
+		if errors.Is(err, storage.ErrChallengeUsed) {
+			log.Warn("mfa challenge completed concurrently")
+			return models.TokenPair{}, storage.ErrInvalidMFAToken
+		}
+		log.Error("faild to use mfa challenge", sl.Err(err))
+		return models.TokenPair{}, err
+	}
+
+	app, err := a.storage.App(ctx, challenge.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.TokenPair{}, storage.ErrInvalidMFAToken
+		}
+		log.Error("faild to get app", sl.Err(err))
+		return models.TokenPair{}, err
+	}
+
+	tokens, err := a.issueTokens(ctx, user, app, "")
+	if err != nil {
+		log.Error("faild to generate token", sl.Err(err))
+		return models.TokenPair{}, err
+	}
+
+	return tokens, nil
+}
+
+// startMFA saves the challenge a login continues with after the password
+// and returns its token.
+func (a *Auth) startMFA(ctx context.Context, user models.User, app models.App) (string, error) {
+	mfaToken, tokenHash, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+
+	err = a.storage.SaveMFAChallenge(ctx, models.MFAChallenge{
+		TokenHash: tokenHash,
+		UserID:    user.ID,
+		AppID:     app.ID,
+		ExpiresAt: time.Now().Add(a.cfg.MFAChallengeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return mfaToken, nil
+}
+
+// verifySecondFactor is checkSecondFactor under the login lockout. A wrong
+// code counts like a wrong password, so knowing the password doesn't buy
+// unlimited guesses over new challenges, and passing forgets the failures.
+func (a *Auth) verifySecondFactor(ctx context.Context, log *slog.Logger, user models.User, code string) error {
+	if err := a.checkLoginBlock(user); err != nil {
+		log.Info("second factor refused, account blocked", sl.Err(err))
+		return err
+	}
+
+	err := a.checkSecondFactor(ctx, log, user, code)
+	if errors.Is(err, storage.ErrInvalidMFACode) {
+		if err := a.failLogin(ctx, log, user); !errors.Is(err, storage.ErrInvalidCredentials) {
+			return err
+		}
+		return storage.ErrInvalidMFACode
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.resetFailedLogins(ctx, log, user)
+}
+
+// checkSecondFactor verifies a TOTP code of the user, or one of their
+// recovery codes. A code is accepted once, replaying it gets
+// ErrInvalidMFACode like a wrong one.
+func (a *Auth) checkSecondFactor(ctx context.Context, log *slog.Logger, user models.User, code string) error {
+	if len(code) != totp.Digits {
+		return a.useRecoveryCode(ctx, log, user, code)
+	}
+	if !user.TOTPEnabled {
+		log.Info("totp code for a user without totp")
+		return storage.ErrInvalidMFACode
+	}
+
+	secret, err := a.cipher.Decrypt(user.TOTPSecret)
+	if err != nil {
+		log.Error("faild to decrypt totp secret", sl.Err(err))
+		return err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		log.Info("wrong totp code")
+		return storage.ErrInvalidMFACode
+	}
+
+	if err := a.storage.UseTOTPStep(ctx, user.ID, step); err != nil {
+		if errors.Is(err, storage.ErrMFACodeUsed) {
+			log.Warn("totp code replayed")
+			return storage.ErrInvalidMFACode
+		}
+		log.Error("faild to save totp step", sl.Err(err))
+		return err
+	}
+
+	return nil
+}
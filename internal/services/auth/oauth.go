@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/jwt"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+const (
+	ScopeOpenID = "openid"
+	ScopeEmail  = "email"
+)
+
+// RFC 7636 section 4.1
+var codeVerifierRe = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// CheckAuthorizeRequest validates the client and redirect URI of an OAuth 2.0
+// authorization request before the login page is shown. ErrInvalidClient and
+// ErrInvalidRedirectURI must not be redirected back, since the redirect URI
+// itself can't be trusted; ErrInvalidRequest and ErrInvalidScope can.
+func (a *Auth) CheckAuthorizeRequest(ctx context.Context, req models.AuthorizeRequest) (models.App, error) {
+	const op = "New.CheckAuthorizeRequest"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("appID", req.AppID),
+	)
+
+	app, err := a.storage.App(ctx, req.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("authorize request for unknown app")
+			return models.App{}, fmt.Errorf("%s %w", op, storage.ErrInvalidClient)
+		}
+		log.Error("faild to get app", sl.Err(err))
+		return models.App{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if !slices.Contains(app.RedirectURIs, req.RedirectURI) {
+		log.Info("authorize request with unregistered redirect uri", slog.String("redirectURI", req.RedirectURI))
+		return models.App{}, fmt.Errorf("%s %w", op, storage.ErrInvalidRedirectURI)
+	}
+
+	if req.ResponseType != models.ResponseTypeCode {
+		return app, fmt.Errorf("%s %w: unsupported response_type", op, storage.ErrInvalidRequest)
+	}
+
+	// PKCE is required for every client, plain challenges are not accepted
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != models.CodeChallengeS256 {
+		return app, fmt.Errorf("%s %w: code_challenge with S256 method is required", op, storage.ErrInvalidRequest)
+	}
+
+	// the OpenID Connect scopes are every app's, the others must be registered
+	if _, err := grantScopes(append([]string{ScopeOpenID, ScopeEmail}, app.Scopes...), req.Scope); err != nil {
+		log.Info("authorize request with unregistered scope", sl.Err(err))
+		return app, fmt.Errorf("%s %w", op, err)
+	}
+
+	return app, nil
+}
+
+// Authorize authenticates the user on the login page and issues a single-use
+// authorization code bound to the client, redirect URI and PKCE challenge.
+// Users with a second factor enter its code on the same page.
+func (a *Auth) Authorize(ctx context.Context, req models.AuthorizeRequest, email, password, mfaCode string) (_ string, err error) {
+	const op = "New.Authorize"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("appID", req.AppID),
+		slog.String("email", email),
+	)
+
+	app, err := a.CheckAuthorizeRequest(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("%s %w", op, err)
+	}
+
+	event := passwordLoginEvent(email, app.ID)
+	defer a.audit(ctx, log, &event, &err)
+
+	user, err := a.authenticateWithCode(ctx, log, &event, email, password, mfaCode)
+	if err != nil {
+		return "", fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := requireVerifiedEmail(user, app); err != nil {
+		log.Info("authorization refused, email not verified")
+		return "", fmt.Errorf("%s %w", op, err)
+	}
+
+	code, codeHash, err := opaque.New()
+	if err != nil {
+		log.Error("faild to generate authorization code", sl.Err(err))
+		return "", fmt.Errorf("%s %w", op, err)
+	}
+
+	now := time.Now()
+
+	err = a.storage.SaveAuthorizationCode(ctx, models.AuthorizationCode{
+		CodeHash:      codeHash,
+		AppID:         req.AppID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(a.cfg.AuthorizationCodeTTL),
+	})
+	if err != nil {
+		log.Error("faild to save authorization code", sl.Err(err))
+		return "", fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("authorization code issued")
+
+	return code, nil
+}
+
+// ExchangeCode is the authorization_code grant of the token endpoint. The
+// client secret is optional because public clients are authenticated by PKCE
+// alone, but when it is sent it must match.
+func (a *Auth) ExchangeCode(ctx context.Context, appID int64, clientSecret, code, redirectURI, codeVerifier string) (pair models.TokenPair, err error) {
+	const op = "New.ExchangeCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("appID", appID),
+	)
+
+	event := tokenIssuedEvent(appID, models.GrantTypeAuthorizationCode)
+	defer a.audit(ctx, log, &event, &err)
+
+	app, err := a.storage.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("token request for unknown app")
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidClient)
+		}
+		log.Error("faild to get app", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if clientSecret != "" && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(app.Secret)) != 1 {
+		log.Info("token request with wrong client secret")
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidClient)
+	}
+
+	stored, err := a.storage.AuthorizationCode(ctx, opaque.Hash(code))
+	if err != nil {
+		if errors.Is(err, storage.ErrCodeNotFound) {
+			log.Info("authorization code is not exists")
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+		}
+		log.Error("faild to get authorization code", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	event.ActorID, event.UserID = stored.UserID, stored.UserID
+
+	if stored.Used || stored.AppID != appID || stored.RedirectURI != redirectURI || time.Now().After(stored.ExpiresAt) {
+		log.Info("authorization code rejected",
+			slog.Bool("used", stored.Used),
+			slog.Int64("codeAppID", stored.AppID),
+		)
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+	}
+
+	if !verifyCodeChallenge(codeVerifier, stored.CodeChallenge) {
+		log.Info("code verifier does not match challenge")
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+	}
+
+	if err := a.storage.UseAuthorizationCode(ctx, stored.CodeHash); err != nil {
+		if errors.Is(err, storage.ErrCodeUsed) {
+			log.Warn("authorization code exchanged concurrently")
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+		}
+		log.Error("faild to use authorization code", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	user, err := a.storage.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidGrant)
+		}
+		log.Error("faild to get user", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	tokens, err := a.issueTokens(ctx, user, app, "")
+	if err != nil {
+		log.Error("faild to generate token", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if hasScope(stored.Scope, ScopeOpenID) {
+		tokens.IDToken, err = a.issueIDToken(user, app, stored.Nonce, stored.AuthTime)
+		if err != nil {
+			log.Error("faild to generate id token", sl.Err(err))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	log.Info("authorization code exchanged", slog.Int64("userID", user.ID))
+
+	return tokens, nil
+}
+
+func (a *Auth) issueIDToken(user models.User, app models.App, nonce string, authTime time.Time) (string, error) {
+	return jwt.NewIDToken(user, app, a.keys, jwt.IDTokenOptions{
+		Issuer:   a.cfg.Issuer,
+		Duration: a.cfg.TokenTTL,
+		Nonce:    nonce,
+		AuthTime: authTime,
+	})
+}
+
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if !codeVerifierRe.MatchString(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// UserInfo is the OpenID Connect UserInfo endpoint: it returns the user the
+// access token was issued to.
+func (a *Auth) UserInfo(ctx context.Context, accessToken string) (models.User, error) {
+	const op = "New.UserInfo"
+
+	user, err := a.userFromToken(ctx, accessToken)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return user, nil
+}
+
+func (a *Auth) Issuer() string {
+	return a.cfg.Issuer
+}
+
+// SigningAlgorithms lists the algorithms tokens may be signed with: the server
+// key's and HS256 for apps that opted in to shared secrets.
+func (a *Auth) SigningAlgorithms() []string {
+	return []string{a.keys.SigningKey().Algorithm, jwt.AlgHS256}
+}
+
+func hasScope(scope, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/sl"
+	"sso/internal/mail"
+	"sso/internal/storage"
+)
+
+// ChangePassword replaces the password of the token's user after checking
+// the current one, which is throttled and locked out like a login. Every
+// other session of the user is revoked, so a stolen token or refresh token
+// doesn't outlive the change.
+func (a *Auth) ChangePassword(ctx context.Context, accessToken, currentPassword, newPassword string) (err error) {
+	const op = "New.ChangePassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	event := models.AuditEvent{Type: models.AuditPasswordChanged}
+	defer a.audit(ctx, log, &event, &err)
+
+	claims, err := a.ValidateToken(ctx, accessToken, 0)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if claims.Service {
+		log.Info("password change with service token")
+		return fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+	}
+
+	log = log.With(slog.Int64("userID", claims.UserID))
+	event.ActorID, event.UserID, event.AppID = claims.UserID, claims.UserID, claims.AppID
+
+	user, err := a.storage.UserByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s %w", op, storage.ErrInvalidToken)
+		}
+		log.Error("faild to get user", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	// a stolen token must not be a way around the login throttling
+	if err := a.checkIPLogins(ctx, log, clientip.FromContext(ctx)); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if _, err := a.verifyPassword(ctx, log, user, currentPassword); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.checkPassword(ctx, log, newPassword, user.Email); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	hashed, err := a.hashPassword(log, newPassword, op)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.storage.UpdatePassword(ctx, user.ID, hashed, time.Now(), claims.SessionID); err != nil {
+		log.Error("faild to update password", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("password succefully changed, other sessions revoked")
+
+	return nil
+}
+
+// RequestPasswordReset emails a single-use reset token to the user. The
+// caller learns nothing about the email: the lookup and the delivery run
+// in the background, so neither the answer nor its timing tells whether
+// the email is registered.
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) {
+	a.inBackground(ctx, func(ctx context.Context) {
+		a.sendPasswordReset(ctx, email)
+	})
+}
+
+func (a *Auth) sendPasswordReset(ctx context.Context, email string) {
+	const op = "New.RequestPasswordReset"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	user, err := a.storage.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("password reset for unknown email")
+			return
+		}
+		log.Error("faild to get user", sl.Err(err))
+		return
+	}
+
+	log = log.With(slog.Int64("userID", user.ID))
+
+	token, tokenHash, err := opaque.New()
+	if err != nil {
+		log.Error("faild to generate reset token", sl.Err(err))
+		return
+	}
+
+	err = a.storage.SavePasswordReset(ctx, models.PasswordReset{
+		TokenHash: tokenHash,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(a.cfg.PasswordResetTTL),
+	})
+	if err != nil {
+		log.Error("faild to save password reset", sl.Err(err))
+		return
+	}
+
+	msg, err := a.passwordResetMessage(user.Email, token)
+	if err != nil {
+		log.Error("faild to compose reset email", sl.Err(err))
+		return
+	}
+
+	if err := a.mailer.Send(ctx, msg); err != nil {
+		log.Error("faild to send reset email", sl.Err(err))
+		return
+	}
+
+	log.Info("password reset succefully sent")
+}
+
+func (a *Auth) passwordResetMessage(email, token string) (mail.Message, error) {
+	var body strings.Builder
+
+	body.WriteString("Someone asked to reset the password of your account.\n\n")
+
+	if a.cfg.PasswordResetURL != "" {
+		link, err := tokenLink(a.cfg.PasswordResetURL, token)
+		if err != nil {
+			return mail.Message{}, err
+		}
+
+		fmt.Fprintf(&body, "Open this link to choose a new password:\n\n%s\n\n", link)
+	} else {
+		fmt.Fprintf(&body, "Use this token to choose a new password:\n\n%s\n\n", token)
+	}
+
+	fmt.Fprintf(&body, "It works once and expires in %d minutes. If it wasn't you, ignore this email, your password stays the same.\n",
+		int(a.cfg.PasswordResetTTL.Minutes()))
+
+	return mail.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body:    body.String(),
+	}, nil
+}
+
+// ResetPassword sets a new password with a token from RequestPasswordReset.
+// All sessions of the user are revoked, whoever knew the old password is
+// logged out.
+func (a *Auth) ResetPassword(ctx context.Context, token, newPassword string) (err error) {
+	const op = "New.ResetPassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	event := models.AuditEvent{Type: models.AuditPasswordReset}
+	defer a.audit(ctx, log, &event, &err)
+
+	reset, err := a.storage.PasswordReset(ctx, opaque.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrResetNotFound) {
+			log.Info("unknown password reset token")
+			return fmt.Errorf("%s %w", op, storage.ErrInvalidResetToken)
+		}
+		log.Error("faild to get password reset", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", reset.UserID))
+	event.UserID = reset.UserID
+
+	if reset.Used {
+		log.Info("password reset token already used")
+		return fmt.Errorf("%s %w", op, storage.ErrInvalidResetToken)
+	}
+	if time.Now().After(reset.ExpiresAt) {
+		log.Info("password reset token expired")
+		return fmt.Errorf("%s %w", op, storage.ErrInvalidResetToken)
+	}
+
+	user, err := a.storage.UserByID(ctx, reset.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s %w", op, storage.ErrInvalidResetToken)
+		}
+		log.Error("faild to get user", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	// checked before the token is used, a refused password can be retried
+	if err := a.checkPassword(ctx, log, newPassword, user.Email); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.storage.UsePasswordReset(ctx, reset.TokenHash); err != nil {
+		if errors.Is(err, storage.ErrResetUsed) {
+			return fmt.Errorf("%s %w", op, storage.ErrInvalidResetToken)
+		}
+		log.Error("faild to use password reset", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	hashed, err := a.hashPassword(log, newPassword, op)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.storage.UpdatePassword(ctx, reset.UserID, hashed, time.Now(), ""); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s %w", op, storage.ErrInvalidResetToken)
+		}
+		log.Error("faild to update password", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("password succefully reset, all sessions revoked")
+
+	return nil
+}
+
+// checkPassword applies the password policy to a new password. A failed
+// breach lookup doesn't stop the user, the other rules still apply.
+func (a *Auth) checkPassword(ctx context.Context, log *slog.Logger, password, email string) error {
+	violations, err := a.passwords.Check(ctx, password, email)
+	if err != nil {
+		log.Warn("faild to check password against breaches", sl.Err(err))
+	}
+	if len(violations) > 0 {
+		log.Info("password refused by policy", slog.Int("violations", len(violations)))
+		return &storage.WeakPasswordError{Violations: violations}
+	}
+	return nil
+}
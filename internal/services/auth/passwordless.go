@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/sl"
+	"sso/internal/mail"
+	"sso/internal/storage"
+)
+
+const passwordlessCodeDigits = 6
+
+// StartPasswordlessLogin emails the user a sign-in link or a one-time code
+// for app. For a code the returned login token names the login, the app
+// finishes it with the token and the code the user types in, for a link the
+// token is empty. Unknown emails get the same answer and no email.
+func (a *Auth) StartPasswordlessLogin(ctx context.Context, email string, appID int64, method string) (string, error) {
+	const op = "New.StartPasswordlessLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+		slog.Int64("appID", appID),
+		slog.String("method", method),
+	)
+
+	if method != models.PasswordlessLink && method != models.PasswordlessCode {
+		return "", fmt.Errorf("%s %w", op, storage.ErrInvalidRequest)
+	}
+
+	app, err := a.storage.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Error("app is not exists", sl.Err(err))
+			return "", fmt.Errorf("%s %w", op, storage.ErrAppNotFound)
+		}
+		log.Error("faild to get app", sl.Err(err))
+		return "", fmt.Errorf("%s %w", op, err)
+	}
+
+	// handed out before the email is looked up, so it tells nothing
+	var loginToken string
+	if method == models.PasswordlessCode {
+		loginToken, _, err = opaque.New()
+		if err != nil {
+			log.Error("faild to generate login token", sl.Err(err))
+			return "", fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	a.inBackground(ctx, func(ctx context.Context) {
+		a.sendPasswordlessLogin(ctx, log, email, app, method, loginToken)
+	})
+
+	return loginToken, nil
+}
+
+func (a *Auth) sendPasswordlessLogin(ctx context.Context, log *slog.Logger, email string, app models.App, method, loginToken string) {
+	user, err := a.storage.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("passwordless login for unknown email")
+			return
+		}
+		log.Error("faild to get user", sl.Err(err))
+		return
+	}
+
+	log = log.With(slog.Int64("userID", user.ID))
+
+	login := models.PasswordlessLogin{
+		UserID:    user.ID,
+		AppID:     app.ID,
+		Method:    method,
+		ExpiresAt: time.Now().Add(a.cfg.PasswordlessTTL),
+	}
+
+	// secret is what the email carries: the link's token or the code
+	var secret string
+	if method == models.PasswordlessLink {
+		secret, login.TokenHash, err = opaque.New()
+	} else {
+		secret, err = newPasswordlessCode()
+		login.TokenHash, login.CodeHash = opaque.Hash(loginToken), opaque.Hash(secret)
+	}
+	if err != nil {
+		log.Error("faild to generate passwordless secret", sl.Err(err))
+		return
+	}
+
+	if err := a.storage.SavePasswordlessLogin(ctx, login); err != nil {
+		log.Error("faild to save passwordless login", sl.Err(err))
+		return
+	}
+
+	msg, err := a.passwordlessMessage(user.Email, app, method, secret)
+	if err != nil {
+		log.Error("faild to compose passwordless email", sl.Err(err))
+		return
+	}
+
+	if err := a.mailer.Send(ctx, msg); err != nil {
+		log.Error("faild to send passwordless email", sl.Err(err))
+		return
+	}
+
+	log.Info("passwordless login succefully sent")
+}
+
+func (a *Auth) passwordlessMessage(email string, app models.App, method, secret string) (mail.Message, error) {
+	var body strings.Builder
+
+	subject := "Your sign-in code"
+	if method == models.PasswordlessLink {
+		subject = "Your sign-in link"
+
+		if a.cfg.PasswordlessURL != "" {
+			link, err := tokenLink(a.cfg.PasswordlessURL, secret)
+			if err != nil {
+				return mail.Message{}, err
+			}
+
+			fmt.Fprintf(&body, "Open this link to sign in to %s:\n\n%s\n\n", app.Name, link)
+		} else {
+			fmt.Fprintf(&body, "Use this token to sign in to %s:\n\n%s\n\n", app.Name, secret)
+		}
+	} else {
+		fmt.Fprintf(&body, "Your code to sign in to %s is:\n\n%s\n\n", app.Name, secret)
+	}
+
+	fmt.Fprintf(&body, "It works once and expires in %d minutes. If you didn't try to sign in, ignore this email.\n",
+		int(a.cfg.PasswordlessTTL.Minutes()))
+
+	return mail.Message{
+		To:      email,
+		Subject: subject,
+		Body:    body.String(),
+	}, nil
+}
+
+// CompletePasswordlessLogin finishes a login from StartPasswordlessLogin,
+// with the link's token or with the login token and the code. The login
+// only finishes for the app that started it, and a code login allows a
+// limited number of wrong codes. Blocked accounts are refused like by
+// Login, and wrong codes count towards the lockout with wrong passwords,
+// so starting new logins doesn't bring new guesses. Like Login, users with
+// a second factor get an MFA token instead of tokens.
+func (a *Auth) CompletePasswordlessLogin(ctx context.Context, token, code string, appID int64) (result models.LoginResult, err error) {
+	const op = "New.CompletePasswordlessLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("appID", appID),
+	)
+
+	event := models.AuditEvent{
+		Type:    models.AuditLogin,
+		AppID:   appID,
+		Details: map[string]string{"method": "passwordless"},
+	}
+	defer a.audit(ctx, log, &event, &err)
+
+	ip := clientip.FromContext(ctx)
+	if err := a.checkIPLogins(ctx, log, ip); err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	login, err := a.storage.PasswordlessLogin(ctx, opaque.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrPasswordlessNotFound) {
+			log.Info("passwordless login is not exists")
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, storage.ErrInvalidPasswordless)
+		}
+		log.Error("faild to get passwordless login", sl.Err(err))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", login.UserID))
+	event.ActorID, event.UserID = login.UserID, login.UserID
+
+	if login.Used || login.Attempts >= a.cfg.PasswordlessMaxAttempts || time.Now().After(login.ExpiresAt) {
+		log.Info("passwordless login rejected", slog.Bool("used", login.Used), slog.Int("attempts", login.Attempts))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, storage.ErrInvalidPasswordless)
+	}
+	if login.AppID != appID {
+		log.Warn("passwordless login completed for another app", slog.Int64("startedFor", login.AppID))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, storage.ErrInvalidPasswordless)
+	}
+
+	user, err := a.storage.UserByID(ctx, login.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, storage.ErrInvalidPasswordless)
+		}
+		log.Error("faild to get user", sl.Err(err))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.checkLoginBlock(user); err != nil {
+		log.Info("login refused, account blocked", sl.Err(err))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if login.Method == models.PasswordlessCode &&
+		subtle.ConstantTimeCompare([]byte(opaque.Hash(code)), []byte(login.CodeHash)) != 1 {
+		log.Info("wrong passwordless code")
+		if err := a.storage.FailPasswordlessLogin(ctx, login.TokenHash); err != nil {
+			log.Error("faild to count passwordless attempt", sl.Err(err))
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+		}
+		if err := a.failIPLogin(ctx, log, ip); err != nil {
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+		}
+		if err := a.failLogin(ctx, log, user); !errors.Is(err, storage.ErrInvalidCredentials) {
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+		}
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, storage.ErrInvalidPasswordlessCode)
+	}
+
+	if err := a.storage.UsePasswordlessLogin(ctx, login.TokenHash); err != nil {
+		if errors.Is(err, storage.ErrPasswordlessUsed) {
+			log.Warn("passwordless login completed concurrently")
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, storage.ErrInvalidPasswordless)
+		}
+		log.Error("faild to use passwordless login", sl.Err(err))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	app, err := a.storage.App(ctx, login.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, storage.ErrInvalidPasswordless)
+		}
+		log.Error("faild to get app", sl.Err(err))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	// reading the email proved the address is the user's
+	if !user.EmailVerified {
+		if err := a.storage.SetEmailVerified(ctx, user.ID); err != nil {
+			log.Error("faild to mark email verified", sl.Err(err))
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+		}
+		user.EmailVerified = true
+	}
+
+	if err := a.storage.InvalidatePasswordResets(ctx, user.ID); err != nil {
+		log.Error("faild to invalidate password resets", sl.Err(err))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if user.MFAEnabled() {
+		mfaToken, err := a.startMFA(ctx, user, app)
+		if err != nil {
+			log.Error("faild to start mfa challenge", sl.Err(err))
+			return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		log.Info("passwordless login accepted, mfa required")
+		event.Outcome = models.AuditMFARequired
+		return models.LoginResult{MFAToken: mfaToken, MFAMethods: user.MFAMethods()}, nil
+	}
+
+	if err := a.resetFailedLogins(ctx, log, user); err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	tokens, err := a.issueTokens(ctx, user, app, "")
+	if err != nil {
+		log.Error("faild to generate token", sl.Err(err))
+		return models.LoginResult{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("user succefully logged in without password")
+
+	return models.LoginResult{Tokens: tokens}, nil
+}
+
+func newPasswordlessCode() (string, error) {
+	limit := big.NewInt(1)
+	for range passwordlessCodeDigits {
+		limit.Mul(limit, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", passwordlessCodeDigits, n), nil
+}
@@ -0,0 +1,409 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/abac"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+// policyErrors are the storage errors of policy calls passed on to the
+// caller, anything else is a failure of the service.
+var policyErrors = []error{
+	storage.ErrPolicyExists,
+	storage.ErrPolicyNotFound,
+	storage.ErrUserNotFound,
+	storage.ErrAppNotFound,
+}
+
+// policyError logs err and returns it wrapped in op.
+func policyError(log *slog.Logger, op string, err error) error {
+	for _, known := range policyErrors {
+		if errors.Is(err, known) {
+			log.Info("policy can't be changed", sl.Err(err))
+			return fmt.Errorf("%s %w", op, known)
+		}
+	}
+	log.Error("faild to change policy", sl.Err(err))
+	return fmt.Errorf("%s %w", op, err)
+}
+
+// attributeName is what subject attributes may be called, conditions
+// read them as subject.<name>.
+var attributeName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// subjectAttributes are filled in by AuthorizeAction, custom attributes can't
+// take their names.
+var subjectAttributes = []string{"id", "email", "email_verified", "roles", "permissions", "groups"}
+
+func invalidPolicy(reason string) error {
+	return &storage.InvalidInputError{Err: storage.ErrInvalidPolicy, Reason: reason}
+}
+
+func invalidAttribute(reason string) error {
+	return &storage.InvalidInputError{Err: storage.ErrInvalidAttribute, Reason: reason}
+}
+
+func validatePolicy(policy models.Policy) error {
+	if policy.Effect != models.EffectAllow && policy.Effect != models.EffectDeny {
+		return invalidPolicy(fmt.Sprintf("effect must be %q or %q", models.EffectAllow, models.EffectDeny))
+	}
+
+	if len(policy.Actions) == 0 {
+		return invalidPolicy("at least one action is required")
+	}
+	for _, action := range policy.Actions {
+		if action == "" || strings.Contains(strings.TrimSuffix(action, "*"), "*") {
+			return invalidPolicy(fmt.Sprintf("action %q must be a name or a prefix ending in *", action))
+		}
+	}
+
+	if policy.Condition != "" {
+		if _, err := abac.Compile(policy.Condition); err != nil {
+			return invalidPolicy(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// CreatePolicy adds an access policy to an app. The access token must
+// carry the policies:manage permission, as for every call managing
+// policies and attributes.
+func (a *Auth) CreatePolicy(ctx context.Context, accessToken string, policy models.Policy) (policyID int64, err error) {
+	const op = "New.CreatePolicy"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("appID", policy.AppID),
+		slog.String("policy", policy.Name),
+	)
+
+	event := models.AuditEvent{Type: models.AuditPolicyCreated, AppID: policy.AppID, Details: map[string]string{
+		"policy": policy.Name,
+	}}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionPoliciesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := validatePolicy(policy); err != nil {
+		log.Info("policy rejected", sl.Err(err))
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	policy.CreatedAt = time.Now()
+	policy.UpdatedAt = policy.CreatedAt
+
+	policyID, err = a.storage.SavePolicy(ctx, policy)
+	if err != nil {
+		return 0, policyError(log, op, err)
+	}
+	event.Details["policy_id"] = strconv.FormatInt(policyID, 10)
+
+	log.Info("policy succefully created", slog.Int64("policyID", policyID))
+
+	return policyID, nil
+}
+
+// UpdatePolicy replaces a policy, it stays in its app.
+func (a *Auth) UpdatePolicy(ctx context.Context, accessToken string, policy models.Policy) (err error) {
+	const op = "New.UpdatePolicy"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("policyID", policy.ID),
+	)
+
+	event := models.AuditEvent{Type: models.AuditPolicyUpdated, Details: map[string]string{
+		"policy_id": strconv.FormatInt(policy.ID, 10),
+		"policy":    policy.Name,
+	}}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionPoliciesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := validatePolicy(policy); err != nil {
+		log.Info("policy rejected", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	stored, err := a.storage.Policy(ctx, policy.ID)
+	if err != nil {
+		return policyError(log, op, err)
+	}
+	event.AppID = stored.AppID
+
+	policy.UpdatedAt = time.Now()
+	if err := a.storage.UpdatePolicy(ctx, policy); err != nil {
+		return policyError(log, op, err)
+	}
+
+	log.Info("policy succefully updated")
+
+	return nil
+}
+
+func (a *Auth) DeletePolicy(ctx context.Context, accessToken string, policyID int64) (err error) {
+	const op = "New.DeletePolicy"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("policyID", policyID),
+	)
+
+	event := models.AuditEvent{Type: models.AuditPolicyDeleted, Details: map[string]string{
+		"policy_id": strconv.FormatInt(policyID, 10),
+	}}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionPoliciesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	stored, err := a.storage.Policy(ctx, policyID)
+	if err != nil {
+		return policyError(log, op, err)
+	}
+	event.AppID = stored.AppID
+	event.Details["policy"] = stored.Name
+
+	if err := a.storage.DeletePolicy(ctx, policyID); err != nil {
+		return policyError(log, op, err)
+	}
+
+	log.Info("policy succefully deleted")
+
+	return nil
+}
+
+// ListPolicies returns the policies of an app.
+func (a *Auth) ListPolicies(ctx context.Context, accessToken string, appID int64) ([]models.Policy, error) {
+	const op = "New.ListPolicies"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("appID", appID),
+	)
+
+	if _, err := a.requirePermission(ctx, log, accessToken, models.PermissionPoliciesManage); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	policies, err := a.storage.Policies(ctx, appID)
+	if err != nil {
+		log.Error("faild to list policies", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return policies, nil
+}
+
+// SetUserAttribute sets an attribute policies read as subject.<name> to a
+// JSON value, or removes it when value is empty.
+func (a *Auth) SetUserAttribute(ctx context.Context, accessToken string, userID int64, name, value string) (err error) {
+	const op = "New.SetUserAttribute"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("userID", userID),
+		slog.String("attribute", name),
+	)
+
+	event := models.AuditEvent{Type: models.AuditUserAttributeSet, UserID: userID, Details: map[string]string{
+		"attribute": name,
+	}}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionPoliciesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	switch {
+	case !attributeName.MatchString(name):
+		err = invalidAttribute("name must be letters, digits and underscores, not starting with a digit")
+	case slices.Contains(subjectAttributes, name):
+		err = invalidAttribute(fmt.Sprintf("%s is a built-in attribute", name))
+	case value != "" && !json.Valid([]byte(value)):
+		err = invalidAttribute("value must be JSON")
+	}
+	if err != nil {
+		log.Info("attribute rejected", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.storage.SetUserAttribute(ctx, userID, name, value); err != nil {
+		return policyError(log, op, err)
+	}
+
+	log.Info("attribute succefully set")
+
+	return nil
+}
+
+// AuthorizeAction decides whether the user may take action on a resource of
+// the app by the app's policies. resource and requestContext are JSON
+// objects the policies read as resource and context, either may be empty.
+// Like HasPermission it's meant for trusted backends and takes no token.
+func (a *Auth) AuthorizeAction(ctx context.Context, userID, appID int64, action, resource, requestContext string) (models.Decision, error) {
+	const op = "New.AuthorizeAction"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("userID", userID),
+		slog.Int64("appID", appID),
+		slog.String("action", action),
+	)
+
+	resourceAttrs, err := jsonObject("resource", resource)
+	if err != nil {
+		return models.Decision{}, fmt.Errorf("%s %w", op, err)
+	}
+	contextAttrs, err := jsonObject("context", requestContext)
+	if err != nil {
+		return models.Decision{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	subject, err := a.subjectAttributes(ctx, userID, appID)
+	if err != nil {
+		for _, known := range []error{storage.ErrAppNotFound, storage.ErrUserNotFound} {
+			if errors.Is(err, known) {
+				log.Info("can't authorize", sl.Err(err))
+				return models.Decision{}, fmt.Errorf("%s %w", op, known)
+			}
+		}
+		log.Error("faild to load subject attributes", sl.Err(err))
+		return models.Decision{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	policies, err := a.storage.Policies(ctx, appID)
+	if err != nil {
+		log.Error("faild to load policies", sl.Err(err))
+		return models.Decision{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	decision := abac.Decide(policies, action, abac.Attributes{
+		abac.RootSubject:  subject,
+		abac.RootResource: resourceAttrs,
+		abac.RootContext:  contextAttrs,
+		abac.RootEnv:      a.envAttributes(),
+	})
+
+	if decision.Allowed != decision.DryRunAllowed {
+		log.Warn("dry-run policies would change the decision",
+			slog.Bool("allowed", decision.Allowed),
+			slog.Bool("dryRunAllowed", decision.DryRunAllowed),
+		)
+	}
+
+	return decision, nil
+}
+
+// subjectAttributes are the user's custom attributes, then who they are
+// and what they hold in the app. It fails with ErrAppNotFound or
+// ErrUserNotFound first.
+func (a *Auth) subjectAttributes(ctx context.Context, userID, appID int64) (map[string]any, error) {
+	if _, err := a.storage.App(ctx, appID); err != nil {
+		return nil, err
+	}
+
+	user, err := a.storage.UserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	custom, err := a.storage.UserAttributes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	grants, err := a.storage.UserGrants(ctx, userID, appID)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := a.storage.UserGroups(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]any, len(custom)+len(subjectAttributes))
+	for name, raw := range custom {
+		var value any
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			return nil, err
+		}
+		attrs[name] = value
+	}
+
+	attrs["id"] = float64(user.ID)
+	attrs["email"] = user.Email
+	attrs["email_verified"] = user.EmailVerified
+	attrs["roles"] = anySlice(grants.Roles)
+	attrs["permissions"] = anySlice(grants.Permissions)
+	attrs["groups"] = anySlice(groups)
+
+	return attrs, nil
+}
+
+// envAttributes is the moment of the request in the configured zone:
+// time as unix seconds, date as YYYY-MM-DD, hour and weekday as
+// "monday".
+func (a *Auth) envAttributes() map[string]any {
+	loc := a.cfg.PolicyLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	now := time.Now().In(loc)
+
+	return map[string]any{
+		"time":    float64(now.Unix()),
+		"date":    now.Format(time.DateOnly),
+		"hour":    float64(now.Hour()),
+		"weekday": strings.ToLower(now.Weekday().String()),
+	}
+}
+
+// jsonObject decodes the attributes of a request, empty is no attributes.
+func jsonObject(field, value string) (map[string]any, error) {
+	attrs := make(map[string]any)
+	if value == "" {
+		return attrs, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &attrs); err != nil || attrs == nil {
+		return nil, invalidAttribute(fmt.Sprintf("%s must be a JSON object", field))
+	}
+
+	return attrs, nil
+}
+
+func anySlice(values []string) []any {
+	items := make([]any, len(values))
+	for i, value := range values {
+		items[i] = value
+	}
+	return items
+}
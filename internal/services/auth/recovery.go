@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+const (
+	recoveryCodeCount = 10
+	// 10 base32 characters, 50 bits, shown as xxxxx-xxxxx
+	recoveryCodeLength = 10
+	recoveryAlphabet   = "abcdefghijklmnopqrstuvwxyz234567"
+	// the first 2 characters are stored in plain to find the code's hash,
+	// the other 40 bits stay behind it
+	recoveryLookupLength = 2
+)
+
+// RegenerateRecoveryCodes replaces the recovery codes of the token's user.
+// The old set stops working.
+func (a *Auth) RegenerateRecoveryCodes(ctx context.Context, accessToken string) ([]string, error) {
+	const op = "New.RegenerateRecoveryCodes"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	user, err := a.userFromToken(ctx, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", user.ID))
+
+	if !user.MFAEnabled() {
+		return nil, fmt.Errorf("%s %w", op, storage.ErrMFANotEnrolled)
+	}
+
+	codes, err := a.newRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		log.Error("faild to generate recovery codes", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	err = a.recordAudit(ctx, models.AuditEvent{
+		Type:      models.AuditRecoveryCodesRegenerated,
+		ActorID:   user.ID,
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Error("faild to save audit event", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("recovery codes regenerated")
+
+	return codes, nil
+}
+
+// newRecoveryCodes generates and stores a fresh set of recovery codes. The
+// plain codes are only ever returned here. No two codes of a set share a
+// lookup id, so a login verifies at most one hash.
+func (a *Auth) newRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	stored := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	lookupIDs := make(map[string]bool, recoveryCodeCount)
+
+	for len(codes) < recoveryCodeCount {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		lookupID := code[:recoveryLookupLength]
+		if lookupIDs[lookupID] {
+			continue
+		}
+		lookupIDs[lookupID] = true
+
+		hash, err := a.hasher.Hash(code)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+		stored = append(stored, models.RecoveryCode{LookupID: lookupID, CodeHash: hash})
+	}
+
+	if err := a.storage.ReplaceRecoveryCodes(ctx, userID, stored); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// useRecoveryCode accepts one of the user's unused recovery codes in place
+// of a TOTP code. Every use is audited, a used code is a lost device.
+func (a *Auth) useRecoveryCode(ctx context.Context, log *slog.Logger, user models.User, code string) error {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) != recoveryCodeLength {
+		return storage.ErrInvalidMFACode
+	}
+
+	stored, err := a.storage.RecoveryCodes(ctx, user.ID)
+	if err != nil {
+		log.Error("faild to get recovery codes", sl.Err(err))
+		return err
+	}
+
+	for _, recoveryCode := range stored {
+		// only the code the lookup id points at is worth the hashing, codes
+		// stored without one are all tried
+		if recoveryCode.LookupID != "" && recoveryCode.LookupID != code[:recoveryLookupLength] {
+			continue
+		}
+
+		// a code made with outdated settings needs no rehash, it is used up here
+		ok, _, err := a.hasher.Verify(recoveryCode.CodeHash, code)
+		if err != nil {
+			log.Error("faild to verify recovery code", sl.Err(err))
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := a.storage.UseRecoveryCode(ctx, recoveryCode.ID); err != nil {
+			if errors.Is(err, storage.ErrRecoveryCodeUsed) {
+				log.Warn("recovery code used concurrently")
+				return storage.ErrInvalidMFACode
+			}
+			log.Error("faild to use recovery code", sl.Err(err))
+			return err
+		}
+
+		remaining := len(stored) - 1
+
+		err = a.recordAudit(ctx, models.AuditEvent{
+			Type:    models.AuditRecoveryCodeUsed,
+			ActorID: user.ID,
+			UserID:  user.ID,
+			Details: map[string]string{
+				"remaining": strconv.Itoa(remaining),
+			},
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			log.Error("faild to save audit event", sl.Err(err))
+			return err
+		}
+
+		log.Warn("recovery code used", slog.Int("remaining", remaining))
+		return nil
+	}
+
+	log.Info("wrong recovery code")
+	return storage.ErrInvalidMFACode
+}
+
+func randomRecoveryCode() (string, error) {
+	buf := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	// 256 is a multiple of 32, so this is uniform
+	for i, b := range buf {
+		buf[i] = recoveryAlphabet[int(b)%len(recoveryAlphabet)]
+	}
+
+	return string(buf), nil
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+)
+
+// CreateRole creates a role with its permissions. The access token must
+// carry the roles:manage permission, as for every call managing roles.
+func (a *Auth) CreateRole(ctx context.Context, accessToken string, role models.Role) (roleID int64, err error) {
+	const op = "New.CreateRole"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("role", role.Name),
+	)
+
+	event := models.AuditEvent{Type: models.AuditRoleCreated, Details: map[string]string{
+		"role":        role.Name,
+		"permissions": strings.Join(role.Permissions, " "),
+	}}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	roleID, err = a.storage.CreateRole(ctx, role)
+	if err != nil {
+		if errors.Is(err, storage.ErrRoleExists) {
+			log.Info("role already exists")
+			return 0, fmt.Errorf("%s %w", op, storage.ErrRoleExists)
+		}
+		log.Error("faild to create role", sl.Err(err))
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+	event.Details["role_id"] = strconv.FormatInt(roleID, 10)
+
+	log.Info("role succefully created", slog.Int64("roleID", roleID))
+
+	return roleID, nil
+}
+
+// DeleteRole deletes a role and takes it away from everyone holding it.
+func (a *Auth) DeleteRole(ctx context.Context, accessToken string, roleID int64) (err error) {
+	const op = "New.DeleteRole"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("roleID", roleID),
+	)
+
+	event := models.AuditEvent{Type: models.AuditRoleDeleted, Details: map[string]string{
+		"role_id": strconv.FormatInt(roleID, 10),
+	}}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.storage.DeleteRole(ctx, roleID); err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) || errors.Is(err, storage.ErrRoleProtected) {
+			log.Info("role can't be deleted", sl.Err(err))
+			return fmt.Errorf("%s %w", op, err)
+		}
+		log.Error("faild to delete role", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("role succefully deleted")
+
+	return nil
+}
+
+// ListRoles returns every role with its permissions.
+func (a *Auth) ListRoles(ctx context.Context, accessToken string) ([]models.Role, error) {
+	const op = "New.ListRoles"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if _, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	roles, err := a.storage.Roles(ctx)
+	if err != nil {
+		log.Error("faild to list roles", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// GrantPermission adds a permission to a role, or takes it away when grant
+// is false.
+func (a *Auth) GrantPermission(ctx context.Context, accessToken string, roleID int64, permission string, grant bool) (err error) {
+	const op = "New.GrantPermission"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("roleID", roleID),
+		slog.String("permission", permission),
+		slog.Bool("grant", grant),
+	)
+
+	event := models.AuditEvent{Type: models.AuditPermissionGranted, Details: map[string]string{
+		"role_id":    strconv.FormatInt(roleID, 10),
+		"permission": permission,
+	}}
+	if !grant {
+		event.Type = models.AuditPermissionRevoked
+	}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if grant {
+		err = a.storage.GrantPermission(ctx, roleID, permission)
+	} else {
+		err = a.storage.RevokePermission(ctx, roleID, permission)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) || errors.Is(err, storage.ErrRoleProtected) {
+			log.Info("permission can't be changed", sl.Err(err))
+			return fmt.Errorf("%s %w", op, err)
+		}
+		log.Error("faild to change permission", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("permission succefully changed")
+
+	return nil
+}
+
+// AssignRole gives a user a role in an app, or in every app when appID is
+// zero, or takes it away when assign is false.
+func (a *Auth) AssignRole(ctx context.Context, accessToken string, userID, roleID, appID int64, assign bool) (err error) {
+	const op = "New.AssignRole"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("userID", userID),
+		slog.Int64("roleID", roleID),
+		slog.Int64("appID", appID),
+		slog.Bool("assign", assign),
+	)
+
+	event := models.AuditEvent{Type: models.AuditRoleAssigned, UserID: userID, AppID: appID, Details: map[string]string{
+		"role_id": strconv.FormatInt(roleID, 10),
+	}}
+	if !assign {
+		event.Type = models.AuditRoleUnassigned
+	}
+	defer a.audit(ctx, log, &event, &err)
+
+	admin, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage)
+	event.ActorID = admin.ID
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if assign {
+		err = a.storage.AssignRole(ctx, userID, roleID, appID)
+	} else {
+		err = a.storage.UnassignRole(ctx, userID, roleID, appID)
+	}
+	if err != nil {
+		for _, known := range []error{storage.ErrUserNotFound, storage.ErrRoleNotFound, storage.ErrAppNotFound, storage.ErrRoleNotAssigned} {
+			if errors.Is(err, known) {
+				log.Info("role can't be changed", sl.Err(err))
+				return fmt.Errorf("%s %w", op, known)
+			}
+		}
+		log.Error("faild to change role", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("role assignment succefully changed")
+
+	return nil
+}
+
+// UserRoles returns the role assignments of a user.
+func (a *Auth) UserRoles(ctx context.Context, accessToken string, userID int64) ([]models.RoleAssignment, error) {
+	const op = "New.UserRoles"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("userID", userID),
+	)
+
+	if _, err := a.requirePermission(ctx, log, accessToken, models.PermissionRolesManage); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	roles, err := a.storage.UserRoles(ctx, userID)
+	if err != nil {
+		log.Error("faild to list user roles", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// HasPermission reports whether a role the user holds in the app, or in
+// every app, grants permission. Like IsAdmin it's meant for trusted
+// backends and takes no token.
+func (a *Auth) HasPermission(ctx context.Context, userID, appID int64, permission string) (bool, error) {
+	const op = "New.HasPermission"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("userID", userID),
+		slog.Int64("appID", appID),
+		slog.String("permission", permission),
+	)
+
+	allowed, err := a.storage.HasPermission(ctx, userID, appID, permission)
+	if err != nil {
+		log.Error("faild to check permission", sl.Err(err))
+		return false, fmt.Errorf("%s %w", op, err)
+	}
+
+	return allowed, nil
+}
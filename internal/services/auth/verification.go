@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/sl"
+	"sso/internal/mail"
+	"sso/internal/storage"
+)
+
+// VerifyEmail confirms the user's email address with a token from the
+// verification email.
+func (a *Auth) VerifyEmail(ctx context.Context, token string) error {
+	const op = "New.VerifyEmail"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	verification, err := a.storage.EmailVerification(ctx, opaque.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrVerificationNotFound) {
+			log.Info("unknown email verification token")
+			return fmt.Errorf("%s %w", op, storage.ErrInvalidVerification)
+		}
+		log.Error("faild to get email verification", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", verification.UserID))
+
+	if verification.Used {
+		log.Info("email verification token already used")
+		return fmt.Errorf("%s %w", op, storage.ErrInvalidVerification)
+	}
+	if time.Now().After(verification.ExpiresAt) {
+		log.Info("email verification token expired")
+		return fmt.Errorf("%s %w", op, storage.ErrInvalidVerification)
+	}
+
+	if err := a.storage.VerifyEmail(ctx, verification.TokenHash, verification.UserID); err != nil {
+		if errors.Is(err, storage.ErrVerificationUsed) {
+			return fmt.Errorf("%s %w", op, storage.ErrInvalidVerification)
+		}
+		log.Error("faild to verify email", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	err = a.recordAudit(ctx, models.AuditEvent{
+		Type:      models.AuditEmailVerified,
+		UserID:    verification.UserID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Error("faild to save audit event", sl.Err(err))
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("email succefully verified")
+
+	return nil
+}
+
+// ResendVerification emails a new verification token to an unverified
+// user. Like RequestPasswordReset it answers the same for every email, and
+// a user gets at most one email per EmailVerificationResendInterval.
+func (a *Auth) ResendVerification(ctx context.Context, email string) {
+	a.inBackground(ctx, func(ctx context.Context) {
+		const op = "New.ResendVerification"
+
+		log := a.log.With(
+			slog.String("op", op),
+			slog.String("email", email),
+		)
+
+		user, err := a.storage.User(ctx, email)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Info("verification for unknown email")
+				return
+			}
+			log.Error("faild to get user", sl.Err(err))
+			return
+		}
+
+		if user.EmailVerified {
+			log.Info("email already verified")
+			return
+		}
+
+		a.sendVerification(ctx, log.With(slog.Int64("userID", user.ID)), user)
+	})
+}
+
+// sendVerification emails the user a new verification token, unless the
+// previous one went out less than the resend interval ago.
+func (a *Auth) sendVerification(ctx context.Context, log *slog.Logger, user models.User) {
+	now := time.Now()
+
+	if err := a.storage.MarkVerificationSent(ctx, user.ID, now, now.Add(-a.cfg.EmailVerificationResendInterval)); err != nil {
+		if errors.Is(err, storage.ErrVerificationThrottled) {
+			log.Info("verification email throttled")
+			return
+		}
+		log.Error("faild to mark verification sent", sl.Err(err))
+		return
+	}
+
+	token, tokenHash, err := opaque.New()
+	if err != nil {
+		log.Error("faild to generate verification token", sl.Err(err))
+		return
+	}
+
+	err = a.storage.SaveEmailVerification(ctx, models.EmailVerification{
+		TokenHash: tokenHash,
+		UserID:    user.ID,
+		ExpiresAt: now.Add(a.cfg.EmailVerificationTTL),
+	})
+	if err != nil {
+		log.Error("faild to save email verification", sl.Err(err))
+		return
+	}
+
+	msg, err := a.verificationMessage(user.Email, token)
+	if err != nil {
+		log.Error("faild to compose verification email", sl.Err(err))
+		return
+	}
+
+	if err := a.mailer.Send(ctx, msg); err != nil {
+		log.Error("faild to send verification email", sl.Err(err))
+		return
+	}
+
+	log.Info("verification email succefully sent")
+}
+
+func (a *Auth) verificationMessage(email, token string) (mail.Message, error) {
+	var body strings.Builder
+
+	body.WriteString("Confirm that this email address belongs to your new account.\n\n")
+
+	if a.cfg.EmailVerificationURL != "" {
+		link, err := tokenLink(a.cfg.EmailVerificationURL, token)
+		if err != nil {
+			return mail.Message{}, err
+		}
+
+		fmt.Fprintf(&body, "Open this link to confirm it:\n\n%s\n\n", link)
+	} else {
+		fmt.Fprintf(&body, "Use this token to confirm it:\n\n%s\n\n", token)
+	}
+
+	fmt.Fprintf(&body, "It expires in %d hours. If you didn't sign up, ignore this email.\n",
+		int(a.cfg.EmailVerificationTTL.Hours()))
+
+	return mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body:    body.String(),
+	}, nil
+}
+
+// requireVerifiedEmail refuses users that didn't confirm their email for
+// apps that only accept verified accounts.
+func requireVerifiedEmail(user models.User, app models.App) error {
+	if app.RequireVerifiedEmail && !user.EmailVerified {
+		return storage.ErrEmailNotVerified
+	}
+	return nil
+}
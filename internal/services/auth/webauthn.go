@@ -0,0 +1,595 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/sl"
+	"sso/internal/storage"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// BeginWebAuthnRegistration starts adding a passkey or security key for the
+// user the access token belongs to. The options go to
+// navigator.credentials.create, its result to FinishWebAuthnRegistration.
+func (a *Auth) BeginWebAuthnRegistration(ctx context.Context, accessToken string) (models.WebAuthnChallenge, error) {
+	const op = "New.BeginWebAuthnRegistration"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	user, err := a.userFromToken(ctx, accessToken)
+	if err != nil {
+		return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", user.ID))
+
+	passkeyUser, err := a.webAuthnUser(ctx, user)
+	if err != nil {
+		log.Error("faild to get webauthn credentials", sl.Err(err))
+		return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	// an authenticator that already holds one of the user's credentials
+	// refuses to create another
+	creation, session, err := a.passkeys.BeginRegistration(passkeyUser,
+		webauthn.WithExclusions(webauthn.Credentials(passkeyUser.WebAuthnCredentials()).CredentialDescriptors()),
+	)
+	if err != nil {
+		log.Error("faild to begin webauthn registration", sl.Err(err))
+		return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	challenge, err := a.startWebAuthnCeremony(ctx, models.WebAuthnCeremony{
+		Kind:   models.WebAuthnRegistration,
+		UserID: user.ID,
+	}, session, creation)
+	if err != nil {
+		log.Error("faild to save webauthn ceremony", sl.Err(err))
+		return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("webauthn registration started")
+
+	return challenge, nil
+}
+
+// FinishWebAuthnRegistration checks the authenticator's attestation and
+// stores the new credential. Only "none" attestation is asked for, the
+// authenticator's make isn't verified. When the credential is the user's
+// first second factor the first set of recovery codes is returned.
+func (a *Auth) FinishWebAuthnRegistration(ctx context.Context, accessToken, ceremonyToken string, response []byte) ([]string, error) {
+	const op = "New.FinishWebAuthnRegistration"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	user, err := a.userFromToken(ctx, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", user.ID))
+
+	ceremony, session, err := a.useWebAuthnCeremony(ctx, log, ceremonyToken, models.WebAuthnRegistration)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	if ceremony.UserID != user.ID {
+		log.Warn("webauthn registration started by another user", slog.Int64("ceremonyUserID", ceremony.UserID))
+		return nil, fmt.Errorf("%s %w", op, storage.ErrInvalidCeremony)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		log.Info("malformed webauthn attestation", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, storage.ErrInvalidWebAuthn)
+	}
+
+	passkeyUser, err := a.webAuthnUser(ctx, user)
+	if err != nil {
+		log.Error("faild to get webauthn credentials", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	credential, err := a.passkeys.CreateCredential(passkeyUser, session, parsed)
+	if err != nil {
+		log.Info("webauthn attestation rejected", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, storage.ErrInvalidWebAuthn)
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	_, err = a.storage.SaveWebAuthnCredential(ctx, models.WebAuthnCredential{
+		UserID:          user.ID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrCredentialExists) {
+			log.Error("faild to save webauthn credential", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	err = a.recordAudit(ctx, models.AuditEvent{
+		Type:    models.AuditWebAuthnCredentialAdded,
+		ActorID: user.ID,
+		UserID:  user.ID,
+		Details: map[string]string{
+			"credential_id": base64.RawURLEncoding.EncodeToString(credential.ID),
+		},
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Error("faild to save audit event", sl.Err(err))
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	var recoveryCodes []string
+	if !user.MFAEnabled() {
+		recoveryCodes, err = a.newRecoveryCodes(ctx, user.ID)
+		if err != nil {
+			log.Error("faild to generate recovery codes", sl.Err(err))
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	log.Info("webauthn credential succefully registered")
+
+	return recoveryCodes, nil
+}
+
+// BeginWebAuthnLogin starts a login with a passkey or security key. With an
+// MFA token it is the second factor of that password login and only the
+// user's credentials are allowed. Without one it is a passwordless login to
+// appID: the authenticator picks a discoverable credential and must verify
+// the user, so the passkey stands for both factors.
+func (a *Auth) BeginWebAuthnLogin(ctx context.Context, appID int64, mfaToken string) (models.WebAuthnChallenge, error) {
+	const op = "New.BeginWebAuthnLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	var (
+		ceremony  models.WebAuthnCeremony
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+	)
+
+	if mfaToken != "" {
+		challenge, err := a.mfaChallenge(ctx, log, opaque.Hash(mfaToken))
+		if err != nil {
+			return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		log = log.With(slog.Int64("userID", challenge.UserID))
+
+		user, err := a.storage.UserByID(ctx, challenge.UserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, storage.ErrInvalidMFAToken)
+			}
+			log.Error("faild to get user", sl.Err(err))
+			return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+		}
+		if !user.WebAuthnEnabled {
+			return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, storage.ErrMFANotEnrolled)
+		}
+
+		passkeyUser, err := a.webAuthnUser(ctx, user)
+		if err != nil {
+			log.Error("faild to get webauthn credentials", sl.Err(err))
+			return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		assertion, session, err = a.passkeys.BeginLogin(passkeyUser)
+		if err != nil {
+			log.Error("faild to begin webauthn login", sl.Err(err))
+			return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		ceremony = models.WebAuthnCeremony{
+			UserID:       user.ID,
+			AppID:        challenge.AppID,
+			MFATokenHash: challenge.TokenHash,
+		}
+	} else {
+		if _, err := a.storage.App(ctx, appID); err != nil {
+			if errors.Is(err, storage.ErrAppNotFound) {
+				log.Error("app is not exists", sl.Err(err))
+				return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, storage.ErrAppNotFound)
+			}
+			log.Error("faild to get app", sl.Err(err))
+			return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		var err error
+		assertion, session, err = a.passkeys.BeginDiscoverableLogin(
+			webauthn.WithUserVerification(protocol.VerificationRequired),
+		)
+		if err != nil {
+			log.Error("faild to begin webauthn login", sl.Err(err))
+			return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		ceremony = models.WebAuthnCeremony{
+			AppID: appID,
+		}
+	}
+
+	ceremony.Kind = models.WebAuthnLogin
+
+	challenge, err := a.startWebAuthnCeremony(ctx, ceremony, session, assertion)
+	if err != nil {
+		log.Error("faild to save webauthn ceremony", sl.Err(err))
+		return models.WebAuthnChallenge{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("webauthn login started", slog.Bool("mfa", mfaToken != ""))
+
+	return challenge, nil
+}
+
+// FinishWebAuthnLogin checks the authenticator's assertion and issues the
+// tokens of the login. Besides the signature the sign counter must have
+// grown, a counter that didn't is taken for a cloned credential. The login
+// lockout applies as to passwords: a blocked account is refused before the
+// assertion is looked at and a rejected one counts as a failed login.
+func (a *Auth) FinishWebAuthnLogin(ctx context.Context, ceremonyToken string, response []byte) (pair models.TokenPair, err error) {
+	const op = "New.FinishWebAuthnLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	event := models.AuditEvent{
+		Type:    models.AuditLogin,
+		Details: map[string]string{"method": "webauthn"},
+	}
+	defer a.audit(ctx, log, &event, &err)
+
+	if err := a.checkIPLogins(ctx, log, clientip.FromContext(ctx)); err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	ceremony, session, err := a.useWebAuthnCeremony(ctx, log, ceremonyToken, models.WebAuthnLogin)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log = log.With(slog.Int64("appID", ceremony.AppID))
+	event.ActorID, event.UserID, event.AppID = ceremony.UserID, ceremony.UserID, ceremony.AppID
+	if ceremony.MFATokenHash != "" {
+		event.Details["method"] = "webauthn_mfa"
+	}
+
+	// the second factor of a password login must still be pending
+	var challenge models.MFAChallenge
+	if ceremony.MFATokenHash != "" {
+		challenge, err = a.mfaChallenge(ctx, log, ceremony.MFATokenHash)
+		if err != nil {
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	var (
+		user       models.User
+		credential *webauthn.Credential
+	)
+	if ceremony.UserID != 0 {
+		user, err = a.storage.UserByID(ctx, ceremony.UserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidCeremony)
+			}
+			log.Error("faild to get user", sl.Err(err))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		if err := a.checkLoginBlock(user); err != nil {
+			log.Info("webauthn login refused, account blocked", sl.Err(err))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		log.Info("malformed webauthn assertion", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, a.failWebAuthnLogin(ctx, log, ceremony, user))
+	}
+
+	if ceremony.UserID != 0 {
+		passkeyUser, err := a.webAuthnUser(ctx, user)
+		if err != nil {
+			log.Error("faild to get webauthn credentials", sl.Err(err))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		credential, err = a.passkeys.ValidateLogin(passkeyUser, session, parsed)
+		if err != nil {
+			log.Info("webauthn assertion rejected", sl.Err(err))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, a.failWebAuthnLogin(ctx, log, ceremony, user))
+		}
+	} else {
+		// the user is known once the library resolved the user handle, a
+		// blocked account is refused before the signature is checked
+		var blocked error
+		_, credential, err = a.passkeys.ValidatePasskeyLogin(func(_, userHandle []byte) (webauthn.User, error) {
+			userID, ok := parseUserHandle(userHandle)
+			if !ok {
+				return nil, storage.ErrUserNotFound
+			}
+
+			found, err := a.storage.UserByID(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+			user = found
+
+			if blocked = a.checkLoginBlock(user); blocked != nil {
+				return nil, blocked
+			}
+
+			return a.webAuthnUser(ctx, user)
+		}, session, parsed)
+		if blocked != nil {
+			log.Info("passkey login refused, account blocked", sl.Err(blocked))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, blocked)
+		}
+		if err != nil {
+			log.Info("webauthn assertion rejected", sl.Err(err))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, a.failWebAuthnLogin(ctx, log, ceremony, user))
+		}
+	}
+
+	log = log.With(slog.Int64("userID", user.ID))
+	event.ActorID, event.UserID = user.ID, user.ID
+
+	if credential.Authenticator.CloneWarning {
+		log.Warn("webauthn sign counter did not grow, credential may be cloned")
+
+		err = a.recordAudit(ctx, models.AuditEvent{
+			Type:   models.AuditWebAuthnCloneDetected,
+			UserID: user.ID,
+			AppID:  ceremony.AppID,
+			Details: map[string]string{
+				"credential_id": base64.RawURLEncoding.EncodeToString(credential.ID),
+			},
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			log.Error("faild to save audit event", sl.Err(err))
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, a.failWebAuthnLogin(ctx, log, ceremony, user))
+	}
+
+	err = a.storage.UpdateWebAuthnCredential(ctx, credential.ID, credential.Authenticator.SignCount, credential.Flags.BackupState, time.Now())
+	if err != nil {
+		log.Error("faild to update webauthn credential", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := a.resetFailedLogins(ctx, log, user); err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	if ceremony.MFATokenHash != "" {
+		tokens, err := a.finishMFA(ctx, log, challenge, user)
+		if err != nil {
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+		}
+
+		log.Info("user succefully logged in with webauthn as second factor")
+		return tokens, nil
+	}
+
+	app, err := a.storage.App(ctx, ceremony.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.TokenPair{}, fmt.Errorf("%s %w", op, storage.ErrInvalidCeremony)
+		}
+		log.Error("faild to get app", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	tokens, err := a.issueTokens(ctx, user, app, "")
+	if err != nil {
+		log.Error("faild to generate token", sl.Err(err))
+		return models.TokenPair{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	log.Info("user succefully logged in with passkey")
+
+	return tokens, nil
+}
+
+// startWebAuthnCeremony saves the session of a started ceremony and returns
+// its token together with the options for the browser.
+func (a *Auth) startWebAuthnCeremony(ctx context.Context, ceremony models.WebAuthnCeremony, session *webauthn.SessionData, options any) (models.WebAuthnChallenge, error) {
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return models.WebAuthnChallenge{}, err
+	}
+
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return models.WebAuthnChallenge{}, err
+	}
+
+	token, tokenHash, err := opaque.New()
+	if err != nil {
+		return models.WebAuthnChallenge{}, err
+	}
+
+	ceremony.TokenHash = tokenHash
+	ceremony.Session = sessionData
+	ceremony.ExpiresAt = time.Now().Add(a.cfg.WebAuthnCeremonyTTL)
+
+	if err := a.storage.SaveWebAuthnCeremony(ctx, ceremony); err != nil {
+		return models.WebAuthnChallenge{}, err
+	}
+
+	return models.WebAuthnChallenge{
+		Token:   token,
+		Options: optionsJSON,
+	}, nil
+}
+
+// useWebAuthnCeremony consumes the ceremony of the token. A challenge is
+// answered once, right or wrong, so a failed response needs a new ceremony.
+func (a *Auth) useWebAuthnCeremony(ctx context.Context, log *slog.Logger, token, kind string) (models.WebAuthnCeremony, webauthn.SessionData, error) {
+	ceremony, err := a.storage.WebAuthnCeremony(ctx, opaque.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrCeremonyNotFound) {
+			log.Info("webauthn ceremony is not exists")
+			return models.WebAuthnCeremony{}, webauthn.SessionData{}, storage.ErrInvalidCeremony
+		}
+		log.Error("faild to get webauthn ceremony", sl.Err(err))
+		return models.WebAuthnCeremony{}, webauthn.SessionData{}, err
+	}
+
+	if ceremony.Kind != kind || ceremony.Used || time.Now().After(ceremony.ExpiresAt) {
+		log.Info("webauthn ceremony rejected", slog.String("kind", ceremony.Kind), slog.Bool("used", ceremony.Used))
+		return models.WebAuthnCeremony{}, webauthn.SessionData{}, storage.ErrInvalidCeremony
+	}
+
+	if err := a.storage.UseWebAuthnCeremony(ctx, ceremony.TokenHash); err != nil {
+		if errors.Is(err, storage.ErrCeremonyUsed) {
+			log.Warn("webauthn ceremony completed concurrently")
+			return models.WebAuthnCeremony{}, webauthn.SessionData{}, storage.ErrInvalidCeremony
+		}
+		log.Error("faild to use webauthn ceremony", sl.Err(err))
+		return models.WebAuthnCeremony{}, webauthn.SessionData{}, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(ceremony.Session, &session); err != nil {
+		log.Error("faild to decode webauthn session", sl.Err(err))
+		return models.WebAuthnCeremony{}, webauthn.SessionData{}, err
+	}
+
+	return ceremony, session, nil
+}
+
+// failWebAuthnLogin counts a rejected assertion like a wrong password:
+// against the client address, the user when it is known, and the MFA
+// challenge the ceremony is the second factor of. It returns the error to
+// report, the lockout when this failure locked the account.
+func (a *Auth) failWebAuthnLogin(ctx context.Context, log *slog.Logger, ceremony models.WebAuthnCeremony, user models.User) error {
+	if ceremony.MFATokenHash != "" {
+		if err := a.storage.FailMFAChallenge(ctx, ceremony.MFATokenHash); err != nil {
+			log.Error("faild to count mfa attempt", sl.Err(err))
+			return err
+		}
+	}
+
+	if err := a.failIPLogin(ctx, log, clientip.FromContext(ctx)); err != nil {
+		return err
+	}
+
+	if user.ID != 0 {
+		if err := a.failLogin(ctx, log, user); !errors.Is(err, storage.ErrInvalidCredentials) {
+			return err
+		}
+	}
+
+	return storage.ErrInvalidWebAuthn
+}
+
+// webAuthnUser loads the user's credentials for the webauthn library.
+func (a *Auth) webAuthnUser(ctx context.Context, user models.User) (webAuthnUser, error) {
+	credentials, err := a.storage.WebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+
+	return webAuthnUser{
+		user:        user,
+		credentials: credentials,
+	}, nil
+}
+
+// webAuthnUser is a user as the webauthn library sees it.
+type webAuthnUser struct {
+	user        models.User
+	credentials []models.WebAuthnCredential
+}
+
+// WebAuthnID is the user handle stored in discoverable credentials. It is
+// the user ID, never the email, so it doesn't change and leaks nothing.
+func (u webAuthnUser) WebAuthnID() []byte {
+	return userHandle(u.user.ID)
+}
+
+func (u webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, stored := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(stored.Transports))
+		for _, transport := range stored.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              stored.CredentialID,
+			PublicKey:       stored.PublicKey,
+			AttestationType: stored.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: stored.BackupEligible,
+				BackupState:    stored.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    stored.AAGUID,
+				SignCount: stored.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+func userHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+func parseUserHandle(handle []byte) (int64, bool) {
+	if len(handle) != 8 {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(handle)), true
+}
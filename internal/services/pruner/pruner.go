@@ -0,0 +1,94 @@
+package pruner
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"sso/internal/lib/sl"
+)
+
+type Storage interface {
+	DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredAuthorizationCodes(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredDeviceCodes(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredMFAChallenges(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredWebAuthnCeremonies(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredPasswordResets(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredEmailVerifications(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredPasswordlessLogins(ctx context.Context, now time.Time) (int64, error)
+	DeleteExpiredIPLoginFailures(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Pruner periodically deletes rows that outlived their expiry, so the
+// revocation list only holds tokens that could still be presented.
+type Pruner struct {
+	log      *slog.Logger
+	storage  Storage
+	interval time.Duration
+	stop     chan struct{}
+}
+
+func New(log *slog.Logger, storage Storage, interval time.Duration) *Pruner {
+	return &Pruner{
+		log:      log,
+		storage:  storage,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Run prunes once per interval until Stop is called.
+func (p *Pruner) Run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.Prune(context.Background())
+		}
+	}
+}
+
+func (p *Pruner) Stop() {
+	close(p.stop)
+}
+
+func (p *Pruner) Prune(ctx context.Context) {
+	const op = "pruner.Prune"
+
+	log := p.log.With(slog.String("op", op))
+
+	now := time.Now()
+
+	jobs := []struct {
+		name  string
+		prune func(ctx context.Context, now time.Time) (int64, error)
+	}{
+		{"revoked_tokens", p.storage.DeleteExpiredRevokedTokens},
+		{"refresh_tokens", p.storage.DeleteExpiredRefreshTokens},
+		{"authorization_codes", p.storage.DeleteExpiredAuthorizationCodes},
+		{"device_codes", p.storage.DeleteExpiredDeviceCodes},
+		{"mfa_challenges", p.storage.DeleteExpiredMFAChallenges},
+		{"webauthn_ceremonies", p.storage.DeleteExpiredWebAuthnCeremonies},
+		{"password_resets", p.storage.DeleteExpiredPasswordResets},
+		{"email_verifications", p.storage.DeleteExpiredEmailVerifications},
+		{"passwordless_logins", p.storage.DeleteExpiredPasswordlessLogins},
+		{"ip_login_failures", p.storage.DeleteExpiredIPLoginFailures},
+	}
+
+	for _, job := range jobs {
+		deleted, err := job.prune(ctx, now)
+		if err != nil {
+			log.Error("faild to prune expired rows", slog.String("table", job.name), sl.Err(err))
+			continue
+		}
+		if deleted > 0 {
+			log.Debug("pruned expired rows", slog.String("table", job.name), slog.Int64("deleted", deleted))
+		}
+	}
+}
@@ -3,17 +3,29 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"sso/internal/domain/models"
+	"sso/internal/lib/auditchain"
 	"sso/internal/storage"
 
 	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
+// auditAppendAttempts bounds how often SaveAuditEvent chains an event again
+// after losing the race for the last event to another process.
+const auditAppendAttempts = 3
+
 type Storage struct {
 	db *sql.DB
+	// auditMu serializes audit writes, each one chains to the last
+	auditMu sync.Mutex
 }
 
 func New(storagePath string) (*Storage, error) {
@@ -59,34 +71,56 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	const op = "storage.sqlite.User"
 
-	stmt, err := s.db.Prepare("SELECT id, email, pass_hash FROM users WHERE email = ?")
+	return s.user(ctx, op, "SELECT "+userColumns+" FROM users WHERE email = ?", email)
+}
+
+const userColumns = `id, email, pass_hash, email_verified, tokens_revoked_at, tokens_revoked_except, totp_secret, totp_enabled, totp_last_step,
+	failed_logins, login_blocked_until,
+	EXISTS (SELECT 1 FROM webauthn_credentials WHERE webauthn_credentials.user_id = users.id)`
+
+func (s *Storage) user(ctx context.Context, op, query string, arg any) (models.User, error) {
+	stmt, err := s.db.Prepare(query)
 	if err != nil {
 		return models.User{}, fmt.Errorf("%s %w", op, err)
 	}
 
-	sqlResult := stmt.QueryRowContext(ctx, email)
+	sqlResult := stmt.QueryRowContext(ctx, arg)
 
-	var user models.User
-	err = sqlResult.Scan(&user.ID, &user.Email, &user.PassHash)
+	var (
+		user              models.User
+		tokensRevokedAt   sql.NullTime
+		loginBlockedUntil sql.NullTime
+	)
+	err = sqlResult.Scan(
+		&user.ID, &user.Email, &user.PassHash, &user.EmailVerified, &tokensRevokedAt, &user.TokensRevokedExcept,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep,
+		&user.FailedLogins, &loginBlockedUntil,
+		&user.WebAuthnEnabled,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s %w", op, storage.ErrUserNotFound)
 		}
 		return models.User{}, fmt.Errorf("%s %w", op, err)
 	}
+	user.TokensRevokedAt = tokensRevokedAt.Time
+	user.LoginBlockedUntil = loginBlockedUntil.Time
 
 	return user, nil
 }
 
+// IsAdmin reports whether the user holds the admin role in every app,
+// assigned or through a group.
 func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	const op = "storage.sqlite.IsAdmin"
 
-	stmt, err := s.db.Prepare("SELECT is_admin FROM USERS WHERE id = ?")
+	stmt, err := s.db.Prepare(heldRoles + `SELECT EXISTS (SELECT 1 FROM held_roles JOIN roles ON roles.id = held_roles.role_id
+		WHERE held_roles.app_id = 0 AND roles.name = ?2) FROM users WHERE id = ?1`)
 	if err != nil {
 		return false, fmt.Errorf("%s %w", op, err)
 	}
 
-	sqlResult := stmt.QueryRowContext(ctx, userID)
+	sqlResult := stmt.QueryRowContext(ctx, userID, models.RoleAdmin)
 
 	var isAdmin bool
 	err = sqlResult.Scan(&isAdmin)
@@ -100,10 +134,30 @@ func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	return isAdmin, nil
 }
 
+// SetAdmin assigns or unassigns the admin role in every app.
+func (s *Storage) SetAdmin(ctx context.Context, userID int64, isAdmin bool) error {
+	const op = "storage.sqlite.SetAdmin"
+
+	if err := s.checkExists(ctx, userExists(userID)); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	query := "INSERT OR IGNORE INTO user_roles (user_id, role_id, app_id) SELECT ?, id, 0 FROM roles WHERE name = ?"
+	if !isAdmin {
+		query = "DELETE FROM user_roles WHERE user_id = ? AND app_id = 0 AND role_id = (SELECT id FROM roles WHERE name = ?)"
+	}
+
+	if _, err := s.db.ExecContext(ctx, query, userID, models.RoleAdmin); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
 func (s *Storage) App(ctx context.Context, appID int64) (models.App, error) {
 	const op = "storage.sqlite.App"
 
-	stmt, err := s.db.Prepare("SELECT * FROM apps WHERE id = ?")
+	stmt, err := s.db.Prepare("SELECT id, name, secret, signing_alg, require_verified_email FROM apps WHERE id = ?")
 	if err != nil {
 		return models.App{}, fmt.Errorf("%s %w", op, err)
 	}
@@ -111,7 +165,7 @@ func (s *Storage) App(ctx context.Context, appID int64) (models.App, error) {
 	sqlResult := stmt.QueryRowContext(ctx, appID)
 
 	var app models.App
-	err = sqlResult.Scan(&app.ID, &app.Name, &app.Secret)
+	err = sqlResult.Scan(&app.ID, &app.Name, &app.Secret, &app.SigningAlg, &app.RequireVerifiedEmail)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s %w", op, storage.ErrAppNotFound)
@@ -119,5 +173,2669 @@ func (s *Storage) App(ctx context.Context, appID int64) (models.App, error) {
 		return models.App{}, fmt.Errorf("%s %w", op, err)
 	}
 
+	app.RedirectURIs, err = s.appStrings(ctx, "SELECT redirect_uri FROM app_redirect_uris WHERE app_id = ?", appID)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	app.Scopes, err = s.appStrings(ctx, "SELECT scope FROM app_scopes WHERE app_id = ?", appID)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s %w", op, err)
+	}
+
 	return app, nil
 }
+
+// appStrings reads a single text column of the app's rows in query.
+func (s *Storage) appStrings(ctx context.Context, query string, appID int64) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.sqlite.UserByID"
+
+	return s.user(ctx, op, "SELECT "+userColumns+" FROM users WHERE id = ?", userID)
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) (int64, error) {
+	const op = "storage.sqlite.SaveRefreshToken"
+
+	stmt, err := s.db.Prepare("INSERT INTO refresh_tokens (token_hash, user_id, app_id, family_id, expires_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, token.TokenHash, token.UserID, token.AppID, token.FamilyID, token.ExpiresAt.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	lastInsertedID, err := sqlResult.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return lastInsertedID, nil
+}
+
+func (s *Storage) RefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	const op = "storage.sqlite.RefreshToken"
+
+	stmt, err := s.db.Prepare("SELECT id, token_hash, user_id, app_id, family_id, expires_at, revoked FROM refresh_tokens WHERE token_hash = ?")
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult := stmt.QueryRowContext(ctx, tokenHash)
+
+	var token models.RefreshToken
+	err = sqlResult.Scan(&token.ID, &token.TokenHash, &token.UserID, &token.AppID, &token.FamilyID, &token.ExpiresAt, &token.Revoked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RefreshToken{}, fmt.Errorf("%s %w", op, storage.ErrRefreshTokenNotFound)
+		}
+		return models.RefreshToken{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return token, nil
+}
+
+// RevokeRefreshToken marks a single token as used. It fails with ErrRefreshTokenRevoked
+// when the token was already revoked, so two concurrent rotations can't both succeed.
+func (s *Storage) RevokeRefreshToken(ctx context.Context, tokenID int64) error {
+	const op = "storage.sqlite.RevokeRefreshToken"
+
+	stmt, err := s.db.Prepare("UPDATE refresh_tokens SET revoked = true WHERE id = ? AND revoked = false")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, tokenID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrRefreshTokenRevoked)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	const op = "storage.sqlite.RevokeRefreshTokenFamily"
+
+	stmt, err := s.db.Prepare("UPDATE refresh_tokens SET revoked = true WHERE family_id = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, familyID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// SessionActive tells whether the refresh token family still has a token
+// that wasn't revoked.
+func (s *Storage) SessionActive(ctx context.Context, familyID string) (bool, error) {
+	const op = "storage.sqlite.SessionActive"
+
+	stmt, err := s.db.Prepare("SELECT EXISTS(SELECT 1 FROM refresh_tokens WHERE family_id = ? AND revoked = false)")
+	if err != nil {
+		return false, fmt.Errorf("%s %w", op, err)
+	}
+
+	var active bool
+	if err := stmt.QueryRowContext(ctx, familyID).Scan(&active); err != nil {
+		return false, fmt.Errorf("%s %w", op, err)
+	}
+
+	return active, nil
+}
+
+func (s *Storage) SaveSigningKey(ctx context.Context, key models.SigningKey) error {
+	const op = "storage.sqlite.SaveSigningKey"
+
+	stmt, err := s.db.Prepare("INSERT INTO signing_keys (id, algorithm, private_key, status, created_at, activated_at) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, key.ID, key.Algorithm, key.PrivateKey, key.Status, key.CreatedAt.UTC(), nullTime(key.ActivatedAt))
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	const op = "storage.sqlite.SigningKeys"
+
+	stmt, err := s.db.Prepare("SELECT id, algorithm, private_key, status, created_at, activated_at, expires_at FROM signing_keys ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var (
+			key         models.SigningKey
+			activatedAt sql.NullTime
+			expiresAt   sql.NullTime
+		)
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &key.Status, &key.CreatedAt, &activatedAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		key.ActivatedAt = activatedAt.Time
+		key.ExpiresAt = expiresAt.Time
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return keys, nil
+}
+
+// RotateSigningKeys retires activeID, promotes the current next key and stores
+// next in its place, all in one transaction. Retired keys past their expiry are
+// dropped on the way. If activeID is no longer the active key another instance
+// rotated first and ErrSigningKeyRotated is returned.
+func (s *Storage) RotateSigningKeys(ctx context.Context, activeID string, next models.SigningKey, retiredExpiresAt time.Time) error {
+	const op = "storage.sqlite.RotateSigningKeys"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	now := next.CreatedAt.UTC()
+
+	sqlResult, err := tx.ExecContext(ctx,
+		"UPDATE signing_keys SET status = ?, expires_at = ? WHERE id = ? AND status = ?",
+		models.SigningKeyRetired, retiredExpiresAt.UTC(), activeID, models.SigningKeyActive,
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrSigningKeyRotated)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE signing_keys SET status = ?, activated_at = ? WHERE status = ?",
+		models.SigningKeyActive, now, models.SigningKeyNext,
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO signing_keys (id, algorithm, private_key, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		next.ID, next.Algorithm, next.PrivateKey, models.SigningKeyNext, now,
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	// keys that signed audit checkpoints verify them for as long as they exist
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM signing_keys WHERE status = ? AND expires_at < ?
+		AND id NOT IN (SELECT json_extract(public_key, '$.kid') FROM audit_checkpoints)`,
+		models.SigningKeyRetired, now,
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func (s *Storage) RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	const op = "storage.sqlite.RevokeToken"
+
+	stmt, err := s.db.Prepare("INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, jti, userID, expiresAt.UTC()); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "storage.sqlite.IsTokenRevoked"
+
+	stmt, err := s.db.Prepare("SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)")
+	if err != nil {
+		return false, fmt.Errorf("%s %w", op, err)
+	}
+
+	var revoked bool
+	if err := stmt.QueryRowContext(ctx, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("%s %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// RevokeUserSessions invalidates every access token issued to the user up to
+// revokedAt and revokes all of the user's refresh tokens.
+func (s *Storage) RevokeUserSessions(ctx context.Context, userID int64, revokedAt time.Time) error {
+	const op = "storage.sqlite.RevokeUserSessions"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	sqlResult, err := tx.ExecContext(ctx, "UPDATE users SET tokens_revoked_at = ?, tokens_revoked_except = '' WHERE id = ?", revokedAt.UTC(), userID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrUserNotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked = true WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// UpdatePassword replaces the user's password hash and, in the same
+// transaction, revokes their sessions like RevokeUserSessions except for
+// keepSessionID.
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte, revokedAt time.Time, keepSessionID string) error {
+	const op = "storage.sqlite.UpdatePassword"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	sqlResult, err := tx.ExecContext(ctx, `UPDATE users SET pass_hash = ?, tokens_revoked_at = ?, tokens_revoked_except = ?,
+		failed_logins = 0, login_blocked_until = NULL WHERE id = ?`,
+		passHash, revokedAt.UTC(), keepSessionID, userID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrUserNotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked = true WHERE user_id = ? AND family_id != ?", userID, keepSessionID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE password_resets SET used = true WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredRevokedTokens"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM revoked_tokens WHERE expires_at < ?", now)
+}
+
+func (s *Storage) DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredRefreshTokens"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM refresh_tokens WHERE expires_at < ?", now)
+}
+
+func (s *Storage) DeleteExpiredAuthorizationCodes(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredAuthorizationCodes"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM authorization_codes WHERE expires_at < ?", now)
+}
+
+func (s *Storage) deleteExpired(ctx context.Context, op, query string, now time.Time) (int64, error) {
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	deleted, err := sqlResult.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error {
+	const op = "storage.sqlite.SaveAuthorizationCode"
+
+	stmt, err := s.db.Prepare(`INSERT INTO authorization_codes
+		(code_hash, app_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		code.CodeHash, code.AppID, code.UserID, code.RedirectURI, code.Scope, code.Nonce,
+		code.CodeChallenge, code.AuthTime.UTC(), code.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) AuthorizationCode(ctx context.Context, codeHash string) (models.AuthorizationCode, error) {
+	const op = "storage.sqlite.AuthorizationCode"
+
+	stmt, err := s.db.Prepare(`SELECT code_hash, app_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at, used
+		FROM authorization_codes WHERE code_hash = ?`)
+	if err != nil {
+		return models.AuthorizationCode{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult := stmt.QueryRowContext(ctx, codeHash)
+
+	var code models.AuthorizationCode
+	err = sqlResult.Scan(
+		&code.CodeHash, &code.AppID, &code.UserID, &code.RedirectURI, &code.Scope, &code.Nonce,
+		&code.CodeChallenge, &code.AuthTime, &code.ExpiresAt, &code.Used,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.AuthorizationCode{}, fmt.Errorf("%s %w", op, storage.ErrCodeNotFound)
+		}
+		return models.AuthorizationCode{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return code, nil
+}
+
+// UseAuthorizationCode consumes the code, failing with ErrCodeUsed if it was
+// already exchanged.
+func (s *Storage) UseAuthorizationCode(ctx context.Context, codeHash string) error {
+	const op = "storage.sqlite.UseAuthorizationCode"
+
+	stmt, err := s.db.Prepare("UPDATE authorization_codes SET used = true WHERE code_hash = ? AND used = false")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, codeHash)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrCodeUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveDeviceCode(ctx context.Context, code models.DeviceCode) error {
+	const op = "storage.sqlite.SaveDeviceCode"
+
+	stmt, err := s.db.Prepare(`INSERT INTO device_codes
+		(device_code_hash, user_code, app_id, scope, status, poll_interval, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		code.DeviceCodeHash, code.UserCode, code.AppID, code.Scope, models.DeviceCodePending,
+		int64(code.Interval.Seconds()), code.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+const deviceCodeColumns = `device_code_hash, user_code, app_id, scope, user_id, status,
+	poll_interval, last_polled_at, auth_time, expires_at`
+
+func (s *Storage) DeviceCode(ctx context.Context, deviceCodeHash string) (models.DeviceCode, error) {
+	const op = "storage.sqlite.DeviceCode"
+
+	return s.deviceCode(ctx, op, "SELECT "+deviceCodeColumns+" FROM device_codes WHERE device_code_hash = ?", deviceCodeHash)
+}
+
+func (s *Storage) DeviceCodeByUserCode(ctx context.Context, userCode string) (models.DeviceCode, error) {
+	const op = "storage.sqlite.DeviceCodeByUserCode"
+
+	return s.deviceCode(ctx, op, "SELECT "+deviceCodeColumns+" FROM device_codes WHERE user_code = ?", userCode)
+}
+
+func (s *Storage) deviceCode(ctx context.Context, op, query, arg string) (models.DeviceCode, error) {
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return models.DeviceCode{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	var (
+		code         models.DeviceCode
+		userID       sql.NullInt64
+		interval     int64
+		lastPolledAt sql.NullTime
+		authTime     sql.NullTime
+	)
+	err = stmt.QueryRowContext(ctx, arg).Scan(
+		&code.DeviceCodeHash, &code.UserCode, &code.AppID, &code.Scope, &userID, &code.Status,
+		&interval, &lastPolledAt, &authTime, &code.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.DeviceCode{}, fmt.Errorf("%s %w", op, storage.ErrDeviceCodeNotFound)
+		}
+		return models.DeviceCode{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	code.UserID = userID.Int64
+	code.Interval = time.Duration(interval) * time.Second
+	code.LastPolledAt = lastPolledAt.Time
+	code.AuthTime = authTime.Time
+
+	return code, nil
+}
+
+func (s *Storage) UpdateDeviceCodePoll(ctx context.Context, deviceCodeHash string, polledAt time.Time, interval time.Duration) error {
+	const op = "storage.sqlite.UpdateDeviceCodePoll"
+
+	stmt, err := s.db.Prepare("UPDATE device_codes SET last_polled_at = ?, poll_interval = ? WHERE device_code_hash = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, polledAt.UTC(), int64(interval.Seconds()), deviceCodeHash)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// ResolveDeviceCode records the user's decision on a pending device code.
+// Codes that are unknown, expired or already decided yield
+// ErrDeviceCodeNotFound.
+func (s *Storage) ResolveDeviceCode(ctx context.Context, userCode string, status string, userID int64, authTime time.Time) error {
+	const op = "storage.sqlite.ResolveDeviceCode"
+
+	stmt, err := s.db.Prepare(`UPDATE device_codes SET status = ?, user_id = ?, auth_time = ?
+		WHERE user_code = ? AND status = ? AND expires_at > ?`)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, status, userID, authTime.UTC(), userCode, models.DeviceCodePending, authTime.UTC())
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrDeviceCodeNotFound)
+	}
+
+	return nil
+}
+
+// UseDeviceCode consumes an approved device code, failing with ErrCodeUsed
+// if tokens were already issued for it.
+func (s *Storage) UseDeviceCode(ctx context.Context, deviceCodeHash string) error {
+	const op = "storage.sqlite.UseDeviceCode"
+
+	stmt, err := s.db.Prepare("UPDATE device_codes SET status = ? WHERE device_code_hash = ? AND status = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, models.DeviceCodeUsed, deviceCodeHash, models.DeviceCodeApproved)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrCodeUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredDeviceCodes(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredDeviceCodes"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM device_codes WHERE expires_at < ?", now)
+}
+
+// SaveTOTPSecret stores a new, not yet confirmed, TOTP secret. Users that
+// already confirmed one get ErrMFAAlreadyEnabled.
+func (s *Storage) SaveTOTPSecret(ctx context.Context, userID int64, secret []byte) error {
+	const op = "storage.sqlite.SaveTOTPSecret"
+
+	stmt, err := s.db.Prepare("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ? AND totp_enabled = false")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, secret, userID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrMFAAlreadyEnabled)
+	}
+
+	return nil
+}
+
+// EnableTOTP turns on the second factor once the user proved their
+// authenticator app produces codes for the stored secret.
+func (s *Storage) EnableTOTP(ctx context.Context, userID int64, step int64) error {
+	const op = "storage.sqlite.EnableTOTP"
+
+	stmt, err := s.db.Prepare(`UPDATE users SET totp_enabled = true, totp_last_step = ?
+		WHERE id = ? AND totp_enabled = false AND totp_secret IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, step, userID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrMFAAlreadyEnabled)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records step as used, failing with ErrMFACodeUsed if a code of
+// this or a later step was already accepted.
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	const op = "storage.sqlite.UseTOTPStep"
+
+	stmt, err := s.db.Prepare("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, step, userID, step)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrMFACodeUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error {
+	const op = "storage.sqlite.SaveMFAChallenge"
+
+	stmt, err := s.db.Prepare("INSERT INTO mfa_challenges (token_hash, user_id, app_id, expires_at) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, challenge.TokenHash, challenge.UserID, challenge.AppID, challenge.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) MFAChallenge(ctx context.Context, tokenHash string) (models.MFAChallenge, error) {
+	const op = "storage.sqlite.MFAChallenge"
+
+	stmt, err := s.db.Prepare("SELECT token_hash, user_id, app_id, attempts, expires_at, used FROM mfa_challenges WHERE token_hash = ?")
+	if err != nil {
+		return models.MFAChallenge{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	var challenge models.MFAChallenge
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(
+		&challenge.TokenHash, &challenge.UserID, &challenge.AppID,
+		&challenge.Attempts, &challenge.ExpiresAt, &challenge.Used,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.MFAChallenge{}, fmt.Errorf("%s %w", op, storage.ErrChallengeNotFound)
+		}
+		return models.MFAChallenge{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return challenge, nil
+}
+
+// FailMFAChallenge counts a wrong code against the challenge.
+func (s *Storage) FailMFAChallenge(ctx context.Context, tokenHash string) error {
+	const op = "storage.sqlite.FailMFAChallenge"
+
+	stmt, err := s.db.Prepare("UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, tokenHash); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// UseMFAChallenge consumes the challenge, failing with ErrChallengeUsed if
+// the login was already completed with it.
+func (s *Storage) UseMFAChallenge(ctx context.Context, tokenHash string) error {
+	const op = "storage.sqlite.UseMFAChallenge"
+
+	stmt, err := s.db.Prepare("UPDATE mfa_challenges SET used = true WHERE token_hash = ? AND used = false")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, tokenHash)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrChallengeUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredMFAChallenges(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredMFAChallenges"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM mfa_challenges WHERE expires_at < ?", now)
+}
+
+// ReplaceRecoveryCodes swaps the user's recovery codes for a new set, the
+// old ones stop working at once.
+func (s *Storage) ReplaceRecoveryCodes(ctx context.Context, userID int64, codes []models.RecoveryCode) error {
+	const op = "storage.sqlite.ReplaceRecoveryCodes"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO recovery_codes (user_id, lookup_id, code_hash, created_at) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	for _, code := range codes {
+		if _, err := stmt.ExecContext(ctx, userID, code.LookupID, code.CodeHash, now); err != nil {
+			return fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// RecoveryCodes returns the user's unused recovery codes.
+func (s *Storage) RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error) {
+	const op = "storage.sqlite.RecoveryCodes"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, user_id, lookup_id, code_hash FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	defer rows.Close()
+
+	var codes []models.RecoveryCode
+	for rows.Next() {
+		var code models.RecoveryCode
+		if err := rows.Scan(&code.ID, &code.UserID, &code.LookupID, &code.CodeHash); err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode consumes the code, failing with ErrRecoveryCodeUsed if it
+// was already used.
+func (s *Storage) UseRecoveryCode(ctx context.Context, codeID int64) error {
+	const op = "storage.sqlite.UseRecoveryCode"
+
+	stmt, err := s.db.Prepare("UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at IS NULL")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, time.Now().UTC(), codeID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrRecoveryCodeUsed)
+	}
+
+	return nil
+}
+
+// SaveAuditEvent appends event to the audit chain, hashed together with the
+// hash of the last event. Writers of this process take turns; another
+// process that chained to the same event first trips the unique prev_hash
+// index, and the event is chained again to the new last one.
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	const op = "storage.sqlite.SaveAuditEvent"
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	var err error
+	for range auditAppendAttempts {
+		err = s.appendAuditEvent(ctx, event)
+
+		var errSqlite sqlite3.Error
+		if !errors.As(err, &errSqlite) || errSqlite.ExtendedCode != sqlite3.ErrConstraintUnique {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// appendAuditEvent reads the last hash and inserts outside a transaction:
+// upgrading a read transaction to a write one fails at once instead of
+// waiting when another connection writes, the prev_hash index is what
+// keeps the chain straight.
+func (s *Storage) appendAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	var prevHash string
+	err = s.db.QueryRowContext(ctx, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	hash, err := auditchain.Hash(prevHash, event)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO audit_events (type, actor_id, user_id, app_id, ip, user_agent, outcome, details, created_at, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.Type, nullInt(event.ActorID), nullInt(event.UserID), nullInt(event.AppID),
+		event.IP, event.UserAgent, event.Outcome, string(details), event.CreatedAt.UTC(), prevHash, hash)
+
+	return err
+}
+
+const auditEventColumns = "id, type, actor_id, user_id, app_id, ip, user_agent, outcome, details, created_at, prev_hash, hash"
+
+// AuditEvents lists the audit events matching filter, newest first.
+func (s *Storage) AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	const op = "storage.sqlite.AuditEvents"
+
+	var (
+		conds []string
+		args  []any
+	)
+	where := func(cond string, arg any) {
+		conds = append(conds, cond)
+		args = append(args, arg)
+	}
+	if filter.ActorID != 0 {
+		where("actor_id = ?", filter.ActorID)
+	}
+	if filter.UserID != 0 {
+		where("user_id = ?", filter.UserID)
+	}
+	if filter.AppID != 0 {
+		where("app_id = ?", filter.AppID)
+	}
+	if filter.Type != "" {
+		where("type = ?", filter.Type)
+	}
+	if filter.Outcome != "" {
+		where("outcome = ?", filter.Outcome)
+	}
+	if !filter.Since.IsZero() {
+		where("created_at >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where("created_at < ?", filter.Until.UTC())
+	}
+	if filter.BeforeID != 0 {
+		where("id < ?", filter.BeforeID)
+	}
+
+	query := "SELECT " + auditEventColumns + " FROM audit_events"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	events, err := s.queryAuditEvents(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return events, nil
+}
+
+// AuditEventsAfter returns up to limit events with an id above afterID,
+// oldest first, the order the chain is walked in.
+func (s *Storage) AuditEventsAfter(ctx context.Context, afterID int64, limit int) ([]models.AuditEvent, error) {
+	const op = "storage.sqlite.AuditEventsAfter"
+
+	events, err := s.queryAuditEvents(ctx,
+		"SELECT "+auditEventColumns+" FROM audit_events WHERE id > ? ORDER BY id LIMIT ?", afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return events, nil
+}
+
+func (s *Storage) queryAuditEvents(ctx context.Context, query string, args ...any) ([]models.AuditEvent, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var (
+			event                  models.AuditEvent
+			actorID, userID, appID sql.NullInt64
+			details                string
+		)
+		err := rows.Scan(&event.ID, &event.Type, &actorID, &userID, &appID, &event.IP, &event.UserAgent,
+			&event.Outcome, &details, &event.CreatedAt, &event.PrevHash, &event.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(details), &event.Details); err != nil {
+			return nil, err
+		}
+		event.ActorID, event.UserID, event.AppID = actorID.Int64, userID.Int64, appID.Int64
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// AuditChainHead returns the id and hash of the last audit event, zero and
+// empty when there are no chained events yet.
+func (s *Storage) AuditChainHead(ctx context.Context) (int64, string, error) {
+	const op = "storage.sqlite.AuditChainHead"
+
+	var (
+		id   int64
+		hash string
+	)
+	err := s.db.QueryRowContext(ctx, "SELECT id, hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&id, &hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", nil
+		}
+		return 0, "", fmt.Errorf("%s %w", op, err)
+	}
+	if hash == "" {
+		return 0, "", nil
+	}
+
+	return id, hash, nil
+}
+
+func (s *Storage) SaveAuditCheckpoint(ctx context.Context, checkpoint models.AuditCheckpoint) error {
+	const op = "storage.sqlite.SaveAuditCheckpoint"
+
+	stmt, err := s.db.Prepare("INSERT INTO audit_checkpoints (event_id, hash, signature, public_key, created_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, checkpoint.EventID, checkpoint.Hash, checkpoint.Signature,
+		string(checkpoint.PublicKey), checkpoint.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// LastAuditCheckpoint returns the newest checkpoint, ErrCheckpointNotFound
+// when none was made yet.
+func (s *Storage) LastAuditCheckpoint(ctx context.Context) (models.AuditCheckpoint, error) {
+	const op = "storage.sqlite.LastAuditCheckpoint"
+
+	checkpoints, err := s.queryAuditCheckpoints(ctx, "SELECT id, event_id, hash, signature, public_key, created_at FROM audit_checkpoints ORDER BY id DESC LIMIT 1")
+	if err != nil {
+		return models.AuditCheckpoint{}, fmt.Errorf("%s %w", op, err)
+	}
+	if len(checkpoints) == 0 {
+		return models.AuditCheckpoint{}, fmt.Errorf("%s %w", op, storage.ErrCheckpointNotFound)
+	}
+
+	return checkpoints[0], nil
+}
+
+// AuditCheckpoints returns every checkpoint, oldest first.
+func (s *Storage) AuditCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error) {
+	const op = "storage.sqlite.AuditCheckpoints"
+
+	checkpoints, err := s.queryAuditCheckpoints(ctx, "SELECT id, event_id, hash, signature, public_key, created_at FROM audit_checkpoints ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return checkpoints, nil
+}
+
+func (s *Storage) queryAuditCheckpoints(ctx context.Context, query string) ([]models.AuditCheckpoint, error) {
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checkpoints []models.AuditCheckpoint
+	for rows.Next() {
+		var (
+			checkpoint models.AuditCheckpoint
+			publicKey  string
+		)
+		err := rows.Scan(&checkpoint.ID, &checkpoint.EventID, &checkpoint.Hash, &checkpoint.Signature, &publicKey, &checkpoint.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		checkpoint.PublicKey = []byte(publicKey)
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return checkpoints, nil
+}
+
+func nullInt(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
+
+func (s *Storage) SaveWebAuthnCredential(ctx context.Context, credential models.WebAuthnCredential) (int64, error) {
+	const op = "storage.sqlite.SaveWebAuthnCredential"
+
+	stmt, err := s.db.Prepare(`INSERT INTO webauthn_credentials
+		(user_id, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx,
+		credential.UserID, credential.CredentialID, credential.PublicKey, credential.AttestationType,
+		credential.AAGUID, credential.SignCount, strings.Join(credential.Transports, ","),
+		credential.BackupEligible, credential.BackupState, credential.CreatedAt.UTC(),
+	)
+	if err != nil {
+		var errSqlite sqlite3.Error
+		if errors.As(err, &errSqlite) {
+			if errSqlite.ExtendedCode == sqlite3.ErrConstraintUnique {
+				return 0, fmt.Errorf("%s %w", op, storage.ErrCredentialExists)
+			}
+		}
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	id, err := sqlResult.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) WebAuthnCredentials(ctx context.Context, userID int64) ([]models.WebAuthnCredential, error) {
+	const op = "storage.sqlite.WebAuthnCredentials"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user_id, credential_id, public_key, attestation_type, aaguid,
+		sign_count, transports, backup_eligible, backup_state, created_at, last_used_at
+		FROM webauthn_credentials WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	defer rows.Close()
+
+	var credentials []models.WebAuthnCredential
+	for rows.Next() {
+		var (
+			credential models.WebAuthnCredential
+			transports string
+			lastUsedAt sql.NullTime
+		)
+		err := rows.Scan(
+			&credential.ID, &credential.UserID, &credential.CredentialID, &credential.PublicKey,
+			&credential.AttestationType, &credential.AAGUID, &credential.SignCount, &transports,
+			&credential.BackupEligible, &credential.BackupState, &credential.CreatedAt, &lastUsedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		if transports != "" {
+			credential.Transports = strings.Split(transports, ",")
+		}
+		credential.LastUsedAt = lastUsedAt.Time
+		credentials = append(credentials, credential)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return credentials, nil
+}
+
+// UpdateWebAuthnCredential records a login with the credential.
+func (s *Storage) UpdateWebAuthnCredential(ctx context.Context, credentialID []byte, signCount uint32, backupState bool, usedAt time.Time) error {
+	const op = "storage.sqlite.UpdateWebAuthnCredential"
+
+	stmt, err := s.db.Prepare("UPDATE webauthn_credentials SET sign_count = ?, backup_state = ?, last_used_at = ? WHERE credential_id = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, signCount, backupState, usedAt.UTC(), credentialID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrCredentialNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveWebAuthnCeremony(ctx context.Context, ceremony models.WebAuthnCeremony) error {
+	const op = "storage.sqlite.SaveWebAuthnCeremony"
+
+	stmt, err := s.db.Prepare(`INSERT INTO webauthn_ceremonies
+		(token_hash, kind, user_id, app_id, mfa_token_hash, session_data, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		ceremony.TokenHash, ceremony.Kind, nullInt(ceremony.UserID), nullInt(ceremony.AppID),
+		ceremony.MFATokenHash, string(ceremony.Session), ceremony.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) WebAuthnCeremony(ctx context.Context, tokenHash string) (models.WebAuthnCeremony, error) {
+	const op = "storage.sqlite.WebAuthnCeremony"
+
+	stmt, err := s.db.Prepare(`SELECT token_hash, kind, user_id, app_id, mfa_token_hash, session_data, expires_at, used
+		FROM webauthn_ceremonies WHERE token_hash = ?`)
+	if err != nil {
+		return models.WebAuthnCeremony{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	var (
+		ceremony models.WebAuthnCeremony
+		userID   sql.NullInt64
+		appID    sql.NullInt64
+		session  string
+	)
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(
+		&ceremony.TokenHash, &ceremony.Kind, &userID, &appID,
+		&ceremony.MFATokenHash, &session, &ceremony.ExpiresAt, &ceremony.Used,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.WebAuthnCeremony{}, fmt.Errorf("%s %w", op, storage.ErrCeremonyNotFound)
+		}
+		return models.WebAuthnCeremony{}, fmt.Errorf("%s %w", op, err)
+	}
+	ceremony.UserID = userID.Int64
+	ceremony.AppID = appID.Int64
+	ceremony.Session = []byte(session)
+
+	return ceremony, nil
+}
+
+// UseWebAuthnCeremony consumes the ceremony, failing with ErrCeremonyUsed if
+// a response was already checked against it.
+func (s *Storage) UseWebAuthnCeremony(ctx context.Context, tokenHash string) error {
+	const op = "storage.sqlite.UseWebAuthnCeremony"
+
+	stmt, err := s.db.Prepare("UPDATE webauthn_ceremonies SET used = true WHERE token_hash = ? AND used = false")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, tokenHash)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrCeremonyUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredWebAuthnCeremonies(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredWebAuthnCeremonies"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM webauthn_ceremonies WHERE expires_at < ?", now)
+}
+
+func (s *Storage) SavePasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	const op = "storage.sqlite.SavePasswordReset"
+
+	stmt, err := s.db.Prepare("INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES (?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, reset.TokenHash, reset.UserID, reset.ExpiresAt.UTC()); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) PasswordReset(ctx context.Context, tokenHash string) (models.PasswordReset, error) {
+	const op = "storage.sqlite.PasswordReset"
+
+	stmt, err := s.db.Prepare("SELECT token_hash, user_id, expires_at, used FROM password_resets WHERE token_hash = ?")
+	if err != nil {
+		return models.PasswordReset{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	var reset models.PasswordReset
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(&reset.TokenHash, &reset.UserID, &reset.ExpiresAt, &reset.Used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordReset{}, fmt.Errorf("%s %w", op, storage.ErrResetNotFound)
+		}
+		return models.PasswordReset{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return reset, nil
+}
+
+// UsePasswordReset consumes the reset, failing with ErrResetUsed if it was
+// already used or invalidated.
+func (s *Storage) UsePasswordReset(ctx context.Context, tokenHash string) error {
+	const op = "storage.sqlite.UsePasswordReset"
+
+	stmt, err := s.db.Prepare("UPDATE password_resets SET used = true WHERE token_hash = ? AND used = false")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, tokenHash)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrResetUsed)
+	}
+
+	return nil
+}
+
+// InvalidatePasswordResets burns every outstanding reset of the user.
+func (s *Storage) InvalidatePasswordResets(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.InvalidatePasswordResets"
+
+	stmt, err := s.db.Prepare("UPDATE password_resets SET used = true WHERE user_id = ? AND used = false")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, userID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredPasswordResets(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredPasswordResets"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM password_resets WHERE expires_at < ?", now)
+}
+
+func (s *Storage) SaveEmailVerification(ctx context.Context, verification models.EmailVerification) error {
+	const op = "storage.sqlite.SaveEmailVerification"
+
+	stmt, err := s.db.Prepare("INSERT INTO email_verifications (token_hash, user_id, expires_at) VALUES (?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, verification.TokenHash, verification.UserID, verification.ExpiresAt.UTC()); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) EmailVerification(ctx context.Context, tokenHash string) (models.EmailVerification, error) {
+	const op = "storage.sqlite.EmailVerification"
+
+	stmt, err := s.db.Prepare("SELECT token_hash, user_id, expires_at, used FROM email_verifications WHERE token_hash = ?")
+	if err != nil {
+		return models.EmailVerification{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	var verification models.EmailVerification
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(&verification.TokenHash, &verification.UserID, &verification.ExpiresAt, &verification.Used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.EmailVerification{}, fmt.Errorf("%s %w", op, storage.ErrVerificationNotFound)
+		}
+		return models.EmailVerification{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return verification, nil
+}
+
+// VerifyEmail consumes the verification and marks the user's email as
+// verified, failing with ErrVerificationUsed if it was already used.
+func (s *Storage) VerifyEmail(ctx context.Context, tokenHash string, userID int64) error {
+	const op = "storage.sqlite.VerifyEmail"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	sqlResult, err := tx.ExecContext(ctx, "UPDATE email_verifications SET used = true WHERE token_hash = ? AND user_id = ? AND used = false", tokenHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrVerificationUsed)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET email_verified = true WHERE id = ?", userID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// MarkVerificationSent records that a verification email goes out now. It
+// fails with ErrVerificationThrottled if the previous one was sent after
+// notBefore.
+func (s *Storage) MarkVerificationSent(ctx context.Context, userID int64, sentAt, notBefore time.Time) error {
+	const op = "storage.sqlite.MarkVerificationSent"
+
+	stmt, err := s.db.Prepare(`UPDATE users SET verification_sent_at = ?
+		WHERE id = ? AND (verification_sent_at IS NULL OR verification_sent_at <= ?)`)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, sentAt.UTC(), userID, notBefore.UTC())
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrVerificationThrottled)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredEmailVerifications(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredEmailVerifications"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM email_verifications WHERE expires_at < ?", now)
+}
+
+// SetEmailVerified marks the user's email as verified outside of a
+// verification email, when the user proved they read their mail otherwise.
+func (s *Storage) SetEmailVerified(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.SetEmailVerified"
+
+	stmt, err := s.db.Prepare("UPDATE users SET email_verified = true WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, userID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SavePasswordlessLogin(ctx context.Context, login models.PasswordlessLogin) error {
+	const op = "storage.sqlite.SavePasswordlessLogin"
+
+	stmt, err := s.db.Prepare(`INSERT INTO passwordless_logins
+		(token_hash, user_id, app_id, method, code_hash, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, login.TokenHash, login.UserID, login.AppID, login.Method, login.CodeHash, login.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) PasswordlessLogin(ctx context.Context, tokenHash string) (models.PasswordlessLogin, error) {
+	const op = "storage.sqlite.PasswordlessLogin"
+
+	stmt, err := s.db.Prepare(`SELECT token_hash, user_id, app_id, method, code_hash, attempts, expires_at, used
+		FROM passwordless_logins WHERE token_hash = ?`)
+	if err != nil {
+		return models.PasswordlessLogin{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	var login models.PasswordlessLogin
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(
+		&login.TokenHash, &login.UserID, &login.AppID, &login.Method,
+		&login.CodeHash, &login.Attempts, &login.ExpiresAt, &login.Used,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordlessLogin{}, fmt.Errorf("%s %w", op, storage.ErrPasswordlessNotFound)
+		}
+		return models.PasswordlessLogin{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return login, nil
+}
+
+// FailPasswordlessLogin counts a wrong code against the login.
+func (s *Storage) FailPasswordlessLogin(ctx context.Context, tokenHash string) error {
+	const op = "storage.sqlite.FailPasswordlessLogin"
+
+	stmt, err := s.db.Prepare("UPDATE passwordless_logins SET attempts = attempts + 1 WHERE token_hash = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, tokenHash); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// UsePasswordlessLogin consumes the login, failing with ErrPasswordlessUsed
+// if it was already completed.
+func (s *Storage) UsePasswordlessLogin(ctx context.Context, tokenHash string) error {
+	const op = "storage.sqlite.UsePasswordlessLogin"
+
+	stmt, err := s.db.Prepare("UPDATE passwordless_logins SET used = true WHERE token_hash = ? AND used = false")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, tokenHash)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrPasswordlessUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredPasswordlessLogins(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredPasswordlessLogins"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM passwordless_logins WHERE expires_at < ?", now)
+}
+
+// FailLogin counts a wrong password for the user and returns the failures
+// since the last successful login.
+func (s *Storage) FailLogin(ctx context.Context, userID int64) (int, error) {
+	const op = "storage.sqlite.FailLogin"
+
+	stmt, err := s.db.Prepare("UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins")
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	var failures int
+	if err := stmt.QueryRowContext(ctx, userID).Scan(&failures); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s %w", op, storage.ErrUserNotFound)
+		}
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	return failures, nil
+}
+
+func (s *Storage) BlockLogin(ctx context.Context, userID int64, until time.Time) error {
+	const op = "storage.sqlite.BlockLogin"
+
+	stmt, err := s.db.Prepare("UPDATE users SET login_blocked_until = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, until.UTC(), userID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// ReplacePassHash swaps the user's password hash for one of the same
+// password, sessions stay. Nothing changes if the hash isn't oldHash any
+// more, a password change in between wins.
+func (s *Storage) ReplacePassHash(ctx context.Context, userID int64, oldHash, newHash []byte) error {
+	const op = "storage.sqlite.ReplacePassHash"
+
+	stmt, err := s.db.Prepare("UPDATE users SET pass_hash = ? WHERE id = ? AND pass_hash = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, newHash, userID, oldHash); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// ResetFailedLogins forgets the user's wrong passwords and lifts a block.
+func (s *Storage) ResetFailedLogins(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.ResetFailedLogins"
+
+	stmt, err := s.db.Prepare("UPDATE users SET failed_logins = 0, login_blocked_until = NULL WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := stmt.ExecContext(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// IPLoginFailures returns the failed logins counted for ip, a zero count
+// when there are none.
+func (s *Storage) IPLoginFailures(ctx context.Context, ip string) (models.IPLoginFailures, error) {
+	const op = "storage.sqlite.IPLoginFailures"
+
+	stmt, err := s.db.Prepare("SELECT ip, failures, window_ends_at FROM ip_login_failures WHERE ip = ?")
+	if err != nil {
+		return models.IPLoginFailures{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	var failures models.IPLoginFailures
+	err = stmt.QueryRowContext(ctx, ip).Scan(&failures.IP, &failures.Failures, &failures.WindowEndsAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.IPLoginFailures{IP: ip}, nil
+		}
+		return models.IPLoginFailures{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return failures, nil
+}
+
+// FailIPLogin counts a failed login from ip. A window that ended by now
+// starts over with this failure and ends at windowEndsAt.
+func (s *Storage) FailIPLogin(ctx context.Context, ip string, now, windowEndsAt time.Time) (models.IPLoginFailures, error) {
+	const op = "storage.sqlite.FailIPLogin"
+
+	stmt, err := s.db.Prepare(`INSERT INTO ip_login_failures (ip, failures, window_ends_at) VALUES (?, 1, ?)
+		ON CONFLICT(ip) DO UPDATE SET
+			failures = CASE WHEN window_ends_at <= ? THEN 1 ELSE failures + 1 END,
+			window_ends_at = CASE WHEN window_ends_at <= ? THEN excluded.window_ends_at ELSE window_ends_at END
+		RETURNING ip, failures, window_ends_at`)
+	if err != nil {
+		return models.IPLoginFailures{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	var failures models.IPLoginFailures
+	err = stmt.QueryRowContext(ctx, ip, windowEndsAt.UTC(), now.UTC(), now.UTC()).
+		Scan(&failures.IP, &failures.Failures, &failures.WindowEndsAt)
+	if err != nil {
+		return models.IPLoginFailures{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return failures, nil
+}
+
+func (s *Storage) DeleteExpiredIPLoginFailures(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.DeleteExpiredIPLoginFailures"
+
+	return s.deleteExpired(ctx, op, "DELETE FROM ip_login_failures WHERE window_ends_at < ?", now)
+}
+
+// exists runs a SELECT EXISTS query.
+func (s *Storage) exists(ctx context.Context, query string, args ...any) (bool, error) {
+	var found bool
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&found); err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// queryColumn reads the single column of the rows of query.
+func queryColumn[T any](ctx context.Context, db *sql.DB, query string, args ...any) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []T
+	for rows.Next() {
+		var value T
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+// CreateRole saves role with its permissions, the ones not known yet are
+// created on the way.
+func (s *Storage) CreateRole(ctx context.Context, role models.Role) (int64, error) {
+	const op = "storage.sqlite.CreateRole"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	sqlResult, err := tx.ExecContext(ctx, "INSERT INTO roles (name, description) VALUES (?, ?)", role.Name, role.Description)
+	if err != nil {
+		var errSqlite sqlite3.Error
+		if errors.As(err, &errSqlite) && errSqlite.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s %w", op, storage.ErrRoleExists)
+		}
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	roleID, err := sqlResult.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	for _, permission := range role.Permissions {
+		if err := grantPermission(ctx, tx, roleID, permission); err != nil {
+			return 0, fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	return roleID, nil
+}
+
+func grantPermission(ctx context.Context, tx *sql.Tx, roleID int64, permission string) error {
+	if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO permissions (name) VALUES (?)", permission); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx,
+		"INSERT OR IGNORE INTO role_permissions (role_id, permission_id) SELECT ?, id FROM permissions WHERE name = ?",
+		roleID, permission,
+	)
+	return err
+}
+
+// roleName returns the name of the role, ErrRoleNotFound if there is none.
+func roleName(ctx context.Context, tx *sql.Tx, roleID int64) (string, error) {
+	var name string
+	err := tx.QueryRowContext(ctx, "SELECT name FROM roles WHERE id = ?", roleID).Scan(&name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", storage.ErrRoleNotFound
+		}
+		return "", err
+	}
+	return name, nil
+}
+
+// DeleteRole deletes the role and takes it away from everyone holding it.
+// The admin role is kept, ErrRoleProtected is returned for it.
+func (s *Storage) DeleteRole(ctx context.Context, roleID int64) error {
+	const op = "storage.sqlite.DeleteRole"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	name, err := roleName(ctx, tx, roleID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if name == models.RoleAdmin {
+		return fmt.Errorf("%s %w", op, storage.ErrRoleProtected)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM user_roles WHERE role_id = ?",
+		"DELETE FROM group_roles WHERE role_id = ?",
+		"DELETE FROM role_permissions WHERE role_id = ?",
+		"DELETE FROM roles WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, roleID); err != nil {
+			return fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// Roles returns every role with its permissions, by name.
+func (s *Storage) Roles(ctx context.Context) ([]models.Role, error) {
+	const op = "storage.sqlite.Roles"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, description FROM roles ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	byID := make(map[int64]int)
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description); err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		byID[role.ID] = len(roles)
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	permRows, err := s.db.QueryContext(ctx, `SELECT role_permissions.role_id, permissions.name FROM role_permissions
+		JOIN permissions ON permissions.id = role_permissions.permission_id ORDER BY permissions.name`)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	defer permRows.Close()
+
+	for permRows.Next() {
+		var (
+			roleID     int64
+			permission string
+		)
+		if err := permRows.Scan(&roleID, &permission); err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		if i, ok := byID[roleID]; ok {
+			roles[i].Permissions = append(roles[i].Permissions, permission)
+		}
+	}
+	if err := permRows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return roles, nil
+}
+
+func (s *Storage) GrantPermission(ctx context.Context, roleID int64, permission string) error {
+	const op = "storage.sqlite.GrantPermission"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := roleName(ctx, tx, roleID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := grantPermission(ctx, tx, roleID, permission); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokePermission takes the permission away from the role. The admin role
+// keeps the permissions it was created with, ErrRoleProtected is returned
+// for them.
+func (s *Storage) RevokePermission(ctx context.Context, roleID int64, permission string) error {
+	const op = "storage.sqlite.RevokePermission"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	name, err := roleName(ctx, tx, roleID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if name == models.RoleAdmin && slices.Contains(models.AdminPermissions, permission) {
+		return fmt.Errorf("%s %w", op, storage.ErrRoleProtected)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM role_permissions WHERE role_id = ? AND permission_id = (SELECT id FROM permissions WHERE name = ?)",
+		roleID, permission,
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// AssignRole gives the user the role in the app, or in every app when appID
+// is zero.
+func (s *Storage) AssignRole(ctx context.Context, userID, roleID, appID int64) error {
+	const op = "storage.sqlite.AssignRole"
+
+	if err := s.checkExists(ctx, userExists(userID), roleExists(roleID), appExists(appID)); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO user_roles (user_id, role_id, app_id) VALUES (?, ?, ?)",
+		userID, roleID, appID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UnassignRole(ctx context.Context, userID, roleID, appID int64) error {
+	const op = "storage.sqlite.UnassignRole"
+
+	return s.deleteOne(ctx, op, storage.ErrRoleNotAssigned,
+		"DELETE FROM user_roles WHERE user_id = ? AND role_id = ? AND app_id = ?", userID, roleID, appID)
+}
+
+// UserRoles returns the role assignments of the user, the ones for every app
+// first.
+func (s *Storage) UserRoles(ctx context.Context, userID int64) ([]models.RoleAssignment, error) {
+	const op = "storage.sqlite.UserRoles"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT roles.id, roles.name, user_roles.app_id FROM user_roles
+		JOIN roles ON roles.id = user_roles.role_id WHERE user_roles.user_id = ? ORDER BY user_roles.app_id, roles.name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	defer rows.Close()
+
+	var assignments []models.RoleAssignment
+	for rows.Next() {
+		var a models.RoleAssignment
+		if err := rows.Scan(&a.RoleID, &a.Role, &a.AppID); err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		assignments = append(assignments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return assignments, nil
+}
+
+// heldRoles is the WITH clause of the roles user ?1 holds: the ones
+// assigned to them and the ones granted to a group they belong to, directly
+// or through subgroups. UNION skips the groups already reached, which also
+// ends the recursion should the groups form a cycle.
+const heldRoles = `WITH RECURSIVE member_of(group_id) AS (
+		SELECT group_id FROM group_members WHERE user_id = ?1
+		UNION
+		SELECT group_subgroups.parent_id FROM group_subgroups
+		JOIN member_of ON group_subgroups.child_id = member_of.group_id
+	),
+	held_roles(role_id, app_id) AS (
+		SELECT role_id, app_id FROM user_roles WHERE user_id = ?1
+		UNION
+		SELECT group_roles.role_id, group_roles.app_id FROM group_roles
+		JOIN member_of ON group_roles.group_id = member_of.group_id
+	)
+	`
+
+// UserGrants returns the roles the user holds in the app, including the ones
+// held in every app and the ones inherited from groups, and their
+// permissions.
+func (s *Storage) UserGrants(ctx context.Context, userID, appID int64) (models.Grants, error) {
+	const op = "storage.sqlite.UserGrants"
+
+	var (
+		grants models.Grants
+		err    error
+	)
+	grants.Roles, err = queryColumn[string](ctx, s.db, heldRoles+`SELECT DISTINCT roles.name FROM held_roles
+		JOIN roles ON roles.id = held_roles.role_id
+		WHERE held_roles.app_id IN (0, ?2) ORDER BY roles.name`, userID, appID)
+	if err != nil {
+		return models.Grants{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	grants.Permissions, err = queryColumn[string](ctx, s.db, heldRoles+`SELECT DISTINCT permissions.name FROM held_roles
+		JOIN role_permissions ON role_permissions.role_id = held_roles.role_id
+		JOIN permissions ON permissions.id = role_permissions.permission_id
+		WHERE held_roles.app_id IN (0, ?2) ORDER BY permissions.name`, userID, appID)
+	if err != nil {
+		return models.Grants{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return grants, nil
+}
+
+// HasPermission reports whether a role the user holds in the app, or in
+// every app, grants the permission.
+func (s *Storage) HasPermission(ctx context.Context, userID, appID int64, permission string) (bool, error) {
+	const op = "storage.sqlite.HasPermission"
+
+	allowed, err := s.exists(ctx, heldRoles+`SELECT EXISTS (SELECT 1 FROM held_roles
+		JOIN role_permissions ON role_permissions.role_id = held_roles.role_id
+		JOIN permissions ON permissions.id = role_permissions.permission_id
+		WHERE held_roles.app_id IN (0, ?2) AND permissions.name = ?3)`, userID, appID, permission)
+	if err != nil {
+		return false, fmt.Errorf("%s %w", op, err)
+	}
+
+	return allowed, nil
+}
+
+// GrantPaths returns every way the user holds the permission in the app:
+// the roles assigned to them, then the ones inherited from groups along
+// each chain of groups leading to them.
+func (s *Storage) GrantPaths(ctx context.Context, userID, appID int64, permission string) ([]models.GrantPath, error) {
+	const op = "storage.sqlite.GrantPaths"
+
+	// chain follows each path up from the user's groups, the JSON array of
+	// the groups passed keeps a path from going round a cycle
+	rows, err := s.db.QueryContext(ctx, `WITH RECURSIVE chain(group_id, path) AS (
+			SELECT group_id, json_array(group_id) FROM group_members WHERE user_id = ?1
+			UNION ALL
+			SELECT group_subgroups.parent_id, json_insert(chain.path, '$[#]', group_subgroups.parent_id) FROM group_subgroups
+			JOIN chain ON group_subgroups.child_id = chain.group_id
+			WHERE NOT EXISTS (SELECT 1 FROM json_each(chain.path) WHERE json_each.value = group_subgroups.parent_id)
+		),
+		paths(role_id, app_id, path) AS (
+			SELECT role_id, app_id, json_array() FROM user_roles WHERE user_id = ?1
+			UNION ALL
+			SELECT group_roles.role_id, group_roles.app_id, chain.path FROM group_roles
+			JOIN chain ON group_roles.group_id = chain.group_id
+		)
+		SELECT roles.id, roles.name, paths.app_id, paths.path FROM paths
+		JOIN roles ON roles.id = paths.role_id
+		JOIN role_permissions ON role_permissions.role_id = paths.role_id
+		JOIN permissions ON permissions.id = role_permissions.permission_id
+		WHERE paths.app_id IN (0, ?2) AND permissions.name = ?3
+		ORDER BY json_array_length(paths.path), roles.name, paths.path`, userID, appID, permission)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	defer rows.Close()
+
+	var (
+		paths    []models.GrantPath
+		groupIDs [][]int64
+	)
+	for rows.Next() {
+		var (
+			path models.GrantPath
+			ids  []byte
+		)
+		if err := rows.Scan(&path.RoleID, &path.Role, &path.AppID, &ids); err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+
+		var pathIDs []int64
+		if err := json.Unmarshal(ids, &pathIDs); err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		paths = append(paths, path)
+		groupIDs = append(groupIDs, pathIDs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	groups, err := s.groupsByID(ctx, slices.Concat(groupIDs...))
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	for i, ids := range groupIDs {
+		for _, id := range ids {
+			paths[i].Groups = append(paths[i].Groups, groups[id])
+		}
+	}
+
+	return paths, nil
+}
+
+func (s *Storage) groupsByID(ctx context.Context, ids []int64) (map[int64]models.Group, error) {
+	groups := make(map[int64]models.Group)
+	if len(ids) == 0 {
+		return groups, nil
+	}
+
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, name, description FROM groups WHERE id IN (SELECT value FROM json_each(?))", string(idsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.ID, &group.Name, &group.Description); err != nil {
+			return nil, err
+		}
+		groups[group.ID] = group
+	}
+
+	return groups, rows.Err()
+}
+
+func (s *Storage) CreateGroup(ctx context.Context, group models.Group) (int64, error) {
+	const op = "storage.sqlite.CreateGroup"
+
+	sqlResult, err := s.db.ExecContext(ctx, "INSERT INTO groups (name, description) VALUES (?, ?)", group.Name, group.Description)
+	if err != nil {
+		var errSqlite sqlite3.Error
+		if errors.As(err, &errSqlite) && errSqlite.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s %w", op, storage.ErrGroupExists)
+		}
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	groupID, err := sqlResult.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	return groupID, nil
+}
+
+// DeleteGroup deletes the group, its memberships both ways and its roles.
+func (s *Storage) DeleteGroup(ctx context.Context, groupID int64) error {
+	const op = "storage.sqlite.DeleteGroup"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	sqlResult, err := tx.ExecContext(ctx, "DELETE FROM groups WHERE id = ?", groupID)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrGroupNotFound)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM group_members WHERE group_id = ?1",
+		"DELETE FROM group_subgroups WHERE parent_id = ?1 OR child_id = ?1",
+		"DELETE FROM group_roles WHERE group_id = ?1",
+	} {
+		if _, err := tx.ExecContext(ctx, query, groupID); err != nil {
+			return fmt.Errorf("%s %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// Groups returns every group, by name.
+func (s *Storage) Groups(ctx context.Context) ([]models.Group, error) {
+	const op = "storage.sqlite.Groups"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, description FROM groups ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.ID, &group.Name, &group.Description); err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return groups, nil
+}
+
+// Group returns the group with its direct members, subgroups and roles.
+func (s *Storage) Group(ctx context.Context, groupID int64) (models.GroupInfo, error) {
+	const op = "storage.sqlite.Group"
+
+	var info models.GroupInfo
+	err := s.db.QueryRowContext(ctx, "SELECT id, name, description FROM groups WHERE id = ?", groupID).
+		Scan(&info.ID, &info.Name, &info.Description)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.GroupInfo{}, fmt.Errorf("%s %w", op, storage.ErrGroupNotFound)
+		}
+		return models.GroupInfo{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	info.UserIDs, err = queryColumn[int64](ctx, s.db, "SELECT user_id FROM group_members WHERE group_id = ? ORDER BY user_id", groupID)
+	if err != nil {
+		return models.GroupInfo{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	info.SubgroupIDs, err = queryColumn[int64](ctx, s.db, "SELECT child_id FROM group_subgroups WHERE parent_id = ? ORDER BY child_id", groupID)
+	if err != nil {
+		return models.GroupInfo{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT roles.id, roles.name, group_roles.app_id FROM group_roles
+		JOIN roles ON roles.id = group_roles.role_id WHERE group_roles.group_id = ? ORDER BY group_roles.app_id, roles.name`, groupID)
+	if err != nil {
+		return models.GroupInfo{}, fmt.Errorf("%s %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.RoleAssignment
+		if err := rows.Scan(&a.RoleID, &a.Role, &a.AppID); err != nil {
+			return models.GroupInfo{}, fmt.Errorf("%s %w", op, err)
+		}
+		info.Roles = append(info.Roles, a)
+	}
+	if err := rows.Err(); err != nil {
+		return models.GroupInfo{}, fmt.Errorf("%s %w", op, err)
+	}
+
+	return info, nil
+}
+
+// existenceCheck is a SELECT EXISTS query and the error returned when
+// it finds nothing.
+type existenceCheck struct {
+	query string
+	arg   int64
+	err   error
+}
+
+func (s *Storage) checkExists(ctx context.Context, checks ...existenceCheck) error {
+	for _, check := range checks {
+		found, err := s.exists(ctx, check.query, check.arg)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return check.err
+		}
+	}
+	return nil
+}
+
+func userExists(userID int64) existenceCheck {
+	return existenceCheck{"SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)", userID, storage.ErrUserNotFound}
+}
+
+func groupExists(groupID int64) existenceCheck {
+	return existenceCheck{"SELECT EXISTS (SELECT 1 FROM groups WHERE id = ?)", groupID, storage.ErrGroupNotFound}
+}
+
+func roleExists(roleID int64) existenceCheck {
+	return existenceCheck{"SELECT EXISTS (SELECT 1 FROM roles WHERE id = ?)", roleID, storage.ErrRoleNotFound}
+}
+
+// appExists passes for app 0, which stands for every app.
+func appExists(appID int64) existenceCheck {
+	return existenceCheck{"SELECT ?1 = 0 OR EXISTS (SELECT 1 FROM apps WHERE id = ?1)", appID, storage.ErrAppNotFound}
+}
+
+func (s *Storage) AddGroupMember(ctx context.Context, groupID, userID int64) error {
+	const op = "storage.sqlite.AddGroupMember"
+
+	if err := s.checkExists(ctx, groupExists(groupID), userExists(userID)); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, "INSERT OR IGNORE INTO group_members (group_id, user_id) VALUES (?, ?)", groupID, userID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RemoveGroupMember(ctx context.Context, groupID, userID int64) error {
+	const op = "storage.sqlite.RemoveGroupMember"
+
+	return s.deleteOne(ctx, op, storage.ErrNotGroupMember,
+		"DELETE FROM group_members WHERE group_id = ? AND user_id = ?", groupID, userID)
+}
+
+// AddSubgroup makes childID a member of parentID. ErrGroupCycle is returned
+// if parentID already is a member of childID, directly or not, or they are
+// the same group.
+func (s *Storage) AddSubgroup(ctx context.Context, parentID, childID int64) error {
+	const op = "storage.sqlite.AddSubgroup"
+
+	if err := s.checkExists(ctx, groupExists(parentID), groupExists(childID)); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var cycle bool
+	err = tx.QueryRowContext(ctx, `WITH RECURSIVE ancestors(id) AS (
+			SELECT ?1
+			UNION
+			SELECT group_subgroups.parent_id FROM group_subgroups
+			JOIN ancestors ON group_subgroups.child_id = ancestors.id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = ?2)`, parentID, childID).Scan(&cycle)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if cycle {
+		return fmt.Errorf("%s %w", op, storage.ErrGroupCycle)
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO group_subgroups (parent_id, child_id) VALUES (?, ?)", parentID, childID); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RemoveSubgroup(ctx context.Context, parentID, childID int64) error {
+	const op = "storage.sqlite.RemoveSubgroup"
+
+	return s.deleteOne(ctx, op, storage.ErrNotGroupMember,
+		"DELETE FROM group_subgroups WHERE parent_id = ? AND child_id = ?", parentID, childID)
+}
+
+// AssignGroupRole grants the role to the group's members in the app, or in
+// every app when appID is zero.
+func (s *Storage) AssignGroupRole(ctx context.Context, groupID, roleID, appID int64) error {
+	const op = "storage.sqlite.AssignGroupRole"
+
+	if err := s.checkExists(ctx, groupExists(groupID), roleExists(roleID), appExists(appID)); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO group_roles (group_id, role_id, app_id) VALUES (?, ?, ?)",
+		groupID, roleID, appID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UnassignGroupRole(ctx context.Context, groupID, roleID, appID int64) error {
+	const op = "storage.sqlite.UnassignGroupRole"
+
+	return s.deleteOne(ctx, op, storage.ErrRoleNotAssigned,
+		"DELETE FROM group_roles WHERE group_id = ? AND role_id = ? AND app_id = ?", groupID, roleID, appID)
+}
+
+// deleteOne runs a DELETE, notFound is returned if it deleted nothing.
+func (s *Storage) deleteOne(ctx context.Context, op string, notFound error, query string, args ...any) error {
+	sqlResult, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, notFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SavePolicy(ctx context.Context, policy models.Policy) (int64, error) {
+	const op = "storage.sqlite.SavePolicy"
+
+	if err := s.checkExists(ctx, appExists(policy.AppID)); err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	actions, err := json.Marshal(policy.Actions)
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := s.db.ExecContext(ctx, `INSERT INTO policies
+		(app_id, name, description, effect, actions, condition, dry_run, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		policy.AppID, policy.Name, policy.Description, policy.Effect, string(actions),
+		policy.Condition, policy.DryRun, policy.CreatedAt.UTC(), policy.UpdatedAt.UTC(),
+	)
+	if err != nil {
+		var errSqlite sqlite3.Error
+		if errors.As(err, &errSqlite) && errSqlite.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s %w", op, storage.ErrPolicyExists)
+		}
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	policyID, err := sqlResult.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s %w", op, err)
+	}
+
+	return policyID, nil
+}
+
+// UpdatePolicy replaces everything but the app and the creation time of
+// the policy.
+func (s *Storage) UpdatePolicy(ctx context.Context, policy models.Policy) error {
+	const op = "storage.sqlite.UpdatePolicy"
+
+	actions, err := json.Marshal(policy.Actions)
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	sqlResult, err := s.db.ExecContext(ctx, `UPDATE policies
+		SET name = ?, description = ?, effect = ?, actions = ?, condition = ?, dry_run = ?, updated_at = ?
+		WHERE id = ?`,
+		policy.Name, policy.Description, policy.Effect, string(actions),
+		policy.Condition, policy.DryRun, policy.UpdatedAt.UTC(), policy.ID,
+	)
+	if err != nil {
+		var errSqlite sqlite3.Error
+		if errors.As(err, &errSqlite) && errSqlite.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s %w", op, storage.ErrPolicyExists)
+		}
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	affected, err := sqlResult.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s %w", op, storage.ErrPolicyNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeletePolicy(ctx context.Context, policyID int64) error {
+	const op = "storage.sqlite.DeletePolicy"
+
+	return s.deleteOne(ctx, op, storage.ErrPolicyNotFound, "DELETE FROM policies WHERE id = ?", policyID)
+}
+
+const policyColumns = "id, app_id, name, description, effect, actions, condition, dry_run, created_at, updated_at"
+
+func (s *Storage) Policy(ctx context.Context, policyID int64) (models.Policy, error) {
+	const op = "storage.sqlite.Policy"
+
+	policies, err := s.queryPolicies(ctx, "SELECT "+policyColumns+" FROM policies WHERE id = ?", policyID)
+	if err != nil {
+		return models.Policy{}, fmt.Errorf("%s %w", op, err)
+	}
+	if len(policies) == 0 {
+		return models.Policy{}, fmt.Errorf("%s %w", op, storage.ErrPolicyNotFound)
+	}
+
+	return policies[0], nil
+}
+
+// Policies returns the policies of the app, by name.
+func (s *Storage) Policies(ctx context.Context, appID int64) ([]models.Policy, error) {
+	const op = "storage.sqlite.Policies"
+
+	policies, err := s.queryPolicies(ctx, "SELECT "+policyColumns+" FROM policies WHERE app_id = ? ORDER BY name", appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return policies, nil
+}
+
+func (s *Storage) queryPolicies(ctx context.Context, query string, args ...any) ([]models.Policy, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []models.Policy
+	for rows.Next() {
+		var (
+			policy  models.Policy
+			actions string
+		)
+		err := rows.Scan(
+			&policy.ID, &policy.AppID, &policy.Name, &policy.Description, &policy.Effect,
+			&actions, &policy.Condition, &policy.DryRun, &policy.CreatedAt, &policy.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(actions), &policy.Actions); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, rows.Err()
+}
+
+// SetUserAttribute saves the JSON value of the user's attribute, an empty
+// value deletes it.
+func (s *Storage) SetUserAttribute(ctx context.Context, userID int64, name, value string) error {
+	const op = "storage.sqlite.SetUserAttribute"
+
+	if err := s.checkExists(ctx, userExists(userID)); err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	var err error
+	if value == "" {
+		_, err = s.db.ExecContext(ctx, "DELETE FROM user_attributes WHERE user_id = ? AND name = ?", userID, name)
+	} else {
+		_, err = s.db.ExecContext(ctx, `INSERT INTO user_attributes (user_id, name, value) VALUES (?, ?, ?)
+			ON CONFLICT (user_id, name) DO UPDATE SET value = excluded.value`, userID, name, value)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %w", op, err)
+	}
+
+	return nil
+}
+
+// UserAttributes returns the JSON values of the user's attributes by name.
+func (s *Storage) UserAttributes(ctx context.Context, userID int64) (map[string]string, error) {
+	const op = "storage.sqlite.UserAttributes"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT name, value FROM user_attributes WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+	defer rows.Close()
+
+	attributes := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("%s %w", op, err)
+		}
+		attributes[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return attributes, nil
+}
+
+// UserGroups returns the names of the groups the user belongs to, directly
+// or through subgroups.
+func (s *Storage) UserGroups(ctx context.Context, userID int64) ([]string, error) {
+	const op = "storage.sqlite.UserGroups"
+
+	groups, err := queryColumn[string](ctx, s.db, heldRoles+`SELECT groups.name FROM member_of
+		JOIN groups ON groups.id = member_of.group_id ORDER BY groups.name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", op, err)
+	}
+
+	return groups, nil
+}
@@ -1,11 +1,123 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"sso/internal/domain/models"
+)
 
 var (
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUserExists         = errors.New("user already exists")
-	ErrUserNotFound       = errors.New("user no found")
-	ErrPasswordIncorect   = errors.New("incorect password")
-	ErrAppNotFound        = errors.New("app not found")
+	ErrInvalidCredentials      = errors.New("invalid credentials")
+	ErrUserExists              = errors.New("user already exists")
+	ErrUserNotFound            = errors.New("user no found")
+	ErrPasswordIncorect        = errors.New("incorect password")
+	ErrAppNotFound             = errors.New("app not found")
+	ErrRefreshTokenNotFound    = errors.New("refresh token not found")
+	ErrRefreshTokenRevoked     = errors.New("refresh token revoked")
+	ErrInvalidRefreshToken     = errors.New("invalid refresh token")
+	ErrSigningKeyRotated       = errors.New("signing key already rotated")
+	ErrInvalidToken            = errors.New("invalid token")
+	ErrInvalidClient           = errors.New("invalid client")
+	ErrInvalidRedirectURI      = errors.New("invalid redirect uri")
+	ErrInvalidRequest          = errors.New("invalid request")
+	ErrInvalidGrant            = errors.New("invalid grant")
+	ErrInvalidScope            = errors.New("invalid scope")
+	ErrCodeNotFound            = errors.New("authorization code not found")
+	ErrCodeUsed                = errors.New("authorization code already used")
+	ErrDeviceCodeNotFound      = errors.New("device code not found")
+	ErrAuthorizationPending    = errors.New("authorization pending")
+	ErrSlowDown                = errors.New("slow down")
+	ErrAccessDenied            = errors.New("access denied")
+	ErrExpiredToken            = errors.New("expired token")
+	ErrMFARequired             = errors.New("mfa required")
+	ErrMFAAlreadyEnabled       = errors.New("mfa already enabled")
+	ErrMFANotEnrolled          = errors.New("mfa not enrolled")
+	ErrInvalidMFACode          = errors.New("invalid mfa code")
+	ErrInvalidMFAToken         = errors.New("invalid mfa token")
+	ErrMFACodeUsed             = errors.New("mfa code already used")
+	ErrChallengeNotFound       = errors.New("mfa challenge not found")
+	ErrChallengeUsed           = errors.New("mfa challenge already used")
+	ErrRecoveryCodeUsed        = errors.New("recovery code already used")
+	ErrCredentialExists        = errors.New("webauthn credential already registered")
+	ErrCredentialNotFound      = errors.New("webauthn credential not found")
+	ErrCeremonyNotFound        = errors.New("webauthn ceremony not found")
+	ErrCeremonyUsed            = errors.New("webauthn ceremony already used")
+	ErrInvalidCeremony         = errors.New("invalid webauthn ceremony")
+	ErrInvalidWebAuthn         = errors.New("invalid webauthn response")
+	ErrResetNotFound           = errors.New("password reset not found")
+	ErrResetUsed               = errors.New("password reset already used")
+	ErrInvalidResetToken       = errors.New("invalid password reset token")
+	ErrVerificationNotFound    = errors.New("email verification not found")
+	ErrVerificationUsed        = errors.New("email verification already used")
+	ErrInvalidVerification     = errors.New("invalid email verification token")
+	ErrVerificationThrottled   = errors.New("verification email sent too recently")
+	ErrEmailNotVerified        = errors.New("email not verified")
+	ErrPasswordlessNotFound    = errors.New("passwordless login not found")
+	ErrPasswordlessUsed        = errors.New("passwordless login already used")
+	ErrInvalidPasswordless     = errors.New("invalid passwordless login")
+	ErrInvalidPasswordlessCode = errors.New("invalid passwordless code")
+	ErrLoginThrottled          = errors.New("too many login attempts")
+	ErrAccountLocked           = errors.New("account locked")
+	ErrPermissionDenied        = errors.New("permission denied")
+	ErrWeakPassword            = errors.New("password does not meet the policy")
+	ErrCheckpointNotFound      = errors.New("audit checkpoint not found")
+	ErrRoleExists              = errors.New("role already exists")
+	ErrRoleNotFound            = errors.New("role not found")
+	ErrRoleNotAssigned         = errors.New("role not assigned")
+	ErrRoleProtected           = errors.New("built-in role can't be changed")
+	ErrGroupExists             = errors.New("group already exists")
+	ErrGroupNotFound           = errors.New("group not found")
+	ErrGroupCycle              = errors.New("group would contain itself")
+	ErrNotGroupMember          = errors.New("not a group member")
+	ErrPolicyExists            = errors.New("policy already exists")
+	ErrPolicyNotFound          = errors.New("policy not found")
+	ErrInvalidPolicy           = errors.New("invalid policy")
+	ErrInvalidAttribute        = errors.New("invalid attribute")
 )
+
+// WeakPasswordError lists the password policy rules a new password
+// breaks. It matches ErrWeakPassword.
+type WeakPasswordError struct {
+	Violations []models.PasswordViolation
+}
+
+func (e *WeakPasswordError) Error() string {
+	return ErrWeakPassword.Error()
+}
+
+func (e *WeakPasswordError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// LoginBlockedError refuses a login before the password is checked, after
+// too many failures from the account or the address. It matches
+// ErrLoginThrottled or ErrAccountLocked.
+type LoginBlockedError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Err, e.RetryAfter)
+}
+
+func (e *LoginBlockedError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidInputError rejects a policy, an attribute or an authorization
+// request, Reason tells the caller what to fix. It matches Err.
+type InvalidInputError struct {
+	Err    error
+	Reason string
+}
+
+func (e *InvalidInputError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err, e.Reason)
+}
+
+func (e *InvalidInputError) Unwrap() error {
+	return e.Err
+}
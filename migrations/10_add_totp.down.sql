@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS mfa_challenges;
+
+ALTER TABLE users
+DROP COLUMN totp_last_step;
+
+ALTER TABLE users
+DROP COLUMN totp_enabled;
+
+ALTER TABLE users
+DROP COLUMN totp_secret;
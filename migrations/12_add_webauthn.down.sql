@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS webauthn_ceremonies;
+
+DROP INDEX IF EXISTS idx_webauthn_credentials_user_id;
+DROP TABLE IF EXISTS webauthn_credentials;
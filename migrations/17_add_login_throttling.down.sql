@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS ip_login_failures;
+
+ALTER TABLE users
+DROP COLUMN login_blocked_until;
+
+ALTER TABLE users
+DROP COLUMN failed_logins;
@@ -0,0 +1,11 @@
+ALTER TABLE users
+  ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE users
+  ADD COLUMN login_blocked_until TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS ip_login_failures (
+    ip TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    window_ends_at TIMESTAMP NOT NULL
+);
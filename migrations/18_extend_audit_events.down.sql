@@ -0,0 +1,17 @@
+DROP TRIGGER IF EXISTS audit_events_no_delete;
+DROP TRIGGER IF EXISTS audit_events_no_update;
+
+DROP INDEX IF EXISTS idx_audit_events_type;
+DROP INDEX IF EXISTS idx_audit_events_actor_id;
+
+ALTER TABLE audit_events
+DROP COLUMN outcome;
+
+ALTER TABLE audit_events
+DROP COLUMN user_agent;
+
+ALTER TABLE audit_events
+DROP COLUMN ip;
+
+ALTER TABLE audit_events
+DROP COLUMN actor_id;
@@ -0,0 +1,27 @@
+ALTER TABLE audit_events
+  ADD COLUMN actor_id INTEGER;
+
+ALTER TABLE audit_events
+  ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE audit_events
+  ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE audit_events
+  ADD COLUMN outcome TEXT NOT NULL DEFAULT 'success';
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_type ON audit_events (type);
+
+-- the audit trail is append-only, rows are never changed or removed
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update
+BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete
+BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
@@ -0,0 +1,13 @@
+DROP TRIGGER IF EXISTS audit_checkpoints_no_delete;
+DROP TRIGGER IF EXISTS audit_checkpoints_no_update;
+
+DROP INDEX IF EXISTS idx_audit_checkpoints_event_id;
+DROP TABLE IF EXISTS audit_checkpoints;
+
+DROP INDEX IF EXISTS idx_audit_events_prev_hash;
+
+ALTER TABLE audit_events
+DROP COLUMN hash;
+
+ALTER TABLE audit_events
+DROP COLUMN prev_hash;
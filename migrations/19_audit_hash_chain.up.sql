@@ -0,0 +1,31 @@
+ALTER TABLE audit_events
+  ADD COLUMN prev_hash TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE audit_events
+  ADD COLUMN hash TEXT NOT NULL DEFAULT '';
+
+-- two events chained to the same one would fork the chain
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_events_prev_hash ON audit_events (prev_hash) WHERE hash != '';
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id INTEGER NOT NULL,
+    hash TEXT NOT NULL,
+    signature TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_checkpoints_event_id ON audit_checkpoints (event_id);
+
+CREATE TRIGGER IF NOT EXISTS audit_checkpoints_no_update
+BEFORE UPDATE ON audit_checkpoints
+BEGIN
+    SELECT RAISE(ABORT, 'audit_checkpoints is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_checkpoints_no_delete
+BEFORE DELETE ON audit_checkpoints
+BEGIN
+    SELECT RAISE(ABORT, 'audit_checkpoints is append-only');
+END;
@@ -0,0 +1,14 @@
+ALTER TABLE users
+  ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE users SET is_admin = true WHERE id IN (
+    SELECT user_roles.user_id FROM user_roles
+    JOIN roles ON roles.id = user_roles.role_id
+    WHERE roles.name = 'admin' AND user_roles.app_id = 0
+);
+
+DROP INDEX IF EXISTS idx_user_roles_role_id;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
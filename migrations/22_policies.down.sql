@@ -0,0 +1,7 @@
+DELETE FROM role_permissions WHERE permission_id IN (
+    SELECT id FROM permissions WHERE name = 'policies:manage'
+);
+DELETE FROM permissions WHERE name = 'policies:manage';
+
+DROP TABLE IF EXISTS user_attributes;
+DROP TABLE IF EXISTS policies;
@@ -0,0 +1,2 @@
+ALTER TABLE recovery_codes
+DROP COLUMN lookup_id;
@@ -0,0 +1,2 @@
+ALTER TABLE recovery_codes
+  ADD COLUMN lookup_id TEXT NOT NULL DEFAULT '';
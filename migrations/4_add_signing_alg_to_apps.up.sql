@@ -0,0 +1,5 @@
+ALTER TABLE apps
+  ADD COLUMN signing_alg TEXT NOT NULL DEFAULT 'RS256';
+
+-- apps created before asymmetric signing keep their shared-secret tokens
+UPDATE apps SET signing_alg = 'HS256';
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_refresh_tokens_user_id;
+
+ALTER TABLE users
+DROP COLUMN tokens_revoked_at;
+
+DROP INDEX IF EXISTS idx_revoked_tokens_expires_at;
+DROP TABLE IF EXISTS revoked_tokens;
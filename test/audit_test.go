@@ -0,0 +1,379 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the admin seeded by test/migrations/6_admin_user.up.sql
+const (
+	adminEmail = "admin@sso.test"
+	adminPass  = "admin-test-password"
+)
+
+func TestListAuditEvents(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := gofakeit.Email(), GeneratePass()
+	reg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	failLogin(ctx, t, sut, email)
+	login(ctx, t, sut, email, pass)
+
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	resp, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+		Type:   "user.login",
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEvents(), 2)
+	assert.Empty(t, resp.GetNextPageToken())
+
+	success, failure := resp.GetEvents()[0], resp.GetEvents()[1]
+	assert.Equal(t, "success", success.GetOutcome())
+	assert.Equal(t, "password", success.GetDetails()["method"])
+	assert.Equal(t, appID, int(success.GetAppId()))
+	assert.NotEmpty(t, success.GetIp())
+	assert.NotEmpty(t, success.GetUserAgent())
+	assert.Equal(t, "failure", failure.GetOutcome())
+	assert.Equal(t, "invalid_credentials", failure.GetDetails()["reason"])
+
+	// one event a page
+	first, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:    admin.GetToken(),
+		UserId:   reg.GetUserId(),
+		Type:     "user.login",
+		PageSize: 1,
+	})
+	require.NoError(t, err)
+	require.Len(t, first.GetEvents(), 1)
+	assert.Equal(t, success.GetId(), first.GetEvents()[0].GetId())
+	require.NotEmpty(t, first.GetNextPageToken())
+
+	second, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:     admin.GetToken(),
+		UserId:    reg.GetUserId(),
+		Type:      "user.login",
+		PageSize:  1,
+		PageToken: first.GetNextPageToken(),
+	})
+	require.NoError(t, err)
+	require.Len(t, second.GetEvents(), 1)
+	assert.Equal(t, failure.GetId(), second.GetEvents()[0].GetId())
+	assert.Empty(t, second.GetNextPageToken())
+
+	failed, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:   admin.GetToken(),
+		UserId:  reg.GetUserId(),
+		Outcome: "failure",
+	})
+	require.NoError(t, err)
+	require.Len(t, failed.GetEvents(), 1)
+	assert.Equal(t, failure.GetId(), failed.GetEvents()[0].GetId())
+}
+
+func TestLoginAuditOutcome(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := gofakeit.Email(), GeneratePass()
+	reg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	// the password is right, the login is still refused
+	_, err = sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    1 << 40,
+	})
+	require.Error(t, err)
+
+	enrollTOTP(ctx, t, sut, login(ctx, t, sut, email, pass).GetToken())
+	require.True(t, login(ctx, t, sut, email, pass).GetMfaRequired())
+
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	resp, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+		Type:   "user.login",
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEvents(), 3)
+
+	mfa, success, refused := resp.GetEvents()[0], resp.GetEvents()[1], resp.GetEvents()[2]
+	assert.Equal(t, "mfa_required", mfa.GetOutcome())
+	assert.Empty(t, mfa.GetDetails()["reason"])
+	assert.Equal(t, "success", success.GetOutcome())
+	assert.Equal(t, "failure", refused.GetOutcome())
+	assert.Equal(t, "app_not_found", refused.GetDetails()["reason"])
+
+	pending, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:   admin.GetToken(),
+		UserId:  reg.GetUserId(),
+		Outcome: "mfa_required",
+	})
+	require.NoError(t, err)
+	require.Len(t, pending.GetEvents(), 1)
+	assert.Equal(t, mfa.GetId(), pending.GetEvents()[0].GetId())
+}
+
+func TestTokenAuditEvents(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := gofakeit.Email(), GeneratePass()
+	reg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	session := login(ctx, t, sut, email, pass)
+	_, err = sut.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{RefreshToken: session.GetRefreshToken()})
+	require.NoError(t, err)
+	_, err = sut.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{RefreshToken: session.GetRefreshToken()})
+	require.Error(t, err)
+
+	enrollTOTP(ctx, t, sut, login(ctx, t, sut, email, pass).GetToken())
+	_, err = sut.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: login(ctx, t, sut, email, pass).GetMfaToken(),
+		Code:     "000000",
+	})
+	require.Error(t, err)
+
+	_, err = sut.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		AppId:        appID,
+		ClientSecret: "wrong-secret",
+	})
+	require.Error(t, err)
+
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	refreshed, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+		Type:   "token.refreshed",
+	})
+	require.NoError(t, err)
+	require.Len(t, refreshed.GetEvents(), 2)
+	reuse := refreshed.GetEvents()[0]
+	assert.Equal(t, "failure", reuse.GetOutcome())
+	assert.Equal(t, "invalid_refresh_token", reuse.GetDetails()["reason"])
+	assert.Equal(t, "true", reuse.GetDetails()["family_revoked"])
+	assert.Equal(t, "success", refreshed.GetEvents()[1].GetOutcome())
+
+	logins, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:    admin.GetToken(),
+		UserId:   reg.GetUserId(),
+		Type:     "user.login",
+		PageSize: 1,
+	})
+	require.NoError(t, err)
+	require.Len(t, logins.GetEvents(), 1)
+	wrongCode := logins.GetEvents()[0]
+	assert.Equal(t, "failure", wrongCode.GetOutcome())
+	assert.Equal(t, "mfa", wrongCode.GetDetails()["method"])
+	assert.Equal(t, "invalid_mfa_code", wrongCode.GetDetails()["reason"])
+
+	issued, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:   admin.GetToken(),
+		AppId:   appID,
+		Type:    "token.issued",
+		Outcome: "failure",
+	})
+	require.NoError(t, err)
+	var badSecret bool
+	for _, event := range issued.GetEvents() {
+		if event.GetDetails()["grant"] == "client_credentials" && event.GetDetails()["reason"] == "invalid_client" {
+			badSecret = true
+		}
+	}
+	assert.True(t, badSecret, "wrong client secret isn't audited")
+}
+
+func TestAuditEventsChained(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	login(ctx, t, sut, email, pass)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	resp, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:    admin.GetToken(),
+		PageSize: 20,
+	})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(resp.GetEvents()), 2)
+
+	// newest first, each event is chained to the one listed after it
+	events := resp.GetEvents()
+	for i := 0; i+1 < len(events); i++ {
+		require.NotEmpty(t, events[i].GetHash())
+		if events[i].GetId() == events[i+1].GetId()+1 {
+			assert.Equal(t, events[i+1].GetHash(), events[i].GetPrevHash())
+		}
+	}
+}
+
+func TestListAuditEventsFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	session := login(ctx, t, sut, email, pass)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	tests := []struct {
+		name         string
+		req          *ssov1.ListAuditEventsRequest
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Not an admin",
+			req:          &ssov1.ListAuditEventsRequest{Token: session.GetToken()},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "Invalid token",
+			req:          &ssov1.ListAuditEventsRequest{Token: "not-a-token"},
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "Empty token",
+			req:          &ssov1.ListAuditEventsRequest{},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Invalid page token",
+			req:          &ssov1.ListAuditEventsRequest{Token: admin.GetToken(), PageToken: "next"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Invalid outcome",
+			req:          &ssov1.ListAuditEventsRequest{Token: admin.GetToken(), Outcome: "maybe"},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sut.AuthClient.ListAuditEvents(ctx, tt.req)
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func TestSetAdmin(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := gofakeit.Email(), GeneratePass()
+	reg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+	session := login(ctx, t, sut, email, pass)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	setAdmin(ctx, t, sut, admin.GetToken(), reg.GetUserId(), true)
+
+	_, err = sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{Token: session.GetToken()})
+	require.NoError(t, err)
+
+	setAdmin(ctx, t, sut, admin.GetToken(), reg.GetUserId(), false)
+
+	_, err = sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{Token: session.GetToken()})
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	resp, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+	})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(resp.GetEvents()), 2)
+	assert.Equal(t, "user.admin_revoked", resp.GetEvents()[0].GetType())
+	assert.Equal(t, "user.admin_granted", resp.GetEvents()[1].GetType())
+	assert.NotZero(t, resp.GetEvents()[0].GetActorId())
+	assert.NotEqual(t, reg.GetUserId(), resp.GetEvents()[0].GetActorId())
+}
+
+func TestSetAdminFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	session := login(ctx, t, sut, email, pass)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	tests := []struct {
+		name         string
+		token        string
+		userID       int64
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Not an admin",
+			token:        session.GetToken(),
+			userID:       1,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "Invalid token",
+			token:        "not-a-token",
+			userID:       1,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "Empty user id",
+			token:        admin.GetToken(),
+			userID:       0,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Unknown user",
+			token:        admin.GetToken(),
+			userID:       1 << 40,
+			expectedCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sut.AuthClient.SetAdmin(ctx, &ssov1.SetAdminRequest{
+				Token:   tt.token,
+				UserId:  tt.userID,
+				IsAdmin: true,
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func setAdmin(ctx context.Context, t *testing.T, sut *suit.Suite, token string, userID int64, isAdmin bool) {
+	t.Helper()
+
+	_, err := sut.AuthClient.SetAdmin(ctx, &ssov1.SetAdminRequest{
+		Token:   token,
+		UserId:  userID,
+		IsAdmin: isAdmin,
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,333 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorizePolicies(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	reg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: GeneratePass(),
+	})
+	require.NoError(t, err)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	// actions of their own keep other tests' policies of the app out
+	resource := "documents-" + gofakeit.UUID()
+	update := resource + ":update"
+
+	roleID := createRole(ctx, t, sut, admin.GetToken(), update)
+	_, err = sut.AuthClient.AssignRole(ctx, &ssov1.AssignRoleRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+		RoleId: roleID,
+		AppId:  appID,
+	})
+	require.NoError(t, err)
+
+	_, err = sut.AuthClient.SetUserAttribute(ctx, &ssov1.SetUserAttributeRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+		Name:   "department",
+		Value:  `"sales"`,
+	})
+	require.NoError(t, err)
+
+	allow := createPolicy(ctx, t, sut, &ssov1.CreatePolicyRequest{
+		Token:     admin.GetToken(),
+		Effect:    "allow",
+		Actions:   []string{update},
+		Condition: `"` + update + `" in subject.permissions && subject.department == resource.department`,
+	})
+	locked := createPolicy(ctx, t, sut, &ssov1.CreatePolicyRequest{
+		Token:     admin.GetToken(),
+		Effect:    "deny",
+		Actions:   []string{resource + ":*"},
+		Condition: `resource.status == "locked"`,
+		DryRun:    true,
+	})
+
+	tests := []struct {
+		name          string
+		resource      string
+		allowed       bool
+		policyID      int64
+		dryRunAllowed bool
+	}{
+		{
+			name:          "Own department",
+			resource:      `{"department": "sales", "status": "draft"}`,
+			allowed:       true,
+			policyID:      allow,
+			dryRunAllowed: true,
+		},
+		{
+			name:          "Other department",
+			resource:      `{"department": "hr", "status": "draft"}`,
+			allowed:       false,
+			dryRunAllowed: false,
+		},
+		{
+			name:          "Locked in dry run",
+			resource:      `{"department": "sales", "status": "locked"}`,
+			allowed:       true,
+			policyID:      allow,
+			dryRunAllowed: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := sut.AuthClient.Authorize(ctx, &ssov1.AuthorizeRequest{
+				UserId:   reg.GetUserId(),
+				AppId:    appID,
+				Action:   update,
+				Resource: tt.resource,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.allowed, resp.GetAllowed())
+			assert.Equal(t, tt.policyID, resp.GetPolicyId())
+			assert.Equal(t, tt.dryRunAllowed, resp.GetDryRunAllowed())
+			assert.NotEmpty(t, resp.GetReason())
+			assert.Len(t, resp.GetResults(), 2)
+		})
+	}
+
+	resp, err := sut.AuthClient.Authorize(ctx, &ssov1.AuthorizeRequest{
+		UserId:   reg.GetUserId(),
+		AppId:    appID,
+		Action:   update,
+		Resource: `{"department": "hr"}`,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.GetResults(), 2)
+	assert.Equal(t, "subject.department == resource.department", policyResult(t, resp, allow).GetUnmet())
+	assert.NotEmpty(t, policyResult(t, resp, locked).GetError(), "resource.status isn't set")
+
+	// enforcing the dry-run policy turns its verdict into the decision
+	_, err = sut.AuthClient.UpdatePolicy(ctx, &ssov1.UpdatePolicyRequest{
+		Token:     admin.GetToken(),
+		PolicyId:  locked,
+		Name:      "locked-" + gofakeit.UUID(),
+		Effect:    "deny",
+		Actions:   []string{resource + ":*"},
+		Condition: `resource.status == "locked"`,
+	})
+	require.NoError(t, err)
+
+	resp, err = sut.AuthClient.Authorize(ctx, &ssov1.AuthorizeRequest{
+		UserId:   reg.GetUserId(),
+		AppId:    appID,
+		Action:   update,
+		Resource: `{"department": "sales", "status": "locked"}`,
+	})
+	require.NoError(t, err)
+	assert.False(t, resp.GetAllowed())
+	assert.Equal(t, locked, resp.GetPolicyId())
+
+	policies, err := sut.AuthClient.ListPolicies(ctx, &ssov1.ListPoliciesRequest{Token: admin.GetToken(), AppId: appID})
+	require.NoError(t, err)
+	var found int
+	for _, policy := range policies.GetPolicies() {
+		if policy.GetId() == allow || policy.GetId() == locked {
+			found++
+		}
+	}
+	assert.Equal(t, 2, found)
+
+	_, err = sut.AuthClient.DeletePolicy(ctx, &ssov1.DeletePolicyRequest{Token: admin.GetToken(), PolicyId: allow})
+	require.NoError(t, err)
+
+	resp, err = sut.AuthClient.Authorize(ctx, &ssov1.AuthorizeRequest{
+		UserId:   reg.GetUserId(),
+		AppId:    appID,
+		Action:   update,
+		Resource: `{"department": "sales", "status": "draft"}`,
+	})
+	require.NoError(t, err)
+	assert.False(t, resp.GetAllowed())
+	assert.Zero(t, resp.GetPolicyId())
+}
+
+func TestAuthorizeFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	session := login(ctx, t, sut, email, pass)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	existing := createPolicy(ctx, t, sut, &ssov1.CreatePolicyRequest{
+		Token:   admin.GetToken(),
+		Effect:  "allow",
+		Actions: []string{"reports-" + gofakeit.UUID() + ":read"},
+	})
+	policies, err := sut.AuthClient.ListPolicies(ctx, &ssov1.ListPoliciesRequest{Token: admin.GetToken(), AppId: appID})
+	require.NoError(t, err)
+	var existingName string
+	for _, policy := range policies.GetPolicies() {
+		if policy.GetId() == existing {
+			existingName = policy.GetName()
+		}
+	}
+	require.NotEmpty(t, existingName)
+
+	newPolicy := func(token, effect, condition string) *ssov1.CreatePolicyRequest {
+		return &ssov1.CreatePolicyRequest{
+			Token:     token,
+			AppId:     appID,
+			Name:      "policy-" + gofakeit.UUID(),
+			Effect:    effect,
+			Actions:   []string{"reports:read"},
+			Condition: condition,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		call         func() error
+		expectedCode codes.Code
+	}{
+		{
+			name: "Not an admin",
+			call: func() error {
+				_, err := sut.AuthClient.CreatePolicy(ctx, newPolicy(session.GetToken(), "allow", ""))
+				return err
+			},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name: "Invalid condition",
+			call: func() error {
+				_, err := sut.AuthClient.CreatePolicy(ctx, newPolicy(admin.GetToken(), "allow", "subject.level >"))
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Unknown root",
+			call: func() error {
+				_, err := sut.AuthClient.CreatePolicy(ctx, newPolicy(admin.GetToken(), "allow", "user.level > 1"))
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid effect",
+			call: func() error {
+				_, err := sut.AuthClient.CreatePolicy(ctx, newPolicy(admin.GetToken(), "permit", ""))
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Policy exists",
+			call: func() error {
+				req := newPolicy(admin.GetToken(), "allow", "")
+				req.Name = existingName
+				_, err := sut.AuthClient.CreatePolicy(ctx, req)
+				return err
+			},
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			name: "Unknown policy",
+			call: func() error {
+				_, err := sut.AuthClient.DeletePolicy(ctx, &ssov1.DeletePolicyRequest{Token: admin.GetToken(), PolicyId: 1 << 40})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "Built-in attribute",
+			call: func() error {
+				_, err := sut.AuthClient.SetUserAttribute(ctx, &ssov1.SetUserAttributeRequest{
+					Token: admin.GetToken(), UserId: 1, Name: "roles", Value: `["admin"]`,
+				})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Attribute not JSON",
+			call: func() error {
+				_, err := sut.AuthClient.SetUserAttribute(ctx, &ssov1.SetUserAttributeRequest{
+					Token: admin.GetToken(), UserId: 1, Name: "level", Value: "high",
+				})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Resource not an object",
+			call: func() error {
+				_, err := sut.AuthClient.Authorize(ctx, &ssov1.AuthorizeRequest{
+					UserId: 1, AppId: appID, Action: "reports:read", Resource: `["a"]`,
+				})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Unknown user",
+			call: func() error {
+				_, err := sut.AuthClient.Authorize(ctx, &ssov1.AuthorizeRequest{UserId: 1 << 40, AppId: appID, Action: "reports:read"})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "Empty action",
+			call: func() error {
+				_, err := sut.AuthClient.Authorize(ctx, &ssov1.AuthorizeRequest{UserId: 1, AppId: appID})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+// createPolicy creates the policy in the test app under a random name.
+func createPolicy(ctx context.Context, t *testing.T, sut *suit.Suite, req *ssov1.CreatePolicyRequest) int64 {
+	t.Helper()
+
+	req.AppId = appID
+	req.Name = "policy-" + gofakeit.UUID()
+	resp, err := sut.AuthClient.CreatePolicy(ctx, req)
+	require.NoError(t, err)
+
+	return resp.GetPolicyId()
+}
+
+// policyResult finds the result of the policy, results come in name order
+// and the names are random.
+func policyResult(t *testing.T, resp *ssov1.AuthorizeResponse, policyID int64) *ssov1.PolicyResult {
+	t.Helper()
+
+	for _, res := range resp.GetResults() {
+		if res.GetPolicyId() == policyID {
+			return res
+		}
+	}
+	t.Fatalf("no result for policy %d", policyID)
+	return nil
+}
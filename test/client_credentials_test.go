@@ -0,0 +1,109 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientCredentials(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	resp, err := sut.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		AppId:        appID,
+		ClientSecret: secret,
+		Scope:        "reports:read",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "reports:read", resp.GetScope())
+	assert.Equal(t, int64(sut.Cfg.TokenTTL.Seconds()), resp.GetExpiresIn())
+
+	respValid, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: resp.GetToken(),
+		AppId: appID,
+	})
+	require.NoError(t, err)
+
+	assert.True(t, respValid.GetActive())
+	assert.Equal(t, "service", respValid.GetTokenType())
+	assert.Equal(t, int64(appID), respValid.GetClientId())
+	assert.Empty(t, respValid.GetUserId())
+	assert.Equal(t, "reports:read", respValid.GetScope())
+}
+
+func TestClientCredentialsFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	tests := []struct {
+		name     string
+		secret   string
+		scope    string
+		wantCode codes.Code
+	}{
+		{
+			name:     "wrong secret",
+			secret:   "wrong-secret",
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "empty secret",
+			secret:   "",
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "unregistered scope",
+			secret:   secret,
+			scope:    "reports:read admin",
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sut.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+				AppId:        appID,
+				ClientSecret: tt.secret,
+				Scope:        tt.scope,
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
+
+func TestClientCredentialsTokenEndpoint(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+
+	status, tokens := postTokenAs(t, sut, strconv.Itoa(appID), secret, form)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.Empty(t, tokens.RefreshToken)
+	// no scope asked for, every registered scope is granted
+	assert.Equal(t, "reports:read reports:write", tokens.Scope)
+
+	respValid, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: tokens.AccessToken,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "service", respValid.GetTokenType())
+
+	// service tokens don't belong to a user
+	userInfo := getUserInfo(t, sut, tokens.AccessToken)
+	userInfo.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, userInfo.StatusCode)
+
+	status, tokens = postTokenAs(t, sut, strconv.Itoa(appID), "wrong-secret", form)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, "invalid_client", tokens.Error)
+}
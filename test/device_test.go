@@ -0,0 +1,138 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	device := startDeviceAuthorization(t, sut)
+
+	assert.Equal(t, sut.Cfg.Issuer+"/oauth/device", device.VerificationURI)
+	assert.Equal(t, int64(sut.Cfg.OAuth.DevicePollInterval.Seconds()), device.Interval)
+	assert.Len(t, device.UserCode, 9)
+
+	resp, err := http.Get(sut.HTTPURL + "/oauth/device?" + url.Values{"user_code": {device.UserCode}}.Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// users type codes in lowercase and without the dash
+	status := verifyDevice(t, sut, strings.ToLower(strings.ReplaceAll(device.UserCode, "-", "")), email, pass, "approve")
+	require.Equal(t, http.StatusOK, status)
+
+	status, tokens := pollDevice(t, sut, device.DeviceCode)
+	require.Equal(t, http.StatusOK, status)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	respValid, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: tokens.AccessToken,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+	assert.True(t, respValid.GetActive())
+	assert.Equal(t, email, respValid.GetEmail())
+
+	// device codes are single-use
+	status, tokens = pollDevice(t, sut, device.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", tokens.Error)
+}
+
+func TestDeviceAuthorizationPending(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	device := startDeviceAuthorization(t, sut)
+
+	status, tokens := pollDevice(t, sut, device.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "authorization_pending", tokens.Error)
+
+	// polled again right away, well within the interval
+	status, tokens = pollDevice(t, sut, device.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "slow_down", tokens.Error)
+
+	status = verifyDevice(t, sut, device.UserCode, email, pass, "deny")
+	require.Equal(t, http.StatusOK, status)
+
+	// a decided code can't be approved afterwards
+	status = verifyDevice(t, sut, device.UserCode, email, pass, "approve")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestDeviceVerifyInvalidCredentials(t *testing.T) {
+	_, sut := suit.New(t)
+
+	device := startDeviceAuthorization(t, sut)
+
+	status := verifyDevice(t, sut, device.UserCode, "nobody@example.com", GeneratePass(), "approve")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func startDeviceAuthorization(t *testing.T, sut *suit.Suite) deviceAuthorizationResponse {
+	t.Helper()
+
+	resp, err := http.PostForm(sut.HTTPURL+"/oauth/device_authorization", url.Values{
+		"client_id": {strconv.Itoa(appID)},
+		"scope":     {"openid"},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var device deviceAuthorizationResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&device))
+
+	return device
+}
+
+func verifyDevice(t *testing.T, sut *suit.Suite, userCode, email, pass, action string) int {
+	t.Helper()
+
+	resp, err := http.PostForm(sut.HTTPURL+"/oauth/device", url.Values{
+		"user_code": {userCode},
+		"email":     {email},
+		"password":  {pass},
+		"action":    {action},
+	})
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func pollDevice(t *testing.T, sut *suit.Suite, deviceCode string) (int, oauthTokenResponse) {
+	t.Helper()
+
+	return postToken(t, sut, url.Values{
+		"grant_type":  {deviceGrantType},
+		"client_id":   {strconv.Itoa(appID)},
+		"device_code": {deviceCode},
+	})
+}
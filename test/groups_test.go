@@ -0,0 +1,262 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGroupRolesInherited(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := gofakeit.Email(), GeneratePass()
+	reg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	// the user is in team, team is in department, department holds the role
+	department := createGroup(ctx, t, sut, admin.GetToken())
+	team := createGroup(ctx, t, sut, admin.GetToken())
+
+	_, err = sut.AuthClient.AddSubgroup(ctx, &ssov1.AddSubgroupRequest{
+		Token:      admin.GetToken(),
+		GroupId:    department,
+		SubgroupId: team,
+	})
+	require.NoError(t, err)
+
+	_, err = sut.AuthClient.AddGroupMember(ctx, &ssov1.AddGroupMemberRequest{
+		Token:   admin.GetToken(),
+		GroupId: team,
+		UserId:  reg.GetUserId(),
+	})
+	require.NoError(t, err)
+
+	roleID := createRole(ctx, t, sut, admin.GetToken(), "reports:read")
+	_, err = sut.AuthClient.AssignGroupRole(ctx, &ssov1.AssignGroupRoleRequest{
+		Token:   admin.GetToken(),
+		GroupId: department,
+		RoleId:  roleID,
+		AppId:   appID,
+	})
+	require.NoError(t, err)
+
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID, "reports:read", true)
+
+	session := login(ctx, t, sut, email, pass)
+	claims, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: session.GetToken()})
+	require.NoError(t, err)
+	assert.Contains(t, claims.GetPermissions(), "reports:read")
+
+	explained, err := sut.AuthClient.ExplainPermission(ctx, &ssov1.ExplainPermissionRequest{
+		Token:      admin.GetToken(),
+		UserId:     reg.GetUserId(),
+		AppId:      appID,
+		Permission: "reports:read",
+	})
+	require.NoError(t, err)
+	assert.True(t, explained.GetAllowed())
+	require.Len(t, explained.GetPaths(), 1)
+	path := explained.GetPaths()[0]
+	assert.Equal(t, roleID, path.GetRoleId())
+	require.Len(t, path.GetGroups(), 2)
+	assert.Equal(t, team, path.GetGroups()[0].GetId())
+	assert.Equal(t, department, path.GetGroups()[1].GetId())
+
+	group, err := sut.AuthClient.GetGroup(ctx, &ssov1.GetGroupRequest{Token: admin.GetToken(), GroupId: department})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{team}, group.GetSubgroupIds())
+	require.Len(t, group.GetRoles(), 1)
+	assert.Equal(t, roleID, group.GetRoles()[0].GetRoleId())
+
+	_, err = sut.AuthClient.RemoveSubgroup(ctx, &ssov1.RemoveSubgroupRequest{
+		Token:      admin.GetToken(),
+		GroupId:    department,
+		SubgroupId: team,
+	})
+	require.NoError(t, err)
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID, "reports:read", false)
+
+	explained, err = sut.AuthClient.ExplainPermission(ctx, &ssov1.ExplainPermissionRequest{
+		Token:      admin.GetToken(),
+		UserId:     reg.GetUserId(),
+		AppId:      appID,
+		Permission: "reports:read",
+	})
+	require.NoError(t, err)
+	assert.False(t, explained.GetAllowed())
+	assert.Empty(t, explained.GetPaths())
+}
+
+func TestGroupAdmin(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := gofakeit.Email(), GeneratePass()
+	reg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+	session := login(ctx, t, sut, email, pass)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	admins := createGroup(ctx, t, sut, admin.GetToken())
+	roles, err := sut.AuthClient.ListRoles(ctx, &ssov1.ListRolesRequest{Token: admin.GetToken()})
+	require.NoError(t, err)
+	for _, role := range roles.GetRoles() {
+		if role.GetName() == "admin" {
+			_, err = sut.AuthClient.AssignGroupRole(ctx, &ssov1.AssignGroupRoleRequest{
+				Token:   admin.GetToken(),
+				GroupId: admins,
+				RoleId:  role.GetId(),
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	_, err = sut.AuthClient.AddGroupMember(ctx, &ssov1.AddGroupMemberRequest{
+		Token:   admin.GetToken(),
+		GroupId: admins,
+		UserId:  reg.GetUserId(),
+	})
+	require.NoError(t, err)
+
+	isAdmin, err := sut.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: reg.GetUserId()})
+	require.NoError(t, err)
+	assert.True(t, isAdmin.GetIsAdmin())
+
+	_, err = sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{Token: session.GetToken()})
+	require.NoError(t, err)
+
+	_, err = sut.AuthClient.DeleteGroup(ctx, &ssov1.DeleteGroupRequest{Token: admin.GetToken(), GroupId: admins})
+	require.NoError(t, err)
+
+	isAdmin, err = sut.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: reg.GetUserId()})
+	require.NoError(t, err)
+	assert.False(t, isAdmin.GetIsAdmin())
+}
+
+func TestGroupsFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	session := login(ctx, t, sut, email, pass)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	parent := createGroup(ctx, t, sut, admin.GetToken())
+	child := createGroup(ctx, t, sut, admin.GetToken())
+	_, err := sut.AuthClient.AddSubgroup(ctx, &ssov1.AddSubgroupRequest{Token: admin.GetToken(), GroupId: parent, SubgroupId: child})
+	require.NoError(t, err)
+
+	groups, err := sut.AuthClient.ListGroups(ctx, &ssov1.ListGroupsRequest{Token: admin.GetToken()})
+	require.NoError(t, err)
+	var parentName string
+	for _, group := range groups.GetGroups() {
+		if group.GetId() == parent {
+			parentName = group.GetName()
+		}
+	}
+	require.NotEmpty(t, parentName)
+
+	tests := []struct {
+		name         string
+		call         func() error
+		expectedCode codes.Code
+	}{
+		{
+			name: "Not an admin",
+			call: func() error {
+				_, err := sut.AuthClient.CreateGroup(ctx, &ssov1.CreateGroupRequest{Token: session.GetToken(), Name: "group-" + gofakeit.UUID()})
+				return err
+			},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name: "Group exists",
+			call: func() error {
+				_, err := sut.AuthClient.CreateGroup(ctx, &ssov1.CreateGroupRequest{Token: admin.GetToken(), Name: parentName})
+				return err
+			},
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			name: "Cycle",
+			call: func() error {
+				_, err := sut.AuthClient.AddSubgroup(ctx, &ssov1.AddSubgroupRequest{Token: admin.GetToken(), GroupId: child, SubgroupId: parent})
+				return err
+			},
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name: "Group in itself",
+			call: func() error {
+				_, err := sut.AuthClient.AddSubgroup(ctx, &ssov1.AddSubgroupRequest{Token: admin.GetToken(), GroupId: parent, SubgroupId: parent})
+				return err
+			},
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name: "Unknown group",
+			call: func() error {
+				_, err := sut.AuthClient.AddGroupMember(ctx, &ssov1.AddGroupMemberRequest{Token: admin.GetToken(), GroupId: 1 << 40, UserId: 1})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "Unknown user",
+			call: func() error {
+				_, err := sut.AuthClient.AddGroupMember(ctx, &ssov1.AddGroupMemberRequest{Token: admin.GetToken(), GroupId: parent, UserId: 1 << 40})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "Not a member",
+			call: func() error {
+				_, err := sut.AuthClient.RemoveGroupMember(ctx, &ssov1.RemoveGroupMemberRequest{Token: admin.GetToken(), GroupId: parent, UserId: 1})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "Empty permission",
+			call: func() error {
+				_, err := sut.AuthClient.ExplainPermission(ctx, &ssov1.ExplainPermissionRequest{Token: admin.GetToken(), UserId: 1})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func createGroup(ctx context.Context, t *testing.T, sut *suit.Suite, token string) int64 {
+	t.Helper()
+
+	resp, err := sut.AuthClient.CreateGroup(ctx, &ssov1.CreateGroupRequest{
+		Token: token,
+		Name:  "group-" + gofakeit.UUID(),
+	})
+	require.NoError(t, err)
+
+	return resp.GetGroupId()
+}
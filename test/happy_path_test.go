@@ -1,6 +1,7 @@
 package test
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
@@ -53,9 +54,10 @@ func TestHappyPath(t *testing.T) {
 	claims, ok := tokenJWT.Claims.(jwt.MapClaims)
 	assert.True(t, ok)
 
-	assert.Equal(t, response.GetUserId(), int64(claims["uid"].(float64)))
+	assert.Equal(t, strconv.FormatInt(response.GetUserId(), 10), claims["sub"])
 	assert.Equal(t, email, claims["email"])
-	assert.Equal(t, appID, int(claims["app_id"].(float64)))
+	assert.Equal(t, strconv.Itoa(appID), claims["aud"])
+	assert.Equal(t, sut.Cfg.Issuer, claims["iss"])
 
 	const deltaSeconds = 1
 	assert.InDelta(t, loginTime.Add(sut.Cfg.TokenTTL).Unix(), claims["exp"].(float64), deltaSeconds)
@@ -0,0 +1,65 @@
+package test
+
+import (
+	"strconv"
+	"testing"
+
+	ssojwt "sso/internal/jwt"
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const asymmetricAppID = 2
+
+func TestLoginAsymmetricApp(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email := gofakeit.Email()
+	pass := GeneratePass()
+
+	_, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	respLog, err := sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    asymmetricAppID,
+	})
+	require.NoError(t, err)
+
+	respJWKS, err := sut.AuthClient.JWKS(ctx, &ssov1.JWKSRequest{})
+	require.NoError(t, err)
+	require.NotEmpty(t, respJWKS.GetKeys())
+
+	tokenJWT, err := jwt.Parse(respLog.GetToken(), func(token *jwt.Token) (interface{}, error) {
+		for _, key := range respJWKS.GetKeys() {
+			if key.GetKid() != token.Header["kid"] {
+				continue
+			}
+			jwk := ssojwt.JSONWebKey{
+				Kty: key.GetKty(),
+				N:   key.GetN(),
+				E:   key.GetE(),
+				Crv: key.GetCrv(),
+				X:   key.GetX(),
+				Y:   key.GetY(),
+			}
+			return jwk.PublicKey()
+		}
+		return nil, jwt.ErrInvalidKey
+	})
+	require.NoError(t, err)
+
+	claims, ok := tokenJWT.Claims.(jwt.MapClaims)
+	require.True(t, ok)
+	assert.Equal(t, email, claims["email"])
+	assert.Equal(t, strconv.Itoa(asymmetricAppID), claims["aud"])
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// loginDelayAfter is lockout.delay_after in config/local.yaml.
+const loginDelayAfter = 3
+
+func TestLoginDelay(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+
+	for range loginDelayAfter {
+		failLogin(ctx, t, sut, email)
+	}
+
+	// the right password waits too
+	_, err := sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	time.Sleep(retryDelay(t, err) + 100*time.Millisecond)
+	login(ctx, t, sut, email, pass)
+
+	// the successful login forgot the failures
+	failLogin(ctx, t, sut, email)
+	login(ctx, t, sut, email, pass)
+}
+
+func TestUnlockAccountFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	session := login(ctx, t, sut, email, pass)
+
+	tests := []struct {
+		name         string
+		token        string
+		userID       int64
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Not an admin",
+			token:        session.GetToken(),
+			userID:       1,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "Invalid token",
+			token:        "not-a-token",
+			userID:       1,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "Empty user id",
+			token:        session.GetToken(),
+			userID:       0,
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sut.AuthClient.UnlockAccount(ctx, &ssov1.UnlockAccountRequest{
+				Token:  tt.token,
+				UserId: tt.userID,
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func failLogin(ctx context.Context, t *testing.T, sut *suit.Suite, email string) {
+	t.Helper()
+
+	_, err := sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: GeneratePass(),
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// retryDelay is the wait the RetryInfo detail of err asks for.
+func retryDelay(t *testing.T, err error) time.Duration {
+	t.Helper()
+
+	var retryAfter time.Duration
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryAfter = info.GetRetryDelay().AsDuration()
+		}
+	}
+	require.Positive(t, retryAfter)
+
+	return retryAfter
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLogout(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+
+	first := login(ctx, t, sut, email, pass)
+	second := login(ctx, t, sut, email, pass)
+
+	_, err := sut.AuthClient.Logout(ctx, &ssov1.LogoutRequest{
+		Token: first.GetToken(),
+	})
+	require.NoError(t, err)
+
+	assertTokenActive(ctx, t, sut, first.GetToken(), false)
+	assertTokenActive(ctx, t, sut, second.GetToken(), true)
+
+	_, err = sut.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: first.GetRefreshToken(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = sut.AuthClient.Logout(ctx, &ssov1.LogoutRequest{
+		Token: first.GetToken(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestLogoutAllSessions(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+
+	first := login(ctx, t, sut, email, pass)
+	second := login(ctx, t, sut, email, pass)
+
+	_, err := sut.AuthClient.Logout(ctx, &ssov1.LogoutRequest{
+		Token:       first.GetToken(),
+		AllSessions: true,
+	})
+	require.NoError(t, err)
+
+	assertTokenActive(ctx, t, sut, first.GetToken(), false)
+	assertTokenActive(ctx, t, sut, second.GetToken(), false)
+
+	_, err = sut.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: second.GetRefreshToken(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// a login right after the logout, most likely in the same second, isn't
+	// revoked by it
+	third := login(ctx, t, sut, email, pass)
+	assertTokenActive(ctx, t, sut, third.GetToken(), true)
+	assertTokenActive(ctx, t, sut, second.GetToken(), false)
+}
+
+func registerUser(ctx context.Context, t *testing.T, sut *suit.Suite) (email string, pass string) {
+	t.Helper()
+
+	email = gofakeit.Email()
+	pass = GeneratePass()
+
+	_, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	return email, pass
+}
+
+func login(ctx context.Context, t *testing.T, sut *suit.Suite, email, pass string) *ssov1.LoginResponse {
+	t.Helper()
+
+	respLog, err := sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	return respLog
+}
+
+func assertTokenActive(ctx context.Context, t *testing.T, sut *suit.Suite, token string, active bool) {
+	t.Helper()
+
+	respValid, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: token,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, active, respValid.GetActive())
+}
@@ -0,0 +1,226 @@
+package test
+
+import (
+	"context"
+	"encoding/base32"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sso/internal/lib/totp"
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTOTPLogin(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	secret, _ := enrollTOTP(ctx, t, sut, login(ctx, t, sut, email, pass).GetToken())
+
+	respLog := login(ctx, t, sut, email, pass)
+	require.True(t, respLog.GetMfaRequired())
+	assert.Empty(t, respLog.GetToken())
+	require.NotEmpty(t, respLog.GetMfaToken())
+
+	_, err := sut.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     "000000",
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the confirmation used the current step, the next one is still in the window
+	code := totp.Code(secret, totp.Step(time.Now())+1)
+
+	respMFA, err := sut.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     code,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respMFA.GetRefreshToken())
+	assertTokenActive(ctx, t, sut, respMFA.GetToken(), true)
+
+	// neither the mfa token nor the code work twice
+	_, err = sut.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     code,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	respLog = login(ctx, t, sut, email, pass)
+	_, err = sut.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLog.GetMfaToken(),
+		Code:     code,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestEnrollTOTPTwice(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	token := login(ctx, t, sut, email, pass).GetToken()
+	enrollTOTP(ctx, t, sut, token)
+
+	_, err := sut.AuthClient.EnrollTOTP(ctx, &ssov1.EnrollTOTPRequest{Token: token})
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	token := login(ctx, t, sut, email, pass).GetToken()
+	_, recoveryCodes := enrollTOTP(ctx, t, sut, token)
+	require.Len(t, recoveryCodes, 10)
+
+	verify := func(code string) error {
+		respLog := login(ctx, t, sut, email, pass)
+		require.True(t, respLog.GetMfaRequired())
+
+		respMFA, err := sut.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+			MfaToken: respLog.GetMfaToken(),
+			Code:     code,
+		})
+		if err == nil {
+			assertTokenActive(ctx, t, sut, respMFA.GetToken(), true)
+		}
+		return err
+	}
+
+	require.NoError(t, verify(recoveryCodes[0]))
+
+	// a recovery code is accepted only once
+	err := verify(recoveryCodes[0])
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	respRegen, err := sut.AuthClient.RegenerateRecoveryCodes(ctx, &ssov1.RegenerateRecoveryCodesRequest{
+		Token: token,
+	})
+	require.NoError(t, err)
+	require.Len(t, respRegen.GetRecoveryCodes(), 10)
+
+	// regenerating invalidates the previous set
+	err = verify(recoveryCodes[1])
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// any code of the set works, typed without the dash too
+	require.NoError(t, verify(respRegen.GetRecoveryCodes()[0]))
+	require.NoError(t, verify(strings.ReplaceAll(respRegen.GetRecoveryCodes()[9], "-", "")))
+}
+
+func TestRegenerateRecoveryCodesWithoutMFA(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	token := login(ctx, t, sut, email, pass).GetToken()
+
+	_, err := sut.AuthClient.RegenerateRecoveryCodes(ctx, &ssov1.RegenerateRecoveryCodesRequest{
+		Token: token,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestMFACodeLockout(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	secret, _ := enrollTOTP(ctx, t, sut, login(ctx, t, sut, email, pass).GetToken())
+	spare := login(ctx, t, sut, email, pass).GetMfaToken()
+
+	// a new challenge for every guess doesn't reset the count
+	for range loginDelayAfter {
+		_, err := sut.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+			MfaToken: login(ctx, t, sut, email, pass).GetMfaToken(),
+			Code:     "000000",
+		})
+		require.Error(t, err)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	_, err := sut.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: spare,
+		Code:     totp.Code(secret, totp.Step(time.Now())+1),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestAuthorizeMFACodeLockout(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	secret, _ := enrollTOTP(ctx, t, sut, login(ctx, t, sut, email, pass).GetToken())
+	_, challenge := pkcePair()
+
+	signIn := func(code string) (int, string) {
+		resp := postAuthorize(t, sut, url.Values{
+			"client_id":             {strconv.Itoa(appID)},
+			"redirect_uri":          {redirectURI},
+			"response_type":         {"code"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}, url.Values{"email": {email}, "password": {pass}, "mfa_code": {code}})
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(body)
+	}
+
+	for range loginDelayAfter {
+		status, body := signIn("000000")
+		require.Equal(t, http.StatusUnauthorized, status)
+		require.Contains(t, body, "Invalid authentication code")
+	}
+
+	status, body := signIn(totp.Code(secret, totp.Step(time.Now())+1))
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Contains(t, body, "Too many failed attempts")
+}
+
+// enrollTOTP enables TOTP for the token's user and returns the secret, as an
+// authenticator app would have it, along with the issued recovery codes.
+func enrollTOTP(ctx context.Context, t *testing.T, sut *suit.Suite, token string) ([]byte, []string) {
+	t.Helper()
+
+	respEnroll, err := sut.AuthClient.EnrollTOTP(ctx, &ssov1.EnrollTOTPRequest{Token: token})
+	require.NoError(t, err)
+	assert.Contains(t, respEnroll.GetProvisioningUri(), "otpauth://totp/")
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(respEnroll.GetSecret())
+	require.NoError(t, err)
+
+	respConfirm, err := sut.AuthClient.ConfirmTOTP(ctx, &ssov1.ConfirmTOTPRequest{
+		Token: token,
+		Code:  totp.Code(secret, totp.Step(time.Now())),
+	})
+	require.NoError(t, err)
+
+	return secret, respConfirm.GetRecoveryCodes()
+}
@@ -0,0 +1,4 @@
+UPDATE apps SET signing_alg = "HS256" WHERE id = 1;
+
+INSERT INTO apps (id, name, secret, signing_alg) VALUES (2, "test-rs256", "test-secret-rs256", "RS256")
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+INSERT INTO app_redirect_uris (app_id, redirect_uri) VALUES (1, "http://localhost:3000/callback")
+ON CONFLICT DO NOTHING;
+
+INSERT INTO app_redirect_uris (app_id, redirect_uri) VALUES (2, "http://localhost:3000/callback")
+ON CONFLICT DO NOTHING;
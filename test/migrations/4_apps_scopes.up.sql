@@ -0,0 +1,5 @@
+INSERT INTO app_scopes (app_id, scope) VALUES (1, "reports:read")
+ON CONFLICT DO NOTHING;
+
+INSERT INTO app_scopes (app_id, scope) VALUES (1, "reports:write")
+ON CONFLICT DO NOTHING;
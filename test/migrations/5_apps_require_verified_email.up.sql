@@ -0,0 +1,2 @@
+INSERT INTO apps (id, name, secret, signing_alg, require_verified_email) VALUES (3, "test-verified", "test-secret-verified", "RS256", true)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+-- password: admin-test-password
+INSERT INTO users (email, pass_hash, email_verified)
+VALUES ("admin@sso.test", "$2a$10$SLt27TztVwtBdKVyy.QNU.7FTOqvcp8eslSwb3tl.u/ib4hA3PBTy", true)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_roles (user_id, role_id, app_id)
+SELECT users.id, roles.id, 0 FROM users, roles WHERE users.email = "admin@sso.test" AND roles.name = "admin"
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,353 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURI = "http://localhost:3000/callback"
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	Scope        string `json:"scope"`
+	Error        string `json:"error"`
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	verifier, challenge := pkcePair()
+	state := gofakeit.UUID()
+	nonce := gofakeit.UUID()
+
+	authorizeParams := url.Values{
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	resp := postAuthorize(t, sut, authorizeParams, url.Values{"email": {email}, "password": {pass}})
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, state, location.Query().Get("state"))
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	tokenForm := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.Itoa(appID)},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+
+	status, tokens := postToken(t, sut, tokenForm)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	respValid, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: tokens.AccessToken,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+	assert.True(t, respValid.GetActive())
+	assert.Equal(t, email, respValid.GetEmail())
+
+	idClaims := jwt.MapClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(tokens.IDToken, idClaims)
+	require.NoError(t, err)
+	assert.Equal(t, sut.Cfg.Issuer, idClaims["iss"])
+	assert.Equal(t, strconv.FormatInt(respValid.GetUserId(), 10), idClaims["sub"])
+	assert.Equal(t, strconv.Itoa(appID), idClaims["aud"])
+	assert.Equal(t, nonce, idClaims["nonce"])
+	assert.NotEmpty(t, idClaims["auth_time"])
+
+	// the ID token is signed with the same key but isn't an access token
+	assertTokenActive(ctx, t, sut, tokens.IDToken, false)
+	assert.Equal(t, http.StatusUnauthorized, getUserInfo(t, sut, tokens.IDToken).StatusCode)
+
+	userInfo := getUserInfo(t, sut, tokens.AccessToken)
+	assert.Equal(t, http.StatusOK, userInfo.StatusCode)
+	var info struct {
+		Sub   string `json:"sub"`
+		Email string `json:"email"`
+	}
+	require.NoError(t, json.NewDecoder(userInfo.Body).Decode(&info))
+	userInfo.Body.Close()
+	assert.Equal(t, idClaims["sub"], info.Sub)
+	assert.Equal(t, email, info.Email)
+
+	// codes are single-use
+	status, tokens = postToken(t, sut, tokenForm)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", tokens.Error)
+}
+
+func TestAuthorizationCodeWrongVerifier(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	_, challenge := pkcePair()
+	otherVerifier, _ := pkcePair()
+
+	resp := postAuthorize(t, sut, url.Values{
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"response_type":         {"code"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}, url.Values{"email": {email}, "password": {pass}})
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	status, tokens := postToken(t, sut, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.Itoa(appID)},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {otherVerifier},
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", tokens.Error)
+}
+
+func TestAuthorizeUnregisteredRedirectURI(t *testing.T) {
+	_, sut := suit.New(t)
+
+	_, challenge := pkcePair()
+
+	resp, err := noRedirectClient().Get(sut.HTTPURL + "/oauth/authorize?" + url.Values{
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {"https://evil.example.com/callback"},
+		"response_type":         {"code"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}.Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// never redirect to an unregistered uri
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Location"))
+}
+
+func TestAuthorizeUnregisteredScope(t *testing.T) {
+	_, sut := suit.New(t)
+
+	_, challenge := pkcePair()
+	state := gofakeit.UUID()
+
+	authorize := func(scope string) *http.Response {
+		resp, err := noRedirectClient().Get(sut.HTTPURL + "/oauth/authorize?" + url.Values{
+			"client_id":             {strconv.Itoa(appID)},
+			"redirect_uri":          {redirectURI},
+			"response_type":         {"code"},
+			"scope":                 {scope},
+			"state":                 {state},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}.Encode())
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, authorize("openid email reports:read").StatusCode)
+
+	resp := authorize("openid admin:write")
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "invalid_scope", location.Query().Get("error"))
+	assert.Equal(t, state, location.Query().Get("state"))
+}
+
+func TestAuthorizeWithoutCSRFToken(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	_, challenge := pkcePair()
+
+	// a form posted by another site has neither the cookie nor the token
+	resp, err := noRedirectClient().PostForm(sut.HTTPURL+"/oauth/authorize", url.Values{
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"response_type":         {"code"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"email":                 {email},
+		"password":              {pass},
+		"csrf_token":            {"forged"},
+	})
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Location"))
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	_, sut := suit.New(t)
+
+	resp, err := http.Get(sut.HTTPURL + "/.well-known/openid-configuration")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var metadata struct {
+		Issuer                string   `json:"issuer"`
+		AuthorizationEndpoint string   `json:"authorization_endpoint"`
+		TokenEndpoint         string   `json:"token_endpoint"`
+		UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+		JWKSURI               string   `json:"jwks_uri"`
+		IDTokenSigningAlgs    []string `json:"id_token_signing_alg_values_supported"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&metadata))
+
+	assert.Equal(t, sut.Cfg.Issuer, metadata.Issuer)
+	assert.Equal(t, sut.Cfg.Issuer+"/oauth/token", metadata.TokenEndpoint)
+	assert.Equal(t, sut.Cfg.Issuer+"/.well-known/jwks.json", metadata.JWKSURI)
+	assert.NotEmpty(t, metadata.IDTokenSigningAlgs)
+}
+
+func TestUserInfoInvalidToken(t *testing.T) {
+	_, sut := suit.New(t)
+
+	resp := getUserInfo(t, sut, "not.a.token")
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "invalid_token")
+}
+
+func getUserInfo(t *testing.T, sut *suit.Suite, accessToken string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, sut.HTTPURL+"/oauth/userinfo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func postToken(t *testing.T, sut *suit.Suite, form url.Values) (int, oauthTokenResponse) {
+	t.Helper()
+
+	resp, err := http.Post(sut.HTTPURL+"/oauth/token", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body oauthTokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	return resp.StatusCode, body
+}
+
+// postTokenAs authenticates the client with HTTP Basic, as confidential
+// clients usually do.
+func postTokenAs(t *testing.T, sut *suit.Suite, clientID, clientSecret string, form url.Values) (int, oauthTokenResponse) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, sut.HTTPURL+"/oauth/token", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body oauthTokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	return resp.StatusCode, body
+}
+
+// postAuthorize signs in on the login page of the authorize request params
+// as a browser does: it opens the page for the CSRF cookie and token first,
+// then posts form along with them.
+func postAuthorize(t *testing.T, sut *suit.Suite, params, form url.Values) *http.Response {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := noRedirectClient()
+	client.Jar = jar
+
+	resp, err := client.Get(sut.HTTPURL + "/oauth/authorize?" + params.Encode())
+	require.NoError(t, err)
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	match := csrfFieldRe.FindSubmatch(page)
+	require.NotNil(t, match, "login page without csrf token")
+
+	values := url.Values{"csrf_token": {string(match[1])}}
+	for key, value := range params {
+		values[key] = value
+	}
+	for key, value := range form {
+		values[key] = value
+	}
+
+	resp, err = client.PostForm(sut.HTTPURL+"/oauth/authorize", values)
+	require.NoError(t, err)
+
+	return resp
+}
+
+var csrfFieldRe = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func noRedirectClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func pkcePair() (verifier string, challenge string) {
+	verifier = gofakeit.Password(true, true, true, false, false, 64)
+	sum := sha256.Sum256([]byte(verifier))
+
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
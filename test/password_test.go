@@ -0,0 +1,148 @@
+package test
+
+import (
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestChangePassword(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+
+	current := login(ctx, t, sut, email, pass)
+	other := login(ctx, t, sut, email, pass)
+
+	newPass := GeneratePass()
+	_, err := sut.AuthClient.ChangePassword(ctx, &ssov1.ChangePasswordRequest{
+		Token:           current.GetToken(),
+		CurrentPassword: pass,
+		NewPassword:     newPass,
+	})
+	require.NoError(t, err)
+
+	// the session the change was made from survives, the other one doesn't
+	assertTokenActive(ctx, t, sut, current.GetToken(), true)
+	assertTokenActive(ctx, t, sut, other.GetToken(), false)
+
+	_, err = sut.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: other.GetRefreshToken(),
+	})
+	require.Error(t, err)
+
+	respRefresh, err := sut.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: current.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+	assertTokenActive(ctx, t, sut, respRefresh.GetToken(), true)
+
+	_, err = sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	login(ctx, t, sut, email, newPass)
+}
+
+func TestChangePasswordFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	token := login(ctx, t, sut, email, pass).GetToken()
+
+	tests := []struct {
+		name            string
+		token           string
+		currentPassword string
+		newPassword     string
+		expectedCode    codes.Code
+	}{
+		{
+			name:            "Wrong current password",
+			token:           token,
+			currentPassword: GeneratePass(),
+			newPassword:     GeneratePass(),
+			expectedCode:    codes.InvalidArgument,
+		},
+		{
+			name:            "New password too short",
+			token:           token,
+			currentPassword: pass,
+			newPassword:     "short",
+			expectedCode:    codes.InvalidArgument,
+		},
+		{
+			name:            "Empty current password",
+			token:           token,
+			currentPassword: "",
+			newPassword:     GeneratePass(),
+			expectedCode:    codes.InvalidArgument,
+		},
+		{
+			name:            "Invalid token",
+			token:           "not-a-token",
+			currentPassword: pass,
+			newPassword:     GeneratePass(),
+			expectedCode:    codes.Unauthenticated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sut.AuthClient.ChangePassword(ctx, &ssov1.ChangePasswordRequest{
+				Token:           tt.token,
+				CurrentPassword: tt.currentPassword,
+				NewPassword:     tt.newPassword,
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+
+	// nothing changed, the old password still works
+	login(ctx, t, sut, email, pass)
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	token := login(ctx, t, sut, email, pass).GetToken()
+
+	// a stolen token doesn't give unlimited guesses at the password
+	for range loginDelayAfter {
+		_, err := sut.AuthClient.ChangePassword(ctx, &ssov1.ChangePasswordRequest{
+			Token:           token,
+			CurrentPassword: GeneratePass(),
+			NewPassword:     GeneratePass(),
+		})
+		require.Error(t, err)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	_, err := sut.AuthClient.ChangePassword(ctx, &ssov1.ChangePasswordRequest{
+		Token:           token,
+		CurrentPassword: pass,
+		NewPassword:     GeneratePass(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,295 @@
+package test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var signInCodeRe = regexp.MustCompile(`\b[0-9]{6}\b`)
+
+const (
+	signInLinkSubject = "Your sign-in link"
+	signInCodeSubject = "Your sign-in code"
+	// signInMaxAttempts is passwordless.max_attempts in config/local.yaml.
+	signInMaxAttempts = 5
+)
+
+func TestPasswordlessLink(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, _ := registerUser(ctx, t, sut)
+
+	startedAt := time.Now()
+	respStart, err := sut.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email:  email,
+		AppId:  verifiedAppID,
+		Method: "link",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, respStart.GetLoginToken())
+
+	token := mailedToken(t, sut, email, signInLinkSubject, startedAt)
+
+	// the email was never verified, following the link verifies it
+	respLogin, err := sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		Token: token,
+		AppId: verifiedAppID,
+	})
+	require.NoError(t, err)
+	assert.False(t, respLogin.GetMfaRequired())
+
+	respValid, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: respLogin.GetToken(),
+		AppId: verifiedAppID,
+	})
+	require.NoError(t, err)
+	assert.True(t, respValid.GetActive())
+	assert.True(t, respValid.GetEmailVerified())
+
+	// single-use
+	_, err = sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		Token: token,
+		AppId: verifiedAppID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestPasswordlessCode(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, _ := registerUser(ctx, t, sut)
+	loginToken, code := startCodeLogin(ctx, t, sut, email, appID)
+
+	_, err := sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		Token: loginToken,
+		Code:  wrongCode(code),
+		AppId: appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// a wrong code doesn't burn the login
+	respLogin, err := sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		Token: loginToken,
+		Code:  code,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+	assertTokenActive(ctx, t, sut, respLogin.GetToken(), true)
+
+	_, err = sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		Token: loginToken,
+		Code:  code,
+		AppId: appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestPasswordlessCodeAttempts(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, _ := registerUser(ctx, t, sut)
+	loginToken, code := startCodeLogin(ctx, t, sut, email, appID)
+
+	complete := func(code string) error {
+		// wrong codes delay the account too, waited out to reach the limit of the login
+		for {
+			_, err := sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+				Token: loginToken,
+				Code:  code,
+				AppId: appID,
+			})
+			if status.Code(err) != codes.ResourceExhausted {
+				return err
+			}
+			time.Sleep(retryDelay(t, err) + 100*time.Millisecond)
+		}
+	}
+
+	for range signInMaxAttempts {
+		err := complete(wrongCode(code))
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	// out of attempts, the right code is refused too
+	err := complete(code)
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestPasswordlessCodeLockout(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+
+	// a new login for every guess doesn't bring new attempts
+	for range loginDelayAfter {
+		loginToken, code := startCodeLogin(ctx, t, sut, email, appID)
+		_, err := sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+			Token: loginToken,
+			Code:  wrongCode(code),
+			AppId: appID,
+		})
+		require.Error(t, err)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	loginToken, code := startCodeLogin(ctx, t, sut, email, appID)
+	_, err := sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		Token: loginToken,
+		Code:  code,
+		AppId: appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestPasswordlessOtherApp(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, _ := registerUser(ctx, t, sut)
+	loginToken, code := startCodeLogin(ctx, t, sut, email, appID)
+
+	_, err := sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		Token: loginToken,
+		Code:  code,
+		AppId: asymmetricAppID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestPasswordlessUnknownEmail(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email := gofakeit.Email()
+
+	// answers like for a registered email
+	respStart, err := sut.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email:  email,
+		AppId:  appID,
+		Method: "code",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, respStart.GetLoginToken())
+
+	time.Sleep(time.Second)
+
+	_, sent := sut.LastMail(email, signInCodeSubject)
+	assert.False(t, sent)
+
+	_, err = sut.AuthClient.CompletePasswordlessLogin(ctx, &ssov1.CompletePasswordlessLoginRequest{
+		Token: respStart.GetLoginToken(),
+		Code:  "123456",
+		AppId: appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestStartPasswordlessLoginFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	tests := []struct {
+		name         string
+		email        string
+		appID        int64
+		method       string
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Empty email",
+			email:        "",
+			appID:        appID,
+			method:       "link",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Unknown method",
+			email:        gofakeit.Email(),
+			appID:        appID,
+			method:       "sms",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Empty app id",
+			email:        gofakeit.Email(),
+			appID:        emptyAppID,
+			method:       "code",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Unknown app",
+			email:        gofakeit.Email(),
+			appID:        999,
+			method:       "code",
+			expectedCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sut.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+				Email:  tt.email,
+				AppId:  tt.appID,
+				Method: tt.method,
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func startCodeLogin(ctx context.Context, t *testing.T, sut *suit.Suite, email string, appID int64) (string, string) {
+	t.Helper()
+
+	startedAt := time.Now()
+	respStart, err := sut.AuthClient.StartPasswordlessLogin(ctx, &ssov1.StartPasswordlessLoginRequest{
+		Email:  email,
+		AppId:  appID,
+		Method: "code",
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respStart.GetLoginToken())
+
+	var code string
+	require.Eventually(t, func() bool {
+		msg, sent := sut.LastMail(email, signInCodeSubject)
+		if !sent || msg.SentAt.Before(startedAt) {
+			return false
+		}
+		code = signInCodeRe.FindString(msg.Body)
+		return code != ""
+	}, 5*time.Second, 50*time.Millisecond)
+
+	return respStart.GetLoginToken(), code
+}
+
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
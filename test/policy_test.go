@@ -0,0 +1,96 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email := gofakeit.Email()
+	local, _, _ := strings.Cut(email, "@")
+
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{
+			name:     "Common password",
+			password: "password123",
+		},
+		{
+			name:     "Contains email",
+			password: GeneratePass() + local,
+		},
+		{
+			name:     "Too short",
+			password: "Xk9#q",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+				Email:    email,
+				Password: tt.password,
+			})
+			require.Error(t, err)
+			st := status.Convert(err)
+			assert.Equal(t, codes.InvalidArgument, st.Code())
+
+			var violations []*errdetails.BadRequest_FieldViolation
+			for _, detail := range st.Details() {
+				if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+					violations = append(violations, badRequest.GetFieldViolations()...)
+				}
+			}
+			require.NotEmpty(t, violations)
+			for _, v := range violations {
+				assert.Equal(t, "password", v.GetField())
+				assert.NotEmpty(t, v.GetDescription())
+			}
+		})
+	}
+
+	// none of the refused passwords created the user
+	_, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: GeneratePass(),
+	})
+	require.NoError(t, err)
+}
+
+func TestResetPasswordPolicy(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, _ := registerUser(ctx, t, sut)
+	token := requestPasswordReset(ctx, t, sut, email)
+
+	_, err := sut.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:       token,
+		NewPassword: "qwerty123",
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the refused password didn't use up the token
+	newPass := GeneratePass()
+	_, err = sut.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:       token,
+		NewPassword: newPass,
+	})
+	require.NoError(t, err)
+
+	login(ctx, t, sut, email, newPass)
+}
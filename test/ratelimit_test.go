@@ -0,0 +1,54 @@
+package test
+
+import (
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// resetRequestsPerUser is the RequestPasswordReset rule in
+// config/local.yaml.
+const resetRequestsPerUser = 5
+
+func TestRateLimit(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email := gofakeit.Email()
+
+	for range resetRequestsPerUser {
+		_, err := sut.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{
+			Email: email,
+		})
+		require.NoError(t, err)
+	}
+
+	_, err := sut.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{
+		Email: email,
+	})
+	require.Error(t, err)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	require.NotNil(t, retryInfo)
+	assert.Positive(t, retryInfo.GetRetryDelay().AsDuration())
+
+	// the bucket is the user's, not the method's
+	_, err = sut.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{
+		Email: gofakeit.Email(),
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,288 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRoles(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := gofakeit.Email(), GeneratePass()
+	reg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	roleID := createRole(ctx, t, sut, admin.GetToken(), "posts:write")
+
+	_, err = sut.AuthClient.AssignRole(ctx, &ssov1.AssignRoleRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+		RoleId: roleID,
+		AppId:  appID,
+	})
+	require.NoError(t, err)
+
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID, "posts:write", true)
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID+1, "posts:write", false)
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID, "posts:delete", false)
+
+	_, err = sut.AuthClient.GrantPermission(ctx, &ssov1.GrantPermissionRequest{
+		Token:      admin.GetToken(),
+		RoleId:     roleID,
+		Permission: "posts:delete",
+	})
+	require.NoError(t, err)
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID, "posts:delete", true)
+
+	// the access token carries what the user holds in the app
+	session := login(ctx, t, sut, email, pass)
+	claims, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: session.GetToken()})
+	require.NoError(t, err)
+	assert.Len(t, claims.GetRoles(), 1)
+	assert.ElementsMatch(t, []string{"posts:delete", "posts:write"}, claims.GetPermissions())
+
+	userRoles, err := sut.AuthClient.ListUserRoles(ctx, &ssov1.ListUserRolesRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+	})
+	require.NoError(t, err)
+	require.Len(t, userRoles.GetRoles(), 1)
+	assert.Equal(t, roleID, userRoles.GetRoles()[0].GetRoleId())
+	assert.Equal(t, int64(appID), userRoles.GetRoles()[0].GetAppId())
+
+	_, err = sut.AuthClient.RevokePermission(ctx, &ssov1.RevokePermissionRequest{
+		Token:      admin.GetToken(),
+		RoleId:     roleID,
+		Permission: "posts:delete",
+	})
+	require.NoError(t, err)
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID, "posts:delete", false)
+
+	_, err = sut.AuthClient.UnassignRole(ctx, &ssov1.UnassignRoleRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+		RoleId: roleID,
+		AppId:  appID,
+	})
+	require.NoError(t, err)
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID, "posts:write", false)
+
+	_, err = sut.AuthClient.DeleteRole(ctx, &ssov1.DeleteRoleRequest{
+		Token:  admin.GetToken(),
+		RoleId: roleID,
+	})
+	require.NoError(t, err)
+
+	resp, err := sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+		Type:   "role.assigned",
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEvents(), 1)
+	assert.Equal(t, int64(appID), resp.GetEvents()[0].GetAppId())
+}
+
+func TestRoleInEveryApp(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := gofakeit.Email(), GeneratePass()
+	reg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+	session := login(ctx, t, sut, email, pass)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	roleID := createRole(ctx, t, sut, admin.GetToken(), "audit:read")
+
+	_, err = sut.AuthClient.AssignRole(ctx, &ssov1.AssignRoleRequest{
+		Token:  admin.GetToken(),
+		UserId: reg.GetUserId(),
+		RoleId: roleID,
+	})
+	require.NoError(t, err)
+
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID, "audit:read", true)
+	assertHasPermission(ctx, t, sut, reg.GetUserId(), appID+1, "audit:read", true)
+
+	// a permission the service checks works through any role holding it
+	_, err = sut.AuthClient.ListAuditEvents(ctx, &ssov1.ListAuditEventsRequest{Token: session.GetToken()})
+	require.NoError(t, err)
+
+	_, err = sut.AuthClient.UnlockAccount(ctx, &ssov1.UnlockAccountRequest{Token: session.GetToken(), UserId: reg.GetUserId()})
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestListRoles(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	resp, err := sut.AuthClient.ListRoles(ctx, &ssov1.ListRolesRequest{Token: admin.GetToken()})
+	require.NoError(t, err)
+
+	var adminRole *ssov1.Role
+	for _, role := range resp.GetRoles() {
+		if role.GetName() == "admin" {
+			adminRole = role
+		}
+	}
+	require.NotNil(t, adminRole)
+	assert.Subset(t, adminRole.GetPermissions(), []string{"audit:read", "roles:manage", "users:unlock"})
+}
+
+func TestRolesFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	session := login(ctx, t, sut, email, pass)
+	admin := login(ctx, t, sut, adminEmail, adminPass)
+
+	roles, err := sut.AuthClient.ListRoles(ctx, &ssov1.ListRolesRequest{Token: admin.GetToken()})
+	require.NoError(t, err)
+	var adminRoleID int64
+	for _, role := range roles.GetRoles() {
+		if role.GetName() == "admin" {
+			adminRoleID = role.GetId()
+		}
+	}
+	require.NotZero(t, adminRoleID)
+
+	name := "role-" + gofakeit.UUID()
+	_, err = sut.AuthClient.CreateRole(ctx, &ssov1.CreateRoleRequest{Token: admin.GetToken(), Name: name})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		call         func() error
+		expectedCode codes.Code
+	}{
+		{
+			name: "Not an admin",
+			call: func() error {
+				_, err := sut.AuthClient.CreateRole(ctx, &ssov1.CreateRoleRequest{Token: session.GetToken(), Name: "role-" + gofakeit.UUID()})
+				return err
+			},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name: "Empty name",
+			call: func() error {
+				_, err := sut.AuthClient.CreateRole(ctx, &ssov1.CreateRoleRequest{Token: admin.GetToken()})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Role exists",
+			call: func() error {
+				_, err := sut.AuthClient.CreateRole(ctx, &ssov1.CreateRoleRequest{Token: admin.GetToken(), Name: name})
+				return err
+			},
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			name: "Delete admin role",
+			call: func() error {
+				_, err := sut.AuthClient.DeleteRole(ctx, &ssov1.DeleteRoleRequest{Token: admin.GetToken(), RoleId: adminRoleID})
+				return err
+			},
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name: "Revoke admin permission",
+			call: func() error {
+				_, err := sut.AuthClient.RevokePermission(ctx, &ssov1.RevokePermissionRequest{
+					Token: admin.GetToken(), RoleId: adminRoleID, Permission: "roles:manage",
+				})
+				return err
+			},
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name: "Unknown role",
+			call: func() error {
+				_, err := sut.AuthClient.AssignRole(ctx, &ssov1.AssignRoleRequest{Token: admin.GetToken(), UserId: 1, RoleId: 1 << 40})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "Unknown app",
+			call: func() error {
+				_, err := sut.AuthClient.AssignRole(ctx, &ssov1.AssignRoleRequest{
+					Token: admin.GetToken(), UserId: 1, RoleId: adminRoleID, AppId: 1 << 40,
+				})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "Role not assigned",
+			call: func() error {
+				_, err := sut.AuthClient.UnassignRole(ctx, &ssov1.UnassignRoleRequest{
+					Token: admin.GetToken(), UserId: 1 << 40, RoleId: adminRoleID,
+				})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "Empty permission",
+			call: func() error {
+				_, err := sut.AuthClient.HasPermission(ctx, &ssov1.HasPermissionRequest{UserId: 1, AppId: appID})
+				return err
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func createRole(ctx context.Context, t *testing.T, sut *suit.Suite, token string, permissions ...string) int64 {
+	t.Helper()
+
+	resp, err := sut.AuthClient.CreateRole(ctx, &ssov1.CreateRoleRequest{
+		Token:       token,
+		Name:        "role-" + gofakeit.UUID(),
+		Permissions: permissions,
+	})
+	require.NoError(t, err)
+
+	return resp.GetRoleId()
+}
+
+func assertHasPermission(ctx context.Context, t *testing.T, sut *suit.Suite, userID, appID int64, permission string, allowed bool) {
+	t.Helper()
+
+	resp, err := sut.AuthClient.HasPermission(ctx, &ssov1.HasPermissionRequest{
+		UserId:     userID,
+		AppId:      appID,
+		Permission: permission,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, allowed, resp.GetAllowed(), "%s in app %d", permission, appID)
+}
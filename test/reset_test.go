@@ -0,0 +1,202 @@
+package test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// resetTokenRe matches the opaque token in reset, verification and sign-in
+// emails, with or without a link around it.
+var resetTokenRe = regexp.MustCompile(`[A-Za-z0-9_-]{43}`)
+
+const resetSubject = "Reset your password"
+
+func TestPasswordReset(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	session := login(ctx, t, sut, email, pass)
+
+	token := requestPasswordReset(ctx, t, sut, email)
+
+	newPass := GeneratePass()
+	_, err := sut.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:       token,
+		NewPassword: newPass,
+	})
+	require.NoError(t, err)
+
+	// every session is gone, not only the other ones
+	assertTokenActive(ctx, t, sut, session.GetToken(), false)
+	_, err = sut.AuthClient.Refresh(ctx, &ssov1.RefreshRequest{
+		RefreshToken: session.GetRefreshToken(),
+	})
+	require.Error(t, err)
+
+	_, err = sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	login(ctx, t, sut, email, newPass)
+
+	// single-use
+	_, err = sut.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+		Token:       token,
+		NewPassword: GeneratePass(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestPasswordResetUnknownEmail(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email := gofakeit.Email()
+
+	_, err := sut.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{
+		Email: email,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	_, sent := sut.LastMail(email, resetSubject)
+	assert.False(t, sent)
+}
+
+func TestPasswordResetInvalidated(t *testing.T) {
+	// session is logged in before the reset is requested
+	tests := []struct {
+		name       string
+		invalidate func(ctx context.Context, t *testing.T, sut *suit.Suite, email, pass, session string)
+	}{
+		{
+			name: "Login",
+			invalidate: func(ctx context.Context, t *testing.T, sut *suit.Suite, email, pass, _ string) {
+				login(ctx, t, sut, email, pass)
+			},
+		},
+		{
+			name: "Password change",
+			invalidate: func(ctx context.Context, t *testing.T, sut *suit.Suite, _, pass, session string) {
+				_, err := sut.AuthClient.ChangePassword(ctx, &ssov1.ChangePasswordRequest{
+					Token:           session,
+					CurrentPassword: pass,
+					NewPassword:     GeneratePass(),
+				})
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, sut := suit.New(t)
+
+			email, pass := registerUser(ctx, t, sut)
+			session := login(ctx, t, sut, email, pass).GetToken()
+			token := requestPasswordReset(ctx, t, sut, email)
+
+			tt.invalidate(ctx, t, sut, email, pass, session)
+
+			_, err := sut.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+				Token:       token,
+				NewPassword: GeneratePass(),
+			})
+			require.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func TestResetPasswordFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, _ := registerUser(ctx, t, sut)
+	token := requestPasswordReset(ctx, t, sut, email)
+
+	tests := []struct {
+		name         string
+		token        string
+		newPassword  string
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Unknown token",
+			token:        "not-a-token",
+			newPassword:  GeneratePass(),
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Empty token",
+			token:        "",
+			newPassword:  GeneratePass(),
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "New password too short",
+			token:        token,
+			newPassword:  "short",
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sut.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{
+				Token:       tt.token,
+				NewPassword: tt.newPassword,
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+// requestPasswordReset asks for a reset and waits for the email, which is
+// sent in the background.
+func requestPasswordReset(ctx context.Context, t *testing.T, sut *suit.Suite, email string) string {
+	t.Helper()
+
+	requestedAt := time.Now()
+
+	_, err := sut.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{
+		Email: email,
+	})
+	require.NoError(t, err)
+
+	return mailedToken(t, sut, email, resetSubject, requestedAt)
+}
+
+// mailedToken waits for an email with subject sent after since and returns
+// the token in it.
+func mailedToken(t *testing.T, sut *suit.Suite, email, subject string, since time.Time) string {
+	t.Helper()
+
+	var token string
+	require.Eventually(t, func() bool {
+		msg, sent := sut.LastMail(email, subject)
+		if !sent || msg.SentAt.Before(since) {
+			return false
+		}
+		token = resetTokenRe.FindString(msg.Body)
+		return token != ""
+	}, 5*time.Second, 50*time.Millisecond)
+
+	return token
+}
@@ -0,0 +1,42 @@
+package suit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"sso/internal/mail/file"
+)
+
+// LastMail returns the latest message with the given subject the server's
+// file mailer wrote to the given address.
+func (s *Suite) LastMail(to, subject string) (file.Record, bool) {
+	s.Helper()
+
+	f, err := os.Open(s.MailPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return file.Record{}, false
+		}
+		s.Fatalf("faild to open mail file %v", err)
+	}
+	defer f.Close()
+
+	var (
+		last  file.Record
+		found bool
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record file.Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.To == to && record.Subject == subject {
+			last, found = record, true
+		}
+	}
+
+	return last, found
+}
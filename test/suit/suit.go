@@ -3,6 +3,7 @@ package suit
 import (
 	"context"
 	"net"
+	"path/filepath"
 	"strconv"
 	"testing"
 
@@ -21,6 +22,9 @@ type Suite struct {
 	*testing.T
 	Cfg        *config.Config
 	AuthClient sso1.AuthClient
+	HTTPURL    string
+	// MailPath is the file the server's file mailer writes to.
+	MailPath string
 }
 
 func New(t *testing.T) (context.Context, *Suite) {
@@ -42,16 +46,27 @@ func New(t *testing.T) (context.Context, *Suite) {
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 	)
 	if err != nil {
-		t.Fatalf("grpc server connection faild %w", err)
+		t.Fatalf("grpc server connection faild %v", err)
 	}
 
 	return ctx, &Suite{
 		T:          t,
 		Cfg:        config,
 		AuthClient: sso1.NewAuthClient(cc),
+		HTTPURL:    "http://" + net.JoinHostPort(grpcHost, strconv.Itoa(config.HTTP.Port)),
+		MailPath:   mailPath(config),
 	}
 }
 
+// mailPath resolves the mail file like the server does, it runs from the
+// repository root and the tests from test/.
+func mailPath(cfg *config.Config) string {
+	if filepath.IsAbs(cfg.Mail.FilePath) {
+		return cfg.Mail.FilePath
+	}
+	return filepath.Join("..", cfg.Mail.FilePath)
+}
+
 func grpcAddress(cfg *config.Config) string {
 	return net.JoinHostPort(grpcHost, strconv.Itoa(cfg.GRPC.Port))
 }
@@ -0,0 +1,234 @@
+package suit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// authenticator data flags, WebAuthn §6.1
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// Authenticator is a software WebAuthn authenticator, standing in for the
+// browser and a platform authenticator. It creates ES256 credentials with
+// "none" attestation, always verifies the user and keeps credentials
+// discoverable.
+type Authenticator struct {
+	Origin      string
+	credentials []*softCredential
+}
+
+type softCredential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Clone copies the authenticator with its keys and counters, like an
+// attacker that extracted them. Once either copy is used, the other one
+// presents a stale counter.
+func (a *Authenticator) Clone() *Authenticator {
+	clone := &Authenticator{Origin: a.Origin}
+	for _, credential := range a.credentials {
+		copied := *credential
+		clone.credentials = append(clone.credentials, &copied)
+	}
+	return clone
+}
+
+// Create answers navigator.credentials.create with the given options JSON
+// and returns the PublicKeyCredential JSON to send back.
+func (a *Authenticator) Create(options string) (string, error) {
+	var creation protocol.CredentialCreation
+	if err := json.Unmarshal([]byte(options), &creation); err != nil {
+		return "", err
+	}
+
+	userID, ok := creation.Response.User.ID.(string)
+	if !ok {
+		return "", errors.New("user id is not a string")
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(userID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, excluded := range creation.Response.CredentialExcludeList {
+		for _, credential := range a.credentials {
+			if string(credential.id) == string(excluded.CredentialID) {
+				return "", errors.New("credential already registered with this authenticator")
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	credential := &softCredential{
+		id:         make([]byte, 16),
+		rpID:       creation.Response.RelyingParty.ID,
+		userHandle: userHandle,
+		key:        key,
+	}
+	if _, err := rand.Read(credential.id); err != nil {
+		return "", err
+	}
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: key.X.FillBytes(make([]byte, 32)),
+		YCoord: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// attested credential data: aaguid, credential id length, id, key
+	authData := a.authenticatorData(credential, flagUserPresent|flagUserVerified|flagAttested)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(credential.id)))
+	authData = append(authData, credential.id...)
+	authData = append(authData, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	clientData, err := a.clientData("webauthn.create", creation.Response.Challenge.String())
+	if err != nil {
+		return "", err
+	}
+
+	a.credentials = append(a.credentials, credential)
+
+	return marshalCredential(credential.id, map[string]any{
+		"clientDataJSON":    encode(clientData),
+		"attestationObject": encode(attestationObject),
+		"transports":        []string{"internal"},
+	})
+}
+
+// Get answers navigator.credentials.get with the given options JSON. It
+// signs with the first credential in the allow list, or with an empty list
+// with the latest credential for the relying party.
+func (a *Authenticator) Get(options string) (string, error) {
+	var assertion protocol.CredentialAssertion
+	if err := json.Unmarshal([]byte(options), &assertion); err != nil {
+		return "", err
+	}
+
+	credential := a.find(assertion.Response.RelyingPartyID, assertion.Response.AllowedCredentials)
+	if credential == nil {
+		return "", fmt.Errorf("no credential for %s", assertion.Response.RelyingPartyID)
+	}
+
+	credential.signCount++
+
+	authData := a.authenticatorData(credential, flagUserPresent|flagUserVerified)
+
+	clientData, err := a.clientData("webauthn.get", assertion.Response.Challenge.String())
+	if err != nil {
+		return "", err
+	}
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, credential.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return marshalCredential(credential.id, map[string]any{
+		"clientDataJSON":    encode(clientData),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(credential.userHandle),
+	})
+}
+
+func (a *Authenticator) find(rpID string, allowed []protocol.CredentialDescriptor) *softCredential {
+	if len(allowed) == 0 {
+		for i := len(a.credentials) - 1; i >= 0; i-- {
+			if a.credentials[i].rpID == rpID {
+				return a.credentials[i]
+			}
+		}
+		return nil
+	}
+
+	for _, descriptor := range allowed {
+		for _, credential := range a.credentials {
+			if credential.rpID == rpID && string(credential.id) == string(descriptor.CredentialID) {
+				return credential
+			}
+		}
+	}
+	return nil
+}
+
+// authenticatorData is the rp id hash, flags and sign counter, WebAuthn §6.1.
+func (a *Authenticator) authenticatorData(credential *softCredential, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(credential.rpID))
+
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, flags)
+	return binary.BigEndian.AppendUint32(authData, credential.signCount)
+}
+
+func (a *Authenticator) clientData(ceremonyType, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":        ceremonyType,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+func marshalCredential(id []byte, response map[string]any) (string, error) {
+	credential, err := json.Marshal(map[string]any{
+		"id":       encode(id),
+		"rawId":    encode(id),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(credential), nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
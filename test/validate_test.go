@@ -0,0 +1,103 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateToken(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email := gofakeit.Email()
+	pass := GeneratePass()
+
+	respReg, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	for _, id := range []int64{appID, asymmetricAppID} {
+		respLog, err := sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+			Email:    email,
+			Password: pass,
+			AppId:    id,
+		})
+		require.NoError(t, err)
+
+		loginTime := time.Now()
+
+		respValid, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+			Token: respLog.GetToken(),
+			AppId: id,
+		})
+		require.NoError(t, err)
+
+		assert.True(t, respValid.GetActive())
+		assert.Equal(t, respReg.GetUserId(), respValid.GetUserId())
+		assert.Equal(t, email, respValid.GetEmail())
+		assert.Equal(t, id, respValid.GetAppId())
+
+		const deltaSeconds = 1
+		assert.InDelta(t, loginTime.Add(sut.Cfg.TokenTTL).Unix(), respValid.GetExpiresAt(), deltaSeconds)
+	}
+}
+
+func TestValidateTokenInactive(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email := gofakeit.Email()
+	pass := GeneratePass()
+
+	_, err := sut.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	respLog, err := sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		appID int64
+	}{
+		{
+			name:  "Garbage token",
+			token: "not.a.token",
+		},
+		{
+			name:  "Tampered token",
+			token: respLog.GetToken() + "x",
+		},
+		{
+			name:  "Token of another app",
+			token: respLog.GetToken(),
+			appID: asymmetricAppID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			respValid, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+				Token: tt.token,
+				AppId: tt.appID,
+			})
+			require.NoError(t, err)
+			assert.False(t, respValid.GetActive())
+			assert.Empty(t, respValid.GetUserId())
+		})
+	}
+}
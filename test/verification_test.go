@@ -0,0 +1,132 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// verifiedAppID only issues tokens to users with a verified email.
+const verifiedAppID = 3
+
+const verificationSubject = "Confirm your email address"
+
+func TestVerifyEmail(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	registeredAt := time.Now()
+	email, pass := registerUser(ctx, t, sut)
+	token := mailedToken(t, sut, email, verificationSubject, registeredAt)
+
+	_, err := sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    verifiedAppID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// apps that don't require it still log the user in
+	respValid, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: login(ctx, t, sut, email, pass).GetToken(),
+	})
+	require.NoError(t, err)
+	assert.False(t, respValid.GetEmailVerified())
+
+	_, err = sut.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: token})
+	require.NoError(t, err)
+
+	respLogin, err := sut.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    verifiedAppID,
+	})
+	require.NoError(t, err)
+
+	respValid, err = sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: respLogin.GetToken(),
+		AppId: verifiedAppID,
+	})
+	require.NoError(t, err)
+	assert.True(t, respValid.GetActive())
+	assert.True(t, respValid.GetEmailVerified())
+
+	// single-use
+	_, err = sut.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: token})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestResendVerificationThrottled(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	registeredAt := time.Now()
+	email, _ := registerUser(ctx, t, sut)
+	mailedToken(t, sut, email, verificationSubject, registeredAt)
+
+	resentAt := time.Now()
+	_, err := sut.AuthClient.ResendVerification(ctx, &ssov1.ResendVerificationRequest{
+		Email: email,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	// the registration email went out less than resend_interval ago
+	msg, sent := sut.LastMail(email, verificationSubject)
+	require.True(t, sent)
+	assert.True(t, msg.SentAt.Before(resentAt))
+}
+
+func TestResendVerificationUnknownEmail(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email := gofakeit.Email()
+
+	_, err := sut.AuthClient.ResendVerification(ctx, &ssov1.ResendVerificationRequest{
+		Email: email,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	_, sent := sut.LastMail(email, verificationSubject)
+	assert.False(t, sent)
+}
+
+func TestVerifyEmailFails(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	tests := []struct {
+		name         string
+		token        string
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Unknown token",
+			token:        "not-a-token",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Empty token",
+			token:        "",
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sut.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: tt.token})
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
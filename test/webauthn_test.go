@@ -0,0 +1,218 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/test/suit"
+
+	ssov1 "github.com/Rostuslavchuk/sso-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWebAuthnSecondFactor(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	device := newAuthenticator(sut)
+	recoveryCodes := registerPasskey(ctx, t, sut, device, login(ctx, t, sut, email, pass).GetToken())
+	assert.Len(t, recoveryCodes, 10)
+
+	respLog := login(ctx, t, sut, email, pass)
+	require.True(t, respLog.GetMfaRequired())
+	assert.Equal(t, []string{"webauthn"}, respLog.GetMfaMethods())
+
+	respBegin, err := sut.AuthClient.BeginWebAuthnLogin(ctx, &ssov1.BeginWebAuthnLoginRequest{
+		MfaToken: respLog.GetMfaToken(),
+	})
+	require.NoError(t, err)
+
+	assertion, err := device.Get(respBegin.GetOptions())
+	require.NoError(t, err)
+
+	respFinish, err := sut.AuthClient.FinishWebAuthnLogin(ctx, &ssov1.FinishWebAuthnLoginRequest{
+		CeremonyToken: respBegin.GetCeremonyToken(),
+		Credential:    assertion,
+	})
+	require.NoError(t, err)
+	assertTokenActive(ctx, t, sut, respFinish.GetToken(), true)
+
+	// the login is finished, neither the ceremony nor the mfa token work again
+	_, err = sut.AuthClient.FinishWebAuthnLogin(ctx, &ssov1.FinishWebAuthnLoginRequest{
+		CeremonyToken: respBegin.GetCeremonyToken(),
+		Credential:    assertion,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = sut.AuthClient.BeginWebAuthnLogin(ctx, &ssov1.BeginWebAuthnLoginRequest{
+		MfaToken: respLog.GetMfaToken(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestWebAuthnPasswordless(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	device := newAuthenticator(sut)
+	registerPasskey(ctx, t, sut, device, login(ctx, t, sut, email, pass).GetToken())
+
+	token := passkeyLogin(ctx, t, sut, device)
+
+	respValidate, err := sut.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: token,
+		AppId: appID,
+	})
+	require.NoError(t, err)
+	assert.True(t, respValidate.GetActive())
+	assert.Equal(t, email, respValidate.GetEmail())
+}
+
+func TestWebAuthnClonedCredential(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	device := newAuthenticator(sut)
+	registerPasskey(ctx, t, sut, device, login(ctx, t, sut, email, pass).GetToken())
+
+	clone := device.Clone()
+	passkeyLogin(ctx, t, sut, device)
+
+	// the clone's counter is behind the one the server has seen
+	respBegin, err := sut.AuthClient.BeginWebAuthnLogin(ctx, &ssov1.BeginWebAuthnLoginRequest{AppId: appID})
+	require.NoError(t, err)
+
+	assertion, err := clone.Get(respBegin.GetOptions())
+	require.NoError(t, err)
+
+	_, err = sut.AuthClient.FinishWebAuthnLogin(ctx, &ssov1.FinishWebAuthnLoginRequest{
+		CeremonyToken: respBegin.GetCeremonyToken(),
+		Credential:    assertion,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestWebAuthnLoginLockout(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	device := newAuthenticator(sut)
+	registerPasskey(ctx, t, sut, device, login(ctx, t, sut, email, pass).GetToken())
+
+	clone := device.Clone()
+	passkeyLogin(ctx, t, sut, device)
+
+	// a rejected assertion counts with the wrong passwords
+	for range loginDelayAfter - 1 {
+		failLogin(ctx, t, sut, email)
+	}
+
+	respBegin, err := sut.AuthClient.BeginWebAuthnLogin(ctx, &ssov1.BeginWebAuthnLoginRequest{AppId: appID})
+	require.NoError(t, err)
+
+	assertion, err := clone.Get(respBegin.GetOptions())
+	require.NoError(t, err)
+
+	_, err = sut.AuthClient.FinishWebAuthnLogin(ctx, &ssov1.FinishWebAuthnLoginRequest{
+		CeremonyToken: respBegin.GetCeremonyToken(),
+		Credential:    assertion,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the blocked account is refused even with the right passkey
+	respBegin, err = sut.AuthClient.BeginWebAuthnLogin(ctx, &ssov1.BeginWebAuthnLoginRequest{AppId: appID})
+	require.NoError(t, err)
+
+	assertion, err = device.Get(respBegin.GetOptions())
+	require.NoError(t, err)
+
+	_, err = sut.AuthClient.FinishWebAuthnLogin(ctx, &ssov1.FinishWebAuthnLoginRequest{
+		CeremonyToken: respBegin.GetCeremonyToken(),
+		Credential:    assertion,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	time.Sleep(retryDelay(t, err) + 100*time.Millisecond)
+	passkeyLogin(ctx, t, sut, device)
+}
+
+func TestWebAuthnWrongOrigin(t *testing.T) {
+	ctx, sut := suit.New(t)
+
+	email, pass := registerUser(ctx, t, sut)
+	token := login(ctx, t, sut, email, pass).GetToken()
+
+	respBegin, err := sut.AuthClient.BeginWebAuthnRegistration(ctx, &ssov1.BeginWebAuthnRegistrationRequest{
+		Token: token,
+	})
+	require.NoError(t, err)
+
+	phishing := suit.NewAuthenticator("https://sso.example.net")
+	credential, err := phishing.Create(respBegin.GetOptions())
+	require.NoError(t, err)
+
+	_, err = sut.AuthClient.FinishWebAuthnRegistration(ctx, &ssov1.FinishWebAuthnRegistrationRequest{
+		Token:         token,
+		CeremonyToken: respBegin.GetCeremonyToken(),
+		Credential:    credential,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func newAuthenticator(sut *suit.Suite) *suit.Authenticator {
+	return suit.NewAuthenticator(sut.Cfg.WebAuthn.RPOrigins[0])
+}
+
+// registerPasskey adds a credential of device for the token's user and
+// returns the recovery codes issued with it.
+func registerPasskey(ctx context.Context, t *testing.T, sut *suit.Suite, device *suit.Authenticator, token string) []string {
+	t.Helper()
+
+	respBegin, err := sut.AuthClient.BeginWebAuthnRegistration(ctx, &ssov1.BeginWebAuthnRegistrationRequest{
+		Token: token,
+	})
+	require.NoError(t, err)
+
+	credential, err := device.Create(respBegin.GetOptions())
+	require.NoError(t, err)
+
+	respFinish, err := sut.AuthClient.FinishWebAuthnRegistration(ctx, &ssov1.FinishWebAuthnRegistrationRequest{
+		Token:         token,
+		CeremonyToken: respBegin.GetCeremonyToken(),
+		Credential:    credential,
+	})
+	require.NoError(t, err)
+
+	return respFinish.GetRecoveryCodes()
+}
+
+// passkeyLogin logs in to the test app with a passkey of device alone and
+// returns the access token.
+func passkeyLogin(ctx context.Context, t *testing.T, sut *suit.Suite, device *suit.Authenticator) string {
+	t.Helper()
+
+	respBegin, err := sut.AuthClient.BeginWebAuthnLogin(ctx, &ssov1.BeginWebAuthnLoginRequest{AppId: appID})
+	require.NoError(t, err)
+
+	assertion, err := device.Get(respBegin.GetOptions())
+	require.NoError(t, err)
+
+	respFinish, err := sut.AuthClient.FinishWebAuthnLogin(ctx, &ssov1.FinishWebAuthnLoginRequest{
+		CeremonyToken: respBegin.GetCeremonyToken(),
+		Credential:    assertion,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respFinish.GetToken())
+
+	return respFinish.GetToken()
+}